@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RemoveOptions are what RemoveAddon deletes besides the addon's release
+// and manifests.
+type RemoveOptions struct {
+	// CRDs deletes the CRDs the chart ships, and with them every custom
+	// resource of theirs. Helm leaves them behind on uninstall.
+	CRDs bool
+	// Namespace deletes the release's namespace when no other release of
+	// the config uses it.
+	Namespace bool
+}
+
+func findAddon(conf *Config, name string) (Addon, bool) {
+	for _, addon := range configAddons(conf) {
+		if addon.Name == name {
+			return addon, true
+		}
+	}
+
+	return Addon{}, false
+}
+
+func addonNames(conf *Config) []string {
+	addons := configAddons(conf)
+	names := make([]string, 0, len(addons))
+	for _, addon := range addons {
+		names = append(names, addon.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// unknownAddon explains why name isn't an addon.
+func unknownAddon(conf *Config, name string) error {
+	releases, err := Releases(conf, "")
+	if err != nil {
+		return err
+	}
+	for _, release := range releases {
+		if release.Spec.ReleaseName == name {
+			return fmt.Errorf("%s is part of the core stack, not an addon", name)
+		}
+	}
+
+	return fmt.Errorf("unknown addon %s, expected one of %s", name, strings.Join(addonNames(conf), ", "))
+}
+
+// RemoveAddon uninstalls a single addon: its extra manifests are deleted
+// and its release uninstalled. The core stack can't be removed, and neither
+// can an addon another installed addon requires.
+func RemoveAddon(ctx context.Context, conf *Config, name string, opts RemoveOptions) error {
+	addon, ok := findAddon(conf, name)
+	if !ok {
+		return unknownAddon(conf, name)
+	}
+
+	for _, other := range configAddons(conf) {
+		for _, required := range other.Requires {
+			if required != name {
+				continue
+			}
+
+			installed, err := addonInstalled(conf, other)
+			if err != nil {
+				return err
+			}
+			if installed {
+				return fmt.Errorf("%s requires %s, remove it first", other.Name, name)
+			}
+		}
+	}
+
+	if addon.Manifests != nil {
+		manifest, err := addon.Manifests(conf)
+		if err != nil {
+			return fmt.Errorf("rendering manifests: %w", err)
+		}
+		if manifest, err = withoutNamespaces(manifest); err != nil {
+			return fmt.Errorf("parsing manifests: %w", err)
+		}
+
+		if manifest != "" {
+			log.Printf("Deleting the %s manifests\n", name)
+			out, err := RunCommandWithInput(ctx, manifest, "kubectl", "delete", "--kubeconfig="+KubeconfigPath, "--ignore-not-found", "-f", "-")
+			if err != nil {
+				return fmt.Errorf("deleting manifests: %w: %s", err, out)
+			}
+		}
+	}
+
+	if addon.Spec == nil {
+		log.Printf("%s isn't installed from a chart, what it set up on the node stays in place\n", name)
+		return warnStillEnabled(conf, addon)
+	}
+
+	spec, err := addon.Spec(conf)
+	if err != nil {
+		return fmt.Errorf("rendering values: %w", err)
+	}
+
+	client, err := helmClientForNs(spec.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// The CRDs are only known from the chart, which has to be rendered
+	// before the release is gone.
+	var crds []string
+	if opts.CRDs {
+		if err := addChartRepo(conf, client, addon.Repo); err != nil {
+			return fmt.Errorf("adding the chart repo: %w", err)
+		}
+
+		crds, err = chartCRDs(client, spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := client.GetRelease(spec.ReleaseName); err == nil {
+		log.Printf("Uninstalling %s\n", spec.ReleaseName)
+		if err := client.UninstallRelease(spec); err != nil {
+			return fmt.Errorf("uninstalling %s: %w", spec.ReleaseName, err)
+		}
+	} else {
+		log.Printf("%s isn't installed\n", spec.ReleaseName)
+	}
+
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+
+	if len(crds) > 0 {
+		log.Printf("Deleting the CRDs of %s: %s\n", name, strings.Join(crds, ", "))
+		args := append([]string{"delete", "--kubeconfig=" + KubeconfigPath, "--ignore-not-found", "crd"}, crds...)
+		if out, err := RunCommand(ctx, "kubectl", args...); err != nil {
+			return fmt.Errorf("deleting CRDs: %w: %s", err, out)
+		}
+	}
+
+	if opts.Namespace {
+		shared, err := namespaceShared(conf, spec)
+		if err != nil {
+			return err
+		}
+
+		if shared != "" {
+			log.Printf("Keeping namespace %s, %s is installed in it too\n", spec.Namespace, shared)
+		} else {
+			log.Printf("Deleting namespace %s\n", spec.Namespace)
+			err := k8sClient.CoreV1().Namespaces().Delete(ctx, spec.Namespace, meta.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("deleting namespace %s: %w", spec.Namespace, err)
+			}
+		}
+	}
+
+	return warnStillEnabled(conf, addon)
+}
+
+// withoutNamespaces drops the Namespace objects of manifest. An addon's
+// manifests may name namespaces it shares with applications, deleting them
+// would delete everything in them, so they are only ever deleted by
+// RemoveOptions.Namespace.
+func withoutNamespaces(manifest string) (string, error) {
+	objects, err := manifestObjects(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	var kept []object
+	for _, key := range sortedKeys(objects) {
+		if !strings.HasPrefix(key, "Namespace/") {
+			kept = append(kept, objects[key])
+		}
+	}
+
+	return renderObjects(kept)
+}
+
+// addonInstalled reports whether the addon's release exists, or for addons
+// without a chart whether they're enabled.
+func addonInstalled(conf *Config, addon Addon) (bool, error) {
+	if addon.Spec == nil {
+		return addon.Enabled(conf), nil
+	}
+
+	spec, err := addon.Spec(conf)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := helmClientForNs(spec.Namespace)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.GetRelease(spec.ReleaseName)
+	return err == nil, nil
+}
+
+// chartCRDs lists the names of the CRDs the release's chart renders.
+func chartCRDs(client helmclient.Client, spec *helmclient.ChartSpec) ([]string, error) {
+	rendered, err := client.TemplateChart(spec, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rendering %s: %w", spec.ReleaseName, err)
+	}
+
+	objects, err := manifestObjects(string(rendered))
+	if err != nil {
+		return nil, err
+	}
+
+	var crds []string
+	for _, key := range sortedKeys(objects) {
+		if strings.HasPrefix(key, "CustomResourceDefinition/") {
+			metadata, _ := objects[key]["metadata"].(map[string]interface{})
+			name, _ := metadata["name"].(string)
+			crds = append(crds, name)
+		}
+	}
+
+	return crds, nil
+}
+
+// namespaceShared returns another release of the config installed in the
+// namespace of spec, if there is one.
+func namespaceShared(conf *Config, spec *helmclient.ChartSpec) (string, error) {
+	releases, err := Releases(conf, "")
+	if err != nil {
+		return "", err
+	}
+
+	for _, release := range releases {
+		if release.Spec.Namespace == spec.Namespace && release.Spec.ReleaseName != spec.ReleaseName {
+			return release.Spec.ReleaseName, nil
+		}
+	}
+
+	return "", nil
+}
+
+// warnStillEnabled points out that the next bootstrap or upgrade installs
+// a removed addon again while the config enables it.
+func warnStillEnabled(conf *Config, addon Addon) error {
+	if addon.Enabled(conf) {
+		log.Printf("%s is still enabled in the config and will be installed again by the next upgrade\n", addon.Name)
+	}
+
+	return nil
+}
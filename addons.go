@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Addon is an optional component installed after the core stack when
+// enabled in the orsted config.
+type Addon struct {
+	Name    string
+	Repo    repo.Entry
+	Enabled func(conf *Config) bool
+	// Spec renders the addon's chart. Addons without a chart leave it nil
+	// and install themselves in Prepare.
+	Spec func(conf *Config) (*helmclient.ChartSpec, error)
+	// Manifests optionally renders extra resources that are applied once
+	// the chart is installed. An empty manifest is skipped.
+	Manifests func(conf *Config) (string, error)
+	// Prepare optionally creates what the chart depends on before it is
+	// installed.
+	Prepare func(ctx context.Context, conf *Config) error
+	// Configure optionally sets up the addon through its own API once it
+	// is installed. It has to be safe to run again on upgrades.
+	Configure func(ctx context.Context, conf *Config) error
+	// Requires names the addons this one doesn't work without, which
+	// can't be removed while it is installed.
+	Requires []string
+	// Section is the key of the addon's settings under addons in the
+	// config, its name in camelCase by default.
+	Section string
+}
+
+// Addons are the addons with code of their own, the built-in catalog's
+// are added in front of them. Those of the config's catalogs are added by
+// configAddons.
+var Addons = []Addon{
+	{
+		Name:      "dex",
+		Repo:      dexRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Dex.Enabled },
+		Spec:      dexSpec,
+		Manifests: gitopsOIDCManifest,
+	},
+	{
+		Name:      "keycloak",
+		Repo:      bitnamiRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Keycloak.Enabled },
+		Spec:      keycloakSpec,
+		Manifests: gitopsOIDCManifest,
+	},
+	{
+		Name:      "vault",
+		Repo:      hashicorpRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Vault.Enabled },
+		Spec:      vaultSpec,
+		Configure: configureVault,
+	},
+	{
+		Name:      "external-secrets",
+		Repo:      externalSecretsRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.ExternalSecrets.Enabled },
+		Spec:      externalSecretsSpec,
+		Manifests: externalSecretsManifests,
+		Configure: configureExternalSecrets,
+	},
+	{
+		Name:      "harbor",
+		Repo:      harborRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Harbor.Enabled },
+		Spec:      harborSpec,
+		Prepare:   prepareHarbor,
+		Configure: configureHarbor,
+	},
+	{
+		Name:    "minio",
+		Repo:    minioRepo,
+		Enabled: func(conf *Config) bool { return conf.Addons.MinIO.Enabled },
+		Spec:    minioSpec,
+		Prepare: prepareMinIO,
+	},
+	{
+		Name: "flux",
+		Repo: fluxRepo,
+		Enabled: func(conf *Config) bool {
+			return conf.Addons.Flux.Enabled || conf.Addons.Gitea.Enabled || tenantsUseGitOps(conf)
+		},
+		Spec: fluxSpec,
+	},
+	{
+		Name:      "gitea",
+		Repo:      giteaRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Gitea.Enabled },
+		Spec:      giteaSpec,
+		Prepare:   prepareGitea,
+		Manifests: giteaManifests,
+		Configure: configureGitea,
+		Requires:  []string{"flux"},
+	},
+	{
+		Name:      "tailscale",
+		Repo:      tailscaleRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Tailscale.Enabled },
+		Spec:      tailscaleSpec,
+		Configure: configureTailscale,
+	},
+	{
+		Name:      "cloudflared",
+		Repo:      cloudflareRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Cloudflared.Enabled },
+		Spec:      cloudflaredSpec,
+		Configure: configureCloudflared,
+	},
+	{
+		Name:      "cert-manager",
+		Repo:      jetstackRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.CertManager.Enabled },
+		Spec:      certManagerSpec,
+		Manifests: certManagerManifests,
+	},
+	{
+		Name:      "multus",
+		Enabled:   func(conf *Config) bool { return conf.Addons.MultusEnabled() },
+		Prepare:   installMultus,
+		Manifests: multusManifests,
+	},
+	{
+		Name:      "sriov",
+		Enabled:   func(conf *Config) bool { return conf.Addons.SRIOV.Enabled },
+		Prepare:   prepareSRIOV,
+		Manifests: sriovManifests,
+		Requires:  []string{"multus"},
+	},
+	{
+		Name:    "linkerd-crds",
+		Repo:    linkerdRepo,
+		Enabled: func(conf *Config) bool { return conf.Addons.Linkerd.Enabled },
+		Spec:    linkerdCRDsSpec,
+		Section: "linkerd",
+	},
+	{
+		Name:      "linkerd",
+		Repo:      linkerdRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.Linkerd.Enabled },
+		Spec:      linkerdSpec,
+		Configure: injectLinkerd,
+		Requires:  []string{"linkerd-crds"},
+	},
+	{
+		Name:      "cluster-autoscaler",
+		Repo:      autoscalerRepo,
+		Enabled:   func(conf *Config) bool { return conf.Addons.ClusterAutoscaler.Enabled },
+		Spec:      clusterAutoscalerSpec,
+		Prepare:   prepareClusterAutoscaler,
+		Manifests: clusterAutoscalerManifests,
+	},
+}
+
+// renderValues executes an embedded values or manifest template against the
+// addon's config section.
+func renderValues(name string, values string, data any) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(values)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+func InstallAddons(ctx context.Context, conf *Config) error {
+	for _, addon := range configAddons(conf) {
+		if !addon.Enabled(conf) {
+			continue
+		}
+
+		if err := InstallAddon(ctx, conf, addon); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InstallAddon installs or upgrades a single addon, whether the config
+// enables it or not.
+func InstallAddon(ctx context.Context, conf *Config, addon Addon) error {
+	if addon.Prepare != nil {
+		if err := addon.Prepare(ctx, conf); err != nil {
+			return failed(addon.Name, fmt.Errorf("preparing: %w", err))
+		}
+	}
+
+	if addon.Spec != nil {
+		if err := installAddonChart(ctx, conf, addon); err != nil {
+			return err
+		}
+	}
+
+	return finishAddon(ctx, conf, addon)
+}
+
+func installAddonChart(ctx context.Context, conf *Config, addon Addon) error {
+	spec, err := addon.Spec(conf)
+	if err != nil {
+		return failedWith(ExitConfig, addon.Name, fmt.Errorf("rendering values: %w", err))
+	}
+
+	client, err := helmClientForNs(spec.Namespace)
+	if err != nil {
+		return failed(addon.Name, fmt.Errorf("creating the helm client: %w", err))
+	}
+
+	if err := addChartRepo(conf, client, addon.Repo, spec); err != nil {
+		return failed(addon.Name, fmt.Errorf("adding the chart repo: %w", err))
+	}
+
+	log.Printf("Deploying %s\n", addon.Name)
+	if err := InstallOrUpgradeSpec(ctx, conf, client, spec); err != nil {
+		return installFailed(addon.Name, err)
+	}
+
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return failed(addon.Name, err)
+	}
+	if err := verifyRelease(ctx, k8sClient, client, spec); err != nil {
+		return installFailed(addon.Name, err)
+	}
+
+	return nil
+}
+
+// finishAddon applies the extra manifests of an installed addon and runs
+// its configuration step.
+func finishAddon(ctx context.Context, conf *Config, addon Addon) error {
+	if addon.Manifests != nil {
+		manifest, err := addon.Manifests(conf)
+		if err != nil {
+			return failedWith(ExitConfig, addon.Name, fmt.Errorf("rendering manifests: %w", err))
+		}
+
+		if manifest != "" {
+			applyOut, err := ApplyManifest(ctx, "addons", manifest)
+			if err != nil {
+				return commandFailed(addon.Name, applyOut, fmt.Errorf("applying manifests: %w", err))
+			}
+		}
+	}
+
+	if addon.Configure != nil {
+		log.Printf("Configuring %s\n", addon.Name)
+		if err := addon.Configure(ctx, conf); err != nil {
+			return failed(addon.Name, fmt.Errorf("configuring: %w", err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// AddonStatePath records the addons enabled or disabled on the cluster with
+// `orsted addon enable/disable`, as {section: {enabled: bool}} overriding
+// the addons section of the config.
+const AddonStatePath = "/var/lib/orsted/addons.yaml"
+
+func (a Addon) section() string {
+	if a.Section != "" {
+		return a.Section
+	}
+
+	words := strings.Split(a.Name, "-")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+
+	return strings.Join(words, "")
+}
+
+func readAddonState() (map[string]map[string]bool, error) {
+	state := map[string]map[string]bool{}
+
+	data, err := os.ReadFile(AddonStatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return state, yaml.UnmarshalStrict(data, &state)
+}
+
+// applyAddonState overrides the addons the config enables with those
+// toggled on the cluster since.
+func applyAddonState(conf *Config) error {
+	state, err := readAddonState()
+	if err != nil || len(state) == 0 {
+		return err
+	}
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := yaml.UnmarshalStrict(data, &conf.Addons); err != nil {
+		return fmt.Errorf("%s: %w", AddonStatePath, err)
+	}
+
+	return nil
+}
+
+func writeAddonState(addon Addon, enabled bool) error {
+	state, err := readAddonState()
+	if err != nil {
+		return err
+	}
+	state[addon.section()] = map[string]bool{"enabled": enabled}
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(AddonStatePath), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(AddonStatePath, data, 0644)
+}
+
+// setAddonEnabled enables or disables the addon in conf, as the config
+// would.
+func setAddonEnabled(conf *Config, addon Addon, enabled bool) error {
+	data, err := yaml.Marshal(map[string]map[string]bool{addon.section(): {"enabled": enabled}})
+	if err != nil {
+		return err
+	}
+
+	return yaml.UnmarshalStrict(data, &conf.Addons)
+}
+
+// EnableAddon installs an addon on the cluster with the versions and values
+// of the config, along with the addons it requires, and keeps it enabled
+// for later upgrades.
+func EnableAddon(ctx context.Context, conf *Config, name string) error {
+	addon, err := toggledAddon(conf, name)
+	if err != nil {
+		return err
+	}
+
+	if err := setAddonEnabled(conf, addon, true); err != nil {
+		return err
+	}
+
+	for _, required := range addon.Requires {
+		dependency, _ := findAddon(conf, required)
+		installed, err := addonInstalled(conf, dependency)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			log.Printf("Installing %s, which %s requires\n", required, name)
+			if err := InstallAddon(ctx, conf, dependency); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := InstallAddon(ctx, conf, addon); err != nil {
+		return err
+	}
+
+	return writeAddonState(addon, true)
+}
+
+// DisableAddon uninstalls an addon like RemoveAddon, keeping its CRDs and
+// namespace and with them its data, and keeps it disabled for later
+// upgrades.
+func DisableAddon(ctx context.Context, conf *Config, name string) error {
+	addon, err := toggledAddon(conf, name)
+	if err != nil {
+		return err
+	}
+
+	if err := setAddonEnabled(conf, addon, false); err != nil {
+		return err
+	}
+
+	if err := RemoveAddon(ctx, conf, name, RemoveOptions{}); err != nil {
+		return err
+	}
+
+	return writeAddonState(addon, false)
+}
+
+func toggledAddon(conf *Config, name string) (Addon, error) {
+	addon, ok := findAddon(conf, name)
+	if !ok {
+		return Addon{}, unknownAddon(conf, name)
+	}
+	if _, _, ok := typedAddonSection(conf, addon.section()); !ok {
+		return Addon{}, fmt.Errorf("%s comes from a catalog of the config, enable or disable it under addons.custom there", name)
+	}
+
+	return addon, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WaitForAPI waits up to kubernetes.apiTimeout for the control plane pods
+// to be ready, the rest of kube-system only starting with Cilium. When
+// they aren't, the failure carries the state of the kubelet and the
+// control plane containers.
+func WaitForAPI(ctx context.Context, conf *Config, k8sClient *kubernetes.Clientset) error {
+	timeout, err := time.ParseDuration(conf.Kubernetes.APITimeout)
+	if err != nil {
+		return failedWith(ExitConfig, "kubernetes", fmt.Errorf("invalid apiTimeout: %w", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		pods, err := k8sClient.CoreV1().Pods("kube-system").List(ctx, meta.ListOptions{LabelSelector: "tier=control-plane"})
+		switch {
+		case err != nil:
+			log.Printf("Kubernetes not yet ready: %s\n", err)
+		case len(pods.Items) == 0:
+			log.Println("Kubernetes not yet ready: no control plane pods yet")
+		default:
+			ready, err := podsReady(pods.Items)
+			if err != nil {
+				return commandFailed("kubernetes", controlPlaneDiagnostics(), err)
+			}
+			if ready {
+				log.Println("Kubernetes ready")
+				return nil
+			}
+			log.Println("Kubernetes not yet ready: the control plane pods aren't ready")
+		}
+
+		select {
+		case <-ctx.Done():
+			err := fmt.Errorf("control plane not ready after %s", timeout)
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = ctx.Err()
+			}
+			return commandFailed("kubernetes", controlPlaneDiagnostics(), err)
+		case <-time.After(time.Second * 10):
+		}
+	}
+}
+
+// controlPlaneDiagnostics collects what shows why the control plane isn't
+// coming up: the kubelet's status and journal, the control plane
+// containers and the API server's logs. With --dev-kind they are taken
+// from the kind node.
+func controlPlaneDiagnostics() string {
+	// The bootstrap's context may be what ran out.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	commands := [][]string{
+		{"systemctl", "status", "kubelet", "--no-pager"},
+		{"journalctl", "-u", "kubelet", "-n", "50", "--no-pager"},
+		{"crictl", "ps", "-a", "--label", "io.kubernetes.pod.namespace=kube-system"},
+		{"sh", "-c", "crictl logs --tail 50 $(crictl ps -a -q --name kube-apiserver | head -n 1)"},
+	}
+
+	var out strings.Builder
+	for _, command := range commands {
+		if devKind {
+			command = append([]string{"docker", "exec", kindAPIHost}, command...)
+		}
+
+		fmt.Fprintf(&out, "$ %s\n", strings.Join(command, " "))
+		output, err := RunCommand(ctx, command[0], command[1:]...)
+		out.WriteString(output)
+		if err != nil {
+			fmt.Fprintf(&out, "(%s)\n", err)
+		}
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+var autoscalerRepo = repo.Entry{
+	Name: "autoscaler",
+	URL:  "https://kubernetes.github.io/autoscaler",
+}
+
+// instanceMetadata is what the cluster autoscaler needs to know of the
+// node from the metadata service of its provider.
+type instanceMetadata struct {
+	Region  string
+	Zone    string
+	Project string
+}
+
+// metadataGet reads url of a metadata service, sending header with it.
+func metadataGet(url string, header map[string]string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	client := http.Client{Timeout: time.Second * 5}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	return strings.TrimSpace(string(data)), err
+}
+
+// fetchInstanceMetadata asks the metadata service of provider where the
+// node runs.
+func fetchInstanceMetadata(provider string) (instanceMetadata, error) {
+	var meta instanceMetadata
+	switch provider {
+	case "aws":
+		// IMDSv2 wants a session token first.
+		req, err := http.NewRequest(http.MethodPut, "http://169.254.169.254/latest/api/token", nil)
+		if err != nil {
+			return meta, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+		client := http.Client{Timeout: time.Second * 5}
+		resp, err := client.Do(req)
+		if err != nil {
+			return meta, err
+		}
+		token, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return meta, err
+		}
+
+		header := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+		if meta.Region, err = metadataGet("http://169.254.169.254/latest/meta-data/placement/region", header); err != nil {
+			return meta, err
+		}
+		meta.Zone, err = metadataGet("http://169.254.169.254/latest/meta-data/placement/availability-zone", header)
+		return meta, err
+	case "gcp":
+		header := map[string]string{"Metadata-Flavor": "Google"}
+		var err error
+		if meta.Project, err = metadataGet("http://metadata.google.internal/computeMetadata/v1/project/project-id", header); err != nil {
+			return meta, err
+		}
+		// The zone comes as projects/<number>/zones/<zone>.
+		zone, err := metadataGet("http://metadata.google.internal/computeMetadata/v1/instance/zone", header)
+		meta.Zone = zone[strings.LastIndex(zone, "/")+1:]
+		return meta, err
+	case "hetzner":
+		// Availability zones are <location>-dc<n>, e.g. fsn1-dc14.
+		zone, err := metadataGet("http://169.254.169.254/hetzner/v1/metadata/availability-zone", nil)
+		meta.Zone = zone
+		meta.Region, _, _ = strings.Cut(zone, "-")
+		return meta, err
+	}
+
+	return meta, fmt.Errorf("the cluster autoscaler supports aws, gcp and hetzner, not %q", provider)
+}
+
+// autoscalerMetadata is the metadata of the node with the settings of the
+// config merged over it, only asking the metadata service when one is
+// missing.
+func autoscalerMetadata(provider string, autoscaler ClusterAutoscalerConfig) (instanceMetadata, error) {
+	meta := instanceMetadata{Region: autoscaler.Region, Zone: autoscaler.Zone, Project: autoscaler.Project}
+
+	var missing bool
+	switch provider {
+	case "aws", "hetzner":
+		missing = meta.Region == ""
+	case "gcp":
+		missing = meta.Zone == "" || meta.Project == ""
+	}
+	if !missing {
+		return meta, nil
+	}
+
+	fetched, err := fetchInstanceMetadata(provider)
+	if err != nil {
+		return meta, fmt.Errorf("reading the instance metadata, set the region, zone and project instead: %w", err)
+	}
+	if meta.Region == "" {
+		meta.Region = fetched.Region
+	}
+	if meta.Zone == "" {
+		meta.Zone = fetched.Zone
+	}
+	if meta.Project == "" {
+		meta.Project = fetched.Project
+	}
+
+	return meta, nil
+}
+
+func clusterAutoscalerSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	autoscaler := conf.Addons.ClusterAutoscaler
+	provider := cloudName(conf)
+	if _, ok := cloudEnabled(conf); !ok || provider == "proxmox" {
+		return nil, fmt.Errorf("the cluster autoscaler needs cloud.controllerManager on aws, gcp or hetzner")
+	}
+
+	meta, err := autoscalerMetadata(provider, autoscaler)
+	if err != nil {
+		return nil, err
+	}
+
+	values := object{
+		"fullnameOverride":  "cluster-autoscaler",
+		"priorityClassName": platformClass,
+		"extraArgs": object{
+			"expander":                      autoscaler.Expander,
+			"scale-down-unneeded-time":      autoscaler.ScaleDownUnneededTime,
+			"balance-similar-node-groups":   true,
+			"skip-nodes-with-local-storage": false,
+		},
+		"tolerations": []object{{
+			"key":      "node-role.kubernetes.io/control-plane",
+			"operator": "Exists",
+			"effect":   "NoSchedule",
+		}},
+	}
+
+	var groups []object
+	switch provider {
+	case "aws":
+		values["cloudProvider"] = "aws"
+		values["awsRegion"] = meta.Region
+		if len(autoscaler.NodeGroups) == 0 {
+			values["autoDiscovery"] = object{"clusterName": autoscaler.ClusterName}
+		}
+		for _, group := range autoscaler.NodeGroups {
+			groups = append(groups, object{"name": group.Name, "minSize": group.Min, "maxSize": group.Max})
+		}
+	case "gcp":
+		values["cloudProvider"] = "gce"
+		for _, group := range autoscaler.NodeGroups {
+			groups = append(groups, object{
+				"name":    fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instanceGroups/%s", meta.Project, meta.Zone, group.Name),
+				"minSize": group.Min,
+				"maxSize": group.Max,
+			})
+		}
+	case "hetzner":
+		values["cloudProvider"] = "hetzner"
+		values["extraEnvSecrets"] = object{
+			"HCLOUD_TOKEN":      object{"name": "hcloud", "key": "token"},
+			"HCLOUD_CLOUD_INIT": object{"name": "cluster-autoscaler-hetzner", "key": "cloud-init"},
+		}
+		if network := conf.Cloud.Hetzner.Network; network != "" {
+			values["extraEnv"] = object{"HCLOUD_NETWORK": network}
+		}
+		for _, group := range autoscaler.NodeGroups {
+			location := group.Location
+			if location == "" {
+				location = meta.Region
+			}
+			groups = append(groups, object{
+				"name":         group.Name,
+				"minSize":      group.Min,
+				"maxSize":      group.Max,
+				"instanceType": group.InstanceType,
+				"region":       location,
+			})
+		}
+	}
+	if len(groups) > 0 {
+		values["autoscalingGroups"] = groups
+	}
+
+	valuesYaml, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName: "cluster-autoscaler",
+		ChartName:   "autoscaler/cluster-autoscaler",
+		Namespace:   "kube-system",
+		Wait:        true,
+		Timeout:     time.Minute * 5,
+		Version:     autoscaler.Version,
+		ValuesYaml:  string(valuesYaml),
+	}, nil
+}
+
+// clusterAutoscalerManifests renders the cloud-init new Hetzner servers
+// join with, which the autoscaler reads base64 encoded. The other
+// providers boot new nodes from the launch template of their group.
+func clusterAutoscalerManifests(conf *Config) (string, error) {
+	if cloudName(conf) != "hetzner" {
+		return "", nil
+	}
+
+	path := conf.Addons.ClusterAutoscaler.JoinCloudInit
+	if path == "" {
+		return "", fmt.Errorf("the cluster autoscaler needs a joinCloudInit file on hetzner")
+	}
+	cloudInit, err := readConfigFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return renderObjects([]object{secretObject("cluster-autoscaler-hetzner", "kube-system", map[string]string{
+		"cloud-init": base64.StdEncoding.EncodeToString(cloudInit),
+	})})
+}
+
+// prepareClusterAutoscaler applies its manifests before the chart as well,
+// the autoscaler doesn't start without the cloud-init secret.
+func prepareClusterAutoscaler(ctx context.Context, conf *Config) error {
+	manifest, err := clusterAutoscalerManifests(conf)
+	if err != nil || manifest == "" {
+		return err
+	}
+
+	out, err := ApplyManifest(ctx, "addons", manifest)
+	if err != nil {
+		return fmt.Errorf("applying the cloud-init secret: %w: %s", err, out)
+	}
+
+	return nil
+}
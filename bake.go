@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+)
+
+// VendoredChartsDir keeps the chart archives bake downloads, which
+// bootstrap installs from instead of the chart repos.
+const VendoredChartsDir = "/var/lib/orsted/charts"
+
+// Bake prepares an image for bootstrapping on its first boot: it installs
+// the packages, pulls the images of kubeadm and of every release, saves
+// the charts and enables the first boot unit. Nothing is initialized, the
+// config only has to be at configPath by the time the machine boots.
+func Bake(ctx context.Context, conf *Config, configPath string) error {
+	if err := ProvisionHost(ctx, conf); err != nil {
+		return err
+	}
+
+	log.Println("Starting Cri-o to pull images into")
+	if out, err := RunCommand(ctx, "systemctl", "enable", "--now", "crio"); err != nil {
+		return commandFailed("cri-o", out, fmt.Errorf("starting: %w", err))
+	}
+
+	if err := pullKubeadmImages(ctx); err != nil {
+		return err
+	}
+
+	releases, err := Releases(conf, "")
+	if err != nil {
+		return failedWith(ExitConfig, "releases", fmt.Errorf("rendering: %w", err))
+	}
+
+	if err := pullReleaseImages(ctx, conf); err != nil {
+		return err
+	}
+
+	for _, release := range releases {
+		if err := vendorChart(release); err != nil {
+			return failed(release.Spec.ReleaseName, fmt.Errorf("saving the chart: %w", err))
+		}
+	}
+
+	if err := InstallService(ctx, configPath, DefaultServiceOptions); err != nil {
+		return failed("firstboot", err)
+	}
+
+	log.Println("Baked, the cluster is bootstrapped on first boot")
+	return nil
+}
+
+// pullKubeadmImages pulls the control plane images of the installed
+// kubeadm, which provisioning made the configured version.
+func pullKubeadmImages(ctx context.Context) error {
+	kubeadm, err := commandVersion(ctx, "kubeadm", "version", "-o", "short")
+	if err != nil {
+		return failed("kubeadm", fmt.Errorf("getting the version: %w", err))
+	}
+
+	log.Printf("Pulling the kubeadm images of v%s\n", kubeadm)
+	out, err := RunCommand(ctx, "kubeadm", "config", "images", "pull", "--kubernetes-version", "v"+kubeadm.String())
+	if err != nil {
+		return commandFailed("kubeadm", out, fmt.Errorf("pulling images: %w", err))
+	}
+
+	return nil
+}
+
+// pullReleaseImages pulls every image the releases deploy, as rewritten
+// for digest pinning and mirrors.
+func pullReleaseImages(ctx context.Context, conf *Config) error {
+	images, err := ReleaseImages(conf)
+	if err != nil {
+		return failed("images", fmt.Errorf("listing: %w", err))
+	}
+
+	var rewriter *ImageRewriter
+	if conf.Images.PinDigests || len(conf.Images.Mirrors) > 0 {
+		if rewriter, err = NewImageRewriter(conf.Images); err != nil {
+			return failedWith(ExitConfig, "images", err)
+		}
+	}
+
+	pulled := map[string]bool{}
+	for release, list := range images {
+		for _, image := range list {
+			if rewriter != nil {
+				if image, err = rewriter.Image(image); err != nil {
+					return failed(release, fmt.Errorf("rewriting %s: %w", image, err))
+				}
+			}
+			if pulled[image] {
+				continue
+			}
+			pulled[image] = true
+
+			log.Printf("Pulling %s\n", image)
+			if out, err := RunCommand(ctx, "crictl", "pull", image); err != nil {
+				return commandFailed(release, out, fmt.Errorf("pulling %s: %w", image, err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// vendorChart saves the chart archive of release to VendoredChartsDir,
+// named after the release.
+func vendorChart(release Release) error {
+	spec := release.Spec
+	client, err := templateClient(spec.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := client.AddOrUpdateChartRepo(release.Repo); err != nil {
+		return fmt.Errorf("adding the %s chart repo: %w", release.Repo.Name, err)
+	}
+
+	settings := cli.New()
+	settings.RepositoryCache = helmRepositoryCache
+	settings.RepositoryConfig = helmRepositoryConfig
+	dl := downloader.ChartDownloader{
+		Out:              io.Discard,
+		Getters:          getter.All(settings),
+		RepositoryConfig: settings.RepositoryConfig,
+		RepositoryCache:  settings.RepositoryCache,
+	}
+
+	if err := os.MkdirAll(VendoredChartsDir, 0755); err != nil {
+		return err
+	}
+
+	log.Printf("Saving the %s chart\n", spec.ChartName)
+	saved, _, err := dl.DownloadTo(spec.ChartName, spec.Version, VendoredChartsDir)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(saved, filepath.Join(VendoredChartsDir, spec.ReleaseName+".tgz"))
+}
+
+// withVendoredChart returns spec installing the chart bake saved for it,
+// when there is one of the version spec asks for.
+func withVendoredChart(spec *helmclient.ChartSpec) *helmclient.ChartSpec {
+	path := filepath.Join(VendoredChartsDir, spec.ReleaseName+".tgz")
+	chart, err := loader.Load(path)
+	if err != nil {
+		return spec
+	}
+	if spec.Version != "" && !versionMatches(spec.Version, chart.Metadata.Version) {
+		return spec
+	}
+
+	vendored := *spec
+	vendored.ChartName = path
+	vendored.Version = ""
+	return &vendored
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// benchImage runs iperf3 as its entrypoint.
+const benchImage = "docker.io/networkstatic/iperf3:latest"
+
+const (
+	benchNamespace = "kube-system"
+	benchLabel     = "orsted-bench"
+
+	// The server on the host network listens on a port of its own, iperf3
+	// may already be running on the node.
+	benchPodPort  = 5201
+	benchHostPort = 5202
+
+	benchServerTimeout = time.Minute * 3
+)
+
+// NetworkBenchmark is the result of one iperf3 run of bench network.
+type NetworkBenchmark struct {
+	Test          string    `json:"test"`
+	ClientNode    string    `json:"clientNode"`
+	ServerNode    string    `json:"serverNode"`
+	Encryption    string    `json:"encryption,omitempty"`
+	Seconds       float64   `json:"seconds"`
+	BitsPerSecond float64   `json:"bitsPerSecond"`
+	Retransmits   int       `json:"retransmits"`
+	MeanRTTMicros int       `json:"meanRTTMicros"`
+	At            time.Time `json:"at"`
+}
+
+// iperfResult is the part of the JSON output of the iperf3 client read.
+type iperfResult struct {
+	End struct {
+		Streams []struct {
+			Sender struct {
+				MeanRTT int `json:"mean_rtt"`
+			} `json:"sender"`
+		} `json:"streams"`
+		SumSent struct {
+			Retransmits int `json:"retransmits"`
+		} `json:"sum_sent"`
+		SumReceived struct {
+			Seconds       float64 `json:"seconds"`
+			BitsPerSecond float64 `json:"bits_per_second"`
+		} `json:"sum_received"`
+	} `json:"end"`
+	Error string `json:"error"`
+}
+
+// ciliumEncryption is the transparent encryption Cilium runs with, as the
+// agent's ConfigMap has it.
+func ciliumEncryption(ctx context.Context, k8sClient *kubernetes.Clientset) (string, error) {
+	cm, err := k8sClient.CoreV1().ConfigMaps("kube-system").Get(ctx, "cilium-config", meta.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case cm.Data["enable-wireguard"] == "true":
+		return "wireguard", nil
+	case cm.Data["enable-ipsec"] == "true":
+		return "ipsec", nil
+	}
+
+	return "", nil
+}
+
+// benchPod is a pod of the benchmark, preferring nodes that run none of
+// its other pods so traffic crosses the network when there is more than
+// one node.
+func benchPod(name string, image string, role string, command []string) *core.Pod {
+	return &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      name,
+			Namespace: benchNamespace,
+			Labels:    map[string]string{"app.kubernetes.io/name": benchLabel, "app.kubernetes.io/component": role},
+		},
+		Spec: core.PodSpec{
+			RestartPolicy: core.RestartPolicyNever,
+			Tolerations:   []core.Toleration{{Operator: core.TolerationOpExists}},
+			Affinity: &core.Affinity{PodAntiAffinity: &core.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []core.WeightedPodAffinityTerm{{
+					Weight: 100,
+					PodAffinityTerm: core.PodAffinityTerm{
+						LabelSelector: &meta.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": benchLabel}},
+						TopologyKey:   "kubernetes.io/hostname",
+					},
+				}},
+			}},
+			Containers: []core.Container{{
+				Name:    "iperf3",
+				Image:   image,
+				Command: command,
+			}},
+		},
+	}
+}
+
+// startBenchServer runs an iperf3 server on port, returning its pod once
+// it accepts connections. The caller deletes it.
+func startBenchServer(ctx context.Context, k8sClient *kubernetes.Clientset, name string, image string, port int, hostNetwork bool) (*core.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, benchServerTimeout)
+	defer cancel()
+
+	pod := benchPod(name, image, "server", []string{"iperf3", "--server", "--port", strconv.Itoa(port)})
+	pod.Spec.HostNetwork = hostNetwork
+	pod.Spec.Containers[0].ReadinessProbe = &core.Probe{
+		ProbeHandler:  core.ProbeHandler{TCPSocket: &core.TCPSocketAction{Port: intstr.FromInt(port)}},
+		PeriodSeconds: 2,
+	}
+
+	pods := k8sClient.CoreV1().Pods(benchNamespace)
+	// A benchmark interrupted before cleaning up leaves its servers.
+	pods.Delete(ctx, name, meta.DeleteOptions{})
+	for {
+		if _, err := pods.Get(ctx, name, meta.GetOptions{}); err != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second * 2):
+		}
+	}
+
+	pod, err := pods.Create(ctx, pod, meta.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for !podHasCondition(*pod, core.PodReady) {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("iperf3 server %s not ready: %w", name, ctx.Err())
+		case <-time.After(time.Second * 2):
+		}
+
+		if pod, err = pods.Get(ctx, name, meta.GetOptions{}); err != nil {
+			return nil, err
+		}
+	}
+
+	return pod, nil
+}
+
+// BenchNetwork measures the TCP throughput and round trip time from a pod
+// to a pod, and from a pod to a server on the host network of a node, for
+// validating the Cilium datapath, and its encryption overhead, on the
+// hardware at hand.
+func BenchNetwork(ctx context.Context, k8sClient *kubernetes.Clientset, conf *Config, duration time.Duration) ([]NetworkBenchmark, error) {
+	image := mirrorImage(conf.Images.Mirrors, benchImage)
+
+	encryption, err := ciliumEncryption(ctx, k8sClient)
+	if err != nil {
+		return nil, fmt.Errorf("reading the Cilium config: %w", err)
+	}
+
+	pods := k8sClient.CoreV1().Pods(benchNamespace)
+	var results []NetworkBenchmark
+	for _, test := range []struct {
+		name        string
+		port        int
+		hostNetwork bool
+	}{
+		{"pod-to-pod", benchPodPort, false},
+		{"pod-to-node", benchHostPort, true},
+	} {
+		name := benchLabel + "-" + test.name
+		log.Printf("Starting the iperf3 server of %s\n", test.name)
+		server, err := startBenchServer(ctx, k8sClient, name+"-server", image, test.port, test.hostNetwork)
+		if err != nil {
+			return nil, fmt.Errorf("starting the iperf3 server: %w", err)
+		}
+		defer pods.Delete(context.Background(), server.Name, meta.DeleteOptions{})
+
+		log.Printf("Measuring %s for %s\n", test.name, duration)
+		client := benchPod(name+"-client", image, "client", []string{
+			"iperf3", "--client", server.Status.PodIP, "--port", strconv.Itoa(test.port),
+			"--time", strconv.Itoa(int(duration.Seconds())), "--json",
+		})
+		client.Name, client.GenerateName = "", name+"-client-"
+		pod, logs, err := runPod(ctx, k8sClient, client, duration+time.Minute*2)
+		if err != nil {
+			return nil, fmt.Errorf("running the iperf3 client: %w", err)
+		}
+
+		var result iperfResult
+		if err := json.Unmarshal([]byte(logs), &result); err != nil {
+			return nil, fmt.Errorf("reading the iperf3 results of %s: %w: %s", test.name, err, logs)
+		}
+		if result.Error != "" || pod.Status.Phase == core.PodFailed {
+			return nil, fmt.Errorf("iperf3 %s failed: %s", test.name, result.Error)
+		}
+
+		benchmark := NetworkBenchmark{
+			Test:          test.name,
+			ClientNode:    pod.Spec.NodeName,
+			ServerNode:    server.Spec.NodeName,
+			Encryption:    encryption,
+			Seconds:       result.End.SumReceived.Seconds,
+			BitsPerSecond: result.End.SumReceived.BitsPerSecond,
+			Retransmits:   result.End.SumSent.Retransmits,
+			At:            time.Now(),
+		}
+		if len(result.End.Streams) > 0 {
+			benchmark.MeanRTTMicros = result.End.Streams[0].Sender.MeanRTT
+		}
+		results = append(results, benchmark)
+	}
+
+	return results, nil
+}
+
+// RecordNetworkBenchmarks replaces the benchmark results of the bootstrap
+// report at path with results.
+func RecordNetworkBenchmarks(path string, results []NetworkBenchmark) error {
+	report, err := ReadReport(path)
+	if err != nil {
+		return err
+	}
+
+	report.NetworkBenchmarks = results
+	return report.Write(path)
+}
+
+// printNetworkBenchmarks writes results as a table.
+func printNetworkBenchmarks(w io.Writer, results []NetworkBenchmark) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TEST\tFROM\tTO\tENCRYPTION\tTHROUGHPUT\tRETRANSMITS\tRTT")
+	for _, result := range results {
+		encryption := result.Encryption
+		if encryption == "" {
+			encryption = "none"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%.2f Gbit/s\t%d\t%s\n", result.Test, result.ClientNode, result.ServerNode, encryption,
+			result.BitsPerSecond/1e9, result.Retransmits, time.Duration(result.MeanRTTMicros)*time.Microsecond)
+	}
+
+	return table.Flush()
+}
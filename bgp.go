@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// peerCIDR turns a peer address into the single host prefix Cilium expects.
+func peerCIDR(address string) string {
+	if strings.Contains(address, "/") {
+		return address
+	}
+
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return address + "/128"
+	}
+
+	return address + "/32"
+}
+
+// bgpManifest renders a CiliumBGPPeeringPolicy peering every node with the
+// configured routers. The addresses of LoadBalancer services are announced
+// when there are pools to allocate them from.
+func bgpManifest(conf *Config) (string, error) {
+	bgp := conf.Network.BGP
+
+	var neighbors []object
+	for _, peer := range bgp.Peers {
+		neighbors = append(neighbors, object{
+			"peerAddress": peerCIDR(peer.Address),
+			"peerASN":     peer.ASN,
+		})
+	}
+
+	router := object{
+		"localASN":      bgp.LocalASN,
+		"exportPodCIDR": bgp.ExportPodCIDR,
+		"neighbors":     neighbors,
+	}
+
+	if len(conf.Network.LoadBalancer.Pools) > 0 {
+		router["serviceSelector"] = allServices
+	}
+
+	return renderObjects([]object{{
+		"apiVersion": "cilium.io/v2alpha1",
+		"kind":       "CiliumBGPPeeringPolicy",
+		"metadata":   object{"name": "orsted"},
+		"spec": object{
+			"nodeSelector":   object{"matchLabels": object{"kubernetes.io/os": "linux"}},
+			"virtualRouters": []object{router},
+		},
+	}})
+}
+
+// ApplyBGP announces LoadBalancer and pod routes to the configured peers.
+func ApplyBGP(ctx context.Context, conf *Config) error {
+	if !conf.Network.BGP.Enabled {
+		return nil
+	}
+
+	if err := waitForCiliumCRD(ctx, "ciliumbgppeeringpolicies.cilium.io"); err != nil {
+		return err
+	}
+
+	manifest, err := bgpManifest(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "bgp", fmt.Errorf("rendering the peering policy: %w", err))
+	}
+
+	log.Println("Configuring BGP peering")
+	bgpOut, err := ApplyManifest(ctx, "cilium", manifest)
+	if err != nil {
+		return commandFailed("bgp", bgpOut, fmt.Errorf("applying the peering policy: %w", err))
+	}
+
+	return nil
+}
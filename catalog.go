@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+//go:embed catalog/builtin.yaml values/*.yaml manifests/*.yaml
+var builtinCatalogFS embed.FS
+
+const builtinCatalog = "catalog/builtin.yaml"
+
+// AddonCatalog lists addons installed from a chart alone, without code of
+// their own, so addons can be added or changed without a new orsted. The
+// built-in catalog is catalog/builtin.yaml, more are loaded from the
+// catalog sources of the config.
+type AddonCatalog struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Addons     []CatalogAddon `json:"addons"`
+}
+
+// CatalogAddon is an addon of a catalog. Version is a semver constraint,
+// an exact version or a range like ~2.9 or ">=1.2 <2", Helm installs the
+// newest chart matching it. The version of the addon's config section wins
+// over it.
+//
+// Values and Manifests are templates executed against the addon's config
+// section, the typed one of a built-in addon or its entry under
+// addons.custom, or read from ValuesFile and ManifestsFile relative to the
+// catalog. Health lists workloads to wait for besides those of the
+// release, and Requires the addons it doesn't work without.
+type CatalogAddon struct {
+	Name            string        `json:"name"`
+	Repo            CatalogRepo   `json:"repo"`
+	Chart           string        `json:"chart"`
+	Version         string        `json:"version,omitempty"`
+	Namespace       string        `json:"namespace"`
+	CreateNamespace bool          `json:"createNamespace,omitempty"`
+	UpgradeCRDs     bool          `json:"upgradeCRDs,omitempty"`
+	Timeout         string        `json:"timeout,omitempty"`
+	Values          string        `json:"values,omitempty"`
+	ValuesFile      string        `json:"valuesFile,omitempty"`
+	Manifests       string        `json:"manifests,omitempty"`
+	ManifestsFile   string        `json:"manifestsFile,omitempty"`
+	Health          []HealthCheck `json:"health,omitempty"`
+	Requires        []string      `json:"requires,omitempty"`
+	Section         string        `json:"section,omitempty"`
+}
+
+type CatalogRepo struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// HealthCheck is a Deployment, StatefulSet or DaemonSet that has to be
+// ready, in the namespace of the addon unless Namespace is set.
+type HealthCheck struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func init() {
+	addons, err := loadCatalog(builtinCatalog, readBuiltinFile)
+	if err != nil {
+		panic(fmt.Sprintf("loading the built-in addon catalog: %s", err))
+	}
+
+	Addons = append(addons, Addons...)
+}
+
+func readBuiltinFile(name string) (string, error) {
+	data, err := builtinCatalogFS.ReadFile(name)
+	return string(data), err
+}
+
+// LoadCatalogs loads the addons of the catalogs at sources, files or https
+// URLs, an addon of a later catalog replacing one of the same name.
+func LoadCatalogs(sources []string) ([]Addon, error) {
+	var loaded []Addon
+	for _, source := range sources {
+		addons, err := loadCatalog(source, readCatalogFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+
+		loaded = withAddons(loaded, addons...)
+	}
+
+	return loaded, nil
+}
+
+// configAddons are the addons conf can install, the built-in ones and
+// those of its catalogs in place of the built-in ones of the same name.
+func configAddons(conf *Config) []Addon {
+	return withAddons(Addons, conf.catalogAddons...)
+}
+
+// withAddons returns base with addons added, each in place of the addon
+// of the same name if there is one. base itself is left as it is.
+func withAddons(base []Addon, addons ...Addon) []Addon {
+	merged := append([]Addon(nil), base...)
+
+next:
+	for _, addon := range addons {
+		for i := range merged {
+			if merged[i].Name == addon.Name {
+				merged[i] = addon
+				continue next
+			}
+		}
+
+		merged = append(merged, addon)
+	}
+
+	return merged
+}
+
+// readCatalogFile reads a local file or fetches an https URL. Catalogs
+// decide what is installed on the cluster, plain http would let anyone on
+// the way decide instead.
+func readCatalogFile(name string) (string, error) {
+	if strings.HasPrefix(name, "http://") {
+		return "", fmt.Errorf("%s isn't https, catalogs are only fetched over https", name)
+	}
+	if !strings.HasPrefix(name, "https://") {
+		data, err := os.ReadFile(name)
+		return string(data), err
+	}
+
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Get(name)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", name, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// catalogPath resolves the file name of a catalog entry relative to the
+// catalog at source.
+func catalogPath(source string, name string) (string, error) {
+	if u, err := url.Parse(source); err == nil && u.Scheme != "" {
+		ref, err := url.Parse(name)
+		if err != nil {
+			return "", err
+		}
+		return u.ResolveReference(ref).String(), nil
+	}
+
+	if source == builtinCatalog {
+		return path.Join(path.Dir(source), name), nil
+	}
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	return filepath.Join(filepath.Dir(source), name), nil
+}
+
+func loadCatalog(source string, read func(name string) (string, error)) ([]Addon, error) {
+	data, err := read(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog AddonCatalog
+	if err := yaml.UnmarshalStrict([]byte(data), &catalog); err != nil {
+		return nil, err
+	}
+	if catalog.Kind != "AddonCatalog" {
+		return nil, fmt.Errorf("kind %q isn't AddonCatalog", catalog.Kind)
+	}
+
+	addons := make([]Addon, 0, len(catalog.Addons))
+	for _, entry := range catalog.Addons {
+		for _, file := range []struct {
+			name string
+			into *string
+		}{{entry.ValuesFile, &entry.Values}, {entry.ManifestsFile, &entry.Manifests}} {
+			if file.name == "" {
+				continue
+			}
+
+			resolved, err := catalogPath(source, file.name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", entry.Name, err)
+			}
+			if *file.into, err = read(resolved); err != nil {
+				return nil, fmt.Errorf("%s: reading %s: %w", entry.Name, file.name, err)
+			}
+		}
+
+		addon, err := entry.addon()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		addons = append(addons, addon)
+	}
+
+	return addons, nil
+}
+
+// addon checks the entry and turns it into an addon, whose values and
+// manifests are read already.
+func (c CatalogAddon) addon() (Addon, error) {
+	if c.Name == "" || c.Chart == "" || c.Namespace == "" {
+		return Addon{}, fmt.Errorf("name, chart and namespace are required")
+	}
+	// The name is the release's name too.
+	if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+		return Addon{}, fmt.Errorf("invalid name %q: %s", c.Name, errs[0])
+	}
+	if c.Repo.Name == "" || c.Repo.URL == "" {
+		return Addon{}, fmt.Errorf("the repo needs a name and url")
+	}
+	if c.Version != "" {
+		if _, err := semver.NewConstraint(c.Version); err != nil {
+			return Addon{}, fmt.Errorf("version %q: %w", c.Version, err)
+		}
+	}
+
+	timeout := time.Minute * 5
+	if c.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return Addon{}, fmt.Errorf("timeout: %w", err)
+		}
+	}
+
+	for _, check := range c.Health {
+		switch check.Kind {
+		case "Deployment", "StatefulSet", "DaemonSet":
+		default:
+			return Addon{}, fmt.Errorf("health check of %s %s, expected a Deployment, StatefulSet or DaemonSet", check.Kind, check.Name)
+		}
+	}
+
+	addon := Addon{
+		Name:     c.Name,
+		Repo:     repo.Entry{Name: c.Repo.Name, URL: c.Repo.URL},
+		Requires: c.Requires,
+		Section:  c.Section,
+	}
+	section := addon.section()
+
+	addon.Enabled = func(conf *Config) bool {
+		_, common := addonSettings(conf, c.Name, section)
+		return common.Enabled
+	}
+	addon.Spec = func(conf *Config) (*helmclient.ChartSpec, error) {
+		settings, common := addonSettings(conf, c.Name, section)
+		values, err := renderValues(c.Name, c.Values, settings)
+		if err != nil {
+			return nil, err
+		}
+
+		version := common.Version
+		if version == "" {
+			version = c.Version
+		}
+
+		return &helmclient.ChartSpec{
+			ReleaseName:     c.Name,
+			ChartName:       c.Chart,
+			Namespace:       c.Namespace,
+			CreateNamespace: c.CreateNamespace,
+			UpgradeCRDs:     c.UpgradeCRDs,
+			Wait:            true,
+			Timeout:         timeout,
+			Version:         version,
+			ValuesYaml:      values,
+		}, nil
+	}
+	if c.Manifests != "" {
+		addon.Manifests = func(conf *Config) (string, error) {
+			settings, _ := addonSettings(conf, c.Name, section)
+			return renderValues(c.Name+"-objects", c.Manifests, settings)
+		}
+	}
+	if len(c.Health) > 0 {
+		addon.Configure = func(ctx context.Context, conf *Config) error {
+			return waitHealthy(ctx, c.Namespace, c.Health, timeout)
+		}
+	}
+
+	return addon, nil
+}
+
+// addonSettings returns the config section of an addon, the typed section
+// of a built-in addon or else its entry under addons.custom, along with
+// the settings every addon shares.
+func addonSettings(conf *Config, name string, section string) (any, AddonConfig) {
+	if settings, common, ok := typedAddonSection(conf, section); ok {
+		return settings, common
+	}
+
+	custom := conf.Addons.Custom[name]
+	return custom, custom.AddonConfig
+}
+
+// typedAddonSection finds the field of the addons config named section,
+// reporting false when there is none.
+func typedAddonSection(conf *Config, section string) (any, AddonConfig, bool) {
+	addons := reflect.ValueOf(conf.Addons)
+	for i := 0; i < addons.NumField(); i++ {
+		name, _, _ := strings.Cut(addons.Type().Field(i).Tag.Get("json"), ",")
+		if name != section {
+			continue
+		}
+
+		field := addons.Field(i)
+		if common, ok := field.Interface().(AddonConfig); ok {
+			return common, common, true
+		}
+		if common := field.FieldByName("AddonConfig"); common.IsValid() {
+			return field.Interface(), common.Interface().(AddonConfig), true
+		}
+	}
+
+	return nil, AddonConfig{}, false
+}
+
+// waitHealthy waits for the workloads of checks to be ready.
+func waitHealthy(ctx context.Context, ns string, checks []HealthCheck, timeout time.Duration) error {
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, check := range checks {
+		checkNs := ns
+		if check.Namespace != "" {
+			checkNs = check.Namespace
+		}
+
+		log.Printf("Waiting for %s %s/%s\n", check.Kind, checkNs, check.Name)
+		for {
+			ready, err := workloadReady(ctx, k8sClient, check.Kind, checkNs, check.Name)
+			if err == nil && ready {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				if err == nil {
+					err = ctx.Err()
+				}
+				return fmt.Errorf("%s %s/%s not ready: %w", check.Kind, checkNs, check.Name, err)
+			case <-time.After(time.Second * 5):
+			}
+		}
+	}
+
+	return nil
+}
+
+// versionMatches reports whether version satisfies constraint, an exact
+// version or a semver range.
+func versionMatches(constraint string, version string) bool {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return strings.TrimPrefix(constraint, "v") == strings.TrimPrefix(version, "v")
+	}
+
+	v, err := semver.NewVersion(version)
+	return err == nil && c.Check(v)
+}
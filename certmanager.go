@@ -0,0 +1,118 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+//go:embed values/cert-manager.yaml
+var CertManagerYaml string
+
+var jetstackRepo = repo.Entry{
+	Name: "jetstack",
+	URL:  "https://charts.jetstack.io",
+}
+
+func certManagerSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	return &helmclient.ChartSpec{
+		ReleaseName:     "cert-manager",
+		ChartName:       "jetstack/cert-manager",
+		Namespace:       "cert-manager",
+		CreateNamespace: true,
+		UpgradeCRDs:     true,
+		Wait:            true,
+		Timeout:         time.Minute * 4,
+		Version:         conf.Addons.CertManager.Version,
+		ValuesYaml:      CertManagerYaml,
+	}, nil
+}
+
+// certManagerManifests renders an ACME ClusterIssuer with a DNS-01 solver
+// for every configured issuer, along with the secrets its solver uses.
+// Issuer secrets live in the cert-manager namespace, which is the cluster
+// resource namespace ClusterIssuers read them from.
+func certManagerManifests(conf *Config) (string, error) {
+	var objects []object
+
+	for _, issuer := range conf.Addons.CertManager.Issuers {
+		secret := issuer.Name + "-dns01"
+
+		var solver object
+		switch issuer.Solver {
+		case "cloudflare":
+			objects = append(objects, secretObject(secret, "cert-manager", map[string]string{
+				"api-token": issuer.Cloudflare.APIToken.Reveal(),
+			}))
+			solver = object{
+				"cloudflare": object{
+					"apiTokenSecretRef": object{"name": secret, "key": "api-token"},
+				},
+			}
+		case "route53":
+			route53 := object{"region": issuer.Route53.Region}
+			if issuer.Route53.HostedZoneID != "" {
+				route53["hostedZoneID"] = issuer.Route53.HostedZoneID
+			}
+
+			// Without keys the node's instance profile is used.
+			if issuer.Route53.AccessKeyID != "" {
+				objects = append(objects, secretObject(secret, "cert-manager", map[string]string{
+					"secret-access-key": issuer.Route53.SecretAccessKey.Reveal(),
+				}))
+				route53["accessKeyID"] = issuer.Route53.AccessKeyID
+				route53["secretAccessKeySecretRef"] = object{"name": secret, "key": "secret-access-key"}
+			}
+
+			solver = object{"route53": route53}
+		case "rfc2136":
+			objects = append(objects, secretObject(secret, "cert-manager", map[string]string{
+				"tsig-secret": issuer.RFC2136.TSIGSecret.Reveal(),
+			}))
+			solver = object{
+				"rfc2136": object{
+					"nameserver":    issuer.RFC2136.Nameserver,
+					"tsigKeyName":   issuer.RFC2136.TSIGKeyName,
+					"tsigAlgorithm": issuer.RFC2136.TSIGAlgorithm,
+					"tsigSecretSecretRef": object{
+						"name": secret,
+						"key":  "tsig-secret",
+					},
+				},
+			}
+		default:
+			return "", fmt.Errorf("issuer %s has unknown solver %q, expected cloudflare, route53 or rfc2136", issuer.Name, issuer.Solver)
+		}
+
+		dns01 := object{"dns01": solver}
+		if len(issuer.DNSZones) > 0 {
+			dns01["selector"] = object{"dnsZones": issuer.DNSZones}
+		}
+
+		server := issuer.Server
+		if server == "" {
+			server = "https://acme-v02.api.letsencrypt.org/directory"
+		}
+
+		objects = append(objects, object{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "ClusterIssuer",
+			"metadata":   object{"name": issuer.Name},
+			"spec": object{
+				"acme": object{
+					"server": server,
+					"email":  issuer.Email,
+					"privateKeySecretRef": object{
+						"name": issuer.Name + "-account-key",
+					},
+					"solvers": []object{dns01},
+				},
+			},
+		})
+	}
+
+	return renderObjects(objects)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// CRI-O reads its drop-ins after crio.conf, so this wins over whatever
+// driver the package configured.
+const crioCgroupConf = "/etc/crio/crio.conf.d/10-orsted-cgroup.conf"
+
+const crioCgroupDriver = `[crio.runtime]
+cgroup_manager = "systemd"
+conmon_cgroup = "pod"
+`
+
+const cgroupV2Remediation = "boot with systemd.unified_cgroup_hierarchy=1 on the kernel command line " +
+	"(e.g. grubby --update-kernel=ALL --args=systemd.unified_cgroup_hierarchy=1) and reboot"
+
+// cgroupMode reports how the host mounts cgroups: v2 for the unified
+// hierarchy, hybrid for v1 controllers with a v2 tree beside them, v1
+// otherwise.
+func cgroupMode() string {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		return "v2"
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/unified/cgroup.controllers"); err == nil {
+		return "hybrid"
+	}
+
+	return "v1"
+}
+
+// ConfigureCgroups checks the host's cgroup mode and has CRI-O use the
+// systemd cgroup driver, which the kubelet is configured with as well.
+// Kubelets from 1.35 on refuse to start on cgroup v1, and MemoryQoS needs
+// v2 at any version.
+func ConfigureCgroups(ctx context.Context, conf *Config) error {
+	mode := cgroupMode()
+	log.Printf("Host is on cgroup %s\n", mode)
+
+	if mode != "v2" {
+		kubeadm, err := commandVersion(ctx, "kubeadm", "version", "-o", "short")
+		if err != nil {
+			return failed("cgroups", err)
+		}
+
+		if kubeadm.Major() == 1 && kubeadm.Minor() >= 35 {
+			return failedWith(ExitPreflight, "cgroups", fmt.Errorf("Kubernetes %s does not support cgroup %s, %s", kubeadm, mode, cgroupV2Remediation))
+		}
+		if conf.ControlPlane.FeatureGates["MemoryQoS"] {
+			return failedWith(ExitPreflight, "cgroups", fmt.Errorf("the MemoryQoS feature gate needs cgroup v2, %s", cgroupV2Remediation))
+		}
+
+		log.Printf("cgroup %s is in maintenance mode upstream, %s\n", mode, cgroupV2Remediation)
+	}
+
+	if err := writeFile(crioCgroupConf, crioCgroupDriver); err != nil {
+		return failed("cri-o", err)
+	}
+
+	// Picks up the driver if CRI-O was already running, the runtime
+	// phase starts it otherwise.
+	restartOut, err := RunCommand(ctx, "systemctl", "try-restart", "crio")
+	if err != nil {
+		return commandFailed("cri-o", restartOut, fmt.Errorf("restarting: %w", err))
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	ciliumRepo = repo.Entry{
+		Name: "cilium",
+		URL:  "https://helm.cilium.io/",
+	}
+
+	kyvernoRepo = repo.Entry{
+		Name: "kyverno",
+		URL:  "https://kyverno.github.io/kyverno/",
+	}
+
+	rookRepo = repo.Entry{
+		Name: "rook",
+		URL:  "https://charts.rook.io/release",
+	}
+
+	gitopsRepo = repo.Entry{
+		Name: "gitops",
+		URL:  "https://helm.gitops.weave.works/",
+	}
+)
+
+// Release is a Helm release managed by orsted together with the repository
+// its chart comes from.
+type Release struct {
+	Repo repo.Entry
+	Spec *helmclient.ChartSpec
+}
+
+// ciliumSpec renders the Cilium release, adjusted for the network config
+// and the addons that depend on it.
+func ciliumSpec(conf *Config, defaultIp string) (*helmclient.ChartSpec, error) {
+	overrides := map[string]interface{}{}
+	if conf.Addons.MultusEnabled() {
+		// Keep Cilium from renaming the Multus CNI config away.
+		overrides["cni"] = map[string]interface{}{"exclusive": false}
+	}
+	if conf.Network.LoadBalancer.L2.Enabled {
+		overrides["l2announcements"] = map[string]interface{}{"enabled": true}
+		overrides["externalIPs"] = map[string]interface{}{"enabled": true}
+	}
+	if len(conf.Network.EgressGateways) > 0 {
+		overrides["egressGateway"] = map[string]interface{}{"enabled": true}
+		overrides["bpf"] = map[string]interface{}{"masquerade": true}
+	}
+	if conf.Addons.Linkerd.Enabled {
+		// Linkerd's proxies have to see connections to service addresses,
+		// not the backends Cilium's socket load balancing swaps them for.
+		overrides["socketLB"] = map[string]interface{}{"hostNamespaceOnly": true}
+	}
+	ciliumPriorityValues(overrides)
+	if err := ciliumNetworkValues(conf, overrides); err != nil {
+		return nil, err
+	}
+
+	override, err := yaml.Marshal(overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := mergeValues(strings.Replace(CiliumYaml, "K8SHOST", defaultIp, 1), override)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName: "cilium",
+		ChartName:   "cilium/cilium",
+		Namespace:   "kube-system",
+		UpgradeCRDs: true,
+		Wait:        true,
+		WaitForJobs: true,
+		Timeout:     time.Minute * 7,
+		Version:     "v1.14.0",
+		ValuesYaml:  values,
+	}, nil
+}
+
+func kyvernoSpec() *helmclient.ChartSpec {
+	return &helmclient.ChartSpec{
+		ReleaseName:     "kyverno",
+		ChartName:       "kyverno/kyverno",
+		Namespace:       "kyverno",
+		CreateNamespace: true,
+		UpgradeCRDs:     true,
+		Wait:            true,
+		WaitForJobs:     true,
+		Timeout:         time.Minute * 4,
+		ValuesYaml:      KyvernoYaml,
+	}
+}
+
+func rookOperatorSpec() *helmclient.ChartSpec {
+	return &helmclient.ChartSpec{
+		ReleaseName: "rook-ceph",
+		ChartName:   "rook/rook-ceph",
+		Namespace:   "rook-ceph",
+		Wait:        true,
+		WaitForJobs: true,
+		Timeout:     time.Minute * 2,
+		UpgradeCRDs: true,
+		ValuesYaml:  RookOperatorYaml,
+	}
+}
+
+func rookClusterSpec() *helmclient.ChartSpec {
+	return &helmclient.ChartSpec{
+		ReleaseName: "rook-ceph-cluster",
+		ChartName:   "rook/rook-ceph-cluster",
+		Namespace:   "rook-ceph",
+		Wait:        true,
+		WaitForJobs: true,
+		Timeout:     time.Minute * 5,
+		UpgradeCRDs: true,
+		ValuesYaml:  CephClusterYaml,
+	}
+}
+
+func gitopsSpec() *helmclient.ChartSpec {
+	return &helmclient.ChartSpec{
+		ReleaseName: "weave-gitops",
+		ChartName:   "gitops/weave-gitops",
+		Namespace:   "weave-gitops",
+		Wait:        true,
+		WaitForJobs: true,
+		Timeout:     time.Minute * 15,
+		ValuesYaml:  GitOpsYaml,
+	}
+}
+
+// rookEnabled reports whether Rook Ceph provides the storage, which kind
+// and the CSI drivers of clouds do instead.
+func rookEnabled(conf *Config) bool {
+	_, cloud := cloudEnabled(conf)
+	return !cloud && !devKind
+}
+
+// Releases lists every release the config deploys, core components first
+// followed by the enabled addons.
+func Releases(conf *Config, defaultIp string) ([]Release, error) {
+	engine, err := PolicyEngineRelease(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	cilium, err := ciliumSpec(conf, defaultIp)
+	if err != nil {
+		return nil, err
+	}
+
+	cloud, err := cloudReleases(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := append([]Release{{ciliumRepo, cilium}}, cloud...)
+	releases = append(releases, engine)
+	if rookEnabled(conf) {
+		releases = append(releases, Release{rookRepo, rookOperatorSpec()}, Release{rookRepo, rookClusterSpec()})
+	}
+	releases = append(releases, Release{gitopsRepo, gitopsSpec()})
+
+	for _, addon := range configAddons(conf) {
+		if !addon.Enabled(conf) || addon.Spec == nil {
+			continue
+		}
+
+		spec, err := addon.Spec(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, Release{addon.Repo, spec})
+	}
+
+	return releases, nil
+}
+
+// addChartRepo adds entry to the repository config and downloads its
+// index, unless the cached index is younger than helm.indexMaxAge or the
+// charts of specs, all from the repo, were vendored by bake.
+func addChartRepo(conf *Config, client helmclient.Client, entry repo.Entry, specs ...*helmclient.ChartSpec) error {
+	if len(specs) > 0 && allVendored(specs) {
+		return nil
+	}
+	if repoIndexFresh(conf, entry) {
+		return nil
+	}
+
+	return client.AddOrUpdateChartRepo(entry)
+}
+
+func allVendored(specs []*helmclient.ChartSpec) bool {
+	for _, spec := range specs {
+		if withVendoredChart(spec) == spec {
+			return false
+		}
+	}
+
+	return true
+}
+
+// repoIndexFresh reports whether entry is in the repository config with
+// its URL and its index was downloaded within helm.indexMaxAge.
+func repoIndexFresh(conf *Config, entry repo.Entry) bool {
+	maxAge, err := time.ParseDuration(conf.Helm.IndexMaxAge)
+	if err != nil || maxAge <= 0 {
+		return false
+	}
+
+	file, err := repo.LoadFile(helmRepositoryConfig)
+	if err != nil {
+		return false
+	}
+	if added := file.Get(entry.Name); added == nil || added.URL != entry.URL {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(helmRepositoryCache, helmpath.CacheIndexFile(entry.Name)))
+	return err == nil && time.Since(info.ModTime()) < maxAge
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// cloudProvider is how orsted integrates with the platform the node runs
+// on when the cloud controllerManager is enabled: the external
+// cloud-controller-manager and CSI driver are installed from Releases and
+// upstream Manifests or Kustomizations, reading the secrets of Objects,
+// and StorageClass takes the place of Rook Ceph's ceph-block.
+type cloudProvider struct {
+	Releases       func(conf *Config) ([]Release, error)
+	Manifests      []string
+	Kustomizations []string
+	Objects        func(conf *Config) ([]object, error)
+	StorageClass   string
+}
+
+var cloudProviders = map[string]cloudProvider{
+	"aws": {
+		Releases:     awsReleases,
+		StorageClass: "ebs-gp3",
+	},
+	"gcp": {
+		// GCP publishes neither as a chart.
+		Manifests: []string{
+			"https://raw.githubusercontent.com/kubernetes/cloud-provider-gcp/master/deploy/packages/default/manifest.yaml",
+		},
+		Kustomizations: []string{
+			"github.com/kubernetes-sigs/gcp-compute-persistent-disk-csi-driver/deploy/kubernetes/overlays/stable-master?ref=v1.10.1",
+		},
+		Objects:      gcpObjects,
+		StorageClass: "pd-balanced",
+	},
+	"hetzner": {
+		Releases:     hetznerReleases,
+		Objects:      hetznerObjects,
+		StorageClass: "hcloud-volumes",
+	},
+	"proxmox": {
+		Manifests: []string{
+			"https://raw.githubusercontent.com/sergelogvinov/proxmox-cloud-controller-manager/main/docs/deploy/cloud-controller-manager.yml",
+			"https://raw.githubusercontent.com/sergelogvinov/proxmox-csi-plugin/main/docs/deploy/proxmox-csi-plugin-release.yml",
+		},
+		Objects:      proxmoxObjects,
+		StorageClass: "proxmox-data",
+	},
+}
+
+var (
+	awsCCMRepo = repo.Entry{
+		Name: "aws-cloud-controller-manager",
+		URL:  "https://kubernetes.github.io/cloud-provider-aws",
+	}
+
+	awsEBSRepo = repo.Entry{
+		Name: "aws-ebs-csi-driver",
+		URL:  "https://kubernetes-sigs.github.io/aws-ebs-csi-driver",
+	}
+
+	hcloudRepo = repo.Entry{
+		Name: "hcloud",
+		URL:  "https://charts.hetzner.cloud",
+	}
+)
+
+// dmiDir holds the firmware's description of the machine, which cloud
+// providers fill in with their name.
+const dmiDir = "/sys/class/dmi/id"
+
+var (
+	detectOnce    sync.Once
+	detectedCloud string
+)
+
+// detectCloud names the provider the host runs on from its DMI data, or
+// returns an empty string when it isn't one orsted knows. Proxmox VMs are
+// only told apart from other QEMU machines when cloud-init set them up.
+func detectCloud() string {
+	detectOnce.Do(func() {
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(dmiDir, name))
+			return strings.TrimSpace(string(data))
+		}
+		vendor, product, bios := read("sys_vendor"), read("product_name"), read("bios_vendor")
+
+		_, err := os.Stat("/var/lib/cloud/instance")
+		cloudInit := err == nil
+
+		switch {
+		case vendor == "Amazon EC2" || strings.HasPrefix(bios, "Amazon"):
+			detectedCloud = "aws"
+		case product == "Google Compute Engine":
+			detectedCloud = "gcp"
+		case vendor == "Hetzner":
+			detectedCloud = "hetzner"
+		case vendor == "QEMU" && cloudInit:
+			detectedCloud = "proxmox"
+		}
+	})
+
+	return detectedCloud
+}
+
+// cloudName is the configured provider, or the detected one unless
+// detection is turned off with none.
+func cloudName(conf *Config) string {
+	switch conf.Cloud.Provider {
+	case "":
+		return detectCloud()
+	case "none":
+		return ""
+	}
+
+	return conf.Cloud.Provider
+}
+
+// cloudEnabled reports whether the provider's controller manager and CSI
+// driver replace Rook Ceph, returning the provider.
+func cloudEnabled(conf *Config) (cloudProvider, bool) {
+	if devKind || !conf.Cloud.ControllerManager {
+		return cloudProvider{}, false
+	}
+
+	provider, ok := cloudProviders[cloudName(conf)]
+	return provider, ok
+}
+
+// logCloud tells which provider was found and whether orsted integrates
+// with it.
+func logCloud(conf *Config) {
+	name := cloudName(conf)
+	switch _, enabled := cloudEnabled(conf); {
+	case name == "":
+		return
+	case enabled:
+		log.Printf("Running on %s, installing its cloud-controller-manager and CSI driver instead of Rook Ceph\n", name)
+	case conf.Cloud.ControllerManager:
+		log.Printf("Unknown cloud provider %s, using Rook Ceph\n", name)
+	default:
+		log.Printf("Running on %s, enable cloud.controllerManager to use its volumes and load balancers\n", name)
+	}
+}
+
+// applyCloud swaps the Rook Ceph StorageClass addons default to for the
+// one of the provider's CSI driver.
+func applyCloud(conf *Config) {
+	if provider, ok := cloudEnabled(conf); ok {
+		replaceStorageClass(conf, provider.StorageClass)
+	}
+}
+
+// applyCloudProvider starts the kubelet with an external cloud provider,
+// which keeps the node tainted uninitialized until the
+// cloud-controller-manager has set its provider ID and addresses.
+func applyCloudProvider(conf *Config, docs []map[string]interface{}) ([]map[string]interface{}, error) {
+	if _, ok := cloudEnabled(conf); !ok {
+		return docs, nil
+	}
+
+	docs, cluster := kubeadmDocument(docs, "kubeadm.k8s.io/v1beta3", "ClusterConfiguration")
+	apiVersion, _ := cluster["apiVersion"].(string)
+	docs, initConfig := kubeadmDocument(docs, apiVersion, "InitConfiguration")
+	args := childMap(childMap(initConfig, "nodeRegistration"), "kubeletExtraArgs")
+	args["cloud-provider"] = "external"
+
+	return docs, nil
+}
+
+// cloudReleases are the charts of the provider's controller manager and
+// CSI driver, if it has any.
+func cloudReleases(conf *Config) ([]Release, error) {
+	provider, ok := cloudEnabled(conf)
+	if !ok || provider.Releases == nil {
+		return nil, nil
+	}
+
+	return provider.Releases(conf)
+}
+
+// cloudManifest renders the secrets and StorageClass the provider's
+// controller manager and CSI driver need.
+func cloudManifest(conf *Config) (string, error) {
+	provider, ok := cloudEnabled(conf)
+	if !ok || provider.Objects == nil {
+		return "", nil
+	}
+
+	objects, err := provider.Objects(conf)
+	if err != nil {
+		return "", err
+	}
+
+	return renderObjects(objects)
+}
+
+// InstallCloud installs the provider's cloud-controller-manager and CSI
+// driver.
+func InstallCloud(ctx context.Context, conf *Config) error {
+	provider, _ := cloudEnabled(conf)
+
+	manifest, err := cloudManifest(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "cloud", fmt.Errorf("rendering the secrets: %w", err))
+	}
+	if manifest != "" {
+		out, err := ApplyManifest(ctx, "cloud", manifest)
+		if err != nil {
+			return commandFailed("cloud", out, fmt.Errorf("applying the secrets: %w", err))
+		}
+	}
+
+	for _, manifest := range provider.Manifests {
+		log.Printf("Applying %s\n", manifest)
+		out, err := applyOwned(ctx, "cloud", "-f", manifest)
+		if err != nil {
+			return commandFailed("cloud", out, fmt.Errorf("applying %s: %w", manifest, err))
+		}
+	}
+
+	for _, kustomization := range provider.Kustomizations {
+		log.Printf("Applying %s\n", kustomization)
+		out, err := applyOwned(ctx, "cloud", "-k", kustomization)
+		if err != nil {
+			return commandFailed("cloud", out, fmt.Errorf("applying %s: %w", kustomization, err))
+		}
+	}
+
+	releases, err := cloudReleases(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "cloud", fmt.Errorf("rendering values: %w", err))
+	}
+	for _, release := range releases {
+		client, err := helmClientForNs(release.Spec.Namespace)
+		if err != nil {
+			return failed(release.Spec.ReleaseName, fmt.Errorf("creating the helm client: %w", err))
+		}
+		if err := addChartRepo(conf, client, release.Repo, release.Spec); err != nil {
+			return failed(release.Spec.ReleaseName, fmt.Errorf("adding the chart repo: %w", err))
+		}
+
+		log.Printf("Deploying %s\n", release.Spec.ReleaseName)
+		if err := InstallOrUpgradeSpec(ctx, conf, client, release.Spec); err != nil {
+			return installFailed(release.Spec.ReleaseName, err)
+		}
+	}
+
+	return nil
+}
+
+// awsReleases authenticate with the node's instance profile, which needs
+// the permissions of the upstream docs.
+func awsReleases(conf *Config) ([]Release, error) {
+	ebsValues, err := yaml.Marshal(object{
+		"storageClasses": []object{{
+			"name":              "ebs-gp3",
+			"volumeBindingMode": "WaitForFirstConsumer",
+			"parameters":        object{"type": "gp3"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []Release{
+		{awsCCMRepo, &helmclient.ChartSpec{
+			ReleaseName: "aws-cloud-controller-manager",
+			ChartName:   "aws-cloud-controller-manager/aws-cloud-controller-manager",
+			Namespace:   "kube-system",
+			Wait:        true,
+			Timeout:     time.Minute * 5,
+		}},
+		{awsEBSRepo, &helmclient.ChartSpec{
+			ReleaseName: "aws-ebs-csi-driver",
+			ChartName:   "aws-ebs-csi-driver/aws-ebs-csi-driver",
+			Namespace:   "kube-system",
+			Wait:        true,
+			Timeout:     time.Minute * 5,
+			ValuesYaml:  string(ebsValues),
+		}},
+	}, nil
+}
+
+func hetznerReleases(conf *Config) ([]Release, error) {
+	ccmValues, err := yaml.Marshal(object{
+		"networking": object{"enabled": conf.Cloud.Hetzner.Network != ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []Release{
+		{hcloudRepo, &helmclient.ChartSpec{
+			ReleaseName: "hcloud-cloud-controller-manager",
+			ChartName:   "hcloud/hcloud-cloud-controller-manager",
+			Namespace:   "kube-system",
+			Wait:        true,
+			Timeout:     time.Minute * 5,
+			ValuesYaml:  string(ccmValues),
+		}},
+		{hcloudRepo, &helmclient.ChartSpec{
+			ReleaseName: "hcloud-csi",
+			ChartName:   "hcloud/hcloud-csi",
+			Namespace:   "kube-system",
+			Wait:        true,
+			Timeout:     time.Minute * 5,
+		}},
+	}, nil
+}
+
+// hetznerObjects is the hcloud secret both charts read the API token, and
+// the private network the CCM routes pods through, from.
+func hetznerObjects(conf *Config) ([]object, error) {
+	hetzner := conf.Cloud.Hetzner
+	if hetzner.Token == "" {
+		return nil, fmt.Errorf("hetzner needs an API token")
+	}
+
+	data := map[string]string{"token": hetzner.Token.Reveal()}
+	if hetzner.Network != "" {
+		data["network"] = hetzner.Network
+	}
+
+	return []object{secretObject("hcloud", "kube-system", data)}, nil
+}
+
+// gcpObjects is the service account key of the PD CSI driver, the CCM uses
+// the node's service account.
+func gcpObjects(conf *Config) ([]object, error) {
+	gcp := conf.Cloud.GCP
+	if gcp.ServiceAccountKey == "" {
+		return nil, fmt.Errorf("gcp needs a serviceAccountKey for the PD CSI driver")
+	}
+
+	return []object{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   object{"name": "gce-pd-csi-driver"},
+		},
+		secretObject("cloud-sa", "gce-pd-csi-driver", map[string]string{"cloud-sa.json": gcp.ServiceAccountKey.Reveal()}),
+		storageClassObject("pd-balanced", "pd.csi.storage.gke.io", map[string]string{"type": "pd-balanced"}),
+	}, nil
+}
+
+// proxmoxObjects are the cluster configs of the CCM and CSI plugin, and
+// the StorageClass of the Proxmox storage volumes are created on.
+func proxmoxObjects(conf *Config) ([]object, error) {
+	proxmox := conf.Cloud.Proxmox
+	if proxmox.URL == "" || proxmox.TokenID == "" || proxmox.TokenSecret == "" || proxmox.Region == "" {
+		return nil, fmt.Errorf("proxmox needs the url, region, tokenID and tokenSecret of the cluster")
+	}
+
+	clusters, err := yaml.Marshal(object{
+		"clusters": []object{{
+			"url":          proxmox.URL,
+			"insecure":     proxmox.Insecure,
+			"token_id":     proxmox.TokenID,
+			"token_secret": proxmox.TokenSecret.Reveal(),
+			"region":       proxmox.Region,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	storage := proxmox.Storage
+	if storage == "" {
+		storage = "local-lvm"
+	}
+
+	return []object{
+		secretObject("proxmox-cloud-controller-manager", "kube-system", map[string]string{"config.yaml": string(clusters)}),
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata": object{
+				"name":   "csi-proxmox",
+				"labels": map[string]string{"pod-security.kubernetes.io/enforce": "privileged"},
+			},
+		},
+		secretObject("proxmox-csi-plugin", "csi-proxmox", map[string]string{"config.yaml": string(clusters)}),
+		storageClassObject("proxmox-data", "csi.proxmox.sinextra.dev", map[string]string{
+			"storage":                   storage,
+			"csi.storage.k8s.io/fstype": "ext4",
+		}),
+	}, nil
+}
+
+func storageClassObject(name string, provisioner string, parameters map[string]string) object {
+	return object{
+		"apiVersion":           "storage.k8s.io/v1",
+		"kind":                 "StorageClass",
+		"metadata":             object{"name": name},
+		"provisioner":          provisioner,
+		"parameters":           parameters,
+		"volumeBindingMode":    "WaitForFirstConsumer",
+		"allowVolumeExpansion": true,
+		"reclaimPolicy":        "Delete",
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+//go:embed values/cloudflare-tunnel.yaml
+var CloudflareTunnelYaml string
+
+var cloudflareRepo = repo.Entry{
+	Name: "cloudflare",
+	URL:  "https://cloudflare.github.io/helm-charts",
+}
+
+var httpRoutes = schema.GroupVersionResource{
+	Group:    "gateway.networking.k8s.io",
+	Version:  "v1beta1",
+	Resource: "httproutes",
+}
+
+// cloudflaredGateway returns the namespace and name of the Gateway the
+// tunnel forwards to.
+func cloudflaredGateway(conf CloudflaredConfig) (string, string, error) {
+	ns, name, ok := strings.Cut(conf.Gateway, "/")
+	if !ok {
+		return "", "", fmt.Errorf("cloudflared gateway %q is not namespace/name", conf.Gateway)
+	}
+
+	return ns, name, nil
+}
+
+func cloudflaredSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	cloudflared := conf.Addons.Cloudflared
+	if cloudflared.TunnelID == "" || cloudflared.TunnelSecret == "" || cloudflared.AccountTag == "" {
+		return nil, errors.New("cloudflared needs the accountTag, tunnelID and tunnelSecret of the tunnel")
+	}
+
+	ns, name, err := cloudflaredGateway(cloudflared)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := renderValues("cloudflare-tunnel", CloudflareTunnelYaml, struct {
+		CloudflaredConfig
+		GatewayService string
+	}{cloudflared, fmt.Sprintf("http://cilium-gateway-%s.%s.svc:80", name, ns)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "cloudflared",
+		ChartName:       "cloudflare/cloudflare-tunnel",
+		Namespace:       "cloudflared",
+		CreateNamespace: true,
+		Wait:            true,
+		Timeout:         time.Minute * 3,
+		Version:         cloudflared.Version,
+		ValuesYaml:      values,
+	}, nil
+}
+
+// cloudflareAPI calls the Cloudflare v4 API, decoding the result field of
+// the response into out.
+func cloudflareAPI(ctx context.Context, token Secret, method string, path string, body interface{}, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "https://api.cloudflare.com/client/v4"+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Reveal())
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool            `json:"success"`
+		Errors  json.RawMessage `json:"errors"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if !result.Success {
+		return fmt.Errorf("%s %s: %s", method, path, result.Errors)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(result.Result, out)
+}
+
+// cloudflareZone finds the zone a hostname belongs to, trying each parent
+// domain in turn.
+func cloudflareZone(ctx context.Context, token Secret, hostname string) (string, error) {
+	for domain := hostname; strings.Contains(domain, "."); {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := cloudflareAPI(ctx, token, "GET", "/zones?name="+url.QueryEscape(domain), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+
+	return "", fmt.Errorf("no cloudflare zone found for %s", hostname)
+}
+
+// configureCloudflared points DNS records for the hostnames of the
+// selected HTTPRoutes at the tunnel.
+func configureCloudflared(ctx context.Context, conf *Config) error {
+	cloudflared := conf.Addons.Cloudflared
+	if cloudflared.APIToken == "" {
+		log.Println("No Cloudflare API token configured, skipping tunnel DNS records")
+		return nil
+	}
+
+	k8sConf, err := clientcmd.BuildConfigFromFlags("", KubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dynamic.NewForConfig(k8sConf)
+	if err != nil {
+		return err
+	}
+
+	routes, err := client.Resource(httpRoutes).List(ctx, meta.ListOptions{LabelSelector: cloudflared.RouteSelector})
+	if err != nil {
+		return err
+	}
+
+	target := cloudflared.TunnelID + ".cfargotunnel.com"
+	for _, route := range routes.Items {
+		hostnames, _, err := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+		if err != nil {
+			return err
+		}
+
+		for _, hostname := range hostnames {
+			zone, err := cloudflareZone(ctx, cloudflared.APIToken, hostname)
+			if err != nil {
+				return err
+			}
+
+			var records []struct {
+				ID      string `json:"id"`
+				Content string `json:"content"`
+			}
+			err = cloudflareAPI(ctx, cloudflared.APIToken, "GET", "/zones/"+zone+"/dns_records?type=CNAME&name="+url.QueryEscape(hostname), nil, &records)
+			if err != nil {
+				return err
+			}
+
+			record := map[string]interface{}{
+				"type":    "CNAME",
+				"name":    hostname,
+				"content": target,
+				"proxied": true,
+				"comment": "managed by orsted for " + route.GetNamespace() + "/" + route.GetName(),
+			}
+
+			switch {
+			case len(records) == 0:
+				log.Printf("Routing %s through the Cloudflare tunnel\n", hostname)
+				err = cloudflareAPI(ctx, cloudflared.APIToken, "POST", "/zones/"+zone+"/dns_records", record, nil)
+			case records[0].Content != target:
+				log.Printf("Moving %s onto the Cloudflare tunnel\n", hostname)
+				err = cloudflareAPI(ctx, cloudflared.APIToken, "PUT", "/zones/"+zone+"/dns_records/"+records[0].ID, record, nil)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,859 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	configPath   string
+	preflightFix bool
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "orsted",
+	Short: "Bootstrap a single node Kubernetes cluster",
+	Long: `orsted initializes a Kubernetes cluster with kubeadm and installs Cilium,
+Kyverno, Rook Ceph, Weave GitOps and any enabled addons on top of it.
+
+Run without a subcommand it performs the bootstrap. With --dev-kind it targets
+a kind cluster named orsted on the local Docker instead, creating it when
+missing: the host setup and kubeadm are skipped and kind's local-path storage
+replaces Rook Ceph, for developing and demoing the addons on a laptop. The
+files read from /root, like the default policies, are still needed.
+
+With --json nothing but a single JSON object is printed on stdout when the
+bootstrap ends, whether it succeeded or not: its status and exit code, the
+kubeconfig path, the API server endpoint, the orsted and Kubernetes versions,
+the installed releases and what it failed on. Logs stay on stderr and --pretty
+is ignored, for Terraform provisioners and external data sources.
+
+Every command also logs to /var/log/orsted/<command>-<time>.log, the newest 20
+logs of each command are kept.
+
+The cluster is reached with --kubeconfig, else kubernetes.kubeconfig of the
+config, else /etc/kubernetes/admin.conf for root and $KUBECONFIG or
+~/.kube/config for other users. Bootstrapping the host needs root, commands
+that only reach the cluster, like upgrade, diff or addon, don't.
+
+Exit codes:
+  1    any other failure
+  3    the config could not be loaded or rendered
+  4    a preflight check failed
+  5    kubeadm init failed
+  6    a release did not become ready in time
+  7    orsted crashed, the failure bundle has the stack
+  130  interrupted by SIGINT or SIGTERM`,
+	Args: cobra.NoArgs,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if completing(cmd) {
+			return
+		}
+
+		name := "bootstrap"
+		if cmd != cmd.Root() {
+			name = strings.ReplaceAll(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "), " ", "-")
+		}
+		teeLogFile(name)
+
+		if kubeconfigFlag != "" {
+			KubeconfigPath = kubeconfigFlag
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if jsonOutput {
+			prettyOutput = false
+		}
+
+		if !devKind {
+			mustBeRoot("bootstrapping the host")
+		}
+
+		mustValidateConfig()
+		if err := Bootstrap(mustLoadConfig(), preflightFix); err != nil {
+			exit(err)
+		}
+	},
+}
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check the host can be bootstrapped",
+	Long: `Preflight runs the checks bootstrap starts with without changing anything,
+unless --fix is passed, in which case the problems it knows how to correct,
+like missing sysctls and kernel modules, are fixed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if preflightFix {
+			mustBeRoot("preflight --fix")
+		}
+
+		if err := Preflight(rootCtx, mustLoadConfig(), preflightFix); err != nil {
+			exit(err)
+		}
+	},
+}
+
+var checkUpdatesCmd = &cobra.Command{
+	Use:   "check-updates",
+	Short: "List chart upgrades available for the pinned versions",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := CheckUpdates(cmd.OutOrStdout(), mustLoadConfig()); err != nil {
+			exit(failed("check-updates", err))
+		}
+	},
+}
+
+var upgradePlan bool
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade the releases of an existing cluster to the current config",
+	Long: `Upgrade installs or upgrades every managed release with the versions and
+values of the current config. Upgrades are atomic: a release that fails to
+upgrade, or whose workloads don't become ready, is rolled back to its previous
+revision.
+
+With --plan nothing is applied. Instead the target Kubernetes version, every
+chart's current and target version, the CRDs the charts would create or change
+and the workloads that would restart are printed for review.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if upgradePlan {
+			if err := PlanUpgrade(cmd.OutOrStdout(), mustLoadConfig()); err != nil {
+				exit(failed("upgrade", fmt.Errorf("planning: %w", err)))
+			}
+			return
+		}
+
+		if err := Upgrade(mustLoadConfig()); err != nil {
+			exit(err)
+		}
+	},
+}
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Manage the images deployed by the managed releases",
+}
+
+var imagesLockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Resolve every chart image to a digest and write the image lock",
+	Long: `Lock renders every release of the current config and records the digest
+each image tag currently points to in the lock file. With images.pinDigests
+set, bootstrap and upgrade deploy exactly these digests.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := LockImages(mustLoadConfig()); err != nil {
+			exit(failed("images", fmt.Errorf("locking: %w", err)))
+		}
+	},
+}
+
+var sbomFormat string
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom",
+	Short: "Write an SBOM of orsted and every image the config deploys",
+	Long: `Sbom lists the Go modules compiled into orsted together with the container
+images of every release the current config deploys. Images are identified by
+the digests of the image lock where one has been written.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		components, err := SBOM(mustLoadConfig())
+		if err != nil {
+			exit(failed("sbom", fmt.Errorf("collecting components: %w", err)))
+		}
+
+		switch sbomFormat {
+		case "cyclonedx":
+			err = WriteCycloneDX(cmd.OutOrStdout(), components)
+		case "spdx":
+			err = WriteSPDX(cmd.OutOrStdout(), components)
+		default:
+			exit(failedWith(ExitConfig, "sbom", fmt.Errorf("unknown format %q, expected cyclonedx or spdx", sbomFormat)))
+		}
+		if err != nil {
+			exit(failed("sbom", fmt.Errorf("writing: %w", err)))
+		}
+	},
+}
+
+var (
+	updateChannel     string
+	updateReleasesURL string
+	updateForce       bool
+	updateSkipSig     bool
+)
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Replace orsted with the latest release of a channel",
+	Long: `Self-update downloads the newest release of the channel, stable for full
+releases or edge to include prereleases, verifies its checksum and its
+signature over the binary and its version, and replaces the running binary
+with it. Releases older than the running version are refused unless --force
+is given. Binaries built without a release public key can't verify the
+signature and refuse to update unless --insecure-skip-signature is given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := SelfUpdate(updateReleasesURL, updateChannel, UpdateOptions{Force: updateForce, InsecureSkipSignature: updateSkipSig}); err != nil {
+			exit(failed("self-update", err))
+		}
+	},
+}
+
+var (
+	serveListen    string
+	serveTokenFile string
+	serveWeb       string
+	webToken       string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the gRPC control API",
+	Long: `Serve exposes the Control service of api/control.proto, which starts a
+bootstrap with the current config, reports its progress, streams its logs and
+tears the cluster down, for orchestrators driving orsted without exec.
+
+--listen is a Unix socket path, or a host:port to listen on TCP. The control
+API tears clusters down, so TCP is only served with --token-file, a file
+holding the token every call has to send as an "authorization: Bearer <token>"
+header. The socket is only accessible to root and needs no token unless one
+is given.
+
+With --web, a progress page is served over HTTPS on that address as well,
+showing the phases, the health of every component and, once bootstrapped, how
+to reach the cluster. Its certificate is self-signed for the run, check the
+browser's warning against the fingerprint printed to the terminal. Downloading
+the admin kubeconfig from it takes --web-token, sent as an
+"Authorization: Bearer <token>" header, which is generated and printed to the
+terminal when not given.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := mustLoadConfig()
+
+		var token string
+		if serveTokenFile != "" {
+			data, err := os.ReadFile(serveTokenFile)
+			if err != nil {
+				exit(failedWith(ExitConfig, "serve", fmt.Errorf("reading the token: %w", err)))
+			}
+			token = strings.TrimSpace(string(data))
+			if token == "" {
+				exit(failedWith(ExitConfig, "serve", fmt.Errorf("the token file %s is empty", serveTokenFile)))
+			}
+			registerSecret(token)
+		}
+
+		listener, err := controlListener(serveListen, token)
+		if err != nil {
+			exit(failedWith(ExitConfig, "serve", fmt.Errorf("listening on %s: %w", serveListen, err)))
+		}
+
+		server := NewControlServer(conf, token)
+		if serveWeb != "" {
+			cert, fingerprint, err := webCertificate()
+			if err != nil {
+				exit(failed("serve", fmt.Errorf("creating the progress page's certificate: %w", err)))
+			}
+			fmt.Fprintf(os.Stderr, "Progress page certificate SHA-256 fingerprint: %s\n", fingerprint)
+
+			if webToken == "" {
+				if webToken, err = generatePassword(); err != nil {
+					exit(failed("serve", fmt.Errorf("generating the web token: %w", err)))
+				}
+				// On the terminal only, not in the log file or the log
+				// stream of the control API.
+				fmt.Fprintf(os.Stderr, "Kubeconfig download token: %s\n", webToken)
+			}
+			registerSecret(webToken)
+
+			go func() {
+				if err := server.ServeWeb(serveWeb, webToken, cert); err != nil {
+					exit(failed("serve", fmt.Errorf("progress page: %w", err)))
+				}
+			}()
+		}
+
+		if err := server.Serve(listener); err != nil {
+			exit(failed("serve", fmt.Errorf("control API: %w", err)))
+		}
+	},
+}
+
+var (
+	drainTimeout time.Duration
+	sshOptions   SSHOptions
+)
+
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Manage the membership of cluster nodes",
+}
+
+var nodeCordonCmd = &cobra.Command{
+	Use:   "cordon <name>",
+	Short: "Mark a node unschedulable",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := CordonNode(rootCtx, mustKubeClient(), args[0], true); err != nil {
+			exit(failed("node "+args[0], fmt.Errorf("cordoning: %w", err)))
+		}
+	},
+}
+
+var nodeUncordonCmd = &cobra.Command{
+	Use:   "uncordon <name>",
+	Short: "Mark a node schedulable again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := CordonNode(rootCtx, mustKubeClient(), args[0], false); err != nil {
+			exit(failed("node "+args[0], fmt.Errorf("uncordoning: %w", err)))
+		}
+	},
+}
+
+var nodeDrainCmd = &cobra.Command{
+	Use:   "drain <name>",
+	Short: "Cordon a node and evict its pods",
+	Long: `Drain cordons the node and evicts every pod on it except DaemonSet and
+static pods. Evictions go through the eviction API, so PodDisruptionBudgets are
+respected and blocked evictions are retried until the timeout. Data in emptyDir
+volumes of evicted pods is lost.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := DrainNode(rootCtx, mustKubeClient(), args[0], drainTimeout); err != nil {
+			exit(failed("node "+args[0], fmt.Errorf("draining: %w", err)))
+		}
+	},
+}
+
+var nodeRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Drain a node, reset it and delete it from the cluster",
+	Long: `Remove drains the node, runs kubeadm reset on it over SSH at its internal
+IP and deletes the Node. The node's host key has to be in the known hosts file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := RemoveNode(rootCtx, mustKubeClient(), args[0], drainTimeout, sshOptions); err != nil {
+			exit(failed("node "+args[0], fmt.Errorf("removing: %w", err)))
+		}
+	},
+}
+
+var (
+	fleetOut         string
+	fleetConcurrency int
+)
+
+var fleetCmd = &cobra.Command{
+	Use:   "fleet",
+	Short: "Bootstrap many independent clusters at once",
+}
+
+var fleetApplyCmd = &cobra.Command{
+	Use:   "apply <manifest>",
+	Short: "Bootstrap every site of a fleet manifest over SSH",
+	Long: `Apply copies orsted and each site's config to its host over SSH and runs
+the bootstrap there, several sites at a time. Every site's log and bootstrap
+report are saved to the output directory, along with fleet.json summarizing
+all of them. Apply fails when any site failed.
+
+The manifest lists the sites, with configs relative to the manifest:
+
+  concurrency: 5
+  sites:
+  - name: store-042
+    host: 10.42.0.10
+    config: sites/store-042.yaml
+    # the site's own key when its config is SOPS encrypted, the host
+    # decrypts it with sops
+    ageKey: sites/store-042.agekey`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configureAgeKey()
+
+		fleet, err := LoadFleet(args[0])
+		if err != nil {
+			exit(failedWith(ExitConfig, "fleet", fmt.Errorf("loading %s: %w", args[0], err)))
+		}
+		if fleetConcurrency > 0 {
+			fleet.Concurrency = fleetConcurrency
+		}
+
+		if err := ApplyFleet(fleet, sshOptions, fleetOut); err != nil {
+			exit(failed("fleet", err))
+		}
+	},
+}
+
+var bakeCmd = &cobra.Command{
+	Use:   "bake",
+	Short: "Prepare a machine image that bootstraps on its first boot",
+	Long: `Bake does everything a bootstrap can ahead of time while building an image,
+e.g. from a Packer shell provisioner, without initializing a cluster: it
+installs the Kubernetes packages and CRI-O, pulls the kubeadm images and those
+of every release, saves the charts to /var/lib/orsted/charts, which bootstrap
+installs them from, and enables the orsted-firstboot unit.
+
+The unit, which install-service describes, runs the bootstrap with --config on
+the machine booted from the image until it succeeds. The config baked with only
+picks what is pulled and saved, the one at --config on first boot, written by
+cloud-init for example, is the one bootstrapped with.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mustBeRoot("bake")
+
+		if err := Bake(rootCtx, mustLoadConfig(), configPath); err != nil {
+			exit(err)
+		}
+	},
+}
+
+var serviceOptions ServiceOptions
+
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service",
+	Short: "Install a systemd unit bootstrapping the host on boot",
+	Long: `Install-service writes and enables orsted-firstboot.service, a oneshot unit
+running the bootstrap with --config once the network is online, logging to the
+journal as orsted-firstboot.
+
+A failed bootstrap is retried after --retry-delay, up to --retries times, with
+the half initialized cluster reset first. Bootstraps failing on the config or
+a preflight check, exit codes 3 and 4, aren't retried. Once the retries are
+used up the --on-failure unit, if any, is started, and the next boot tries
+again. When a bootstrap succeeds /var/lib/orsted/bootstrapped is created and
+the unit doesn't run anymore.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mustBeRoot("install-service")
+
+		if err := InstallService(rootCtx, configPath, serviceOptions); err != nil {
+			exit(failed("firstboot", err))
+		}
+	},
+}
+
+var renderOut string
+
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Write the kubeadm config, values and manifests a bootstrap would apply",
+	Long: `Render writes everything the bootstrap would install to --out, for reviewing,
+diffing between versions or committing to a GitOps repo:
+
+  kubeadm/clusterconfig.yaml  the generated kubeadm config
+  values/<release>.yaml       the values of every release
+  charts/<release>.yaml       the rendered manifests of every release
+  manifests/<name>.yaml       the manifests orsted applies itself
+
+No cluster is needed. Manifests fetched when applying, like the Gateway API
+CRDs, aren't included. The values and manifests hold the config's secrets in
+plain text, encrypt them before committing them anywhere.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Render(rootCtx, mustLoadConfig(), renderOut); err != nil {
+			exit(failed("render", err))
+		}
+	},
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show what an upgrade would change on the live cluster",
+	Long: `Diff compares the configuration against the live cluster and prints unified
+diffs of what an upgrade would change: the chart version and values of every
+release, and the objects of every release and of the manifests orsted applies
+itself against the live ones, as kubectl diff shows them. Helm hooks aren't
+compared. Nothing is changed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		changed, err := Diff(rootCtx, os.Stdout, mustLoadConfig())
+		if err != nil {
+			exit(failed("diff", err))
+		}
+
+		if !changed {
+			fmt.Println("No changes")
+		}
+	},
+}
+
+var gcYes bool
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete what orsted installed that the config no longer deploys",
+	Long: `GC finds the objects labeled as managed by orsted that neither a release nor a
+manifest of the config deploys anymore, like the releases of removed addons or
+of renamed releases, lists them and deletes them once confirmed. Releases are
+uninstalled with all their objects, namespaces are deleted last and only when
+no release of the config is installed in them.
+
+Objects applied from manifests orsted fetches rather than renders, like the
+Gateway API CRDs, are never collected. --yes deletes without asking.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := CollectGarbage(rootCtx, mustLoadConfig(), os.Stdin, os.Stdout, gcYes); err != nil {
+			exit(failed("gc", err))
+		}
+	},
+}
+
+var benchDuration time.Duration
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure the performance of the cluster",
+}
+
+var benchNetworkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Measure pod-to-pod and pod-to-node throughput with iperf3",
+	Long: `Network runs an iperf3 server in a pod and one on the host network of a node,
+and measures the TCP throughput, retransmits and mean round trip time to each
+from a client pod, for validating the Cilium datapath and the overhead of
+WireGuard or IPsec encryption on the hardware at hand. On clusters with more
+than one node the client runs on another node than the servers where it can.
+
+The results are printed and recorded in the report of the last bootstrap,
+/var/lib/orsted/report.json, replacing those of the previous run.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		results, err := BenchNetwork(rootCtx, mustKubeClient(), mustLoadConfig(), benchDuration)
+		if err != nil {
+			exit(failed("bench", err))
+		}
+
+		printNetworkBenchmarks(cmd.OutOrStdout(), results)
+		if err := RecordNetworkBenchmarks(ReportPath, results); err != nil {
+			log.Printf("Failed to record the results in the report: %s\n", err)
+		}
+	},
+}
+
+var removeOptions RemoveOptions
+
+var addonCmd = &cobra.Command{
+	Use:   "addon",
+	Short: "Manage individual addons",
+}
+
+var addonRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Uninstall a single addon",
+	Long: `Remove deletes the extra manifests of an addon and uninstalls its release,
+waiting for its resources to be gone. The core stack, Cilium, the policy
+engine, Rook Ceph and Weave GitOps, can't be removed, and neither can an addon
+another installed addon requires, like Flux while Gitea is installed.
+
+Helm leaves CRDs behind, --crds deletes those of the chart along with all
+their custom resources. --namespace deletes the release's namespace unless
+another release lives in it.
+
+An addon still enabled in the config is installed again by the next upgrade,
+disable it there too.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := RemoveAddon(rootCtx, mustLoadConfig(), args[0], removeOptions); err != nil {
+			exit(failed(args[0], fmt.Errorf("removing: %w", err)))
+		}
+	},
+}
+
+var addonEnableCmd = &cobra.Command{
+	Use:   "enable <name>",
+	Short: "Install an addon on the existing cluster",
+	Long: `Enable installs an addon with the versions and values of the config, along
+with the addons it requires, whether the config enables it or not. It stays
+enabled for later upgrades, recorded in /var/lib/orsted/addons.yaml, which
+overrides the addons the config enables.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := EnableAddon(rootCtx, mustLoadConfig(), args[0]); err != nil {
+			exit(err)
+		}
+	},
+}
+
+var addonDisableCmd = &cobra.Command{
+	Use:   "disable <name>",
+	Short: "Uninstall an addon, keeping its data",
+	Long: `Disable uninstalls an addon like remove does, keeping its CRDs and namespace
+and with them the volumes of its StatefulSets. It stays disabled for
+later upgrades, recorded in /var/lib/orsted/addons.yaml, which overrides the
+addons the config enables.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := DisableAddon(rootCtx, mustLoadConfig(), args[0]); err != nil {
+			exit(failed(args[0], fmt.Errorf("disabling: %w", err)))
+		}
+	},
+}
+
+var vclusterCmd = &cobra.Command{
+	Use:   "vcluster",
+	Short: "Manage virtual clusters for tenants",
+}
+
+var vclusterCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Install a virtual cluster and write its kubeconfig",
+	Long: `Create installs a vcluster release in the vcluster-<name> namespace of the
+host cluster, isolated with a resource quota, a network policy and the
+baseline pod security standard. Its kubeconfig, reaching the virtual API
+server on a node port, is written to the vcluster kubeconfigDir. Running it
+again for an existing name upgrades the release and rewrites the kubeconfig.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := CreateVCluster(rootCtx, mustLoadConfig(), args[0])
+		if err != nil {
+			exit(installFailed("vcluster "+args[0], err))
+		}
+
+		log.Printf("vcluster %s is ready, its kubeconfig is %s\n", args[0], path)
+	},
+}
+
+var (
+	credentialOptions CredentialOptions
+	credentialsOut    string
+)
+
+var credentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Issue scoped credentials for the cluster",
+}
+
+var credentialsIssueCmd = &cobra.Command{
+	Use:   "issue",
+	Short: "Create a ServiceAccount for a pipeline and write its kubeconfig",
+	Long: `Issue creates a ServiceAccount in --namespace, ci-<role> unless --name is given,
+binds it to --role in that namespace only and writes a kubeconfig with a token
+of it expiring after --ttl, so CI pipelines don't need the admin kubeconfig:
+
+  viewer    read only, the view ClusterRole
+  deployer  manage workloads, the edit ClusterRole
+  admin     also manage Roles and RoleBindings, the admin ClusterRole
+
+The kubeconfig is written to --out, or stdout. Issuing again for the same name
+renews the token, earlier tokens stay valid until they expire unless the
+ServiceAccount is deleted.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := IssueCredentials(rootCtx, credentialOptions, credentialsOut); err != nil {
+			exit(failed("credentials", err))
+		}
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Create and check the config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config for mistakes before bootstrapping",
+	Long: `Validate checks the config file, decrypting it when SOPS encrypted, and lists
+each problem with its line: unknown keys and values of the wrong type, invalid
+CIDRs and addresses, options that conflict, like both dex and keycloak, and
+credentials enabled addons need but lack. A bootstrap validates the config
+first and doesn't start when there are problems.
+
+It exits 3 when there are problems.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mustValidateConfig()
+		fmt.Printf("%s is valid\n", configPath)
+	},
+}
+
+var (
+	initProfile string
+	initForce   bool
+)
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a commented default config for this host",
+	Long: `Init writes a config to --config with every section and its defaults, most of
+them commented out, for the --profile sysctl profile: default, storage-heavy or
+network-heavy. It notes what it finds on the host, its interfaces for L2
+announcements and egress gateways and the empty disks Rook Ceph would claim,
+along with the CPUs, memory and disk the profile needs.
+
+An existing config is only replaced with --force.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := InitConfig(rootCtx, configPath, initProfile, initForce); err != nil {
+			exit(failedWith(ExitConfig, "config", err))
+		}
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON schema of the config file",
+	Long: `Schema prints the JSON schema of the config file, which editors use to complete
+and check it. It is published as orsted.schema.json, point the YAML language
+server at it with a comment at the top of the config:
+
+  # yaml-language-server: $schema=` + SchemaID,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := json.MarshalIndent(ConfigSchema(), "", "  ")
+		if err != nil {
+			exit(failed("config", fmt.Errorf("encoding the schema: %w", err)))
+		}
+
+		fmt.Println(string(data))
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", ConfigPath, "path to the orsted config file")
+	rootCmd.PersistentFlags().BoolVar(&prettyOutput, "pretty", false, "print phase headers, outcomes and durations for interactive runs")
+	rootCmd.PersistentFlags().StringVar(&kubeconfigFlag, "kubeconfig", "", "kubeconfig to reach the cluster with, overriding kubernetes.kubeconfig")
+	rootCmd.PersistentFlags().StringVar(&ageKeyPath, "age-key", "", "age key decrypting SOPS encrypted config and values files")
+
+	for _, cmd := range []*cobra.Command{rootCmd, preflightCmd} {
+		cmd.Flags().BoolVar(&preflightFix, "fix", false, "fix the problems preflight finds where possible")
+	}
+	rootCmd.Flags().BoolVar(&devKind, "dev-kind", false, "bootstrap onto a local kind cluster instead of the host")
+	rootCmd.Flags().BoolVar(&jsonOutput, "json", false, "print only a JSON result on stdout, for automation")
+	rootCmd.AddCommand(preflightCmd)
+	rootCmd.AddCommand(checkUpdatesCmd)
+	upgradeCmd.Flags().BoolVar(&upgradePlan, "plan", false, "print what the upgrade would change without applying it")
+	rootCmd.AddCommand(upgradeCmd)
+
+	sbomCmd.Flags().StringVar(&sbomFormat, "format", "cyclonedx", "SBOM format, cyclonedx or spdx")
+	rootCmd.AddCommand(sbomCmd)
+
+	selfUpdateCmd.Flags().StringVar(&updateChannel, "channel", "stable", "release channel, stable or edge")
+	selfUpdateCmd.Flags().StringVar(&updateReleasesURL, "releases-url", ReleasesURL, "Gitea API URL listing orsted releases")
+	selfUpdateCmd.Flags().BoolVar(&updateForce, "force", false, "install the release even if it is older than the running version")
+	selfUpdateCmd.Flags().BoolVar(&updateSkipSig, "insecure-skip-signature", false, "install the release unverified when orsted was built without a release public key")
+	rootCmd.AddCommand(selfUpdateCmd)
+
+	serveCmd.Flags().StringVar(&serveListen, "listen", "/run/orsted.sock", "Unix socket path or host:port to serve on")
+	serveCmd.Flags().StringVar(&serveTokenFile, "token-file", "", "file with the bearer token calls to the control API have to carry, required on TCP")
+	serveCmd.Flags().StringVar(&serveWeb, "web", "", "host:port to serve the progress page on, disabled when empty")
+	serveCmd.Flags().StringVar(&webToken, "web-token", "", "token for downloading the kubeconfig from the progress page")
+	rootCmd.AddCommand(serveCmd)
+
+	imagesCmd.AddCommand(imagesLockCmd)
+	rootCmd.AddCommand(imagesCmd)
+
+	for _, cmd := range []*cobra.Command{nodeDrainCmd, nodeRemoveCmd} {
+		cmd.Flags().DurationVar(&drainTimeout, "timeout", time.Minute*5, "how long to wait for pods to be evicted")
+	}
+	for _, cmd := range []*cobra.Command{nodeRemoveCmd, fleetApplyCmd} {
+		cmd.Flags().StringVar(&sshOptions.User, "ssh-user", "root", "user to log into the host as")
+		cmd.Flags().IntVar(&sshOptions.Port, "ssh-port", 22, "SSH port of the host")
+		cmd.Flags().StringVar(&sshOptions.KeyFile, "ssh-key", defaultSSHKey(), "private key to log in with")
+		cmd.Flags().StringVar(&sshOptions.KnownHosts, "known-hosts", defaultKnownHosts(), "known hosts file with the host's key")
+	}
+	nodeCmd.AddCommand(nodeCordonCmd, nodeUncordonCmd, nodeDrainCmd, nodeRemoveCmd)
+	rootCmd.AddCommand(nodeCmd)
+
+	fleetApplyCmd.Flags().StringVar(&fleetOut, "out", "fleet", "directory to save the sites' logs and reports to")
+	fleetApplyCmd.Flags().IntVar(&fleetConcurrency, "concurrency", 0, "how many sites to bootstrap at once, overriding the manifest")
+	fleetCmd.AddCommand(fleetApplyCmd)
+	rootCmd.AddCommand(fleetCmd)
+
+	rootCmd.AddCommand(diffCmd)
+
+	gcCmd.Flags().BoolVar(&gcYes, "yes", false, "delete without asking for confirmation")
+	rootCmd.AddCommand(gcCmd)
+
+	benchNetworkCmd.Flags().DurationVar(&benchDuration, "duration", time.Second*10, "how long each iperf3 test runs")
+	benchCmd.AddCommand(benchNetworkCmd)
+	rootCmd.AddCommand(benchCmd)
+	rootCmd.AddCommand(bakeCmd)
+
+	installServiceCmd.Flags().IntVar(&serviceOptions.Retries, "retries", DefaultServiceOptions.Retries, "how many times to retry a failed bootstrap before the next boot")
+	installServiceCmd.Flags().DurationVar(&serviceOptions.RetryDelay, "retry-delay", DefaultServiceOptions.RetryDelay, "how long to wait before retrying")
+	installServiceCmd.Flags().DurationVar(&serviceOptions.Timeout, "timeout", DefaultServiceOptions.Timeout, "how long a bootstrap may take before it is failed")
+	installServiceCmd.Flags().StringVar(&serviceOptions.OnFailure, "on-failure", "", "unit to start once the retries are used up")
+	rootCmd.AddCommand(installServiceCmd)
+
+	renderCmd.Flags().StringVar(&renderOut, "out", "rendered", "directory to write the rendered files to")
+	rootCmd.AddCommand(renderCmd)
+
+	addonRemoveCmd.Flags().BoolVar(&removeOptions.CRDs, "crds", false, "also delete the CRDs of the chart and all their resources")
+	addonRemoveCmd.Flags().BoolVar(&removeOptions.Namespace, "namespace", false, "also delete the release's namespace")
+	addonCmd.AddCommand(addonRemoveCmd, addonEnableCmd, addonDisableCmd)
+	rootCmd.AddCommand(addonCmd)
+
+	vclusterCmd.AddCommand(vclusterCreateCmd)
+	rootCmd.AddCommand(vclusterCmd)
+
+	credentialsIssueCmd.Flags().StringVar(&credentialOptions.Role, "role", "deployer", "role to bind, viewer, deployer or admin")
+	credentialsIssueCmd.Flags().StringVar(&credentialOptions.Namespace, "namespace", "default", "namespace the role is bound in")
+	credentialsIssueCmd.Flags().StringVar(&credentialOptions.Name, "name", "", "name of the ServiceAccount, ci-<role> when empty")
+	credentialsIssueCmd.Flags().DurationVar(&credentialOptions.TTL, "ttl", time.Hour*24, "how long the token is valid")
+	credentialsIssueCmd.Flags().StringVar(&credentialsOut, "out", "", "file to write the kubeconfig to, stdout when empty")
+	credentialsCmd.AddCommand(credentialsIssueCmd)
+	rootCmd.AddCommand(credentialsCmd)
+
+	configInitCmd.Flags().StringVar(&initProfile, "profile", "default", "sysctl profile to write the config for")
+	configInitCmd.Flags().BoolVar(&initForce, "force", false, "replace an existing config")
+	configCmd.AddCommand(configInitCmd, configValidateCmd, configSchemaCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func mustKubeClient() *kubernetes.Clientset {
+	client, err := KubeClient()
+	if err != nil {
+		exit(failed("kubernetes", err))
+	}
+
+	return client
+}
+
+// mustValidateConfig exits listing the problems of the config file, if it
+// has any.
+func mustValidateConfig() {
+	configureAgeKey()
+
+	problems, err := ValidateConfig(configPath)
+	if err != nil {
+		exit(failedWith(ExitConfig, "config", fmt.Errorf("validating: %w", err)))
+	}
+	if len(problems) > 0 {
+		fmt.Fprint(os.Stderr, FormatProblems(configPath, problems))
+		exit(failedWith(ExitConfig, "config", fmt.Errorf("%d problems in %s", len(problems), configPath)))
+	}
+}
+
+func mustLoadConfig() *Config {
+	configureAgeKey()
+
+	conf, err := LoadConfig(configPath)
+	if err != nil {
+		exit(failedWith(ExitConfig, "config", fmt.Errorf("loading: %w", err)))
+	}
+	applyKubeconfig(conf)
+
+	return conf
+}
+
+// mustBeRoot exits unless running as root.
+func mustBeRoot(what string) {
+	if err := requireRoot(what); err != nil {
+		exit(failed("host", err))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Generate the shell completion script",
+	Long: `Completion prints the completion script of the shell, generated from the
+command tree. It completes commands and flags, addon names for the addon
+commands and profile names for config init. Load it for the current shell
+with:
+
+  source <(orsted completion bash)
+  source <(orsted completion zsh)
+  orsted completion fish | source
+
+or install it for every shell, e.g.
+
+  orsted completion bash > /etc/bash_completion.d/orsted
+  orsted completion zsh > "${fpath[1]}/_orsted"
+  orsted completion fish > ~/.config/fish/completions/orsted.fish`,
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = cmd.Root().GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			err = cmd.Root().GenZshCompletion(os.Stdout)
+		case "fish":
+			err = cmd.Root().GenFishCompletion(os.Stdout, true)
+		}
+		if err != nil {
+			exit(failed("completion", fmt.Errorf("generating the %s completion: %w", args[0], err)))
+		}
+	},
+}
+
+// completing reports whether cmd only prints a completion script or
+// completions, which run on every tab and shouldn't leave logs behind.
+func completing(cmd *cobra.Command) bool {
+	return cmd == completionCmd || cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd
+}
+
+// completeAddon completes the first argument with the addon names.
+func completeAddon(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	// A config that doesn't load still completes the built-in addons.
+	conf, err := LoadConfig(configPath)
+	if err != nil {
+		conf = DefaultConfig()
+	}
+
+	return addonNames(conf), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeProfile(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	profiles := make([]string, 0, len(sysctlProfiles))
+	for profile := range sysctlProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+
+	return profiles, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completePhase(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return Phases, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeRole(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return CredentialRoles(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(completionCmd)
+
+	for _, cmd := range []*cobra.Command{addonRemoveCmd, addonEnableCmd, addonDisableCmd} {
+		cmd.ValidArgsFunction = completeAddon
+	}
+	configInitCmd.RegisterFlagCompletionFunc("profile", completeProfile)
+	credentialsIssueCmd.RegisterFlagCompletionFunc("role", completeRole)
+}
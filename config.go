@@ -0,0 +1,1177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigPath is where the orsted configuration is read from. A missing file
+// is not an error, the defaults below are used instead.
+const ConfigPath = "/root/orsted.yaml"
+
+type Config struct {
+	Tracing       TracingConfig       `json:"tracing"`
+	Telemetry     TelemetryConfig     `json:"telemetry"`
+	Notifications NotificationsConfig `json:"notifications"`
+	Kubernetes    KubernetesConfig    `json:"kubernetes"`
+	Cloud         CloudConfig         `json:"cloud"`
+	Network       NetworkConfig       `json:"network"`
+	Kubelet       KubeletConfig       `json:"kubelet"`
+	ControlPlane  ControlPlaneConfig  `json:"controlPlane"`
+	Kubeadm       KubeadmConfig       `json:"kubeadm"`
+	Etcd          EtcdConfig          `json:"etcd"`
+	Sysctl        SysctlConfig        `json:"sysctl"`
+	Hugepages     HugepagesConfig     `json:"hugepages"`
+	OSUpdates     OSUpdatesConfig     `json:"osUpdates"`
+	Provision     ProvisionConfig     `json:"provision"`
+	Namespaces    []NamespaceConfig   `json:"namespaces,omitempty"`
+	Tenants       []TenantConfig      `json:"tenants,omitempty"`
+	Resources     ResourcesConfig     `json:"resources"`
+	Helm          HelmConfig          `json:"helm"`
+	Images        ImagesConfig        `json:"images"`
+	Scheduling    SchedulingConfig    `json:"scheduling,omitempty"`
+	Policies      PoliciesConfig      `json:"policies"`
+	Catalog       CatalogConfig       `json:"catalog"`
+	Addons        AddonsConfig        `json:"addons"`
+	VCluster      VClusterConfig      `json:"vcluster"`
+
+	// catalogAddons are the addons of the catalogs of Catalog, loaded
+	// along with the config.
+	catalogAddons []Addon
+}
+
+// KubeletConfig holds the KubeletConfiguration fields orsted sets, each
+// named as in the KubeletConfiguration and left to kubeadm's default when
+// unset. Reserved resources are maps like {cpu: 500m, memory: 1Gi} and
+// durations Go durations like 30s.
+type KubeletConfig struct {
+	MaxPods                         int               `json:"maxPods,omitempty"`
+	SystemReserved                  map[string]string `json:"systemReserved,omitempty"`
+	KubeReserved                    map[string]string `json:"kubeReserved,omitempty"`
+	EvictionHard                    map[string]string `json:"evictionHard,omitempty"`
+	ShutdownGracePeriod             string            `json:"shutdownGracePeriod,omitempty"`
+	ShutdownGracePeriodCriticalPods string            `json:"shutdownGracePeriodCriticalPods,omitempty"`
+	SerializeImagePulls             *bool             `json:"serializeImagePulls,omitempty"`
+	MaxParallelImagePulls           int               `json:"maxParallelImagePulls,omitempty"`
+
+	// CPUManagerPolicy static gives Guaranteed pods with integer CPU
+	// requests exclusive cores. It needs CPU reserved through
+	// ReservedSystemCPUs, e.g. "0,1", or the reserved resources above.
+	CPUManagerPolicy        string            `json:"cpuManagerPolicy,omitempty"`
+	CPUManagerPolicyOptions map[string]string `json:"cpuManagerPolicyOptions,omitempty"`
+	ReservedSystemCPUs      string            `json:"reservedSystemCPUs,omitempty"`
+	// MemoryManagerPolicy Static needs ReservedMemory per NUMA node adding
+	// up to the reserved memory and the memory.available eviction threshold.
+	MemoryManagerPolicy   string               `json:"memoryManagerPolicy,omitempty"`
+	ReservedMemory        []NUMAReservedMemory `json:"reservedMemory,omitempty"`
+	TopologyManagerPolicy string               `json:"topologyManagerPolicy,omitempty"`
+	TopologyManagerScope  string               `json:"topologyManagerScope,omitempty"`
+}
+
+type NUMAReservedMemory struct {
+	NUMANode int32             `json:"numaNode"`
+	Limits   map[string]string `json:"limits"`
+}
+
+// ControlPlaneConfig passes extra flags to the control plane components
+// kubeadm runs. FeatureGates are enabled on all of them and the kubelet.
+type ControlPlaneConfig struct {
+	APIServer         ComponentConfig `json:"apiServer"`
+	ControllerManager ComponentConfig `json:"controllerManager"`
+	Scheduler         ComponentConfig `json:"scheduler"`
+	FeatureGates      map[string]bool `json:"featureGates,omitempty"`
+}
+
+// KubeadmConfig passes settings orsted doesn't model straight to kubeadm.
+// PatchesDir holds patches in kubeadm's --patches format, e.g.
+// etcd+strategic.yaml or kubeletconfiguration+merge.yaml. SkipPhases are
+// kubeadm init phases not to run, e.g. addon/kube-proxy.
+type KubeadmConfig struct {
+	PatchesDir string   `json:"patchesDir,omitempty"`
+	SkipPhases []string `json:"skipPhases,omitempty"`
+}
+
+// EtcdConfig uses an External etcd cluster when it has endpoints, rather
+// than a stacked member on the control plane node.
+type EtcdConfig struct {
+	External ExternalEtcd `json:"external"`
+}
+
+// ExternalEtcd lists the https client URLs of the members, and the CA and
+// client certificate files on the node the API server authenticates with.
+type ExternalEtcd struct {
+	Endpoints []string `json:"endpoints,omitempty"`
+	CAFile    string   `json:"caFile,omitempty"`
+	CertFile  string   `json:"certFile,omitempty"`
+	KeyFile   string   `json:"keyFile,omitempty"`
+}
+
+// ComponentConfig maps flag names, without the leading dashes, to values,
+// e.g. enable-admission-plugins: NodeRestriction,PodNodeSelector.
+type ComponentConfig struct {
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+}
+
+// NetworkConfig sets the pod and service CIDRs, one per IP family and the
+// primary family first. Listing an IPv4 and an IPv6 CIDR makes the cluster
+// dual-stack. Left empty, the networking of the kubeadm config and the
+// IPv4 pool of the Cilium values are used as they are.
+type NetworkConfig struct {
+	PodCIDRs     []string  `json:"podCIDRs,omitempty"`
+	ServiceCIDRs []string  `json:"serviceCIDRs,omitempty"`
+	BGP          BGPConfig `json:"bgp"`
+
+	LoadBalancer LoadBalancerConfig `json:"loadBalancer"`
+
+	EgressGateways []EgressGateway `json:"egressGateways,omitempty"`
+
+	DNSCheck DNSCheckConfig `json:"dnsCheck"`
+}
+
+// DNSCheckConfig has a pod resolve kubernetes.default, External and
+// Records through CoreDNS once the network is up, failing bootstrap when
+// one doesn't. It is off by default as the pod pulls Image and resolves
+// External, air-gapped clusters enabling it leave External empty and
+// mirror Image.
+type DNSCheckConfig struct {
+	Enabled  bool        `json:"enabled"`
+	External string      `json:"external,omitempty"`
+	Records  []DNSRecord `json:"records,omitempty"`
+	Image    string      `json:"image"`
+}
+
+// DNSRecord is a name that has to resolve, to Address if it is set, like
+// a stub domain or rewrite of the CoreDNS config.
+type DNSRecord struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+}
+
+// BGPConfig peers Cilium's BGP control plane with upstream routers. The
+// addresses of LoadBalancer services are announced, along with the pod
+// CIDR when ExportPodCIDR is set.
+type BGPConfig struct {
+	Enabled       bool      `json:"enabled"`
+	LocalASN      int       `json:"localASN"`
+	Peers         []BGPPeer `json:"peers,omitempty"`
+	ExportPodCIDR bool      `json:"exportPodCIDR"`
+}
+
+type BGPPeer struct {
+	Address string `json:"address"`
+	ASN     int    `json:"asn"`
+}
+
+// LoadBalancerConfig has Cilium's LB-IPAM hand out addresses to services of
+// type LoadBalancer from Pools. They are reachable through BGP, or on flat
+// networks through L2 announcements.
+type LoadBalancerConfig struct {
+	Pools []LoadBalancerPool `json:"pools,omitempty"`
+	L2    L2Announcements    `json:"l2"`
+}
+
+// LoadBalancerPool serves the services matching ServiceSelector, or every
+// service when it is empty.
+type LoadBalancerPool struct {
+	Name            string            `json:"name"`
+	CIDRs           []string          `json:"cidrs"`
+	ServiceSelector map[string]string `json:"serviceSelector,omitempty"`
+}
+
+// EgressGateway sends the traffic of pods in Namespace matching PodSelector
+// to DestinationCIDRs, everywhere by default, out of a gateway node with
+// EgressIP as its source. EgressIP has to be assigned to an interface of
+// the nodes matching NodeSelector, alternatively Interface picks the
+// interface whose first address is used.
+type EgressGateway struct {
+	Name             string            `json:"name"`
+	Namespace        string            `json:"namespace,omitempty"`
+	PodSelector      map[string]string `json:"podSelector,omitempty"`
+	DestinationCIDRs []string          `json:"destinationCIDRs,omitempty"`
+	ExcludedCIDRs    []string          `json:"excludedCIDRs,omitempty"`
+	EgressIP         string            `json:"egressIP,omitempty"`
+	Interface        string            `json:"interface,omitempty"`
+	NodeSelector     map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// L2Announcements answers ARP and NDP requests for service addresses on
+// the node interfaces matching the Interfaces regexes, all of them by
+// default.
+type L2Announcements struct {
+	Enabled    bool     `json:"enabled"`
+	Interfaces []string `json:"interfaces,omitempty"`
+}
+
+type ImagesConfig struct {
+	// PinDigests replaces image tags in every rendered chart with the
+	// digests recorded in LockFile by `orsted images lock`, failing on
+	// images missing from it.
+	PinDigests bool   `json:"pinDigests"`
+	LockFile   string `json:"lockFile"`
+	// Mirrors maps a registry host to the prefix images from it are
+	// pulled through instead, e.g. quay.io: harbor.lan/quay.
+	Mirrors map[string]string `json:"mirrors,omitempty"`
+}
+
+// SchedulingConfig maps a release name to the scheduling and metadata
+// injected into the pods of its workloads, for clusters keeping the
+// control plane taints or labeling nodes by role. The entry "*" applies
+// to every release, the entry of a release is merged over it.
+type SchedulingConfig map[string]WorkloadConfig
+
+type WorkloadConfig struct {
+	Tolerations       []Toleration      `json:"tolerations,omitempty"`
+	NodeSelector      map[string]string `json:"nodeSelector,omitempty"`
+	PriorityClassName string            `json:"priorityClassName,omitempty"`
+	// Labels and Annotations are set on the workloads and their pods.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Toleration is a pod toleration, Operator Exists when Value is empty.
+type Toleration struct {
+	Key      string `json:"key,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Effect   string `json:"effect,omitempty"`
+}
+
+// NamespaceConfig is a namespace orsted creates and keeps the labels and
+// annotations of. Those of the core namespaces, rook-ceph and
+// weave-gitops, are merged into theirs. PodSecurity sets the Pod Security
+// Admission levels, privileged, baseline or restricted, of each mode.
+type NamespaceConfig struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	PodSecurity PodSecurityConfig `json:"podSecurity"`
+	// Quota and Limits are merged over those of resources, or are all a
+	// core namespace gets.
+	Quota  map[string]string `json:"quota,omitempty"`
+	Limits LimitsConfig      `json:"limits"`
+}
+
+// ResourcesConfig is the LimitRange and ResourceQuota of the namespaces of
+// the config and the tenants, so workloads without requests don't starve
+// Ceph and Cilium. Quota is the hard limits of the ResourceQuota, e.g.
+// requests.cpu: "4", none is created when it is empty. The core
+// namespaces, rook-ceph and weave-gitops, don't get them, their charts
+// size their own pods.
+type ResourcesConfig struct {
+	Limits LimitsConfig      `json:"limits"`
+	Quota  map[string]string `json:"quota,omitempty"`
+}
+
+// TenantConfig is a team given namespaces of its own, its name unless
+// Namespaces lists them, with everything it needs to start deploying
+// there. Admins, Members and Viewers are users, or groups written as
+// group:<name>, bound to the admin, edit and view ClusterRoles in them.
+// Quota and Limits are merged over those of resources in each of its
+// namespaces.
+type TenantConfig struct {
+	Name        string            `json:"name"`
+	Namespaces  []string          `json:"namespaces,omitempty"`
+	PodSecurity PodSecurityConfig `json:"podSecurity"`
+	Admins      []string          `json:"admins,omitempty"`
+	Members     []string          `json:"members,omitempty"`
+	Viewers     []string          `json:"viewers,omitempty"`
+	Quota       map[string]string `json:"quota,omitempty"`
+	Limits      LimitsConfig      `json:"limits"`
+	// Isolated only lets pods of the tenant's namespaces, and those of
+	// AllowFrom, reach its pods.
+	Isolated  bool               `json:"isolated"`
+	AllowFrom []string           `json:"allowFrom,omitempty"`
+	GitOps    TenantGitOpsConfig `json:"gitops"`
+}
+
+// LimitsConfig are the resources of containers in a namespace that don't
+// set their own, and the most one may ask for.
+type LimitsConfig struct {
+	DefaultRequest map[string]string `json:"defaultRequest,omitempty"`
+	Default        map[string]string `json:"default,omitempty"`
+	Max            map[string]string `json:"max,omitempty"`
+}
+
+// TenantGitOpsConfig has Flux sync Path of the Git repository at URL into
+// the first namespace of the tenant, with no more rights than its admins.
+// SecretRef names a secret in that namespace with the credentials of the
+// repository, if it needs any.
+type TenantGitOpsConfig struct {
+	URL       string `json:"url,omitempty"`
+	Branch    string `json:"branch,omitempty"`
+	Path      string `json:"path,omitempty"`
+	SecretRef string `json:"secretRef,omitempty"`
+	Interval  string `json:"interval,omitempty"`
+}
+
+type PodSecurityConfig struct {
+	Enforce string `json:"enforce,omitempty"`
+	Audit   string `json:"audit,omitempty"`
+	Warn    string `json:"warn,omitempty"`
+}
+
+type HelmConfig struct {
+	// MaxHistory is the number of revisions kept per release, older
+	// release secrets are pruned after every bootstrap and upgrade.
+	MaxHistory int `json:"maxHistory"`
+	// RunTests runs the `helm test` hooks of every release once the stack
+	// is installed and records the results in the report.
+	RunTests bool `json:"runTests"`
+	// Values maps a release name to a values file merged over the values
+	// orsted renders for it. The files may be SOPS encrypted, and may be
+	// fetched from an https URL, pinned with ?checksum=sha256:<hex>, or
+	// from a Git repository as git::<repo>//<file>?ref=<commit>.
+	Values map[string]string `json:"values,omitempty"`
+	// IndexMaxAge is how long a downloaded repository index is used before
+	// it is downloaded again, a Go duration like 1h. 0 always refreshes.
+	IndexMaxAge string `json:"indexMaxAge,omitempty"`
+}
+
+type TracingConfig struct {
+	// Endpoint is the host:port of an OTLP/HTTP collector. Tracing is
+	// disabled when empty.
+	Endpoint string `json:"endpoint"`
+	Insecure bool   `json:"insecure"`
+}
+
+// TelemetryConfig opts into sending the outcome, phase durations and
+// component versions of each bootstrap to Endpoint, a URL receiving them
+// as JSON. Nothing identifying the host or cluster is sent.
+type TelemetryConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Endpoint string `json:"endpoint"`
+}
+
+// NotificationsConfig sends failed bootstraps, with where their failure
+// bundle was written, as JSON to Webhook.
+type NotificationsConfig struct {
+	Webhook string `json:"webhook"`
+}
+
+// SysctlConfig picks the node's sysctl profile, default, storage-heavy or
+// network-heavy, and individual Settings overriding it.
+type SysctlConfig struct {
+	Profile  string            `json:"profile"`
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// HugepagesConfig reserves hugepages for pods requesting hugepages-2Mi or
+// hugepages-1Gi resources. With BootTime the pages are reserved on the
+// kernel command line, which requires a reboot the first time.
+type HugepagesConfig struct {
+	Pages    []Hugepages `json:"pages,omitempty"`
+	BootTime bool        `json:"bootTime"`
+}
+
+// Hugepages is a Count of pages of Size, 2Mi or 1Gi.
+type Hugepages struct {
+	Size  string `json:"size"`
+	Count int    `json:"count"`
+}
+
+// KubernetesConfig selects the Kubernetes version, a minor like v1.28 or a
+// patch like v1.28.2. The host's kubeadm and kubelet have to match it when
+// it is set, otherwise the installed kubeadm's version is used.
+// APITimeout is how long the control plane may take to be ready after
+// kubeadm init, a Go duration like 10m. Kubeconfig is the kubeconfig
+// orsted reaches the cluster with, which bootstrap copies the admin
+// kubeconfig to, /etc/kubernetes/admin.conf for root when empty.
+type KubernetesConfig struct {
+	Version    string `json:"version"`
+	APITimeout string `json:"apiTimeout,omitempty"`
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+}
+
+// CloudConfig integrates with the cloud the node runs on. Provider is aws,
+// gcp, hetzner or proxmox, detected from the host's firmware when empty,
+// and none turns detection off. With ControllerManager the kubelet runs
+// with an external cloud provider and the provider's
+// cloud-controller-manager and CSI driver are installed in place of Rook
+// Ceph, its StorageClass replacing ceph-block.
+type CloudConfig struct {
+	Provider          string       `json:"provider,omitempty"`
+	ControllerManager bool         `json:"controllerManager"`
+	Hetzner           HetznerCloud `json:"hetzner"`
+	GCP               GCPCloud     `json:"gcp"`
+	Proxmox           ProxmoxCloud `json:"proxmox"`
+}
+
+// HetznerCloud authenticates with an API Token of the project. Network is
+// the name or ID of the private network pods are routed through, if any.
+type HetznerCloud struct {
+	Token   Secret `json:"token"`
+	Network string `json:"network,omitempty"`
+}
+
+// GCPCloud holds the JSON key of the service account the PD CSI driver
+// manages disks with, the controller manager uses the node's own.
+type GCPCloud struct {
+	ServiceAccountKey Secret `json:"serviceAccountKey"`
+}
+
+// ProxmoxCloud is the Proxmox VE cluster the node is a VM of, reached at
+// URL, e.g. https://pve.lan:8006/api2/json, with an API token. Region names
+// the cluster and volumes are created on Storage, local-lvm by default.
+type ProxmoxCloud struct {
+	URL         string `json:"url"`
+	Insecure    bool   `json:"insecure"`
+	Region      string `json:"region"`
+	TokenID     string `json:"tokenID"`
+	TokenSecret Secret `json:"tokenSecret"`
+	Storage     string `json:"storage,omitempty"`
+}
+
+// ProvisionConfig installs kubeadm, kubelet, kubectl and CRI-O of the
+// configured Kubernetes version from the upstream pkgs.k8s.io repos when
+// they are missing from the host or don't match it. CRI-O follows the
+// Kubernetes minor.
+type ProvisionConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// OSUpdatesConfig enables unattended package updates on the host. Updates
+// never reboot the node themselves, that is left to kured.
+type OSUpdatesConfig struct {
+	Enabled bool `json:"enabled"`
+	// Schedule is a systemd OnCalendar expression for the maintenance
+	// window updates are installed in.
+	Schedule     string `json:"schedule"`
+	SecurityOnly bool   `json:"securityOnly"`
+}
+
+type PoliciesConfig struct {
+	// Engine is the admission policy engine, kyverno or gatekeeper.
+	Engine     string           `json:"engine"`
+	Gatekeeper GatekeeperConfig `json:"gatekeeper"`
+	// VerifyImages only admits pods whose images are signed by one of the
+	// given cosign keys or keyless identities.
+	VerifyImages []VerifyImagesPolicy `json:"verifyImages,omitempty"`
+	// ImageVerificationAction is Enforce to reject unsigned images or
+	// Audit to only report them.
+	ImageVerificationAction string `json:"imageVerificationAction"`
+	RekorURL                string `json:"rekorURL"`
+}
+
+type GatekeeperConfig struct {
+	Version       string `json:"version,omitempty"`
+	AuditInterval int    `json:"auditInterval"`
+	// Library is the kustomization of constraint templates installed with
+	// kubectl apply -k.
+	Library string `json:"library"`
+	// Constraints is a manifest of constraints using the library templates.
+	Constraints string `json:"constraints"`
+}
+
+type VerifyImagesPolicy struct {
+	Name string `json:"name"`
+	// ImageReferences are the image patterns the policy applies to, e.g.
+	// ghcr.io/example/*.
+	ImageReferences []string `json:"imageReferences"`
+	// Namespaces limits the policy to pods in these namespaces, all
+	// namespaces when empty.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// PublicKeys are PEM encoded cosign public keys.
+	PublicKeys []string          `json:"publicKeys,omitempty"`
+	Keyless    []KeylessIdentity `json:"keyless,omitempty"`
+}
+
+// KeylessIdentity is the Fulcio certificate identity a keyless signature
+// has to be made with.
+type KeylessIdentity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+type AddonsConfig struct {
+	Loki LokiConfig `json:"loki"`
+	VPA  VPAConfig  `json:"vpa"`
+
+	NodeProblemDetector NodeProblemDetectorConfig `json:"nodeProblemDetector"`
+	Kured               KuredConfig               `json:"kured"`
+	Descheduler         DeschedulerConfig         `json:"descheduler"`
+	TrivyOperator       TrivyOperatorConfig       `json:"trivyOperator"`
+	Falco               FalcoConfig               `json:"falco"`
+	Dex                 DexConfig                 `json:"dex"`
+	Keycloak            KeycloakConfig            `json:"keycloak"`
+	Vault               VaultConfig               `json:"vault"`
+	ExternalSecrets     ExternalSecretsConfig     `json:"externalSecrets"`
+	Harbor              HarborConfig              `json:"harbor"`
+	MinIO               MinIOConfig               `json:"minio"`
+	// Flux is enabled along with Gitea, which it syncs from.
+	Flux  AddonConfig `json:"flux"`
+	Gitea GiteaConfig `json:"gitea"`
+
+	Tailscale   TailscaleConfig   `json:"tailscale"`
+	Cloudflared CloudflaredConfig `json:"cloudflared"`
+	CertManager CertManagerConfig `json:"certManager"`
+	// Multus is enabled along with SR-IOV, which attaches VFs through it.
+	Multus MultusConfig `json:"multus"`
+	SRIOV  SRIOVConfig  `json:"sriov"`
+
+	Linkerd LinkerdConfig `json:"linkerd"`
+
+	ClusterAutoscaler ClusterAutoscalerConfig `json:"clusterAutoscaler"`
+
+	// Custom holds the settings of the addons of the config's catalogs
+	// that have no section above, by addon name.
+	Custom map[string]CustomAddonConfig `json:"custom,omitempty"`
+}
+
+// ClusterAutoscalerConfig scales the node groups of the cloud the cluster
+// runs on, which needs its cloud-controller-manager to set the provider
+// IDs of the nodes. Region, Zone and Project are read from the metadata
+// service of the node unless set: the AWS region, the location of new
+// Hetzner servers, and the zone and project of GCP instance groups.
+//
+// Without NodeGroups, the ASGs tagged k8s.io/cluster-autoscaler/<ClusterName>
+// are discovered on AWS. JoinCloudInit is a file on the node with the
+// cloud-init new Hetzner servers join the cluster with.
+type ClusterAutoscalerConfig struct {
+	AddonConfig
+	NodeGroups    []NodeGroup `json:"nodeGroups,omitempty"`
+	ClusterName   string      `json:"clusterName"`
+	Region        string      `json:"region,omitempty"`
+	Zone          string      `json:"zone,omitempty"`
+	Project       string      `json:"project,omitempty"`
+	JoinCloudInit string      `json:"joinCloudInit,omitempty"`
+	// Expander picks the group to grow, e.g. least-waste or random, and
+	// ScaleDownUnneededTime is how long a node stays unneeded before it
+	// is removed.
+	Expander              string `json:"expander"`
+	ScaleDownUnneededTime string `json:"scaleDownUnneededTime"`
+}
+
+// NodeGroup is an ASG on AWS, a managed instance group on GCP, or a pool
+// of servers of InstanceType, e.g. cx31, in Location on Hetzner.
+type NodeGroup struct {
+	Name         string `json:"name"`
+	Min          int    `json:"min"`
+	Max          int    `json:"max"`
+	InstanceType string `json:"instanceType,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+// MultusEnabled reports whether Multus is installed, either on its own or
+// for SR-IOV.
+func (a AddonsConfig) MultusEnabled() bool {
+	return a.Multus.Enabled || a.SRIOV.Enabled
+}
+
+// AddonConfig holds the settings shared by every optional addon.
+type AddonConfig struct {
+	Enabled bool   `json:"enabled"`
+	Version string `json:"version,omitempty"`
+}
+
+// CatalogConfig loads addon catalogs from Sources, files or https URLs of
+// AddonCatalog documents, adding their addons to the built-in ones or
+// replacing those of the same name.
+type CatalogConfig struct {
+	Sources []string `json:"sources,omitempty"`
+}
+
+// CustomAddonConfig enables an addon of a catalog, optionally overriding
+// its version constraint. Settings are what its values and manifests
+// templates see as .Settings.
+type CustomAddonConfig struct {
+	AddonConfig
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+type LokiConfig struct {
+	AddonConfig
+	Retention    string `json:"retention"`
+	StorageClass string `json:"storageClass"`
+	Size         string `json:"size"`
+	// Grafana deploys a bundled Grafana with Loki preconfigured as its
+	// datasource. When false only the datasource ConfigMap is created.
+	Grafana bool `json:"grafana"`
+}
+
+type VPAConfig struct {
+	AddonConfig
+	// Updater enables the updater and admission controller so pods are
+	// evicted and resized, otherwise only recommendations are produced.
+	Updater bool `json:"updater"`
+	// UpdateMode of the VerticalPodAutoscalers created for the bundled
+	// components.
+	UpdateMode string `json:"updateMode"`
+}
+
+type NodeProblemDetectorConfig struct {
+	AddonConfig
+	// Monitoring creates the ServiceMonitor and PrometheusRule, which
+	// requires the Prometheus operator CRDs to be present.
+	Monitoring bool `json:"monitoring"`
+}
+
+// KuredConfig sets when kured may drain and reboot the node after updates
+// have dropped the reboot sentinel.
+type KuredConfig struct {
+	AddonConfig
+	Period     string   `json:"period"`
+	RebootDays []string `json:"rebootDays"`
+	StartTime  string   `json:"startTime"`
+	EndTime    string   `json:"endTime"`
+	TimeZone   string   `json:"timeZone"`
+	Sentinel   string   `json:"sentinel"`
+	// SentinelCommand replaces the sentinel file check, e.g. for
+	// distributions that only expose `needs-restarting -r`.
+	SentinelCommand string `json:"sentinelCommand,omitempty"`
+	DrainTimeout    string `json:"drainTimeout"`
+}
+
+// DeschedulerConfig evicts the pods the scheduler would now place
+// elsewhere, those on nodes with taints they don't tolerate or affinities
+// they no longer match and duplicates of a workload on one node, once
+// more nodes have joined. Pods of the platform priority classes and with
+// local storage or volume claims are never evicted.
+type DeschedulerConfig struct {
+	AddonConfig
+	// Schedule is the cron schedule descheduling runs on.
+	Schedule            string `json:"schedule"`
+	MaxEvictionsPerNode int    `json:"maxEvictionsPerNode"`
+}
+
+// TrivyOperatorConfig controls the vulnerability and config audit reports
+// generated for every workload in the cluster.
+type TrivyOperatorConfig struct {
+	AddonConfig
+	// Severity is the comma separated list of severities reported.
+	Severity      string `json:"severity"`
+	IgnoreUnfixed bool   `json:"ignoreUnfixed"`
+	// ConfigAudit also produces config audit and RBAC assessment reports.
+	ConfigAudit             bool   `json:"configAudit"`
+	ScanJobsConcurrentLimit int    `json:"scanJobsConcurrentLimit"`
+	ReportTTL               string `json:"reportTTL"`
+	// Monitoring creates a ServiceMonitor for the report metrics, which
+	// requires the Prometheus operator CRDs to be present.
+	Monitoring bool `json:"monitoring"`
+}
+
+type FalcoConfig struct {
+	AddonConfig
+	// Rules maps a rules file name to its contents. The files are loaded
+	// after the default ruleset, so they can add exceptions to or override
+	// upstream rules.
+	Rules map[string]string `json:"rules,omitempty"`
+	// Sidekick deploys falcosidekick and its web UI to browse events.
+	Sidekick bool `json:"sidekick"`
+}
+
+// DexConfig sets up Dex as the OIDC provider the apiserver and Weave GitOps
+// accept logins from.
+type DexConfig struct {
+	AddonConfig
+	// Issuer is the public https URL Dex is reached at, the apiserver
+	// verifies tokens against it.
+	Issuer string `json:"issuer"`
+	// CAFile is a path on the node to the CA signing the issuer's
+	// certificate, if it isn't publicly trusted.
+	CAFile string `json:"caFile,omitempty"`
+	// IngressClass exposes the issuer through an Ingress of this class.
+	IngressClass  string `json:"ingressClass,omitempty"`
+	UsernameClaim string `json:"usernameClaim"`
+	GroupsClaim   string `json:"groupsClaim"`
+	// Connectors are passed to Dex as is, see
+	// https://dexidp.io/docs/connectors/ for the LDAP, GitHub and OIDC
+	// settings.
+	Connectors []DexConnector `json:"connectors"`
+	GitOps     OIDCClient     `json:"gitops"`
+}
+
+type DexConnector struct {
+	Type   string                 `json:"type"`
+	ID     string                 `json:"id"`
+	Name   string                 `json:"name"`
+	Config map[string]interface{} `json:"config"`
+}
+
+// OIDCClient is an OAuth2 client registered with the identity provider,
+// left out when it has no redirect URL.
+type OIDCClient struct {
+	RedirectURL  string `json:"redirectURL,omitempty"`
+	ClientSecret Secret `json:"clientSecret,omitempty"`
+}
+
+// KeycloakConfig runs Keycloak as a self contained identity provider with
+// a realm holding the cluster and GitOps clients.
+type KeycloakConfig struct {
+	AddonConfig
+	// Hostname Keycloak is served at over https by the ingress.
+	Hostname     string `json:"hostname"`
+	IngressClass string `json:"ingressClass"`
+	// CAFile is a path on the node to the CA signing the ingress
+	// certificate, if it isn't publicly trusted.
+	CAFile           string     `json:"caFile,omitempty"`
+	Realm            string     `json:"realm"`
+	AdminPassword    Secret     `json:"adminPassword"`
+	DatabasePassword Secret     `json:"databasePassword"`
+	StorageClass     string     `json:"storageClass"`
+	Size             string     `json:"size"`
+	UsernameClaim    string     `json:"usernameClaim"`
+	GitOps           OIDCClient `json:"gitops"`
+}
+
+// Issuer is the URL of the realm's OIDC endpoints.
+func (k KeycloakConfig) Issuer() string {
+	return "https://" + k.Hostname + "/realms/" + k.Realm
+}
+
+// VaultConfig runs a single Vault server with integrated raft storage and
+// the Kubernetes auth method enabled.
+type VaultConfig struct {
+	AddonConfig
+	StorageClass string `json:"storageClass"`
+	Size         string `json:"size"`
+	UI           bool   `json:"ui"`
+	// Injector deploys the agent injector mutating webhook.
+	Injector bool `json:"injector"`
+	// KeyShares and KeyThreshold split the unseal key, or the recovery key
+	// with an auto-unseal seal.
+	KeyShares    int `json:"keyShares"`
+	KeyThreshold int `json:"keyThreshold"`
+	// InitFile on the node receives the root token and keys when Vault is
+	// initialized, and is read back to unseal it.
+	InitFile string    `json:"initFile"`
+	Seal     VaultSeal `json:"seal"`
+}
+
+// VaultSeal configures auto-unseal, e.g. type transit, awskms or gcpckms,
+// with the seal stanza parameters as options. Shamir keys are used without
+// a type.
+type VaultSeal struct {
+	Type    string            `json:"type,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+type ExternalSecretsConfig struct {
+	AddonConfig
+	Stores []SecretStore `json:"stores,omitempty"`
+}
+
+// SecretStore becomes a ClusterSecretStore of the same name. Type is vault,
+// aws or sops and picks which of the sections below is used.
+type SecretStore struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Vault VaultStore      `json:"vault"`
+	AWS   AWSSecretsStore `json:"aws"`
+	SOPS  SOPSStore       `json:"sops"`
+}
+
+// inClusterVault is the address of the vault addon. Stores pointing at it
+// get their KV engine, policy and role set up by orsted.
+const inClusterVault = "http://vault.vault.svc:8200"
+
+// VaultStore defaults to the vault addon, a KV engine at secret and the
+// external-secrets role.
+type VaultStore struct {
+	Server string `json:"server,omitempty"`
+	// Path of the KV v2 engine.
+	Path string `json:"path,omitempty"`
+	// Role of the Kubernetes auth method ESO logs in with.
+	Role string `json:"role,omitempty"`
+}
+
+func (v VaultStore) withDefaults() VaultStore {
+	if v.Server == "" {
+		v.Server = inClusterVault
+	}
+	if v.Path == "" {
+		v.Path = "secret"
+	}
+	if v.Role == "" {
+		v.Role = "external-secrets"
+	}
+
+	return v
+}
+
+type AWSSecretsStore struct {
+	Region string `json:"region"`
+	// Without access keys the node's instance profile is used.
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	SecretAccessKey Secret `json:"secretAccessKey,omitempty"`
+}
+
+// SOPSStore serves the keys of a flat SOPS encrypted file, decrypted on the
+// node with the sops binary, through ESO's Kubernetes provider.
+type SOPSStore struct {
+	File string `json:"file"`
+}
+
+// HarborConfig runs Harbor as a local registry with proxy cache projects
+// CRI-O pulls through.
+type HarborConfig struct {
+	AddonConfig
+	// Hostname Harbor is reached at, the node's address by default.
+	Hostname      string `json:"hostname,omitempty"`
+	NodePort      int    `json:"nodePort"`
+	AdminPassword Secret `json:"adminPassword"`
+	// Storage is block for a ceph-block volume, or s3 for a bucket on the
+	// Ceph object store.
+	Storage      string `json:"storage"`
+	StorageClass string `json:"storageClass"`
+	Size         string `json:"size"`
+	// ProxyCache maps upstream registries to their URL. Each gets a proxy
+	// cache project named after it, e.g. docker-io.
+	ProxyCache map[string]string `json:"proxyCache"`
+	// MirrorCRIO configures CRI-O to pull through the proxy caches.
+	MirrorCRIO bool `json:"mirrorCRIO"`
+}
+
+// MinIOConfig runs a standalone MinIO as an in-cluster S3 endpoint, e.g.
+// for Loki and Velero.
+type MinIOConfig struct {
+	AddonConfig
+	StorageClass string   `json:"storageClass"`
+	Size         string   `json:"size"`
+	Buckets      []string `json:"buckets"`
+}
+
+// GiteaConfig runs Gitea as the GitOps source of disconnected clusters, with
+// Flux syncing Path of the Org/Repo repository.
+type GiteaConfig struct {
+	AddonConfig
+	AdminPassword Secret `json:"adminPassword"`
+	StorageClass  string `json:"storageClass"`
+	Size          string `json:"size"`
+	Org           string `json:"org"`
+	Repo          string `json:"repo"`
+	Path          string `json:"path"`
+}
+
+// TailscaleConfig joins the cluster to a tailnet through the Tailscale
+// operator, authenticated with an OAuth client allowed to create auth keys
+// for the tag:k8s-operator tag.
+type TailscaleConfig struct {
+	AddonConfig
+	ClientID     string `json:"clientID"`
+	ClientSecret Secret `json:"clientSecret"`
+	// Hostname of the operator, which also serves the API server proxy.
+	Hostname       string `json:"hostname"`
+	APIServerProxy bool   `json:"apiServerProxy"`
+	// Expose lists namespace/name of services, e.g. Gateway services,
+	// made reachable on the tailnet.
+	Expose []string `json:"expose,omitempty"`
+}
+
+// CloudflaredConfig runs a Cloudflare Tunnel created beforehand, e.g. with
+// `cloudflared tunnel create`, in front of a Gateway.
+type CloudflaredConfig struct {
+	AddonConfig
+	AccountTag   string `json:"accountTag"`
+	TunnelName   string `json:"tunnelName"`
+	TunnelID     string `json:"tunnelID"`
+	TunnelSecret Secret `json:"tunnelSecret"`
+	// Gateway is the namespace/name of the Gateway requests for Domains
+	// and their subdomains are forwarded to.
+	Gateway string   `json:"gateway"`
+	Domains []string `json:"domains"`
+	// APIToken, with DNS edit permission, lets orsted create records for
+	// the hostnames of HTTPRoutes matching RouteSelector.
+	APIToken      Secret `json:"apiToken,omitempty"`
+	RouteSelector string `json:"routeSelector"`
+}
+
+type CertManagerConfig struct {
+	AddonConfig
+	Issuers []ACMEIssuer `json:"issuers,omitempty"`
+}
+
+// MultusConfig installs the Multus thick plugin, whose Version is a
+// multus-cni release tag, so pods can attach the Networks below as
+// secondary interfaces next to Cilium.
+type MultusConfig struct {
+	AddonConfig
+	Networks []NetworkAttachment `json:"networks,omitempty"`
+}
+
+// NetworkAttachment is a NetworkAttachmentDefinition. Config is the CNI
+// configuration of the network, e.g. a macvlan or bridge plugin with its
+// IPAM, and gets its name and cniVersion filled in.
+type NetworkAttachment struct {
+	Name      string                 `json:"name"`
+	Namespace string                 `json:"namespace,omitempty"`
+	Config    map[string]interface{} `json:"config"`
+}
+
+// SRIOVConfig installs the SR-IOV network device plugin, whose Version is a
+// sriov-network-device-plugin release tag, and the SR-IOV CNI. The VFs of
+// Interfaces are created on the node and offered to pods as the
+// orsted.io/<resourceName> extended resource.
+type SRIOVConfig struct {
+	AddonConfig
+	CNIVersion string           `json:"cniVersion,omitempty"`
+	Interfaces []SRIOVInterface `json:"interfaces,omitempty"`
+}
+
+// SRIOVInterface is a physical function to split into NumVFs VFs. With a
+// DeviceType of vfio-pci the VFs are bound to vfio-pci for DPDK or VMs,
+// otherwise they keep their netdevice driver.
+type SRIOVInterface struct {
+	Name         string `json:"name"`
+	NumVFs       int    `json:"numVFs"`
+	DeviceType   string `json:"deviceType,omitempty"`
+	ResourceName string `json:"resourceName,omitempty"`
+	// Networks are attached through the VFs of this interface and default
+	// to the sriov CNI plugin.
+	Networks []NetworkAttachment `json:"networks,omitempty"`
+}
+
+// LinkerdConfig installs the Linkerd service mesh, Version being that of
+// the linkerd-control-plane chart. Pods in InjectNamespaces get a proxy and
+// talk mTLS to each other, orsted only annotates those namespaces and
+// doesn't create them. DefaultInboundPolicy is the proxies' policy for
+// traffic without a Server resource, see the Linkerd docs for the values.
+type LinkerdConfig struct {
+	AddonConfig
+	CRDsVersion          string   `json:"crdsVersion,omitempty"`
+	InjectNamespaces     []string `json:"injectNamespaces,omitempty"`
+	DefaultInboundPolicy string   `json:"defaultInboundPolicy,omitempty"`
+}
+
+// ACMEIssuer is an ACME ClusterIssuer solving DNS-01 challenges. Solver is
+// cloudflare, route53 or rfc2136 and picks which section below is used.
+type ACMEIssuer struct {
+	Name string `json:"name"`
+	// Server is the ACME directory, Let's Encrypt production by default.
+	Server string `json:"server,omitempty"`
+	Email  string `json:"email"`
+	// DNSZones limits the issuer to names in these zones.
+	DNSZones   []string        `json:"dnsZones,omitempty"`
+	Solver     string          `json:"solver"`
+	Cloudflare CloudflareDNS01 `json:"cloudflare"`
+	Route53    Route53DNS01    `json:"route53"`
+	RFC2136    RFC2136DNS01    `json:"rfc2136"`
+}
+
+type CloudflareDNS01 struct {
+	// APIToken needs Zone:Read and DNS:Edit permissions.
+	APIToken Secret `json:"apiToken"`
+}
+
+type Route53DNS01 struct {
+	Region       string `json:"region"`
+	HostedZoneID string `json:"hostedZoneID,omitempty"`
+	// Without access keys the node's instance profile is used.
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	SecretAccessKey Secret `json:"secretAccessKey,omitempty"`
+}
+
+type RFC2136DNS01 struct {
+	Nameserver    string `json:"nameserver"`
+	TSIGKeyName   string `json:"tsigKeyName"`
+	TSIGAlgorithm string `json:"tsigAlgorithm"`
+	TSIGSecret    Secret `json:"tsigSecret"`
+}
+
+// VClusterConfig is used by `orsted vcluster create`, which installs
+// virtual clusters of chart Version for tenants and writes their
+// kubeconfigs to KubeconfigDir.
+type VClusterConfig struct {
+	Version       string `json:"version"`
+	KubeconfigDir string `json:"kubeconfigDir"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Kubernetes: KubernetesConfig{
+			APITimeout: "10m",
+		},
+		Network: NetworkConfig{
+			DNSCheck: DNSCheckConfig{
+				External: "registry.k8s.io",
+				Image:    "docker.io/library/debian:bookworm-slim",
+			},
+		},
+		Sysctl: SysctlConfig{
+			Profile: "default",
+		},
+		OSUpdates: OSUpdatesConfig{
+			Schedule:     "Sun *-*-* 02:00:00",
+			SecurityOnly: true,
+		},
+		Resources: ResourcesConfig{
+			Limits: LimitsConfig{
+				DefaultRequest: map[string]string{"cpu": "100m", "memory": "128Mi"},
+				Default:        map[string]string{"memory": "1Gi"},
+			},
+		},
+		Helm: HelmConfig{
+			MaxHistory:  10,
+			IndexMaxAge: "1h",
+		},
+		Images: ImagesConfig{
+			LockFile: "/var/lib/orsted/images.lock.json",
+		},
+		Policies: PoliciesConfig{
+			Engine: "kyverno",
+			Gatekeeper: GatekeeperConfig{
+				Version:       "3.13.0",
+				AuditInterval: 60,
+				Library:       "https://github.com/open-policy-agent/gatekeeper-library/library/pod-security-policy",
+				Constraints:   "/root/default-constraints.yaml",
+			},
+			ImageVerificationAction: "Enforce",
+			RekorURL:                "https://rekor.sigstore.dev",
+		},
+		Addons: AddonsConfig{
+			Loki: LokiConfig{
+				AddonConfig:  AddonConfig{Version: "2.9.11"},
+				Retention:    "168h",
+				StorageClass: "ceph-block",
+				Size:         "20Gi",
+			},
+			VPA: VPAConfig{
+				AddonConfig: AddonConfig{Version: "2.5.1"},
+				UpdateMode:  "Off",
+			},
+			NodeProblemDetector: NodeProblemDetectorConfig{
+				AddonConfig: AddonConfig{Version: "2.3.5"},
+			},
+			Kured: KuredConfig{
+				AddonConfig:  AddonConfig{Version: "5.1.0"},
+				Period:       "1h",
+				RebootDays:   []string{"mo", "tu", "we", "th", "fr", "sa", "su"},
+				StartTime:    "0:00",
+				EndTime:      "23:59:59",
+				TimeZone:     "UTC",
+				Sentinel:     "/var/run/reboot-required",
+				DrainTimeout: "30m",
+			},
+			Descheduler: DeschedulerConfig{
+				AddonConfig:         AddonConfig{Version: "0.28.0"},
+				Schedule:            "*/15 * * * *",
+				MaxEvictionsPerNode: 5,
+			},
+			TrivyOperator: TrivyOperatorConfig{
+				AddonConfig:             AddonConfig{Version: "0.16.4"},
+				Severity:                "HIGH,CRITICAL",
+				ConfigAudit:             true,
+				ScanJobsConcurrentLimit: 2,
+				ReportTTL:               "24h",
+			},
+			Falco: FalcoConfig{
+				AddonConfig: AddonConfig{Version: "3.5.0"},
+			},
+			Dex: DexConfig{
+				AddonConfig:   AddonConfig{Version: "0.15.3"},
+				UsernameClaim: "email",
+				GroupsClaim:   "groups",
+				Connectors:    []DexConnector{},
+			},
+			Keycloak: KeycloakConfig{
+				AddonConfig:   AddonConfig{Version: "16.0.5"},
+				IngressClass:  "cilium",
+				Realm:         "orsted",
+				StorageClass:  "ceph-block",
+				Size:          "8Gi",
+				UsernameClaim: "email",
+			},
+			Vault: VaultConfig{
+				AddonConfig:  AddonConfig{Version: "0.25.0"},
+				StorageClass: "ceph-block",
+				Size:         "10Gi",
+				UI:           true,
+				Injector:     true,
+				KeyShares:    5,
+				KeyThreshold: 3,
+				InitFile:     "/root/vault-init.json",
+			},
+			ExternalSecrets: ExternalSecretsConfig{
+				AddonConfig: AddonConfig{Version: "0.9.1"},
+			},
+			Harbor: HarborConfig{
+				AddonConfig:  AddonConfig{Version: "1.12.4"},
+				NodePort:     30002,
+				Storage:      "block",
+				StorageClass: "ceph-block",
+				Size:         "50Gi",
+				ProxyCache: map[string]string{
+					"docker.io":       "https://hub.docker.com",
+					"quay.io":         "https://quay.io",
+					"ghcr.io":         "https://ghcr.io",
+					"registry.k8s.io": "https://registry.k8s.io",
+				},
+				MirrorCRIO: true,
+			},
+			MinIO: MinIOConfig{
+				AddonConfig:  AddonConfig{Version: "5.0.13"},
+				StorageClass: "ceph-block",
+				Size:         "50Gi",
+				Buckets:      []string{"loki", "velero"},
+			},
+			Flux: AddonConfig{Version: "2.9.2"},
+			Gitea: GiteaConfig{
+				AddonConfig:  AddonConfig{Version: "9.1.0"},
+				StorageClass: "ceph-block",
+				Size:         "10Gi",
+				Org:          "orsted",
+				Repo:         "cluster",
+				Path:         "./",
+			},
+			Tailscale: TailscaleConfig{
+				AddonConfig:    AddonConfig{Version: "1.52.0"},
+				Hostname:       "orsted",
+				APIServerProxy: true,
+			},
+			Cloudflared: CloudflaredConfig{
+				AddonConfig:   AddonConfig{Version: "0.3.0"},
+				RouteSelector: "orsted.io/cloudflare-tunnel=true",
+			},
+			CertManager: CertManagerConfig{
+				AddonConfig: AddonConfig{Version: "v1.12.3"},
+			},
+			Multus: MultusConfig{
+				AddonConfig: AddonConfig{Version: "v4.0.2"},
+			},
+			Linkerd: LinkerdConfig{
+				AddonConfig:          AddonConfig{Version: "1.16.11"},
+				CRDsVersion:          "1.8.0",
+				DefaultInboundPolicy: "cluster-authenticated",
+			},
+			ClusterAutoscaler: ClusterAutoscalerConfig{
+				AddonConfig:           AddonConfig{Version: "9.29.3"},
+				ClusterName:           "orsted",
+				Expander:              "least-waste",
+				ScaleDownUnneededTime: "10m",
+			},
+			SRIOV: SRIOVConfig{
+				AddonConfig: AddonConfig{Version: "v3.6.2"},
+				CNIVersion:  "v2.7.0",
+			},
+		},
+		VCluster: VClusterConfig{
+			Version:       "0.16.4",
+			KubeconfigDir: "/root/vclusters",
+		},
+	}
+}
+
+func LoadConfig(path string) (*Config, error) {
+	conf := DefaultConfig()
+
+	data, err := readConfigFile(path)
+	if err == nil {
+		if err := yaml.UnmarshalStrict(data, conf); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := applyAddonState(conf); err != nil {
+		return nil, err
+	}
+	if conf.catalogAddons, err = LoadCatalogs(conf.Catalog.Sources); err != nil {
+		return nil, fmt.Errorf("loading the addon catalog: %w", err)
+	}
+	applyCloud(conf)
+
+	return conf, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// registries are the hosts the charts pull their images from. The Docker
+// Hub API lives on its own host.
+var registries = map[string]string{
+	"docker.io":       "registry-1.docker.io",
+	"quay.io":         "quay.io",
+	"ghcr.io":         "ghcr.io",
+	"registry.k8s.io": "registry.k8s.io",
+}
+
+// endpoints lists every URL bootstrap downloads from: the index of each
+// chart repo, the Gateway API CRDs and the /v2/ API of each image
+// registry, or of its mirror when one is configured.
+func endpoints(conf *Config) ([]string, error) {
+	defaultIp, err := primaryNodeIP(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := Releases(conf, defaultIp)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := map[string]bool{}
+	for _, release := range releases {
+		urls[strings.TrimSuffix(release.Repo.URL, "/")+"/index.yaml"] = true
+	}
+	for _, crd := range gatewayCRDs {
+		urls[crd] = true
+	}
+
+	for registry, host := range registries {
+		if mirror, ok := conf.Images.Mirrors[registry]; ok {
+			host, _, _ = strings.Cut(mirror, "/")
+		}
+		urls["https://"+host+"/v2/"] = true
+	}
+
+	list := make([]string, 0, len(urls))
+	for url := range urls {
+		list = append(list, url)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// checkConnectivity requests every endpoint at once so an unreachable
+// network fails in seconds instead of at a Helm timeout. Registries
+// answer /v2/ with 401 until logged into, which counts as reachable.
+func checkConnectivity(ctx context.Context, conf *Config) error {
+	urls, err := endpoints(conf)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: time.Second * 10}
+	problems := make([]string, len(urls))
+
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+			if err != nil {
+				problems[i] = err.Error()
+				return
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				problems[i] = err.Error()
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode >= 400 && resp.StatusCode != http.StatusUnauthorized {
+				problems[i] = fmt.Sprintf("%s: %s", url, resp.Status)
+			}
+		}(i, url)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, problem := range problems {
+		if problem != "" {
+			failed = append(failed, problem)
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// logBacklog is how many log lines StreamLogs replays to a new client.
+const logBacklog = 1000
+
+// ControlServer implements the Control service of api/control.proto.
+type ControlServer struct {
+	conf  *Config
+	token string
+
+	mu       sync.Mutex
+	status   string
+	failure  *Failure
+	lines    []string
+	partial  []byte
+	watchers map[chan string]struct{}
+}
+
+// NewControlServer returns a server for conf. Unless token is empty,
+// every call has to carry it as a bearer token in its authorization
+// metadata.
+func NewControlServer(conf *Config, token string) *ControlServer {
+	return &ControlServer{
+		conf:     conf,
+		token:    token,
+		status:   "idle",
+		watchers: map[chan string]struct{}{},
+	}
+}
+
+// Serve adds the server to the log output and serves the Control service
+// on listener until it fails.
+func (s *ControlServer) Serve(listener net.Listener) error {
+	log.SetOutput(io.MultiWriter(log.Writer(), redactWriter{s}))
+
+	server := grpc.NewServer()
+	server.RegisterService(&controlServiceDesc, s)
+
+	log.Printf("Serving the control API on %s\n", listener.Addr())
+	return server.Serve(listener)
+}
+
+// authorize fails with UNAUTHENTICATED unless the call carries the token
+// of the server.
+func (s *ControlServer) authorize(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+s.token)) == 1 {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+// Write splits the log output into lines for StreamLogs.
+func (s *ControlServer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(s.partial[:i])
+		s.partial = s.partial[i+1:]
+
+		s.lines = append(s.lines, line)
+		if len(s.lines) > logBacklog {
+			s.lines = s.lines[len(s.lines)-logBacklog:]
+		}
+
+		for watcher := range s.watchers {
+			// A client too slow to keep up misses lines rather than
+			// blocking the bootstrap.
+			select {
+			case watcher <- line:
+			default:
+			}
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *ControlServer) StartBootstrap(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status == "running" {
+		return nil, status.Error(codes.AlreadyExists, "a bootstrap is already running")
+	}
+	s.status = "running"
+	s.failure = nil
+	phaseMu.Lock()
+	phaseState = PhaseState{}
+	phaseMu.Unlock()
+
+	go func() {
+		err := s.bootstrap()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err != nil {
+			s.status = "failed"
+			s.failure = asFailure(err)
+		} else {
+			s.status = "succeeded"
+		}
+	}()
+
+	return &emptypb.Empty{}, nil
+}
+
+// bootstrap runs Bootstrap, turning a panic into a failure so it doesn't
+// take the server down with it.
+func (s *ControlServer) bootstrap() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure := &Failure{Phase: currentPhaseState().Current, Code: ExitPanic, Err: fmt.Errorf("panic: %v", r)}
+			recordFailure(failure, nil)
+			err = failure
+		}
+	}()
+
+	return Bootstrap(s.conf, false)
+}
+
+func (s *ControlServer) GetProgress(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	return structpb.NewStruct(s.progress())
+}
+
+func (s *ControlServer) progress() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := currentPhaseState()
+	finished := []interface{}{}
+	for _, phase := range state.Finished {
+		finished = append(finished, map[string]interface{}{"name": phase.Name, "seconds": phase.Seconds})
+	}
+
+	progress := map[string]interface{}{
+		"status":   s.status,
+		"current":  state.Current,
+		"finished": finished,
+	}
+	if s.failure != nil {
+		progress["error"] = Redact(s.failure.Error())
+		progress["exitCode"] = s.failure.Code
+	}
+
+	return progress
+}
+
+func (s *ControlServer) StreamLogs(_ *emptypb.Empty, stream grpc.ServerStream) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+
+	watcher := make(chan string, 256)
+
+	s.mu.Lock()
+	backlog := append([]string(nil), s.lines...)
+	s.watchers[watcher] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, watcher)
+		s.mu.Unlock()
+	}()
+
+	for _, line := range backlog {
+		if err := stream.SendMsg(wrapperspb.String(line)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case line := <-watcher:
+			if err := stream.SendMsg(wrapperspb.String(line)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *ControlServer) Teardown(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	running := s.status == "running"
+	s.mu.Unlock()
+	if running {
+		return nil, status.Error(codes.FailedPrecondition, "a bootstrap is running")
+	}
+
+	log.Println("Resetting kubeadm")
+	out, err := RunCommand(ctx, "kubeadm", "reset", "--force")
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "kubeadm reset: %s: %s", err, strings.TrimSpace(out))
+	}
+	resetHelmClients()
+
+	s.mu.Lock()
+	s.status = "idle"
+	s.failure = nil
+	s.mu.Unlock()
+
+	return &emptypb.Empty{}, nil
+}
+
+// controlServiceDesc is what protoc-gen-go-grpc would generate for the
+// Control service, written out since it only needs well-known types.
+var controlServiceDesc = grpc.ServiceDesc{
+	ServiceName: "orsted.v1.Control",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartBootstrap",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(emptypb.Empty)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return srv.(*ControlServer).StartBootstrap(ctx, in)
+			},
+		},
+		{
+			MethodName: "GetProgress",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(emptypb.Empty)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return srv.(*ControlServer).GetProgress(ctx, in)
+			},
+		},
+		{
+			MethodName: "Teardown",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(emptypb.Empty)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return srv.(*ControlServer).Teardown(ctx, in)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLogs",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := new(emptypb.Empty)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(*ControlServer).StreamLogs(in, stream)
+			},
+		},
+	},
+	Metadata: "api/control.proto",
+}
+
+// controlListener listens on addr, a Unix socket path when it contains a
+// slash and a TCP address otherwise. A stale socket of a previous server is
+// replaced. Anyone reaching a TCP port could tear the cluster down, so it
+// is only listened on with a token.
+func controlListener(addr string, token string) (net.Listener, error) {
+	if !strings.Contains(addr, "/") {
+		if token == "" {
+			return nil, errors.New("serving the control API on TCP needs a token, pass --token-file")
+		}
+		return net.Listen("tcp", addr)
+	}
+
+	if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only root drives orsted, like the CLI.
+	return listener, os.Chmod(addr, 0600)
+}
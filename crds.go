@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// crdTimeout is how long a CRD may take to be served after it is created.
+const crdTimeout = time.Minute * 2
+
+// The CRDs the resources orsted creates right after installing their
+// charts are of. Helm only waits for the CRDs of a chart's crds directory,
+// these charts template theirs.
+var (
+	rookCRDs       = []string{"cephclusters.ceph.rook.io", "cephblockpools.ceph.rook.io"}
+	kyvernoCRDs    = []string{"clusterpolicies.kyverno.io", "policies.kyverno.io"}
+	gatekeeperCRDs = []string{"constrainttemplates.templates.gatekeeper.sh"}
+)
+
+// gatewayCRDNames are the names of the gatewayCRDs, which their files are
+// named after as <group>_<plural>.yaml.
+func gatewayCRDNames() []string {
+	var names []string
+	for _, url := range gatewayCRDs {
+		group, plural, _ := strings.Cut(strings.TrimSuffix(path.Base(url), ".yaml"), "_")
+		names = append(names, plural+"."+group)
+	}
+
+	return names
+}
+
+// WaitForCRDs watches the named CRDs until every one is Established and
+// its names are accepted, so resources of them can be created, waiting
+// for those that don't exist yet to be created.
+func WaitForCRDs(ctx context.Context, timeout time.Duration, names ...string) error {
+	k8sConf, err := clientcmd.BuildConfigFromFlags("", KubeconfigPath)
+	if err != nil {
+		return fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+	client, err := dynamic.NewForConfig(k8sConf)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, name := range names {
+		log.Printf("Waiting for CRD %s\n", name)
+		selector := fields.OneTermEqualSelector("metadata.name", name).String()
+		crds := client.Resource(crdResource)
+		lw := &cache.ListWatch{
+			ListFunc: func(opts meta.ListOptions) (runtime.Object, error) {
+				opts.FieldSelector = selector
+				return crds.List(ctx, opts)
+			},
+			WatchFunc: func(opts meta.ListOptions) (watch.Interface, error) {
+				opts.FieldSelector = selector
+				return crds.Watch(ctx, opts)
+			},
+		}
+
+		_, err := watchtools.UntilWithSync(ctx, lw, &unstructured.Unstructured{}, nil, func(event watch.Event) (bool, error) {
+			crd, ok := event.Object.(*unstructured.Unstructured)
+			return ok && event.Type != watch.Deleted && crdServed(crd), nil
+		})
+		if err != nil {
+			return fmt.Errorf("CRD %s not established: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// crdServed reports whether crd has the Established and NamesAccepted
+// conditions.
+func crdServed(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+
+	met := map[string]bool{}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["status"] == "True" {
+			met[fmt.Sprint(condition["type"])] = true
+		}
+	}
+
+	return met["Established"] && met["NamesAccepted"]
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	authn "k8s.io/api/authentication/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	rbacv1ac "k8s.io/client-go/applyconfigurations/rbac/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// credentialRoles maps the roles credentials are issued for to the
+// built-in ClusterRole they are bound to, in their namespace only.
+var credentialRoles = map[string]string{
+	"viewer":   "view",
+	"deployer": "edit",
+	"admin":    "admin",
+}
+
+// CredentialRoles lists the roles credentials can be issued for.
+func CredentialRoles() []string {
+	roles := make([]string, 0, len(credentialRoles))
+	for role := range credentialRoles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	return roles
+}
+
+// CredentialOptions describes the credentials to issue: a ServiceAccount
+// named Name, bound to Role in Namespace, whose token expires after TTL.
+type CredentialOptions struct {
+	Name      string
+	Role      string
+	Namespace string
+	TTL       time.Duration
+}
+
+// IssueCredentials creates the ServiceAccount of opts and its RoleBinding,
+// or updates them, and writes a kubeconfig with a token bound to it to
+// out, stdout when empty. The token can't be revoked before it expires
+// other than by deleting the ServiceAccount.
+func IssueCredentials(ctx context.Context, opts CredentialOptions, out string) error {
+	clusterRole, ok := credentialRoles[opts.Role]
+	if !ok {
+		return fmt.Errorf("unknown role %q, expected one of %v", opts.Role, CredentialRoles())
+	}
+	if opts.Name == "" {
+		opts.Name = "ci-" + opts.Role
+	}
+
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+
+	if _, err := k8sClient.CoreV1().Namespaces().Get(ctx, opts.Namespace, meta.GetOptions{}); err != nil {
+		return fmt.Errorf("getting namespace %s: %w", opts.Namespace, err)
+	}
+
+	apply := meta.ApplyOptions{FieldManager: fieldManager, Force: true}
+	account := corev1ac.ServiceAccount(opts.Name, opts.Namespace)
+	if _, err := k8sClient.CoreV1().ServiceAccounts(opts.Namespace).Apply(ctx, account, apply); err != nil {
+		return fmt.Errorf("applying the service account: %w", err)
+	}
+
+	binding := rbacv1ac.RoleBinding(opts.Name, opts.Namespace).
+		WithRoleRef(rbacv1ac.RoleRef().WithAPIGroup("rbac.authorization.k8s.io").WithKind("ClusterRole").WithName(clusterRole)).
+		WithSubjects(rbacv1ac.Subject().WithKind("ServiceAccount").WithName(opts.Name).WithNamespace(opts.Namespace))
+	if _, err := k8sClient.RbacV1().RoleBindings(opts.Namespace).Apply(ctx, binding, apply); err != nil {
+		return fmt.Errorf("applying the role binding: %w", err)
+	}
+
+	expiration := int64(opts.TTL.Seconds())
+	token, err := k8sClient.CoreV1().ServiceAccounts(opts.Namespace).CreateToken(ctx, opts.Name, &authn.TokenRequest{
+		Spec: authn.TokenRequestSpec{ExpirationSeconds: &expiration},
+	}, meta.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("requesting a token: %w", err)
+	}
+
+	kubeconfig, err := credentialsKubeconfig(opts, token.Status.Token)
+	if err != nil {
+		return err
+	}
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(out, data, 0600); err != nil {
+		return err
+	}
+
+	log.Printf("Wrote credentials of %s/%s as %s, expiring %s, to %s\n", opts.Namespace, opts.Name, opts.Role,
+		token.Status.ExpirationTimestamp.Format(time.RFC3339), out)
+	return nil
+}
+
+// credentialsKubeconfig is a kubeconfig reaching the cluster of the current
+// context of KubeconfigPath with token, defaulting to the namespace of
+// opts.
+func credentialsKubeconfig(opts CredentialOptions, token string) (*clientcmdapi.Config, error) {
+	current, err := clientcmd.LoadFromFile(KubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading kubeconfig: %w", err)
+	}
+	currentContext, ok := current.Contexts[current.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig %s has no current context", KubeconfigPath)
+	}
+	cluster, ok := current.Clusters[currentContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig %s has no cluster %s", KubeconfigPath, currentContext.Cluster)
+	}
+
+	// The CA has to travel with the kubeconfig to wherever it is used.
+	caData := cluster.CertificateAuthorityData
+	if len(caData) == 0 && cluster.CertificateAuthority != "" {
+		if caData, err = os.ReadFile(cluster.CertificateAuthority); err != nil {
+			return nil, fmt.Errorf("reading the cluster CA: %w", err)
+		}
+	}
+
+	name := opts.Namespace + "-" + opts.Name
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[currentContext.Cluster] = &clientcmdapi.Cluster{
+		Server:                   cluster.Server,
+		CertificateAuthorityData: caData,
+	}
+	kubeconfig.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: token}
+	kubeconfig.Contexts[name] = &clientcmdapi.Context{
+		Cluster:   currentContext.Cluster,
+		AuthInfo:  name,
+		Namespace: opts.Namespace,
+	}
+	kubeconfig.CurrentContext = name
+
+	return kubeconfig, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+//go:embed values/dex.yaml
+var DexYaml string
+
+var dexRepo = repo.Entry{
+	Name: "dex",
+	URL:  "https://charts.dexidp.io",
+}
+
+func dexSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	dex := conf.Addons.Dex
+
+	issuer, err := url.Parse(dex.Issuer)
+	if err != nil || issuer.Scheme != "https" || issuer.Host == "" {
+		return nil, fmt.Errorf("dex issuer %q is not an https URL", dex.Issuer)
+	}
+
+	connectors, err := json.Marshal(dex.Connectors)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []map[string]interface{}{{
+		"id":           oidcClusterClient,
+		"name":         "Kubernetes",
+		"public":       true,
+		"redirectURIs": oidcClusterRedirects,
+	}}
+	if dex.GitOps.RedirectURL != "" {
+		clients = append(clients, map[string]interface{}{
+			"id":           "weave-gitops",
+			"name":         "Weave GitOps",
+			"secret":       dex.GitOps.ClientSecret.Reveal(),
+			"redirectURIs": []string{dex.GitOps.RedirectURL},
+		})
+	}
+
+	staticClients, err := json.Marshal(clients)
+	if err != nil {
+		return nil, err
+	}
+
+	path := issuer.Path
+	if path == "" {
+		path = "/"
+	}
+
+	values, err := renderValues("dex", DexYaml, map[string]string{
+		"Issuer":        dex.Issuer,
+		"Connectors":    string(connectors),
+		"StaticClients": string(staticClients),
+		"IngressClass":  dex.IngressClass,
+		"Host":          issuer.Hostname(),
+		"Path":          path,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "dex",
+		ChartName:       "dex/dex",
+		Namespace:       "dex",
+		CreateNamespace: true,
+		Wait:            true,
+		Timeout:         time.Minute * 3,
+		Version:         dex.Version,
+		ValuesYaml:      values,
+	}, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Diff prints what an upgrade would change on the live cluster as unified
+// diffs: the chart version and values of every release, and the objects of
+// the rendered releases and of the manifests orsted applies itself against
+// the live ones. It returns whether anything differs.
+func Diff(ctx context.Context, out io.Writer, conf *Config) (bool, error) {
+	defaultIp, err := primaryNodeIP(conf)
+	if err != nil {
+		return false, err
+	}
+
+	releases, err := Releases(conf, defaultIp)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for _, release := range releases {
+		spec := release.Spec
+		if err := applyHelmDefaults(conf, spec); err != nil {
+			return false, err
+		}
+
+		client, err := helmClientForNs(spec.Namespace)
+		if err != nil {
+			return false, err
+		}
+		if err := addChartRepo(conf, client, release.Repo); err != nil {
+			return false, err
+		}
+
+		rel, err := client.GetRelease(spec.ReleaseName)
+		if err != nil {
+			fmt.Fprintf(out, "# %s/%s is not installed and would be\n", spec.Namespace, spec.ReleaseName)
+			changed = true
+			continue
+		}
+
+		if spec.Version != "" && !versionMatches(spec.Version, rel.Chart.Metadata.Version) {
+			fmt.Fprintf(out, "# %s/%s chart %s -> %s\n", spec.Namespace, spec.ReleaseName, rel.Chart.Metadata.Version, spec.Version)
+			changed = true
+		}
+
+		live, err := yaml.Marshal(rel.Config)
+		if err != nil {
+			return false, err
+		}
+		desired, err := normalizeValues(spec.ValuesYaml)
+		if err != nil {
+			return false, fmt.Errorf("parsing the %s values: %w", spec.ReleaseName, err)
+		}
+		valuesDiff, err := unifiedDiff(ctx, spec.ReleaseName+"/values.yaml", string(live), desired)
+		if err != nil {
+			return false, err
+		}
+		if valuesDiff != "" {
+			fmt.Fprint(out, Redact(valuesDiff))
+			changed = true
+		}
+
+		rendered, err := client.TemplateChart(spec, nil)
+		if err != nil {
+			return false, fmt.Errorf("rendering %s: %w", spec.ReleaseName, err)
+		}
+		opts, err := helmOptions(conf, spec.ReleaseName)
+		if err != nil {
+			return false, err
+		}
+		buf, err := opts.PostRenderer.Run(bytes.NewBuffer(rendered))
+		if err != nil {
+			return false, err
+		}
+		rendered = buf.Bytes()
+
+		objectsDiff, err := kubectlDiff(ctx, spec.Namespace, withoutHooks(string(rendered)))
+		if err != nil {
+			return false, fmt.Errorf("diffing %s: %w", spec.ReleaseName, err)
+		}
+		if objectsDiff != "" {
+			fmt.Fprint(out, Redact(objectsDiff))
+			changed = true
+		}
+	}
+
+	manifests, err := renderManifests(conf)
+	if err != nil {
+		return false, err
+	}
+	names := make([]string, 0, len(manifests))
+	for name := range manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		objectsDiff, err := kubectlDiff(ctx, "", manifests[name])
+		if err != nil {
+			return false, fmt.Errorf("diffing the %s manifest: %w", name, err)
+		}
+		if objectsDiff != "" {
+			fmt.Fprint(out, Redact(objectsDiff))
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// normalizeValues re-encodes values the way helm stores them, so only
+// actual changes show up in the diff.
+func normalizeValues(values string) (string, error) {
+	decoded := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(values), &decoded); err != nil {
+		return "", err
+	}
+
+	data, err := yaml.Marshal(decoded)
+	return string(data), err
+}
+
+// withoutHooks drops the hook resources of a rendered chart, helm runs and
+// usually deletes them rather than keeping them around to compare.
+func withoutHooks(rendered string) string {
+	docs := releaseutil.SplitManifests(rendered)
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var out strings.Builder
+	for _, key := range keys {
+		var obj struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(docs[key]), &obj); err == nil {
+			if _, ok := obj.Metadata.Annotations["helm.sh/hook"]; ok {
+				continue
+			}
+		}
+
+		out.WriteString("---\n")
+		out.WriteString(docs[key])
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
+
+// kubectlDiff diffs manifest against the live objects, returning an empty
+// diff when they match.
+func kubectlDiff(ctx context.Context, ns string, manifest string) (string, error) {
+	args := []string{"diff", "--kubeconfig=" + KubeconfigPath, "-f", "-"}
+	if ns != "" {
+		args = append(args, "-n", ns)
+	}
+
+	out, err := RunCommandWithInput(ctx, manifest, "kubectl", args...)
+	return diffResult(out, err)
+}
+
+// unifiedDiff diffs live against desired with diff -u, returning an empty
+// diff when they match.
+func unifiedDiff(ctx context.Context, name string, live string, desired string) (string, error) {
+	dir, err := os.MkdirTemp("", "orsted-diff")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	livePath, desiredPath := filepath.Join(dir, "live"), filepath.Join(dir, "desired")
+	if err := os.WriteFile(livePath, []byte(live), 0600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(desiredPath, []byte(desired), 0600); err != nil {
+		return "", err
+	}
+
+	out, err := RunCommand(ctx, "diff", "-u", "--label", "live/"+name, "--label", "desired/"+name, livePath, desiredPath)
+	return diffResult(out, err)
+}
+
+// diffResult interprets the exit code of diff and kubectl diff, 1 meaning
+// there are differences.
+func diffResult(out string, err error) (string, error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return out, nil
+	} else if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+
+	return "", nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Distro adapts orsted to what differs between distribution families: the
+// package manager, unattended updates, the bootloader and SELinux.
+type Distro struct {
+	Name string
+	// IDs are matched against ID and ID_LIKE of /etc/os-release.
+	IDs []string
+	// RepoFormat is the package format of the pkgs.k8s.io repos, rpm or
+	// deb.
+	RepoFormat string
+	// AddRepo adds a signed package repo at url. Its packages are only
+	// installed or upgraded when asked for by name.
+	AddRepo func(ctx context.Context, name string, url string, packages []string) error
+	// Install installs packages, pinned to version unless it is empty.
+	Install func(ctx context.Context, version string, packages ...string) error
+	// Hold keeps packages at their installed version.
+	Hold func(ctx context.Context, packages ...string) error
+	// ConfigureUpdates sets up unattended updates, never of heldPackages.
+	ConfigureUpdates func(ctx context.Context, conf *Config) error
+	// RebootCheck is the command kured asks whether a reboot is pending,
+	// empty when the distribution writes /var/run/reboot-required.
+	RebootCheck string
+	// AddKernelArgs adds args to the kernel command line of every boot
+	// entry.
+	AddKernelArgs func(ctx context.Context, args []string) error
+	// SELinuxPackages provide the policy containers run under when SELinux
+	// is enforcing.
+	SELinuxPackages []string
+}
+
+// Packages are excluded from the repo so only orsted installs or upgrades
+// them, in step with each other.
+const rpmRepo = `[%s]
+name=%s
+baseurl=%s
+enabled=1
+gpgcheck=1
+gpgkey=%srepodata/repomd.xml.key
+exclude=%s
+`
+
+var Distros = []Distro{
+	{
+		Name:       "Fedora/RHEL",
+		IDs:        []string{"fedora", "rhel", "centos", "rocky", "almalinux"},
+		RepoFormat: "rpm",
+		AddRepo: func(ctx context.Context, name string, url string, packages []string) error {
+			return writeFile("/etc/yum.repos.d/"+name+".repo", fmt.Sprintf(rpmRepo, name, name, url, url, strings.Join(packages, " ")))
+		},
+		Install: func(ctx context.Context, version string, packages ...string) error {
+			args := []string{"install", "-y", "--disableexcludes=all"}
+			for _, pkg := range packages {
+				if version != "" {
+					pkg += "-" + version
+				}
+				args = append(args, pkg)
+			}
+
+			return runHostCommand(ctx, "dnf", args...)
+		},
+		Hold: func(ctx context.Context, packages ...string) error {
+			// The repo excludes them already.
+			return nil
+		},
+		ConfigureUpdates: dnfAutomatic,
+		RebootCheck:      dnfRebootCheck,
+		AddKernelArgs: func(ctx context.Context, args []string) error {
+			return runHostCommand(ctx, "grubby", "--update-kernel=ALL", "--args="+strings.Join(args, " "))
+		},
+		SELinuxPackages: []string{"container-selinux"},
+	},
+	{
+		Name:       "Debian/Ubuntu",
+		IDs:        []string{"debian", "ubuntu"},
+		RepoFormat: "deb",
+		AddRepo: func(ctx context.Context, name string, url string, packages []string) error {
+			keyring := "/etc/apt/keyrings/" + name + ".gpg"
+			script := strings.Join([]string{
+				"set -e",
+				"mkdir -p /etc/apt/keyrings",
+				fmt.Sprintf("curl -fsSL %sRelease.key | gpg --dearmor --yes -o %s", url, keyring),
+			}, "\n")
+			if err := runHostCommand(ctx, "bash", "-c", script); err != nil {
+				return err
+			}
+
+			if err := writeFile("/etc/apt/sources.list.d/"+name+".list", fmt.Sprintf("deb [signed-by=%s] %s /\n", keyring, url)); err != nil {
+				return err
+			}
+			return runHostCommand(ctx, "apt-get", "update")
+		},
+		Install: func(ctx context.Context, version string, packages ...string) error {
+			args := []string{"install", "-y", "--allow-change-held-packages"}
+			for _, pkg := range packages {
+				if version != "" {
+					pkg += "=" + version + "-*"
+				}
+				args = append(args, pkg)
+			}
+
+			return runHostCommand(ctx, "env", append([]string{"DEBIAN_FRONTEND=noninteractive", "apt-get"}, args...)...)
+		},
+		Hold: func(ctx context.Context, packages ...string) error {
+			return runHostCommand(ctx, "apt-mark", append([]string{"hold"}, packages...)...)
+		},
+		ConfigureUpdates: aptUnattendedUpgrades,
+		AddKernelArgs: func(ctx context.Context, args []string) error {
+			// Each call adds a line extending what the previous ones set.
+			path := "/etc/default/grub.d/90-orsted.cfg"
+			current, err := os.ReadFile(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			if err := writeFile(path, string(current)+fmt.Sprintf("GRUB_CMDLINE_LINUX_DEFAULT=\"$GRUB_CMDLINE_LINUX_DEFAULT %s\"\n", strings.Join(args, " "))); err != nil {
+				return err
+			}
+			return runHostCommand(ctx, "update-grub")
+		},
+		SELinuxPackages: []string{"selinux-policy-default"},
+	},
+	{
+		Name:       "openSUSE",
+		IDs:        []string{"opensuse", "opensuse-leap", "opensuse-tumbleweed", "suse", "sles"},
+		RepoFormat: "rpm",
+		AddRepo: func(ctx context.Context, name string, url string, packages []string) error {
+			// zypper ignores exclude, Hold locks the packages instead.
+			if err := writeFile("/etc/zypp/repos.d/"+name+".repo", fmt.Sprintf(rpmRepo, name, name, url, url, strings.Join(packages, " "))); err != nil {
+				return err
+			}
+			return runHostCommand(ctx, "zypper", "--non-interactive", "--gpg-auto-import-keys", "refresh", name)
+		},
+		Install: func(ctx context.Context, version string, packages ...string) error {
+			// Held packages are locked, which blocks installing them too.
+			if err := runHostCommand(ctx, "zypper", append([]string{"removelock"}, packages...)...); err != nil {
+				return err
+			}
+
+			args := []string{"--non-interactive", "install"}
+			for _, pkg := range packages {
+				if version != "" {
+					pkg += "=" + version
+				}
+				args = append(args, pkg)
+			}
+
+			return runHostCommand(ctx, "zypper", args...)
+		},
+		Hold: func(ctx context.Context, packages ...string) error {
+			return runHostCommand(ctx, "zypper", append([]string{"addlock"}, packages...)...)
+		},
+		ConfigureUpdates: zypperPatches,
+		RebootCheck:      zypperRebootCheck,
+		AddKernelArgs: func(ctx context.Context, args []string) error {
+			for _, arg := range args {
+				if err := runHostCommand(ctx, "pbl", "--add-option", arg); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		SELinuxPackages: []string{"container-selinux"},
+	},
+}
+
+// DetectDistro finds the adapter for the host from /etc/os-release.
+func DetectDistro() (*Distro, error) {
+	release, err := os.Open("/etc/os-release")
+	if err != nil {
+		return nil, err
+	}
+	defer release.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(release)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && (key == "ID" || key == "ID_LIKE") {
+			ids = append(ids, strings.Fields(strings.Trim(value, `"'`))...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		for i := range Distros {
+			for _, distroID := range Distros[i].IDs {
+				if id == distroID {
+					return &Distros[i], nil
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported distribution %s", strings.Join(ids, "/"))
+}
+
+// ConfigureSELinux has CRI-O label containers when SELinux is enforcing,
+// installing the container policy first, rather than turning SELinux off.
+func ConfigureSELinux(ctx context.Context) error {
+	enforce, err := os.ReadFile("/sys/fs/selinux/enforce")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return failed("selinux", fmt.Errorf("reading the mode: %w", err))
+	}
+	if strings.TrimSpace(string(enforce)) != "1" {
+		return nil
+	}
+
+	distro, err := DetectDistro()
+	if err != nil {
+		return failed("selinux", err)
+	}
+
+	log.Printf("SELinux is enforcing, installing %s\n", strings.Join(distro.SELinuxPackages, " "))
+	if err := distro.Install(ctx, "", distro.SELinuxPackages...); err != nil {
+		return failed("selinux", fmt.Errorf("installing the container policy: %w", err))
+	}
+
+	if err := writeFile("/etc/crio/crio.conf.d/10-orsted-selinux.conf", "[crio.runtime]\nselinux = true\n"); err != nil {
+		return failed("selinux", err)
+	}
+
+	return nil
+}
+
+func runHostCommand(ctx context.Context, command string, args ...string) error {
+	out, err := RunCommand(ctx, command, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", command, err, out)
+	}
+
+	return nil
+}
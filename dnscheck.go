@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const dnsCheckTimeout = time.Minute * 3
+
+// dnsCheckScript resolves every record with the resolver of the C
+// library, search domains and all like the applications on the cluster,
+// printing OK or FAIL for each and exiting non-zero when one failed.
+func dnsCheckScript(records []DNSRecord) string {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	var script strings.Builder
+	script.WriteString(`status=0
+check() {
+  if ! addresses=$(getent ahosts "$1" | awk '{print $1}' | sort -u | tr '\n' ' ') || [ -z "$addresses" ]; then
+    echo "FAIL $1 doesn't resolve"
+    status=1
+  elif [ -n "$2" ] && ! echo " $addresses" | grep -qF " $2 "; then
+    echo "FAIL $1 resolves to $addresses, expected $2"
+    status=1
+  else
+    echo "OK $1 $addresses"
+  fi
+}
+`)
+	for _, record := range records {
+		fmt.Fprintf(&script, "check %s %s\n", quote(record.Name), quote(record.Address))
+	}
+	script.WriteString("exit $status\n")
+
+	return script.String()
+}
+
+// CheckDNS runs a pod resolving kubernetes.default, the external name and
+// the records of the config through CoreDNS, since a broken DNS otherwise
+// only shows once applications fail to reach each other.
+func CheckDNS(ctx context.Context, k8sClient *kubernetes.Clientset, conf *Config) error {
+	check := conf.Network.DNSCheck
+	svc, err := k8sClient.CoreV1().Services("default").Get(ctx, "kubernetes", meta.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting the kubernetes service: %w", err)
+	}
+
+	records := []DNSRecord{{Name: "kubernetes.default", Address: svc.Spec.ClusterIP}}
+	if check.External != "" {
+		records = append(records, DNSRecord{Name: check.External})
+	}
+	records = append(records, check.Records...)
+
+	nonRoot := true
+	user := int64(65534)
+	noEscalation := false
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{GenerateName: "orsted-dns-check-", Namespace: "default"},
+		Spec: core.PodSpec{
+			RestartPolicy: core.RestartPolicyNever,
+			// Whichever node it lands on, taints or not.
+			Tolerations: []core.Toleration{{Operator: core.TolerationOpExists}},
+			SecurityContext: &core.PodSecurityContext{
+				RunAsNonRoot:   &nonRoot,
+				RunAsUser:      &user,
+				SeccompProfile: &core.SeccompProfile{Type: core.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []core.Container{{
+				Name:    "check",
+				Image:   mirrorImage(conf.Images.Mirrors, check.Image),
+				Command: []string{"sh", "-c", dnsCheckScript(records)},
+				SecurityContext: &core.SecurityContext{
+					AllowPrivilegeEscalation: &noEscalation,
+					Capabilities:             &core.Capabilities{Drop: []core.Capability{"ALL"}},
+				},
+			}},
+		},
+	}
+
+	log.Printf("Resolving %d names through CoreDNS\n", len(records))
+	pod, logs, err := runPod(ctx, k8sClient, pod, dnsCheckTimeout)
+	if err != nil {
+		return fmt.Errorf("running the DNS check pod: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		log.Println(line)
+	}
+
+	if pod.Status.Phase == core.PodFailed {
+		return fmt.Errorf("names don't resolve through CoreDNS:\n%s", logs)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// egressGatewayManifest renders a CiliumEgressGatewayPolicy per configured
+// gateway.
+func egressGatewayManifest(conf *Config) (string, error) {
+	var objects []object
+	for _, gw := range conf.Network.EgressGateways {
+		// Cilium matches the namespace through the label it sets on every
+		// pod's endpoint.
+		labels := map[string]string{}
+		for key, value := range gw.PodSelector {
+			labels[key] = value
+		}
+		if gw.Namespace != "" {
+			labels["io.kubernetes.pod.namespace"] = gw.Namespace
+		}
+
+		destinations := gw.DestinationCIDRs
+		if len(destinations) == 0 {
+			destinations = []string{"0.0.0.0/0"}
+		}
+
+		nodeSelector := gw.NodeSelector
+		if len(nodeSelector) == 0 {
+			nodeSelector = map[string]string{"kubernetes.io/os": "linux"}
+		}
+
+		gateway := object{"nodeSelector": object{"matchLabels": nodeSelector}}
+		if gw.EgressIP != "" {
+			gateway["egressIP"] = gw.EgressIP
+		} else if gw.Interface != "" {
+			gateway["interface"] = gw.Interface
+		}
+
+		spec := object{
+			"selectors":        []object{{"podSelector": object{"matchLabels": labels}}},
+			"destinationCIDRs": destinations,
+			"egressGateway":    gateway,
+		}
+		if len(gw.ExcludedCIDRs) > 0 {
+			spec["excludedCIDRs"] = gw.ExcludedCIDRs
+		}
+
+		objects = append(objects, object{
+			"apiVersion": "cilium.io/v2",
+			"kind":       "CiliumEgressGatewayPolicy",
+			"metadata":   object{"name": gw.Name},
+			"spec":       spec,
+		})
+	}
+
+	return renderObjects(objects)
+}
+
+// ApplyEgressGateways routes the selected pods' traffic out through their
+// fixed source IPs.
+func ApplyEgressGateways(ctx context.Context, conf *Config) error {
+	if len(conf.Network.EgressGateways) == 0 {
+		return nil
+	}
+
+	if err := waitForCiliumCRD(ctx, "ciliumegressgatewaypolicies.cilium.io"); err != nil {
+		return err
+	}
+
+	manifest, err := egressGatewayManifest(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "egress-gateways", fmt.Errorf("rendering the policies: %w", err))
+	}
+
+	log.Println("Configuring egress gateways")
+	egressOut, err := ApplyManifest(ctx, "cilium", manifest)
+	if err != nil {
+		return commandFailed("egress-gateways", egressOut, fmt.Errorf("applying the policies: %w", err))
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Failure is an error bootstrap or upgrade stops on, with what is needed
+// to describe it: the phase and component it happened in, the output of
+// the command that failed and the exit code it maps to.
+type Failure struct {
+	Phase     string
+	Component string
+	Output    string
+	Code      int
+	Err       error
+}
+
+func (f *Failure) Error() string {
+	msg := f.Err.Error()
+	if f.Component != "" {
+		msg = f.Component + ": " + msg
+	}
+	if f.Phase != "" {
+		msg = fmt.Sprintf("%s phase: %s", f.Phase, msg)
+	}
+
+	return msg
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// failed marks err as the failure of component.
+func failed(component string, err error) error {
+	return &Failure{Component: component, Code: ExitFailure, Err: err}
+}
+
+// failedWith is failed with a specific exit code.
+func failedWith(code int, component string, err error) error {
+	return &Failure{Component: component, Code: code, Err: err}
+}
+
+// commandFailed marks err as the failure of a command run for component,
+// keeping its output.
+func commandFailed(component string, output string, err error) error {
+	return &Failure{Component: component, Output: output, Code: ExitFailure, Err: err}
+}
+
+// installFailed marks err as a release that failed to install, with
+// ExitAddonTimeout when its workloads didn't become ready in time.
+func installFailed(component string, err error) error {
+	code := ExitFailure
+	if errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timed out waiting") {
+		code = ExitAddonTimeout
+	}
+
+	return &Failure{Component: component, Code: code, Err: fmt.Errorf("installing: %w", err)}
+}
+
+// asFailure returns the Failure in err's chain, wrapping err in one if
+// there is none.
+func asFailure(err error) *Failure {
+	var failure *Failure
+	if errors.As(err, &failure) {
+		return failure
+	}
+
+	return &Failure{Code: ExitFailure, Err: err}
+}
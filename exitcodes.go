@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit codes wrapping automation can tell failures apart by. Anything not
+// classified exits with ExitFailure, like log.Fatal does.
+const (
+	ExitFailure      = 1
+	ExitConfig       = 3
+	ExitPreflight    = 4
+	ExitKubeadm      = 5
+	ExitAddonTimeout = 6
+	ExitPanic        = 7
+	ExitInterrupted  = 130
+)
+
+// exit is where failed commands end: it logs err with the output of the
+// command behind it, if any, and exits with its code. With --json, the
+// failure is printed as the Result unless Bootstrap already printed one.
+func exit(err error) {
+	code := ExitFailure
+	var failure *Failure
+	if errors.As(err, &failure) {
+		code = failure.Code
+		if failure.Output != "" {
+			log.Printf("Command output: %s\n", failure.Output)
+		}
+	}
+
+	log.Printf("Failed: %s\n", err)
+	if jsonOutput && !resultWritten {
+		writeResult(failureResult(asFailure(err)))
+	}
+	os.Exit(code)
+}
+
+// errInterrupted is the cause rootCtx is cancelled with on SIGINT or
+// SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// rootCtx is the context commands run in. It is cancelled on SIGINT or
+// SIGTERM, which kills the commands orsted runs and fails what it waits
+// for, so the command returns and exits through exit with
+// ExitInterrupted, writing its report and shutting tracing down first.
+var rootCtx, cancelRoot = context.WithCancelCause(context.Background())
+
+// interrupted reports whether ctx ended because orsted was interrupted.
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errInterrupted)
+}
+
+// cancelOnInterrupt cancels rootCtx on SIGINT or SIGTERM rather than
+// letting the runtime exit with its default code for a fatal signal. A
+// second signal exits right away, for commands stuck despite the cancel.
+func cancelOnInterrupt() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Printf("Interrupted by %s, stopping\n", sig)
+		cancelRoot(fmt.Errorf("%w by %s", errInterrupted, sig))
+
+		sig = <-signals
+		log.Printf("Interrupted by %s again, exiting\n", sig)
+		os.Exit(ExitInterrupted)
+	}()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+var externalSecretsRepo = repo.Entry{
+	Name: "external-secrets",
+	URL:  "https://charts.external-secrets.io",
+}
+
+// sopsStoreNamespace holds the decrypted SOPS secrets, ESO has no SOPS
+// provider and serves them through its Kubernetes provider instead.
+const sopsStoreNamespace = "external-secrets-sops"
+
+func externalSecretsSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	return &helmclient.ChartSpec{
+		ReleaseName:     "external-secrets",
+		ChartName:       "external-secrets/external-secrets",
+		Namespace:       "external-secrets",
+		CreateNamespace: true,
+		UpgradeCRDs:     true,
+		Wait:            true,
+		Timeout:         time.Minute * 3,
+		Version:         conf.Addons.ExternalSecrets.Version,
+		ValuesYaml:      "installCRDs: true\n",
+	}, nil
+}
+
+func clusterSecretStore(name string, provider object) object {
+	return object{
+		"apiVersion": "external-secrets.io/v1beta1",
+		"kind":       "ClusterSecretStore",
+		"metadata":   object{"name": name},
+		"spec":       object{"provider": provider},
+	}
+}
+
+// externalSecretsManifests renders a ClusterSecretStore for every store in
+// the config, along with the credentials and SOPS secrets backing them.
+func externalSecretsManifests(conf *Config) (string, error) {
+	var objects []object
+	sopsNamespace := false
+
+	for _, store := range conf.Addons.ExternalSecrets.Stores {
+		switch store.Type {
+		case "vault":
+			vault := store.Vault.withDefaults()
+			objects = append(objects, clusterSecretStore(store.Name, object{
+				"vault": object{
+					"server":  vault.Server,
+					"path":    vault.Path,
+					"version": "v2",
+					"auth": object{
+						"kubernetes": object{
+							"mountPath": "kubernetes",
+							"role":      vault.Role,
+							"serviceAccountRef": object{
+								"name":      "external-secrets",
+								"namespace": "external-secrets",
+							},
+						},
+					},
+				},
+			}))
+		case "aws":
+			aws := object{
+				"service": "SecretsManager",
+				"region":  store.AWS.Region,
+			}
+
+			// Without keys the node's instance profile is used.
+			if store.AWS.AccessKeyID != "" {
+				credentials := "aws-" + store.Name
+				objects = append(objects, secretObject(credentials, "external-secrets", map[string]string{
+					"access-key-id":     store.AWS.AccessKeyID,
+					"secret-access-key": store.AWS.SecretAccessKey.Reveal(),
+				}))
+
+				aws["auth"] = object{
+					"secretRef": object{
+						"accessKeyIDSecretRef": object{
+							"name":      credentials,
+							"namespace": "external-secrets",
+							"key":       "access-key-id",
+						},
+						"secretAccessKeySecretRef": object{
+							"name":      credentials,
+							"namespace": "external-secrets",
+							"key":       "secret-access-key",
+						},
+					},
+				}
+			}
+
+			objects = append(objects, clusterSecretStore(store.Name, object{"aws": aws}))
+		case "sops":
+			data, err := sopsStoreData(store.SOPS.File)
+			if err != nil {
+				return "", err
+			}
+
+			if !sopsNamespace {
+				objects = append(objects, sopsStoreObjects()...)
+				sopsNamespace = true
+			}
+
+			objects = append(objects, secretObject(store.Name, sopsStoreNamespace, data))
+			objects = append(objects, clusterSecretStore(store.Name, object{
+				"kubernetes": object{
+					"remoteNamespace": sopsStoreNamespace,
+					"server": object{
+						"caProvider": object{
+							"type":      "ConfigMap",
+							"name":      "kube-root-ca.crt",
+							"key":       "ca.crt",
+							"namespace": sopsStoreNamespace,
+						},
+					},
+					"auth": object{
+						"serviceAccount": object{
+							"name":      "sops-store",
+							"namespace": sopsStoreNamespace,
+						},
+					},
+				},
+			}))
+		default:
+			return "", fmt.Errorf("secret store %s has unknown type %q, expected vault, aws or sops", store.Name, store.Type)
+		}
+	}
+
+	return renderObjects(objects)
+}
+
+// sopsStoreObjects lets the SOPS stores read the secrets of their namespace
+// and nothing else.
+func sopsStoreObjects() []object {
+	meta := func(name string) object {
+		return object{"name": name, "namespace": sopsStoreNamespace}
+	}
+
+	return []object{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   object{"name": sopsStoreNamespace},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   meta("sops-store"),
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "Role",
+			"metadata":   meta("sops-store"),
+			"rules": []object{{
+				"apiGroups": []string{""},
+				"resources": []string{"secrets"},
+				"verbs":     []string{"get", "list", "watch"},
+			}, {
+				"apiGroups": []string{"authorization.k8s.io"},
+				"resources": []string{"selfsubjectrulesreviews"},
+				"verbs":     []string{"create"},
+			}},
+		},
+		{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "RoleBinding",
+			"metadata":   meta("sops-store"),
+			"roleRef": object{
+				"apiGroup": "rbac.authorization.k8s.io",
+				"kind":     "Role",
+				"name":     "sops-store",
+			},
+			"subjects": []object{{
+				"kind":      "ServiceAccount",
+				"name":      "sops-store",
+				"namespace": sopsStoreNamespace,
+			}},
+		},
+	}
+}
+
+// sopsStoreData decrypts a flat SOPS encrypted YAML or JSON file.
+func sopsStoreData(path string) (map[string]string, error) {
+	out, err := decryptSOPS(path, "json")
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]string
+	if err := json.Unmarshal(out, &data); err != nil {
+		return nil, fmt.Errorf("sops file must map keys to string values: %w", err)
+	}
+
+	for _, value := range data {
+		registerSecret(value)
+	}
+
+	return data, nil
+}
+
+// configureExternalSecrets prepares the in-cluster Vault for the stores
+// pointing at it: a KV engine at the store path and a role for ESO that
+// can read it.
+func configureExternalSecrets(ctx context.Context, conf *Config) error {
+	for _, store := range conf.Addons.ExternalSecrets.Stores {
+		vault := store.Vault.withDefaults()
+		if store.Type != "vault" || vault.Server != inClusterVault || !conf.Addons.Vault.Enabled {
+			continue
+		}
+
+		log.Printf("Configuring Vault for the %s secret store\n", store.Name)
+		path, role := vault.Path, vault.Role
+		err := vaultRootExec(ctx, conf,
+			fmt.Sprintf("(vault secrets list -format=json | grep -q '\"%s/\"' || vault secrets enable -path=%s kv-v2)", path, path),
+			fmt.Sprintf("echo 'path \"%s/data/*\" { capabilities = [\"read\"] }\npath \"%s/metadata/*\" { capabilities = [\"read\", \"list\"] }' | vault policy write %s -", path, path, role),
+			fmt.Sprintf("vault write auth/kubernetes/role/%s bound_service_account_names=external-secrets bound_service_account_namespaces=external-secrets policies=%s ttl=1h", role, role),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
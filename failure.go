@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// FailureBundleDir keeps a bundle for every failed bootstrap, for
+// unattended runs nobody watched the logs of.
+const FailureBundleDir = "/var/lib/orsted/failures"
+
+// PhaseState is how far the bootstrap got: the phases that finished and
+// the one running, which is where a panic happened.
+type PhaseState struct {
+	Current  string        `json:"current,omitempty"`
+	Finished []PhaseTiming `json:"finished"`
+}
+
+var (
+	phaseState PhaseState
+	phaseMu    sync.Mutex
+)
+
+// currentPhaseState returns a copy of phaseState that is safe to read
+// while a bootstrap is running.
+func currentPhaseState() PhaseState {
+	phaseMu.Lock()
+	defer phaseMu.Unlock()
+
+	state := phaseState
+	state.Finished = append([]PhaseTiming(nil), phaseState.Finished...)
+	return state
+}
+
+// notifyWebhook receives failure notifications, set from the config by
+// Bootstrap.
+var notifyWebhook string
+
+// FailureNotification is what the webhook receives.
+type FailureNotification struct {
+	Hostname  string `json:"hostname"`
+	Phase     string `json:"phase,omitempty"`
+	Component string `json:"component,omitempty"`
+	Error     string `json:"error"`
+	ExitCode  int    `json:"exitCode"`
+	Bundle    string `json:"bundle,omitempty"`
+}
+
+// recoverPanic turns a panic into a failure, with the stack in its bundle,
+// instead of letting it kill the process with only stderr to show for it.
+func recoverPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	failure := &Failure{Phase: currentPhaseState().Current, Code: ExitPanic, Err: fmt.Errorf("panic: %v", r)}
+	stack := debug.Stack()
+	log.Printf("%s\n", stack)
+
+	recordFailure(failure, stack)
+	exit(failure)
+}
+
+// recordFailure writes the failure bundle, notifies the webhook and sends
+// telemetry, returning the bundle's path.
+func recordFailure(failure *Failure, stack []byte) string {
+	bundle, err := writeFailureBundle(failure, stack)
+	if err != nil {
+		log.Printf("Failed to write the failure bundle: %s\n", err)
+	} else {
+		log.Printf("Wrote failure bundle %s\n", bundle)
+	}
+
+	notifyFailure(failure, bundle)
+	FailTelemetry(failure)
+
+	return bundle
+}
+
+// writeFailureBundle writes the failure, the phase state, the output of
+// the command that failed and the stack of a panic to a new directory
+// under FailureBundleDir.
+func writeFailureBundle(failure *Failure, stack []byte) (string, error) {
+	dir := filepath.Join(FailureBundleDir, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Orsted    string     `json:"orsted"`
+		Phase     string     `json:"phase,omitempty"`
+		Component string     `json:"component,omitempty"`
+		Error     string     `json:"error"`
+		ExitCode  int        `json:"exitCode"`
+		Phases    PhaseState `json:"phases"`
+	}{orstedVersion(), failure.Phase, failure.Component, failure.Err.Error(), failure.Code, currentPhaseState()}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	files := map[string]string{"failure.json": string(data)}
+	if failure.Output != "" {
+		files["output.txt"] = failure.Output
+	}
+	if stack != nil {
+		files["stack.txt"] = string(stack)
+	}
+
+	for name, contents := range files {
+		// Command output may echo secrets.
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(Redact(contents)), 0600); err != nil {
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// notifyFailure posts the failure to the webhook, if one is configured,
+// only logging when that fails.
+func notifyFailure(failure *Failure, bundle string) {
+	if notifyWebhook == "" {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(FailureNotification{
+		Hostname:  hostname,
+		Phase:     failure.Phase,
+		Component: failure.Component,
+		Error:     Redact(failure.Err.Error()),
+		ExitCode:  failure.Code,
+		Bundle:    bundle,
+	})
+	if err != nil {
+		log.Printf("Failed to encode the failure notification: %s\n", err)
+		return
+	}
+
+	client := http.Client{Timeout: time.Second * 10}
+	resp, err := client.Post(notifyWebhook, "application/json", bytes.NewReader(data))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("webhook returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to send the failure notification: %s\n", err)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"sigs.k8s.io/yaml"
+)
+
+// Fleet is a manifest of independent single node clusters bootstrapped in
+// one run, e.g. the sites of an edge rollout.
+type Fleet struct {
+	// Concurrency is how many sites bootstrap at once, 5 by default.
+	Concurrency int         `json:"concurrency,omitempty"`
+	Sites       []FleetSite `json:"sites"`
+}
+
+type FleetSite struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	// Config is the local orsted config of the site, relative to the
+	// manifest. A SOPS encrypted config is copied as it is and decrypted
+	// by the site with sops, using AgeKey.
+	Config string `json:"config"`
+	// AgeKey is the site's own age key, relative to the manifest, which
+	// its encrypted config has to be encrypted for. It is copied to the
+	// site, so a site never holds the key of another.
+	AgeKey string `json:"ageKey,omitempty"`
+	// User and Port override the SSH options of the run.
+	User string `json:"user,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+type FleetResult struct {
+	Site     string  `json:"site"`
+	Host     string  `json:"host"`
+	Status   string  `json:"status"`
+	ExitCode int     `json:"exitCode"`
+	Error    string  `json:"error,omitempty"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// remoteBinary is where the running orsted is copied to on every site.
+const remoteBinary = "/usr/local/bin/orsted"
+
+func LoadFleet(path string) (*Fleet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fleet := &Fleet{}
+	if err := yaml.UnmarshalStrict(data, fleet); err != nil {
+		return nil, err
+	}
+	if fleet.Concurrency <= 0 {
+		fleet.Concurrency = 5
+	}
+
+	names := map[string]bool{}
+	for i, site := range fleet.Sites {
+		if site.Name == "" || site.Host == "" || site.Config == "" {
+			return nil, fmt.Errorf("site %d needs a name, host and config", i)
+		}
+		if names[site.Name] {
+			return nil, fmt.Errorf("site %s is listed twice", site.Name)
+		}
+		names[site.Name] = true
+
+		if !filepath.IsAbs(site.Config) {
+			fleet.Sites[i].Config = filepath.Join(filepath.Dir(path), site.Config)
+		}
+		if site.AgeKey != "" && !filepath.IsAbs(site.AgeKey) {
+			fleet.Sites[i].AgeKey = filepath.Join(filepath.Dir(path), site.AgeKey)
+		}
+	}
+
+	return fleet, nil
+}
+
+// ApplyFleet bootstraps every site of the fleet over SSH, writing each
+// site's log and bootstrap report to outDir/<site>, and a summary of all
+// of them to outDir/fleet.json. It fails when any site failed.
+func ApplyFleet(fleet *Fleet, opts SSHOptions, outDir string) error {
+	binary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, 0700); err != nil {
+		return err
+	}
+
+	// Configs are checked before touching any site. They are copied as
+	// they are, so a decrypted config never leaves this host.
+	configs := map[string][]byte{}
+	keys := map[string][]byte{}
+	for _, site := range fleet.Sites {
+		if _, err := LoadConfig(site.Config); err != nil {
+			return fmt.Errorf("%s config: %w", site.Name, err)
+		}
+
+		data, err := os.ReadFile(site.Config)
+		if err != nil {
+			return fmt.Errorf("%s config: %w", site.Name, err)
+		}
+		configs[site.Name] = data
+
+		if isSOPSEncrypted(data) {
+			if site.AgeKey == "" {
+				return fmt.Errorf("%s config is SOPS encrypted, set the site's ageKey", site.Name)
+			}
+			if keys[site.Name], err = os.ReadFile(site.AgeKey); err != nil {
+				return fmt.Errorf("%s age key: %w", site.Name, err)
+			}
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make([]FleetResult, len(fleet.Sites))
+		done    = 0
+		failing = 0
+	)
+	slots := make(chan struct{}, fleet.Concurrency)
+
+	for i, site := range fleet.Sites {
+		wg.Add(1)
+		go func(i int, site FleetSite) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			log.Printf("[%s] Bootstrapping %s\n", site.Name, site.Host)
+			start := time.Now()
+			result := bootstrapSite(site, siteSSHOptions(site, opts), binary, configs[site.Name], keys[site.Name], filepath.Join(outDir, site.Name))
+			result.Seconds = time.Since(start).Seconds()
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = result
+			done++
+			if result.Status != "succeeded" {
+				failing++
+			}
+			log.Printf("[%s] %s, %d of %d sites done, %d failed\n", site.Name, result.Status, done, len(fleet.Sites), failing)
+		}(i, site)
+	}
+	wg.Wait()
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, "fleet.json"), data, 0644); err != nil {
+		return err
+	}
+
+	var summary strings.Builder
+	table := tabwriter.NewWriter(&summary, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "SITE\tHOST\tSTATUS\tEXIT\tDURATION")
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\n", result.Site, result.Host, result.Status, result.ExitCode, time.Duration(result.Seconds*float64(time.Second)).Round(time.Second))
+	}
+	table.Flush()
+	log.Printf("Fleet summary:\n%s", summary.String())
+
+	if failing > 0 {
+		return fmt.Errorf("%d of %d sites failed", failing, len(fleet.Sites))
+	}
+
+	return nil
+}
+
+func siteSSHOptions(site FleetSite, opts SSHOptions) SSHOptions {
+	if site.User != "" {
+		opts.User = site.User
+	}
+	if site.Port != 0 {
+		opts.Port = site.Port
+	}
+
+	return opts
+}
+
+// bootstrapSite copies orsted and the site's config to the host, with its
+// age key when the config is SOPS encrypted, runs the bootstrap there and
+// fetches its report.
+func bootstrapSite(site FleetSite, opts SSHOptions, binary string, config []byte, key []byte, dir string) FleetResult {
+	result := FleetResult{Site: site.Name, Host: site.Host, Status: "failed", ExitCode: ExitFailure}
+	fail := func(err error) FleetResult {
+		result.Error = Redact(err.Error())
+		log.Printf("[%s] %s\n", site.Name, result.Error)
+		return result
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fail(err)
+	}
+
+	client, err := dialSSH(site.Host, opts)
+	if err != nil {
+		return fail(fmt.Errorf("connecting: %w", err))
+	}
+	defer client.Close()
+
+	if key != nil {
+		if _, err := runSSHClient(client, "command -v sops"); err != nil {
+			return fail(errors.New("sops isn't installed, the encrypted config can't be decrypted on the host"))
+		}
+	}
+
+	orsted, err := os.Open(binary)
+	if err != nil {
+		return fail(err)
+	}
+	defer orsted.Close()
+
+	if err := sshUpload(client, orsted, remoteBinary, "0755"); err != nil {
+		return fail(fmt.Errorf("copying orsted: %w", err))
+	}
+	if key != nil {
+		if err := sshUpload(client, bytes.NewReader(key), DefaultAgeKeyPath, "0600"); err != nil {
+			return fail(fmt.Errorf("copying the age key: %w", err))
+		}
+	}
+	if err := sshUpload(client, bytes.NewReader(config), ConfigPath, "0600"); err != nil {
+		return fail(fmt.Errorf("copying the config: %w", err))
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(dir, "bootstrap.log"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fail(err)
+	}
+	defer logFile.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fail(err)
+	}
+	defer session.Close()
+
+	// The site's output goes to its log file and, prefixed with the site,
+	// to the fleet's log.
+	output := prefixWriter(site.Name, logFile)
+	session.Stdout = output
+	session.Stderr = output
+
+	err = session.Run(remoteBinary)
+	output.Close()
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitStatus()
+		result.Error = fmt.Sprintf("orsted exited with %d", result.ExitCode)
+	} else if err != nil {
+		return fail(err)
+	} else {
+		result.Status = "succeeded"
+		result.ExitCode = 0
+	}
+
+	if report, err := runSSHClient(client, "cat "+ReportPath); err == nil {
+		if err := os.WriteFile(filepath.Join(dir, "report.json"), []byte(report), 0644); err != nil {
+			log.Printf("[%s] Failed to save the report: %s\n", site.Name, err)
+		}
+	} else {
+		log.Printf("[%s] Failed to fetch the report: %s\n", site.Name, err)
+	}
+
+	return result
+}
+
+// sshUpload writes contents to path on the host, replacing it atomically.
+func sshUpload(client *ssh.Client, contents io.Reader, path string, mode string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	session.Stdin = contents
+	tmp := path + ".orsted-upload"
+	out, err := session.CombinedOutput(fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s && chmod %s %s && mv %s %s",
+		filepath.Dir(path), tmp, mode, tmp, tmp, path))
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	return nil
+}
+
+func runSSHClient(client *ssh.Client, command string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	out, err := session.Output(command)
+	return string(out), err
+}
+
+// prefixWriter copies everything written to file and logs it line by line
+// prefixed with the site's name. Close returns once the last line is
+// logged.
+func prefixWriter(site string, file io.Writer) io.WriteCloser {
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			log.Printf("[%s] %s\n", site, scanner.Text())
+		}
+		reader.CloseWithError(scanner.Err())
+	}()
+
+	return &prefixedLog{Writer: io.MultiWriter(file, writer), pipe: writer, done: done}
+}
+
+type prefixedLog struct {
+	io.Writer
+	pipe *io.PipeWriter
+	done chan struct{}
+}
+
+func (p *prefixedLog) Close() error {
+	err := p.pipe.Close()
+	<-p.done
+
+	return err
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+// The annotations Helm records the release of its objects in.
+const (
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// Orphans are what orsted labeled as its own on the cluster that the
+// config no longer deploys.
+type Orphans struct {
+	// Releases are namespace/name of Helm releases, uninstalled with all
+	// their objects.
+	Releases []string
+	// Objects are applied from manifests, namespaces last.
+	Objects []object
+}
+
+func (o Orphans) Empty() bool {
+	return len(o.Releases) == 0 && len(o.Objects) == 0
+}
+
+// FindOrphans lists the objects labeled as managed by orsted that neither
+// a release nor a manifest of the config deploys anymore, like those of
+// removed addons or renamed releases. Objects with an owner are left to
+// the garbage collector of Kubernetes, and those applied from manifests
+// orsted fetches rather than renders are never orphans, it can't tell
+// whether they are still wanted. Neither are the virtual clusters of
+// tenants, created outside the config, nor namespaces holding anything
+// orsted doesn't own.
+func FindOrphans(ctx context.Context, conf *Config) (Orphans, error) {
+	releases, err := Releases(conf, "")
+	if err != nil {
+		return Orphans{}, err
+	}
+	wantedReleases := map[string]bool{}
+	usedNamespaces := map[string]bool{}
+	for _, release := range releases {
+		wantedReleases[release.Spec.Namespace+"/"+release.Spec.ReleaseName] = true
+		usedNamespaces[release.Spec.Namespace] = true
+	}
+
+	manifests, err := renderManifests(conf)
+	if err != nil {
+		return Orphans{}, err
+	}
+	wanted := map[string]bool{}
+	for _, manifest := range manifests {
+		objects, err := manifestObjects(manifest)
+		if err != nil {
+			return Orphans{}, err
+		}
+		for key, obj := range objects {
+			wanted[key] = true
+			metadata, _ := obj["metadata"].(map[string]interface{})
+			if ns, _ := metadata["namespace"].(string); ns != "" {
+				usedNamespaces[ns] = true
+			}
+		}
+	}
+	for _, ns := range Namespaces(conf) {
+		wanted["Namespace//"+ns.Name] = true
+	}
+
+	live, err := listManaged(ctx)
+	if err != nil {
+		return Orphans{}, err
+	}
+
+	var orphans Orphans
+	var namespaces []object
+	seenReleases := map[string]bool{}
+	for _, obj := range live {
+		if len(obj.Metadata.OwnerReferences) > 0 || obj.Metadata.Labels[fetchedLabel] == "true" {
+			continue
+		}
+		// A virtual cluster syncs the objects of its tenant into its
+		// namespace, labels and all.
+		if obj.Metadata.Labels[vclusterSyncedLabel] != "" {
+			continue
+		}
+
+		if name := obj.Metadata.Annotations[helmReleaseNameAnnotation]; name != "" {
+			ns := obj.Metadata.Annotations[helmReleaseNamespaceAnnotation]
+			if ns == vclusterNamespace(name) {
+				continue
+			}
+
+			release := ns + "/" + name
+			if !wantedReleases[release] && !seenReleases[release] {
+				seenReleases[release] = true
+				orphans.Releases = append(orphans.Releases, release)
+			}
+			continue
+		}
+
+		key := obj.Kind + "/" + obj.Metadata.Namespace + "/" + obj.Metadata.Name
+		// Manifests may leave the namespace of their objects to kubectl.
+		defaulted := obj.Kind + "//" + obj.Metadata.Name
+		if wanted[key] || (obj.Metadata.Namespace == "default" && wanted[defaulted]) {
+			continue
+		}
+
+		orphan := obj.object()
+		if obj.Kind != "Namespace" {
+			orphans.Objects = append(orphans.Objects, orphan)
+		} else if !usedNamespaces[obj.Metadata.Name] {
+			foreign, err := foreignObjects(ctx, obj.Metadata.Name)
+			if err != nil {
+				return Orphans{}, err
+			}
+			if len(foreign) > 0 {
+				log.Printf("Keeping namespace %s, it holds %s orsted doesn't own\n", obj.Metadata.Name, strings.Join(foreign, ", "))
+				continue
+			}
+
+			namespaces = append(namespaces, orphan)
+		}
+	}
+
+	sort.Strings(orphans.Releases)
+	sort.SliceStable(orphans.Objects, func(i, j int) bool {
+		return objectRef(orphans.Objects[i]) < objectRef(orphans.Objects[j])
+	})
+	orphans.Objects = append(orphans.Objects, namespaces...)
+
+	return orphans, nil
+}
+
+// managedObject is what FindOrphans needs to know of a live object.
+type managedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+		OwnerReferences []interface{}     `json:"ownerReferences"`
+	} `json:"metadata"`
+}
+
+// object is the reference to obj kubectl delete needs.
+func (obj managedObject) object() object {
+	metadata := object{"name": obj.Metadata.Name}
+	if obj.Metadata.Namespace != "" {
+		metadata["namespace"] = obj.Metadata.Namespace
+	}
+
+	return object{"apiVersion": obj.APIVersion, "kind": obj.Kind, "metadata": metadata}
+}
+
+// listManaged lists the objects of every resource type the cluster serves
+// that carry the managed-by label of orsted.
+func listManaged(ctx context.Context) ([]managedObject, error) {
+	resources, err := listResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return getObjects(ctx, resources, "--all-namespaces", "-l", managedSelector)
+}
+
+// foreignObjects lists what namespace ns holds that orsted doesn't own,
+// besides what Kubernetes creates in every namespace and objects with an
+// owner, which go along with it. orsted may have labeled a namespace it
+// only annotated, deleting it would delete those objects.
+func foreignObjects(ctx context.Context, ns string) ([]string, error) {
+	resources, err := listResources(ctx, "--namespaced=true")
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := getObjects(ctx, resources, "-n", ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var foreign []string
+	for _, obj := range objects {
+		if obj.Metadata.Labels[managedByLabel] == managedByValue || len(obj.Metadata.OwnerReferences) > 0 {
+			continue
+		}
+		if (obj.Kind == "ServiceAccount" && obj.Metadata.Name == "default") || (obj.Kind == "ConfigMap" && obj.Metadata.Name == "kube-root-ca.crt") {
+			continue
+		}
+
+		foreign = append(foreign, obj.Kind+"/"+obj.Metadata.Name)
+	}
+
+	return foreign, nil
+}
+
+// listResources lists the resource types the cluster serves that can be
+// listed and deleted, narrowed by the kubectl api-resources args.
+func listResources(ctx context.Context, args ...string) ([]string, error) {
+	args = append([]string{"api-resources", "--kubeconfig=" + KubeconfigPath, "--verbs=list,delete", "-o", "name"}, args...)
+	out, err := RunCommand(ctx, "kubectl", args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing resource types: %w: %s", err, out)
+	}
+
+	var resources []string
+	for _, resource := range strings.Fields(out) {
+		// Events copy nothing of their object's labels, but there are many.
+		if resource != "events" && resource != "events.events.k8s.io" {
+			resources = append(resources, resource)
+		}
+	}
+
+	return resources, nil
+}
+
+// getObjects gets the objects of resources selected by the kubectl get
+// args.
+func getObjects(ctx context.Context, resources []string, args ...string) ([]managedObject, error) {
+	args = append([]string{"get", "--kubeconfig=" + KubeconfigPath, strings.Join(resources, ","), "-o", "json"}, args...)
+	out, err := RunCommand(ctx, "kubectl", args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing objects: %w: %s", err, out)
+	}
+
+	var list struct {
+		Items []managedObject `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, fmt.Errorf("parsing objects: %w", err)
+	}
+
+	return list.Items, nil
+}
+
+func objectRef(obj object) string {
+	metadata, _ := obj["metadata"].(object)
+	ref := fmt.Sprintf("%s/%s", obj["kind"], metadata["name"])
+	if ns, ok := metadata["namespace"]; ok {
+		ref = fmt.Sprintf("%s/%s", ns, ref)
+	}
+
+	return ref
+}
+
+// Print lists the orphans as gc would delete them.
+func (o Orphans) Print(out io.Writer) {
+	for _, release := range o.Releases {
+		fmt.Fprintf(out, "  release  %s\n", release)
+	}
+	for _, obj := range o.Objects {
+		fmt.Fprintf(out, "  object   %s\n", objectRef(obj))
+	}
+}
+
+// Delete uninstalls the orphaned releases and deletes the orphaned
+// objects, the namespaces last.
+func (o Orphans) Delete(ctx context.Context) error {
+	for _, release := range o.Releases {
+		ns, name, _ := strings.Cut(release, "/")
+		client, err := helmClientForNs(ns)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Uninstalling %s\n", release)
+		if err := client.UninstallReleaseByName(name); err != nil {
+			return fmt.Errorf("uninstalling %s: %w", release, err)
+		}
+	}
+
+	if len(o.Objects) == 0 {
+		return nil
+	}
+
+	manifest, err := renderObjects(o.Objects)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleting %d objects\n", len(o.Objects))
+	out, err := RunCommandWithInput(ctx, manifest, "kubectl", "delete", "--kubeconfig="+KubeconfigPath, "--ignore-not-found", "-f", "-")
+	if err != nil {
+		return fmt.Errorf("deleting objects: %w: %s", err, out)
+	}
+
+	return nil
+}
+
+// CollectGarbage finds the orphans of the config and deletes them once
+// confirmed on in, or right away with yes.
+func CollectGarbage(ctx context.Context, conf *Config, in io.Reader, out io.Writer, yes bool) error {
+	orphans, err := FindOrphans(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	if orphans.Empty() {
+		fmt.Fprintln(out, "Nothing to collect")
+		return nil
+	}
+
+	fmt.Fprintln(out, "The config no longer deploys:")
+	orphans.Print(out)
+
+	if !yes {
+		fmt.Fprint(out, "Delete them? [y/N] ")
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		if answer := strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Fprintln(out, "Nothing deleted")
+			return nil
+		}
+	}
+
+	return orphans.Delete(ctx)
+}
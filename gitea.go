@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	_ "embed"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"helm.sh/helm/v3/pkg/repo"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	//go:embed values/gitea.yaml
+	GiteaYaml string
+
+	//go:embed manifests/gitea-flux.yaml
+	GiteaFluxManifest string
+)
+
+var giteaRepo = repo.Entry{
+	Name: "gitea-charts",
+	URL:  "https://dl.gitea.com/charts/",
+}
+
+var fluxRepo = repo.Entry{
+	Name: "fluxcd-community",
+	URL:  "https://fluxcd-community.github.io/helm-charts",
+}
+
+// giteaSSHHost is the name Flux clones from, the deploy key's known_hosts
+// entry is written for it.
+const giteaSSHHost = "gitea-ssh.gitea.svc.cluster.local"
+
+func fluxSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	return &helmclient.ChartSpec{
+		ReleaseName:     "flux",
+		ChartName:       "fluxcd-community/flux2",
+		Namespace:       "flux-system",
+		CreateNamespace: true,
+		UpgradeCRDs:     true,
+		Wait:            true,
+		Timeout:         time.Minute * 5,
+		Version:         conf.Addons.Flux.Version,
+	}, nil
+}
+
+func giteaSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	values, err := renderValues("gitea", GiteaYaml, conf.Addons.Gitea)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "gitea",
+		ChartName:       "gitea-charts/gitea",
+		Namespace:       "gitea",
+		CreateNamespace: true,
+		Wait:            true,
+		Timeout:         time.Minute * 10,
+		Version:         conf.Addons.Gitea.Version,
+		ValuesYaml:      values,
+	}, nil
+}
+
+func prepareGitea(ctx context.Context, conf *Config) error {
+	if conf.Addons.Gitea.AdminPassword == "" {
+		return errors.New("gitea needs an adminPassword")
+	}
+
+	_, err := ensureSecret(ctx, "gitea", "gitea-admin", map[string]string{
+		"username": "orsted",
+		"password": conf.Addons.Gitea.AdminPassword.Reveal(),
+	})
+
+	return err
+}
+
+func giteaManifests(conf *Config) (string, error) {
+	return renderValues("gitea-flux", GiteaFluxManifest, conf.Addons.Gitea)
+}
+
+// giteaAPI calls the Gitea API as the admin user. It returns false for a
+// 404 response so callers can tell missing objects apart from failures.
+func giteaAPI(ctx context.Context, base string, conf GiteaConfig, method string, path string, body interface{}, out interface{}) (bool, error) {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return false, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, base+"/api/v1"+path, &payload)
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth("orsted", conf.AdminPassword.Reveal())
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	if out != nil {
+		return true, json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	return true, nil
+}
+
+func serviceAddress(ctx context.Context, k8sClient *kubernetes.Clientset, ns string, name string, port string) (string, error) {
+	svc, err := k8sClient.CoreV1().Services(ns).Get(ctx, name, meta.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(svc.Spec.ClusterIP, port), nil
+}
+
+// giteaDeployKey returns the Flux deploy key secret, generating the key and
+// recording Gitea's SSH host key on the first run.
+func giteaDeployKey(ctx context.Context, k8sClient *kubernetes.Clientset) (*core.Secret, error) {
+	secrets := k8sClient.CoreV1().Secrets("flux-system")
+
+	secret, err := secrets.Get(ctx, "gitea-deploy-key", meta.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return secret, err
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	sshAddr, err := serviceAddress(ctx, k8sClient, "gitea", "gitea-ssh", "22")
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the host key is needed, the handshake is aborted once it has
+	// been offered.
+	var hostKey ssh.PublicKey
+	_, err = ssh.Dial("tcp", sshAddr, &ssh.ClientConfig{
+		User: "git",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errors.New("host key recorded")
+		},
+		Timeout: time.Second * 10,
+	})
+	if hostKey == nil {
+		return nil, fmt.Errorf("reading gitea host key: %w", err)
+	}
+
+	secret = &core.Secret{
+		ObjectMeta: meta.ObjectMeta{Name: "gitea-deploy-key", Namespace: "flux-system"},
+		Data: map[string][]byte{
+			"identity":     pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+			"identity.pub": ssh.MarshalAuthorizedKey(sshPub),
+			"known_hosts":  []byte(knownhosts.Line([]string{giteaSSHHost}, hostKey) + "\n"),
+		},
+	}
+
+	return secrets.Create(ctx, secret, meta.CreateOptions{})
+}
+
+// configureGitea creates the GitOps organization and repository and gives
+// Flux read access to it with a deploy key.
+func configureGitea(ctx context.Context, conf *Config) error {
+	gitea := conf.Addons.Gitea
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+
+	addr, err := serviceAddress(ctx, k8sClient, "gitea", "gitea-http", "3000")
+	if err != nil {
+		return err
+	}
+	base := "http://" + addr
+
+	found, err := giteaAPI(ctx, base, gitea, "GET", "/orgs/"+gitea.Org, nil, nil)
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.Printf("Creating Gitea organization %s\n", gitea.Org)
+		if _, err := giteaAPI(ctx, base, gitea, "POST", "/orgs", map[string]interface{}{
+			"username":   gitea.Org,
+			"visibility": "private",
+		}, nil); err != nil {
+			return err
+		}
+	}
+
+	found, err = giteaAPI(ctx, base, gitea, "GET", "/repos/"+gitea.Org+"/"+gitea.Repo, nil, nil)
+	if err != nil {
+		return err
+	}
+	if !found {
+		log.Printf("Creating Gitea repository %s/%s\n", gitea.Org, gitea.Repo)
+		if _, err := giteaAPI(ctx, base, gitea, "POST", "/orgs/"+gitea.Org+"/repos", map[string]interface{}{
+			"name":           gitea.Repo,
+			"private":        true,
+			"auto_init":      true,
+			"default_branch": "main",
+		}, nil); err != nil {
+			return err
+		}
+	}
+
+	secret, err := giteaDeployKey(ctx, k8sClient)
+	if err != nil {
+		return err
+	}
+
+	var keys []struct {
+		Title string `json:"title"`
+	}
+	if _, err := giteaAPI(ctx, base, gitea, "GET", "/repos/"+gitea.Org+"/"+gitea.Repo+"/keys", nil, &keys); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if key.Title == "flux" {
+			return nil
+		}
+	}
+
+	log.Println("Adding the Flux deploy key to Gitea")
+	_, err = giteaAPI(ctx, base, gitea, "POST", "/repos/"+gitea.Org+"/"+gitea.Repo+"/keys", map[string]interface{}{
+		"title":     "flux",
+		"key":       string(secret.Data["identity.pub"]),
+		"read_only": true,
+	}, nil)
+
+	return err
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	//go:embed values/harbor.yaml
+	HarborYaml string
+
+	//go:embed manifests/harbor-bucket.yaml
+	HarborBucketManifest string
+)
+
+var harborRepo = repo.Entry{
+	Name: "harbor",
+	URL:  "https://helm.goharbor.io",
+}
+
+// CRIORegistriesConf points CRI-O at the Harbor proxy cache projects.
+const CRIORegistriesConf = "/etc/containers/registries.conf.d/50-orsted-harbor.conf"
+
+// harborEndpoint returns the host:port Harbor is reached at from the node
+// and the local network.
+func harborEndpoint(conf HarborConfig) (string, error) {
+	host := conf.Hostname
+	if host == "" {
+		ip, err := GetDefaultIP()
+		if err != nil {
+			return "", err
+		}
+		host = ip.String()
+	}
+
+	return host + ":" + strconv.Itoa(conf.NodePort), nil
+}
+
+// harborProject is the proxy cache project mirroring a registry.
+func harborProject(registry string) string {
+	return strings.NewReplacer(".", "-", ":", "-").Replace(registry)
+}
+
+func harborSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	harbor := conf.Addons.Harbor
+	if harbor.AdminPassword == "" {
+		return nil, errors.New("harbor needs an adminPassword")
+	}
+	if harbor.Storage != "block" && harbor.Storage != "s3" {
+		return nil, fmt.Errorf("unknown harbor storage %q, expected block or s3", harbor.Storage)
+	}
+
+	endpoint, err := harborEndpoint(harbor)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := renderValues("harbor", HarborYaml, struct {
+		HarborConfig
+		ExternalURL string
+	}{harbor, "http://" + endpoint})
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "harbor",
+		ChartName:       "harbor/harbor",
+		Namespace:       "harbor",
+		CreateNamespace: true,
+		Wait:            true,
+		Timeout:         time.Minute * 10,
+		Version:         harbor.Version,
+		ValuesYaml:      values,
+	}, nil
+}
+
+// prepareHarbor claims the registry bucket on the Ceph object store and
+// hands its keys to Harbor under the names the chart expects.
+func prepareHarbor(ctx context.Context, conf *Config) error {
+	if conf.Addons.Harbor.Storage != "s3" {
+		return nil
+	}
+
+	applyOut, err := ApplyManifest(ctx, "addons", HarborBucketManifest)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, applyOut)
+	}
+
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+	secrets := k8sClient.CoreV1().Secrets("harbor")
+
+	var bucket *core.Secret
+	deadline := time.Now().Add(time.Minute * 3)
+	for {
+		bucket, err = secrets.Get(ctx, "harbor-registry", meta.GetOptions{})
+		if err == nil {
+			break
+		}
+		if !apierrors.IsNotFound(err) || time.Now().After(deadline) {
+			return fmt.Errorf("waiting for the harbor bucket: %w", err)
+		}
+		time.Sleep(time.Second * 5)
+	}
+
+	credentials := &core.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "harbor-s3",
+			Namespace: "harbor",
+		},
+		Data: map[string][]byte{
+			"REGISTRY_STORAGE_S3_ACCESSKEY": bucket.Data["AWS_ACCESS_KEY_ID"],
+			"REGISTRY_STORAGE_S3_SECRETKEY": bucket.Data["AWS_SECRET_ACCESS_KEY"],
+		},
+	}
+
+	_, err = secrets.Create(ctx, credentials, meta.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(ctx, credentials, meta.UpdateOptions{})
+	}
+
+	return err
+}
+
+// harborAPI calls the Harbor v2 API as admin, decoding the response into
+// out if given.
+func harborAPI(ctx context.Context, harbor HarborConfig, method string, path string, body interface{}, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	endpoint, err := harborEndpoint(harbor)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+endpoint+"/api/v2.0"+path, &payload)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("admin", harbor.AdminPassword.Reveal())
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// harborRegistryType maps well known registries to their Harbor adapters.
+func harborRegistryType(registry string) string {
+	switch registry {
+	case "docker.io":
+		return "docker-hub"
+	case "quay.io":
+		return "quay"
+	case "ghcr.io":
+		return "github-ghcr"
+	}
+
+	return "docker-registry"
+}
+
+// configureHarbor creates a proxy cache project for every upstream registry
+// and points CRI-O at them.
+func configureHarbor(ctx context.Context, conf *Config) error {
+	harbor := conf.Addons.Harbor
+
+	endpoint, err := harborEndpoint(harbor)
+	if err != nil {
+		return err
+	}
+
+	registries := make([]string, 0, len(harbor.ProxyCache))
+	for registry := range harbor.ProxyCache {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+
+	var mirrors strings.Builder
+	for _, registry := range registries {
+		type named struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		}
+
+		var existing []named
+		if err := harborAPI(ctx, harbor, "GET", "/registries?q=name%3D"+url.QueryEscape(registry), nil, &existing); err != nil {
+			return err
+		}
+
+		if len(existing) == 0 {
+			err := harborAPI(ctx, harbor, "POST", "/registries", map[string]interface{}{
+				"name": registry,
+				"type": harborRegistryType(registry),
+				"url":  harbor.ProxyCache[registry],
+			}, nil)
+			if err != nil {
+				return err
+			}
+
+			if err := harborAPI(ctx, harbor, "GET", "/registries?q=name%3D"+url.QueryEscape(registry), nil, &existing); err != nil {
+				return err
+			}
+			if len(existing) == 0 {
+				return fmt.Errorf("harbor registry endpoint %s missing after creating it", registry)
+			}
+		}
+
+		project := harborProject(registry)
+		var projects []struct {
+			Name string `json:"name"`
+		}
+		if err := harborAPI(ctx, harbor, "GET", "/projects?name="+url.QueryEscape(project), nil, &projects); err != nil {
+			return err
+		}
+
+		found := false
+		for _, p := range projects {
+			found = found || p.Name == project
+		}
+
+		if !found {
+			log.Printf("Creating Harbor proxy cache %s for %s\n", project, registry)
+			err := harborAPI(ctx, harbor, "POST", "/projects", map[string]interface{}{
+				"project_name": project,
+				"registry_id":  existing[0].ID,
+				"metadata":     map[string]string{"public": "true"},
+			}, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(&mirrors, "[[registry]]\nprefix = %q\nlocation = %q\n\n[[registry.mirror]]\nlocation = %q\ninsecure = true\n\n",
+			registry, registry, endpoint+"/"+project)
+	}
+
+	if !harbor.MirrorCRIO || len(registries) == 0 {
+		return nil
+	}
+
+	if err := writeFile(CRIORegistriesConf, mirrors.String()); err != nil {
+		return err
+	}
+
+	log.Println("Reloading CRI-O registry mirrors")
+	reloadOut, err := RunCommand(ctx, "systemctl", "reload", "crio")
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, reloadOut)
+	}
+
+	return nil
+}
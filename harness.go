@@ -0,0 +1,119 @@
+//go:build harness
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// The integration harness runs the bootstrap against a kind cluster with
+// a command runner that records every command and fakes or fails the ones
+// it is told to, checking which phase the bootstrap ends in and with what
+// exit code. It's only built with -tags harness, see `make harness`.
+
+var (
+	harnessFail          []string
+	harnessFake          []string
+	harnessExpectPhase   string
+	harnessExpectCode    int
+	harnessTranscript    string
+	errInjectedByHarness = errors.New("failure injected by the harness")
+)
+
+// fakeRunner wraps the real command runner. Commands starting with one of
+// fail fail, those starting with one of fake succeed without running and
+// the rest run for real.
+type fakeRunner struct {
+	fail []string
+	fake []string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *fakeRunner) run(ctx context.Context, input string, command string, args ...string) (string, error) {
+	line := strings.Join(append([]string{command}, args...), " ")
+
+	r.mu.Lock()
+	r.commands = append(r.commands, line)
+	r.mu.Unlock()
+
+	if matchesPrefix(line, r.fail) {
+		log.Printf("Harness failing %s\n", line)
+		return "injected by the harness\n", errInjectedByHarness
+	}
+	if matchesPrefix(line, r.fake) {
+		log.Printf("Harness faking %s\n", line)
+		return "", nil
+	}
+
+	return execCommand(ctx, input, command, args...)
+}
+
+func matchesPrefix(line string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var harnessCmd = &cobra.Command{
+	Use:   "harness",
+	Short: "Run the bootstrap against kind with faked commands and check how it ends",
+	Long: `Harness bootstraps onto the kind cluster of --dev-kind, creating it when
+missing, with every command orsted runs recorded. Commands starting with a
+--fake prefix succeed without running and those starting with a --fail prefix
+fail, e.g. --fail "kubectl apply" to check how the Gateway CRD phase fails.
+
+It exits 0 when the bootstrap ended in the expected phase with the expected
+exit code, and 1 otherwise. The defaults expect it to succeed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runner := &fakeRunner{fail: harnessFail, fake: harnessFake}
+		commandRunner = runner.run
+		devKind = true
+
+		err := Bootstrap(mustLoadConfig(), false)
+
+		phase, code := "", 0
+		if err != nil {
+			failure := asFailure(err)
+			phase, code = failure.Phase, failure.Code
+		}
+
+		if harnessTranscript != "" {
+			if err := os.WriteFile(harnessTranscript, []byte(strings.Join(runner.commands, "\n")+"\n"), 0644); err != nil {
+				log.Printf("Failed to write the transcript: %s\n", err)
+			}
+		}
+
+		log.Printf("Harness ran %d commands, the bootstrap ended in phase %q with exit code %d\n", len(runner.commands), phase, code)
+		if phase != harnessExpectPhase || code != harnessExpectCode {
+			fmt.Printf("FAIL: expected phase %q with exit code %d\n", harnessExpectPhase, harnessExpectCode)
+			os.Exit(1)
+		}
+
+		fmt.Println("PASS")
+	},
+}
+
+func init() {
+	harnessCmd.Flags().StringArrayVar(&harnessFail, "fail", nil, "fail commands starting with this, repeatable")
+	harnessCmd.Flags().StringArrayVar(&harnessFake, "fake", nil, "succeed commands starting with this without running them, repeatable")
+	harnessCmd.Flags().StringVar(&harnessExpectPhase, "expect-phase", "", "phase the bootstrap is expected to fail in, empty to expect success")
+	harnessCmd.Flags().IntVar(&harnessExpectCode, "expect-code", 0, "exit code the bootstrap is expected to end with")
+	harnessCmd.Flags().StringVar(&harnessTranscript, "transcript", "", "file to write every command run to")
+	harnessCmd.RegisterFlagCompletionFunc("expect-phase", completePhase)
+	rootCmd.AddCommand(harnessCmd)
+}
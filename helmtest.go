@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+type TestResult struct {
+	Release string       `json:"release"`
+	Passed  bool         `json:"passed"`
+	Hooks   []HookResult `json:"hooks,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	// Logs of the test pods, only kept when the test failed.
+	Logs string `json:"logs,omitempty"`
+}
+
+type HookResult struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+}
+
+// TestRelease runs the `helm test` hooks of an installed release. Charts
+// without test hooks pass trivially.
+func TestRelease(ctx context.Context, client helmclient.Client, spec *helmclient.ChartSpec) TestResult {
+	_, span := tracer.Start(ctx, "helm test "+spec.ReleaseName, chartAttributes(spec))
+
+	result := TestResult{Release: spec.ReleaseName}
+
+	helmClient, ok := client.(*helmclient.HelmClient)
+	if !ok {
+		err := errors.New("helm client does not expose an action configuration")
+		endSpan(span, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	testing := action.NewReleaseTesting(helmClient.ActionConfig)
+	testing.Namespace = spec.Namespace
+	testing.Timeout = time.Minute * 5
+
+	rel, err := testing.Run(spec.ReleaseName)
+	endSpan(span, err)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Passed = true
+	}
+
+	if rel == nil {
+		return result
+	}
+
+	for _, hook := range rel.Hooks {
+		for _, event := range hook.Events {
+			if event == release.HookTest {
+				result.Hooks = append(result.Hooks, HookResult{hook.Name, hook.LastRun.Phase.String()})
+			}
+		}
+	}
+
+	if !result.Passed {
+		var logs strings.Builder
+		if err := testing.GetPodLogs(&logs, rel); err == nil {
+			result.Logs = logs.String()
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PruneReleaseHistory deletes the Helm release secrets of managed releases
+// beyond the newest max revisions. MaxHistory only trims a release when it
+// is next upgraded, releases that were installed before it was set keep
+// every revision they ever had.
+func PruneReleaseHistory(ctx context.Context, k8sClient *kubernetes.Clientset, releases []Release, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	for _, release := range releases {
+		secrets := k8sClient.CoreV1().Secrets(release.Spec.Namespace)
+		list, err := secrets.List(ctx, meta.ListOptions{
+			LabelSelector: fmt.Sprintf("owner=helm,name=%s", release.Spec.ReleaseName),
+		})
+		if err != nil {
+			return err
+		}
+
+		revisions := list.Items
+		sort.Slice(revisions, func(i, j int) bool {
+			a, _ := strconv.Atoi(revisions[i].Labels["version"])
+			b, _ := strconv.Atoi(revisions[j].Labels["version"])
+			return a > b
+		})
+
+		for i := max; i < len(revisions); i++ {
+			if revisions[i].Labels["status"] == "deployed" {
+				continue
+			}
+
+			log.Printf("Pruning %s revision %s\n", release.Spec.ReleaseName, revisions[i].Labels["version"])
+			if err := secrets.Delete(ctx, revisions[i].Name, meta.DeleteOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// nodeName is the name kubeadm registers the node under: the
+// nodeRegistration name of the kubeadm config, or the lowercased hostname
+// kubeadm and the kubelet default to. Not `hostname -f`, which is the FQDN
+// on hosts with a domain.
+func nodeName() (string, error) {
+	docs, err := readKubeadmConfig()
+	if err != nil {
+		return "", err
+	}
+
+	for _, doc := range docs {
+		if doc["kind"] != "InitConfiguration" {
+			continue
+		}
+
+		registration, _ := doc["nodeRegistration"].(map[string]interface{})
+		if name, ok := registration["name"].(string); ok && name != "" {
+			return name, nil
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(strings.TrimSpace(hostname)), nil
+}
+
+// checkHostname verifies the node name is valid, the hostname survives
+// reboots and the node name resolves to an address of this host.
+func checkHostname(ctx context.Context, conf *Config) error {
+	name, err := nodeName()
+	if err != nil {
+		return err
+	}
+
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("node name %s is invalid: %s", name, strings.Join(errs, ", "))
+	}
+
+	var problems []string
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	static, err := os.ReadFile("/etc/hostname")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if strings.TrimSpace(string(static)) != hostname {
+		problems = append(problems, fmt.Sprintf("hostname %s is not the static hostname in /etc/hostname and changes on reboot", hostname))
+	}
+
+	local, err := resolvesLocally(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !local {
+		problems = append(problems, fmt.Sprintf("node name %s does not resolve to an address of this host", name))
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// fixHostname makes the current hostname the static one and adds a hosts
+// entry for the node name at the node IP when it doesn't resolve here.
+func fixHostname(ctx context.Context, conf *Config) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	static, err := os.ReadFile("/etc/hostname")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if strings.TrimSpace(string(static)) != hostname {
+		out, err := RunCommand(ctx, "hostnamectl", "set-hostname", "--static", hostname)
+		if err != nil {
+			return fmt.Errorf("hostnamectl: %w: %s", err, out)
+		}
+	}
+
+	name, err := nodeName()
+	if err != nil {
+		return err
+	}
+
+	local, err := resolvesLocally(ctx, name)
+	if err != nil || local {
+		return err
+	}
+
+	ip, err := primaryNodeIP(conf)
+	if err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile("/etc/hosts")
+	if err != nil {
+		return err
+	}
+
+	hosts, err := os.OpenFile("/etc/hosts", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer hosts.Close()
+
+	// The entry would run into the last line otherwise.
+	entry := fmt.Sprintf("%s %s # added by orsted\n", ip, name)
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		entry = "\n" + entry
+	}
+
+	_, err = hosts.WriteString(entry)
+	return err
+}
+
+// resolvesLocally reports whether name resolves to at least one address
+// assigned to this host.
+func resolvesLocally(ctx context.Context, name string) (bool, error) {
+	resolved, err := net.DefaultResolver.LookupHost(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false, err
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		for _, ip := range resolved {
+			if ipNet.IP.Equal(net.ParseIP(ip)) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// registeredNodeName finds the Node the kubelet registered for this host:
+// the one named as kubeadm was told to, or else the one with an address
+// of this host, which is what it is when the kubelet's idea of the
+// hostname differs from ours.
+func registeredNodeName(ctx context.Context, k8sClient kubernetes.Interface) (string, error) {
+	expected, err := nodeName()
+	if err != nil {
+		return "", err
+	}
+
+	nodes, err := k8sClient.CoreV1().Nodes().List(ctx, meta.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Items {
+		if node.Name == expected {
+			return node.Name, nil
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type != core.NodeInternalIP && address.Type != core.NodeExternalIP {
+				continue
+			}
+
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(net.ParseIP(address.Address)) {
+					log.Printf("Node %s registered as %s\n", expected, node.Name)
+					return node.Name, nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no node registered as %s or with an address of this host", expected)
+}
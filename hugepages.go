@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// hugepageSizes maps the Kubernetes resource suffix of a page size to its
+// sysfs directory and kernel parameter names.
+var hugepageSizes = map[string]struct{ sysfs, param string }{
+	"2Mi": {"hugepages-2048kB", "2M"},
+	"1Gi": {"hugepages-1048576kB", "1G"},
+}
+
+// The pages are allocated by a unit on every boot, before the kubelet
+// starts and advertises them as hugepages-<size> resources.
+const hugepagesUnit = `[Unit]
+Description=Allocate hugepages
+Before=kubelet.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh -c '%s'
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// ConfigureHugepages reserves the configured hugepages, at runtime through
+// sysfs or on the kernel command line, which takes a reboot but is the
+// only reliable way to get 1Gi pages on a fragmented system.
+func ConfigureHugepages(ctx context.Context, conf *Config) error {
+	hugepages := conf.Hugepages
+
+	var args []string
+	var commands []string
+	for _, pages := range hugepages.Pages {
+		size, ok := hugepageSizes[pages.Size]
+		if !ok {
+			return failedWith(ExitConfig, "hugepages", fmt.Errorf("unsupported size %s, use 2Mi or 1Gi", pages.Size))
+		}
+
+		dir := "/sys/kernel/mm/hugepages/" + size.sysfs
+		if _, err := os.Stat(dir); err != nil {
+			return failedWith(ExitPreflight, "hugepages", fmt.Errorf("the CPU or kernel does not support %s pages: %w", pages.Size, err))
+		}
+
+		args = append(args, "hugepagesz="+size.param, fmt.Sprintf("hugepages=%d", pages.Count))
+		commands = append(commands, fmt.Sprintf("echo %d > %s/nr_hugepages", pages.Count, dir))
+	}
+
+	if hugepages.BootTime {
+		if err := setHugepagesCmdline(ctx, args); err != nil {
+			return err
+		}
+	} else {
+		if err := writeFile("/etc/systemd/system/orsted-hugepages.service", fmt.Sprintf(hugepagesUnit, strings.Join(commands, "; "))); err != nil {
+			return failed("hugepages", err)
+		}
+
+		log.Println("Allocating hugepages")
+		for _, command := range [][]string{{"daemon-reload"}, {"enable", "orsted-hugepages.service"}, {"restart", "orsted-hugepages.service"}} {
+			systemctlOut, err := RunCommand(ctx, "systemctl", command...)
+			if err != nil {
+				return commandFailed("hugepages", systemctlOut, fmt.Errorf("allocating: %w", err))
+			}
+		}
+	}
+
+	// The kernel allocates fewer pages than asked for when memory is too
+	// fragmented, rather than failing.
+	for _, pages := range hugepages.Pages {
+		data, err := os.ReadFile("/sys/kernel/mm/hugepages/" + hugepageSizes[pages.Size].sysfs + "/nr_hugepages")
+		if err != nil {
+			return failed("hugepages", fmt.Errorf("reading the allocated pages: %w", err))
+		}
+
+		allocated, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if allocated < pages.Count {
+			return failed("hugepages", fmt.Errorf("only %d of %d %s pages could be allocated, set hugepages.bootTime to reserve them at boot", allocated, pages.Count, pages.Size))
+		}
+	}
+
+	return nil
+}
+
+// setHugepagesCmdline adds the hugepage parameters to the kernel command
+// line, stopping the bootstrap until the node has rebooted with them.
+func setHugepagesCmdline(ctx context.Context, args []string) error {
+	cmdline, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return failed("hugepages", fmt.Errorf("reading the kernel command line: %w", err))
+	}
+
+	current := strings.Fields(string(cmdline))
+	missing := false
+	for _, arg := range args {
+		found := false
+		for _, c := range current {
+			found = found || c == arg
+		}
+		missing = missing || !found
+	}
+	if !missing {
+		return nil
+	}
+
+	distro, err := DetectDistro()
+	if err != nil {
+		return failed("hugepages", err)
+	}
+
+	log.Println("Adding hugepages to the kernel command line")
+	if err := distro.AddKernelArgs(ctx, args); err != nil {
+		return failed("hugepages", fmt.Errorf("updating the kernel command line: %w", err))
+	}
+
+	return failed("hugepages", errors.New("reboot the node to reserve the hugepages, then run orsted again"))
+}
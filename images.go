@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// ImageLock maps fully qualified image references to the digests they
+// resolved to when the lock was written.
+type ImageLock map[string]string
+
+func LoadImageLock(path string) (ImageLock, error) {
+	lock := ImageLock{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lock, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+func (l ImageLock) Write(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// resolveDigest asks the image's registry which digest a tag points to.
+func resolveDigest(ctx context.Context, image string) (string, error) {
+	resolver := docker.NewResolver(docker.ResolverOptions{})
+	_, desc, err := resolver.Resolve(ctx, image)
+	if err != nil {
+		return "", err
+	}
+
+	return desc.Digest.String(), nil
+}
+
+// ImageRewriter is a Helm post-renderer that pins image tags to digests and
+// moves images onto the configured mirrors.
+type ImageRewriter struct {
+	conf ImagesConfig
+	lock ImageLock
+}
+
+func NewImageRewriter(conf ImagesConfig) (*ImageRewriter, error) {
+	lock, err := LoadImageLock(conf.LockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageRewriter{conf, lock}, nil
+}
+
+func (r *ImageRewriter) Run(rendered *bytes.Buffer) (*bytes.Buffer, error) {
+	return mapManifests(rendered.String(), func(obj map[string]interface{}) error {
+		return walkImages(obj, r.Image)
+	})
+}
+
+// mapManifests decodes every document of a rendered manifest, hands it to
+// fn and encodes the result again.
+func mapManifests(rendered string, fn func(obj map[string]interface{}) error) (*bytes.Buffer, error) {
+	docs := releaseutil.SplitManifests(rendered)
+	keys := make([]string, 0, len(docs))
+	for key := range docs {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	out := &bytes.Buffer{}
+	for _, key := range keys {
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal([]byte(docs[key]), &obj); err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			continue
+		}
+
+		if err := fn(obj); err != nil {
+			return nil, err
+		}
+
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+
+	return out, nil
+}
+
+// walkImages replaces every string under an `image` key of a decoded
+// manifest with the result of fn.
+func walkImages(node interface{}, fn func(image string) (string, error)) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			if image, ok := value.(string); ok && key == "image" {
+				rewritten, err := fn(image)
+				if err != nil {
+					return err
+				}
+				n[key] = rewritten
+				continue
+			}
+
+			if err := walkImages(value, fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range n {
+			if err := walkImages(value, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// taggedImage normalizes an image reference, returning false for values
+// that aren't references, like policy patterns, or are already pinned.
+func taggedImage(image string) (reference.Named, bool) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, false
+	}
+
+	if _, pinned := named.(reference.Digested); pinned {
+		return nil, false
+	}
+
+	return reference.TagNameOnly(named), true
+}
+
+// Image returns the pinned and mirrored form of an image reference. One
+// the chart pinned already is only mirrored.
+func (r *ImageRewriter) Image(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image, nil
+	}
+	if _, pinned := named.(reference.Digested); pinned {
+		return mirrorImage(r.conf.Mirrors, named.String()), nil
+	}
+	named = reference.TagNameOnly(named)
+
+	if r.conf.PinDigests {
+		// Resolving it here would trust whatever the registry serves at
+		// install time, which the lock is there to rule out.
+		ref, ok := r.lock[named.String()]
+		if !ok {
+			return "", fmt.Errorf("image %s not in lock, run `orsted images lock`", named)
+		}
+
+		image = named.Name() + "@" + ref
+	} else {
+		image = named.String()
+	}
+
+	return mirrorImage(r.conf.Mirrors, image), nil
+}
+
+// mirrorImage swaps the registry of a normalized image reference for its
+// configured mirror prefix.
+func mirrorImage(mirrors map[string]string, image string) string {
+	registry, path, _ := strings.Cut(image, "/")
+	if mirror, ok := mirrors[registry]; ok {
+		return strings.TrimSuffix(mirror, "/") + "/" + path
+	}
+
+	return image
+}
+
+// ReleaseImages renders every release of the config and returns the
+// normalized images each one deploys, keyed by release name.
+func ReleaseImages(conf *Config) (map[string][]string, error) {
+	releases, err := Releases(conf, "")
+	if err != nil {
+		return nil, err
+	}
+
+	images := map[string][]string{}
+	for _, release := range releases {
+		client, err := templateClient(release.Spec.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := addChartRepo(conf, client, release.Repo); err != nil {
+			return nil, err
+		}
+
+		rendered, err := client.TemplateChart(release.Spec, nil)
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s: %w", release.Spec.ReleaseName, err)
+		}
+
+		seen := map[string]bool{}
+		_, err = mapManifests(string(rendered), func(obj map[string]interface{}) error {
+			return walkImages(obj, func(image string) (string, error) {
+				named, err := reference.ParseNormalizedNamed(image)
+				if err != nil {
+					return image, nil
+				}
+
+				name := reference.TagNameOnly(named).String()
+				if !seen[name] {
+					seen[name] = true
+					images[release.Spec.ReleaseName] = append(images[release.Spec.ReleaseName], name)
+				}
+				return image, nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(images[release.Spec.ReleaseName])
+	}
+
+	return images, nil
+}
+
+// LockImages records the digest every tagged image of the config currently
+// resolves to in the lock file.
+func LockImages(conf *Config) error {
+	images, err := ReleaseImages(conf)
+	if err != nil {
+		return err
+	}
+
+	lock := ImageLock{}
+	for _, list := range images {
+		for _, image := range list {
+			named, ok := taggedImage(image)
+			if !ok {
+				continue
+			}
+			if _, locked := lock[named.String()]; locked {
+				continue
+			}
+
+			digest, err := resolveDigest(context.Background(), named.String())
+			if err != nil {
+				return fmt.Errorf("resolving %s: %w", named, err)
+			}
+
+			log.Printf("%s -> %s\n", named, digest)
+			lock[named.String()] = digest
+		}
+	}
+
+	return lock.Write(conf.Images.LockFile)
+}
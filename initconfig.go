@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/orsted.yaml
+var ConfigTemplateYaml string
+
+// hostInterface is a network interface of the host, for pointing out which
+// to use for L2 announcements and egress gateways.
+type hostInterface struct {
+	Name      string
+	Addresses []string
+	Default   bool
+}
+
+// hostInterfaces lists the interfaces that are up, other than loopback,
+// marking the one the default route goes through.
+func hostInterfaces() ([]hostInterface, error) {
+	defaultIP, _ := GetDefaultIP()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []hostInterface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+
+		host := hostInterface{Name: iface.Name}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			host.Addresses = append(host.Addresses, ipNet.String())
+			if defaultIP != nil && ipNet.IP.Equal(defaultIP) {
+				host.Default = true
+			}
+		}
+		out = append(out, host)
+	}
+
+	return out, nil
+}
+
+// InitConfig writes a commented config to path with the defaults, the
+// sysctl profile and what it finds on the host: its interfaces and the
+// empty disks Rook Ceph would claim. An existing config is only replaced
+// with force.
+func InitConfig(ctx context.Context, path string, profile string, force bool) error {
+	requirements, ok := resourceProfiles[profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, expected default, storage-heavy or network-heavy", profile)
+	}
+
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists, pass --force to replace it", path)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	interfaces, err := hostInterfaces()
+	if err != nil {
+		return fmt.Errorf("listing the interfaces: %w", err)
+	}
+	defaultInterface := ""
+	for _, iface := range interfaces {
+		if iface.Default {
+			defaultInterface = iface.Name
+		}
+	}
+
+	disks, err := emptyDisks(ctx)
+	if err != nil {
+		log.Printf("Not listing the disks: %s\n", err)
+	}
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Name < disks[j].Name })
+
+	memory, err := totalMemory()
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+
+	tmpl, err := template.New("orsted.yaml").Option("missingkey=error").Funcs(template.FuncMap{
+		"join": strings.Join,
+		"gib":  func(size uint64) uint64 { return size / gib },
+	}).Parse(ConfigTemplateYaml)
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, map[string]interface{}{
+		"Profile":          profile,
+		"Hostname":         hostname,
+		"SchemaID":         SchemaID,
+		"CPUs":             runtime.NumCPU(),
+		"MemoryGiB":        memory / gib,
+		"Minimum":          requirements.Minimum,
+		"Recommended":      requirements.Recommended,
+		"Defaults":         DefaultConfig(),
+		"IPv6":             GetDefaultIPv6() != nil,
+		"Interfaces":       interfaces,
+		"DefaultInterface": defaultInterface,
+		"Disks":            disks,
+		"Cloud":            detectCloud(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// The config is where the credentials of the addons go.
+	if err := os.WriteFile(path, []byte(out.String()), 0600); err != nil {
+		return err
+	}
+
+	log.Printf("Wrote %s for the %s profile, check it with `orsted config validate` after editing\n", path, profile)
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+//go:embed values/keycloak.yaml
+var KeycloakYaml string
+
+var bitnamiRepo = repo.Entry{
+	Name: "bitnami",
+	URL:  "https://charts.bitnami.com/bitnami",
+}
+
+// keycloakRealm builds the realm import with the cluster and GitOps clients,
+// both of which get the user's groups in a groups claim.
+func keycloakRealm(conf KeycloakConfig) (string, error) {
+	groups := map[string]interface{}{
+		"name":           "groups",
+		"protocol":       "openid-connect",
+		"protocolMapper": "oidc-group-membership-mapper",
+		"config": map[string]string{
+			"claim.name":           "groups",
+			"full.path":            "false",
+			"id.token.claim":       "true",
+			"access.token.claim":   "true",
+			"userinfo.token.claim": "true",
+		},
+	}
+
+	clients := []map[string]interface{}{{
+		"clientId":            oidcClusterClient,
+		"name":                "Kubernetes",
+		"publicClient":        true,
+		"standardFlowEnabled": true,
+		"redirectUris":        oidcClusterRedirects,
+		"protocolMappers":     []interface{}{groups},
+	}}
+	if conf.GitOps.RedirectURL != "" {
+		clients = append(clients, map[string]interface{}{
+			"clientId":            "weave-gitops",
+			"name":                "Weave GitOps",
+			"publicClient":        false,
+			"secret":              conf.GitOps.ClientSecret.Reveal(),
+			"standardFlowEnabled": true,
+			"redirectUris":        []string{conf.GitOps.RedirectURL},
+			"protocolMappers":     []interface{}{groups},
+		})
+	}
+
+	realm, err := json.Marshal(map[string]interface{}{
+		"realm":   conf.Realm,
+		"enabled": true,
+		"clients": clients,
+	})
+
+	return string(realm), err
+}
+
+func keycloakSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	keycloak := conf.Addons.Keycloak
+	if keycloak.Hostname == "" {
+		return nil, errors.New("keycloak needs a hostname")
+	}
+	if keycloak.AdminPassword == "" || keycloak.DatabasePassword == "" {
+		return nil, errors.New("keycloak needs an adminPassword and databasePassword")
+	}
+
+	realm, err := keycloakRealm(keycloak)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := renderValues("keycloak", KeycloakYaml, struct {
+		KeycloakConfig
+		RealmJSON string
+	}{keycloak, realm})
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "keycloak",
+		ChartName:       "bitnami/keycloak",
+		Namespace:       "keycloak",
+		CreateNamespace: true,
+		Wait:            true,
+		WaitForJobs:     true,
+		Timeout:         time.Minute * 10,
+		Version:         keycloak.Version,
+		ValuesYaml:      values,
+	}, nil
+}
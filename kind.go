@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// devKind bootstraps onto a kind cluster on the local Docker instead of
+// the host, see --dev-kind.
+var devKind bool
+
+const kindClusterName = "orsted"
+
+// kindAPIHost is the control plane container, which Cilium reaches the API
+// server at from inside the kind network.
+const kindAPIHost = kindClusterName + "-control-plane"
+
+// kindStorageClass is the local-path StorageClass every kind cluster comes
+// with, used in place of Rook Ceph.
+const kindStorageClass = "standard"
+
+// kindConfig leaves networking to Cilium, like kubeadm init does.
+const kindConfig = `kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+networking:
+  disableDefaultCNI: true
+  kubeProxyMode: none
+`
+
+// CreateKindCluster creates the kind cluster unless it already exists and
+// points KubeconfigPath at its kubeconfig.
+func CreateKindCluster(ctx context.Context) error {
+	clustersOut, err := RunCommand(ctx, "kind", "get", "clusters")
+	if err != nil {
+		return commandFailed("kind", clustersOut, fmt.Errorf("listing clusters: %w", err))
+	}
+
+	exists := false
+	for _, line := range strings.Split(clustersOut, "\n") {
+		exists = exists || strings.TrimSpace(line) == kindClusterName
+	}
+
+	if exists {
+		log.Printf("Reusing kind cluster %s\n", kindClusterName)
+	} else {
+		log.Printf("Creating kind cluster %s\n", kindClusterName)
+		createOut, err := RunCommandWithInput(ctx, kindConfig, "kind", "create", "cluster", "--name", kindClusterName, "--config", "-")
+		if err != nil {
+			return commandFailed("kind", createOut, fmt.Errorf("creating the cluster: %w", err))
+		}
+	}
+
+	kubeconfig, err := RunCommand(ctx, "kind", "get", "kubeconfig", "--name", kindClusterName)
+	if err != nil {
+		return commandFailed("kind", kubeconfig, fmt.Errorf("getting the kubeconfig: %w", err))
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return failed("kind", err)
+	}
+	path := filepath.Join(home, ".kube", "orsted-kind.conf")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return failed("kind", err)
+	}
+	if err := os.WriteFile(path, []byte(kubeconfig), 0600); err != nil {
+		return failed("kind", fmt.Errorf("writing the kubeconfig: %w", err))
+	}
+
+	log.Printf("kind kubeconfig written to %s\n", path)
+	KubeconfigPath = path
+	return nil
+}
+
+// applyDevKind swaps the Rook Ceph StorageClass addons default to for
+// kind's local-path one.
+func applyDevKind(conf *Config) {
+	replaceStorageClass(conf, kindStorageClass)
+}
+
+// replaceStorageClass has the addons that default to Rook Ceph's
+// ceph-block use class instead.
+func replaceStorageClass(conf *Config, class string) {
+	for _, storageClass := range []*string{
+		&conf.Addons.Loki.StorageClass,
+		&conf.Addons.Keycloak.StorageClass,
+		&conf.Addons.Vault.StorageClass,
+		&conf.Addons.Harbor.StorageClass,
+		&conf.Addons.MinIO.StorageClass,
+		&conf.Addons.Gitea.StorageClass,
+	} {
+		if *storageClass == "ceph-block" {
+			*storageClass = class
+		}
+	}
+}
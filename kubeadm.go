@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// ClusterConfigPath is the kubeadm config the cluster is initialized from.
+const ClusterConfigPath = "/root/clusterconfig.yaml"
+
+// RenderedClusterConfigPath is where the kubeadm config is written once the
+// orsted config is applied to it.
+const RenderedClusterConfigPath = "/etc/kubernetes/orsted-clusterconfig.yaml"
+
+// KubeadmPatchesDir holds the patches kubeadm applies to the control plane
+// static pod manifests and kubelet config it generates.
+const KubeadmPatchesDir = "/etc/kubernetes/orsted-patches"
+
+// oidcPatchFile is named like any kubeadm patch, target[suffix][+type].ext.
+const oidcPatchFile = "kube-apiserver+json.json"
+
+// writeKubeadmPatches writes the patches of the configured patches
+// directory and those the config needs into KubeadmPatchesDir, and returns
+// whether there were any.
+func writeKubeadmPatches(conf *Config) (bool, error) {
+	if err := os.RemoveAll(KubeadmPatchesDir); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(KubeadmPatchesDir, 0755); err != nil {
+		return false, err
+	}
+
+	patches := 0
+	if dir := conf.Kubeadm.PatchesDir; dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return false, err
+		}
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			if entry.Name() == oidcPatchFile {
+				return false, fmt.Errorf("%s in %s clashes with the OIDC patch orsted writes, add a suffix to its name", oidcPatchFile, dir)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return false, err
+			}
+			if err := os.WriteFile(filepath.Join(KubeadmPatchesDir, entry.Name()), data, 0644); err != nil {
+				return false, err
+			}
+			patches++
+		}
+	}
+
+	args, err := oidcAPIServerArgs(conf)
+	if err != nil {
+		return false, err
+	}
+	if len(args) == 0 {
+		return patches > 0, nil
+	}
+
+	var patch []map[string]string
+	for _, arg := range args {
+		patch = append(patch, map[string]string{
+			"op":    "add",
+			"path":  "/spec/containers/0/command/-",
+			"value": arg,
+		})
+	}
+
+	data, err := json.MarshalIndent(patch, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	return true, os.WriteFile(filepath.Join(KubeadmPatchesDir, oidcPatchFile), data, 0644)
+}
+
+// writeKubeadmConfig renders the kubeadm config for the installed kubeadm
+// and returns the path of the config to initialize the cluster from.
+func writeKubeadmConfig(ctx context.Context, conf *Config) (string, error) {
+	// Preflight made sure kubeadm is the configured version, down to the
+	// patch when one is pinned.
+	kubeadm, err := commandVersion(ctx, "kubeadm", "version", "-o", "short")
+	if err != nil {
+		return "", err
+	}
+
+	rendered, err := renderKubeadmConfig(conf, "v"+kubeadm.String())
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeFile(RenderedClusterConfigPath, rendered); err != nil {
+		return "", err
+	}
+
+	return RenderedClusterConfigPath, nil
+}
+
+// renderKubeadmConfig applies the Kubernetes version and the network, etcd,
+// control plane and kubelet settings to the kubeadm config.
+func renderKubeadmConfig(conf *Config, version string) (string, error) {
+	docs, err := readKubeadmConfig()
+	if err != nil {
+		return "", err
+	}
+
+	docs, cluster := kubeadmDocument(docs, "kubeadm.k8s.io/v1beta3", "ClusterConfiguration")
+	cluster["kubernetesVersion"] = version
+
+	docs, err = applyKubeadmNetworking(conf, docs)
+	if err != nil {
+		return "", err
+	}
+
+	docs, err = applyCloudProvider(conf, docs)
+	if err != nil {
+		return "", err
+	}
+
+	docs, err = applyExternalEtcd(conf, docs)
+	if err != nil {
+		return "", err
+	}
+
+	docs, err = applyControlPlaneArgs(conf, docs)
+	if err != nil {
+		return "", err
+	}
+
+	docs, err = applyKubeletConfig(conf, docs)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for _, doc := range docs {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+
+	return out.String(), nil
+}
+
+// readKubeadmConfig decodes the documents of the kubeadm config at
+// ClusterConfigPath.
+func readKubeadmConfig() ([]map[string]interface{}, error) {
+	data, err := os.ReadFile(ClusterConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []map[string]interface{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		if len(obj) > 0 {
+			docs = append(docs, obj)
+		}
+	}
+
+	return docs, nil
+}
+
+// applyKubeletConfig sets the configured kubelet settings in the
+// KubeletConfiguration document, adding one when there is none.
+func applyKubeletConfig(conf *Config, docs []map[string]interface{}) ([]map[string]interface{}, error) {
+	kubelet := conf.Kubelet
+	if kubelet.CPUManagerPolicy == "static" && kubelet.ReservedSystemCPUs == "" &&
+		kubelet.KubeReserved["cpu"] == "" && kubelet.SystemReserved["cpu"] == "" {
+		return nil, errors.New("the static CPU manager policy needs reserved CPUs")
+	}
+	if kubelet.MemoryManagerPolicy == "Static" && len(kubelet.ReservedMemory) == 0 {
+		return nil, errors.New("the Static memory manager policy needs reservedMemory")
+	}
+
+	data, err := yaml.Marshal(kubelet)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	if len(conf.ControlPlane.FeatureGates) > 0 {
+		settings["featureGates"] = conf.ControlPlane.FeatureGates
+	}
+	// Has to match the cgroup_manager ConfigureCgroups sets for CRI-O.
+	settings["cgroupDriver"] = "systemd"
+
+	docs, doc := kubeadmDocument(docs, "kubelet.config.k8s.io/v1beta1", "KubeletConfiguration")
+	for key, value := range settings {
+		doc[key] = value
+	}
+
+	return docs, nil
+}
+
+// applyControlPlaneArgs adds the configured extra args and feature gates
+// of the control plane components to the ClusterConfiguration, adding one
+// when there is none. Feature gates are enabled on the kubelet as well.
+func applyControlPlaneArgs(conf *Config, docs []map[string]interface{}) ([]map[string]interface{}, error) {
+	controlPlane := conf.ControlPlane
+
+	components := map[string]map[string]string{
+		"apiServer":         controlPlane.APIServer.ExtraArgs,
+		"controllerManager": controlPlane.ControllerManager.ExtraArgs,
+		"scheduler":         controlPlane.Scheduler.ExtraArgs,
+	}
+
+	var gates []string
+	for gate, enabled := range controlPlane.FeatureGates {
+		gates = append(gates, fmt.Sprintf("%s=%t", gate, enabled))
+	}
+	sort.Strings(gates)
+
+	empty := len(gates) == 0
+	for _, args := range components {
+		empty = empty && len(args) == 0
+	}
+	if empty {
+		return docs, nil
+	}
+
+	docs, cluster := kubeadmDocument(docs, "kubeadm.k8s.io/v1beta3", "ClusterConfiguration")
+
+	for component, args := range components {
+		if len(args) == 0 && len(gates) == 0 {
+			continue
+		}
+
+		extraArgs := childMap(childMap(cluster, component), "extraArgs")
+		for key, value := range args {
+			extraArgs[key] = value
+		}
+		if len(gates) > 0 {
+			extraArgs["feature-gates"] = strings.Join(gates, ",")
+		}
+	}
+
+	return docs, nil
+}
+
+// applyExternalEtcd points kubeadm at the configured external etcd cluster
+// instead of running a stacked etcd member on the node.
+func applyExternalEtcd(conf *Config, docs []map[string]interface{}) ([]map[string]interface{}, error) {
+	external := conf.Etcd.External
+	if len(external.Endpoints) == 0 {
+		return docs, nil
+	}
+
+	for _, file := range []string{external.CAFile, external.CertFile, external.KeyFile} {
+		if file == "" {
+			return nil, errors.New("external etcd needs caFile, certFile and keyFile")
+		}
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+	}
+
+	docs, cluster := kubeadmDocument(docs, "kubeadm.k8s.io/v1beta3", "ClusterConfiguration")
+	cluster["etcd"] = map[string]interface{}{
+		"external": map[string]interface{}{
+			"endpoints": external.Endpoints,
+			"caFile":    external.CAFile,
+			"certFile":  external.CertFile,
+			"keyFile":   external.KeyFile,
+		},
+	}
+
+	return docs, nil
+}
+
+// kubeadmDocument returns the document of a kind, appending an empty one
+// when the kubeadm config has none.
+func kubeadmDocument(docs []map[string]interface{}, apiVersion string, kind string) ([]map[string]interface{}, map[string]interface{}) {
+	for _, doc := range docs {
+		if doc["kind"] == kind {
+			return docs, doc
+		}
+	}
+
+	doc := map[string]interface{}{"apiVersion": apiVersion, "kind": kind}
+	return append(docs, doc), doc
+}
+
+// childMap returns the nested map under key, creating it when missing.
+func childMap(parent map[string]interface{}, key string) map[string]interface{} {
+	child, ok := parent[key].(map[string]interface{})
+	if !ok {
+		child = map[string]interface{}{}
+		parent[key] = child
+	}
+
+	return child
+}
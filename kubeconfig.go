@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// AdminKubeconfigPath is the admin kubeconfig kubeadm init writes.
+const AdminKubeconfigPath = "/etc/kubernetes/admin.conf"
+
+// KubeconfigPath is the kubeconfig orsted reaches the cluster with, through
+// its clients, Helm and kubectl alike. It is set from --kubeconfig, then
+// kubernetes.kubeconfig of the config, or else defaults to the admin
+// kubeconfig for root and to $KUBECONFIG or ~/.kube/config for other
+// users. --dev-kind points it at the kind cluster's.
+var KubeconfigPath = defaultKubeconfig()
+
+// kubeconfigFlag is --kubeconfig, which wins over the config.
+var kubeconfigFlag string
+
+func defaultKubeconfig() string {
+	if os.Geteuid() == 0 {
+		return AdminKubeconfigPath
+	}
+
+	if env := filepath.SplitList(os.Getenv("KUBECONFIG")); len(env) > 0 && env[0] != "" {
+		return env[0]
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".kube", "config")
+	}
+
+	return AdminKubeconfigPath
+}
+
+// applyKubeconfig sets KubeconfigPath from the config, unless --kubeconfig
+// was given.
+func applyKubeconfig(conf *Config) {
+	if kubeconfigFlag == "" && conf.Kubernetes.Kubeconfig != "" {
+		KubeconfigPath = conf.Kubernetes.Kubeconfig
+	}
+}
+
+// copyAdminKubeconfig copies the admin kubeconfig kubeadm init wrote to
+// KubeconfigPath when that is somewhere else, readable only by its owner.
+func copyAdminKubeconfig() error {
+	if KubeconfigPath == AdminKubeconfigPath {
+		return nil
+	}
+
+	data, err := os.ReadFile(AdminKubeconfigPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(KubeconfigPath), 0700); err != nil {
+		return err
+	}
+
+	log.Printf("Copying the admin kubeconfig to %s\n", KubeconfigPath)
+	return os.WriteFile(KubeconfigPath, data, 0600)
+}
+
+// requireRoot fails commands that change the host when not run as root,
+// instead of letting them fail half way through.
+func requireRoot(what string) error {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%s needs root, commands only reaching the cluster, like upgrade or diff, don't", what)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	//go:embed values/linkerd.yaml
+	LinkerdYaml string
+
+	//go:embed values/linkerd-crds.yaml
+	LinkerdCRDsYaml string
+)
+
+var linkerdRepo = repo.Entry{
+	Name: "linkerd",
+	URL:  "https://helm.linkerd.io/stable",
+}
+
+// LinkerdPKIDir holds the mesh's trust anchor and the identity issuer it
+// signs, next to the cluster PKI kubeadm generates.
+const LinkerdPKIDir = "/etc/kubernetes/pki/linkerd"
+
+// The issuer is reissued from the trust anchor when it gets close to
+// expiring, on the next bootstrap or upgrade.
+const (
+	linkerdAnchorValidity = time.Hour * 24 * 365 * 10
+	linkerdIssuerValidity = time.Hour * 24 * 365
+	linkerdIssuerRenewal  = time.Hour * 24 * 30
+	linkerdTrustDomain    = "cluster.local"
+)
+
+func linkerdCRDsSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	return &helmclient.ChartSpec{
+		ReleaseName:     "linkerd-crds",
+		ChartName:       "linkerd/linkerd-crds",
+		Namespace:       "linkerd",
+		CreateNamespace: true,
+		Wait:            true,
+		Timeout:         time.Minute * 2,
+		Version:         conf.Addons.Linkerd.CRDsVersion,
+		ValuesYaml:      LinkerdCRDsYaml,
+	}, nil
+}
+
+func linkerdSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	linkerd := conf.Addons.Linkerd
+
+	anchor, issuerCert, issuerKey, err := linkerdIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := renderValues("linkerd", LinkerdYaml, struct {
+		TrustAnchor          string
+		IssuerCert           string
+		IssuerKey            string
+		DefaultInboundPolicy string
+	}{anchor, issuerCert, issuerKey, linkerd.DefaultInboundPolicy})
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName: "linkerd-control-plane",
+		ChartName:   "linkerd/linkerd-control-plane",
+		Namespace:   "linkerd",
+		Wait:        true,
+		Timeout:     time.Minute * 5,
+		Version:     linkerd.Version,
+		ValuesYaml:  values,
+	}, nil
+}
+
+// injectLinkerd opts the configured namespaces into proxy injection. They
+// belong to the applications in them, so only the annotation is patched
+// onto those that exist rather than orsted applying them as its own.
+func injectLinkerd(ctx context.Context, conf *Config) error {
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+
+	patch := []byte(`{"metadata":{"annotations":{"linkerd.io/inject":"enabled"}}}`)
+	for _, ns := range conf.Addons.Linkerd.InjectNamespaces {
+		_, err := k8sClient.CoreV1().Namespaces().Patch(ctx, ns, types.MergePatchType, patch, meta.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			log.Printf("Namespace %s doesn't exist, not injecting the Linkerd proxy into it\n", ns)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("annotating namespace %s: %w", ns, err)
+		}
+	}
+
+	return nil
+}
+
+// linkerdIdentity loads the trust anchor and issuer from LinkerdPKIDir,
+// generating them when missing and reissuing an expiring issuer.
+func linkerdIdentity() (string, string, string, error) {
+	anchorCertPath := filepath.Join(LinkerdPKIDir, "ca.crt")
+	anchorKeyPath := filepath.Join(LinkerdPKIDir, "ca.key")
+	issuerCertPath := filepath.Join(LinkerdPKIDir, "issuer.crt")
+	issuerKeyPath := filepath.Join(LinkerdPKIDir, "issuer.key")
+
+	anchor, anchorKey, err := loadKeyPair(anchorCertPath, anchorKeyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		anchor, anchorKey, err = issueLinkerdCert("root.linkerd."+linkerdTrustDomain, linkerdAnchorValidity, nil, nil)
+		if err == nil {
+			err = writeKeyPair(anchorCertPath, anchorKeyPath, anchor, anchorKey)
+		}
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+
+	issuer, issuerKey, err := loadKeyPair(issuerCertPath, issuerKeyPath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && time.Until(issuer.NotAfter) < linkerdIssuerRenewal) {
+		issuer, issuerKey, err = issueLinkerdCert("identity.linkerd."+linkerdTrustDomain, linkerdIssuerValidity, anchor, anchorKey)
+		if err == nil {
+			err = writeKeyPair(issuerCertPath, issuerKeyPath, issuer, issuerKey)
+		}
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+
+	issuerKeyDER, err := x509.MarshalECPrivateKey(issuerKey)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: anchor.Raw})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: issuerKeyDER})),
+		nil
+}
+
+// issueLinkerdCert creates a CA certificate, self-signed when parent is nil.
+// Linkerd requires both the anchor and the issuer to be CAs.
+func issueLinkerdCert(cn string, validity time.Duration, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute * 5),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	} else {
+		template.MaxPathLenZero = true
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func loadKeyPair(certPath string, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("invalid PEM in " + filepath.Dir(certPath))
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	return cert, key, err
+}
+
+func writeKeyPair(certPath string, keyPath string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644)
+}
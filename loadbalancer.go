@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// allServices selects every service. An empty selector in Cilium's
+// policies matches none, so a NotIn on a made up label is used instead.
+var allServices = object{
+	"matchExpressions": []object{{
+		"key":      "orsted.io/no-announcement",
+		"operator": "NotIn",
+		"values":   []string{"true"},
+	}},
+}
+
+// waitForCiliumCRD waits for a CRD the Cilium operator registers once it
+// starts, which is only after the chart itself is installed.
+func waitForCiliumCRD(ctx context.Context, crd string) error {
+	if err := WaitForCRDs(ctx, crdTimeout, crd); err != nil {
+		return failed("cilium", err)
+	}
+
+	return nil
+}
+
+// loadBalancerManifest renders the LB-IPAM pools and, when enabled, the L2
+// announcement policy for the addresses allocated from them.
+func loadBalancerManifest(conf *Config) (string, error) {
+	lb := conf.Network.LoadBalancer
+
+	var objects []object
+	for _, pool := range lb.Pools {
+		var cidrs []object
+		for _, cidr := range pool.CIDRs {
+			cidrs = append(cidrs, object{"cidr": cidr})
+		}
+
+		spec := object{"cidrs": cidrs}
+		if len(pool.ServiceSelector) > 0 {
+			spec["serviceSelector"] = object{"matchLabels": pool.ServiceSelector}
+		}
+
+		objects = append(objects, object{
+			"apiVersion": "cilium.io/v2alpha1",
+			"kind":       "CiliumLoadBalancerIPPool",
+			"metadata":   object{"name": pool.Name},
+			"spec":       spec,
+		})
+	}
+
+	if lb.L2.Enabled {
+		spec := object{
+			"serviceSelector": allServices,
+			"nodeSelector":    object{"matchLabels": object{"kubernetes.io/os": "linux"}},
+			"loadBalancerIPs": true,
+			"externalIPs":     true,
+		}
+		if len(lb.L2.Interfaces) > 0 {
+			spec["interfaces"] = lb.L2.Interfaces
+		}
+
+		objects = append(objects, object{
+			"apiVersion": "cilium.io/v2alpha1",
+			"kind":       "CiliumL2AnnouncementPolicy",
+			"metadata":   object{"name": "orsted"},
+			"spec":       spec,
+		})
+	}
+
+	return renderObjects(objects)
+}
+
+// ApplyLoadBalancer creates the LoadBalancer address pools and announces
+// their addresses on the local network.
+func ApplyLoadBalancer(ctx context.Context, conf *Config) error {
+	lb := conf.Network.LoadBalancer
+	if len(lb.Pools) == 0 && !lb.L2.Enabled {
+		return nil
+	}
+
+	if err := waitForCiliumCRD(ctx, "ciliumloadbalancerippools.cilium.io"); err != nil {
+		return err
+	}
+	if lb.L2.Enabled {
+		if err := waitForCiliumCRD(ctx, "ciliuml2announcementpolicies.cilium.io"); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := loadBalancerManifest(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "loadbalancer", fmt.Errorf("rendering the IP pools: %w", err))
+	}
+
+	log.Println("Configuring LoadBalancer IP pools")
+	lbOut, err := ApplyManifest(ctx, "cilium", manifest)
+	if err != nil {
+		return commandFailed("loadbalancer", lbOut, fmt.Errorf("applying the IP pools: %w", err))
+	}
+
+	return nil
+}
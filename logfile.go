@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// LogDir keeps a log file of every run besides the console, which under
+// systemd is the journal.
+const LogDir = "/var/log/orsted"
+
+// keepLogs is how many log files of each command are kept, older ones are
+// removed when a new one is opened.
+const keepLogs = 20
+
+// teeLogFile adds a new file named after the command and the time to the
+// log output. Logging carries on to the console alone when the file can't
+// be opened, e.g. when not running as root.
+func teeLogFile(command string) {
+	if err := os.MkdirAll(LogDir, 0700); err != nil {
+		log.Printf("Not logging to a file: %s\n", err)
+		return
+	}
+
+	path := filepath.Join(LogDir, command+"-"+time.Now().UTC().Format("20060102T150405Z")+".log")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Not logging to a file: %s\n", err)
+		return
+	}
+
+	log.SetOutput(redactWriter{io.MultiWriter(os.Stderr, file)})
+	rotateLogs(command)
+}
+
+// rotateLogs removes all but the newest keepLogs log files of command. The
+// timestamps in their names sort in the order they were written.
+func rotateLogs(command string) {
+	logs, err := filepath.Glob(filepath.Join(LogDir, command+"-2*.log"))
+	if err != nil {
+		return
+	}
+	sort.Strings(logs)
+
+	for len(logs) > keepLogs {
+		if err := os.Remove(logs[0]); err != nil {
+			log.Printf("Failed to remove old log %s: %s\n", logs[0], err)
+		}
+		logs = logs[1:]
+	}
+}
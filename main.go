@@ -3,26 +3,35 @@ package main
 import (
 	"context"
 	_ "embed"
+	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
 
-	core "k8s.io/api/core/v1"
-	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	//go:embed values/cilium.yaml
 	CiliumYaml string
 
+	//go:embed values/kyverno.yaml
+	KyvernoYaml string
+
 	//go:embed values/rook-op.yaml
 	RookOperatorYaml string
 
@@ -33,279 +42,571 @@ var (
 	GitOpsYaml string
 )
 
+// gatewayCRDs are the Gateway API CRDs installed before Cilium, which
+// only enables its Gateway API support when they exist.
+var gatewayCRDs = []string{
+	"https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_gatewayclasses.yaml",
+	"https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_gateways.yaml",
+	"https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_httproutes.yaml",
+	"https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_referencegrants.yaml",
+	"https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/experimental/gateway.networking.k8s.io_tlsroutes.yaml",
+}
+
 func main() {
+	log.SetOutput(redactWriter{os.Stderr})
+	cancelOnInterrupt()
+	defer recoverPanic()
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// Bootstrap initializes the cluster and installs the configured stack.
+// With fix set, preflight corrects the host problems it knows how to.
+func Bootstrap(conf *Config, fix bool) error {
 	log.Println("We're in!")
 
-	log.Println("Enabling and starting Kubelet and Cri-o")
-	enableKubeletOut, err := RunCommand("bash", "-c", "systemctl enable --now kubelet crio")
+	shutdownTracing, err := InitTracing(rootCtx, conf.Tracing)
 	if err != nil {
-		log.Printf("Systemctl output: %s\n", enableKubeletOut)
-		log.Fatalf("Unable to enable kubelet and crio: %s\n", err)
+		return failed("tracing", fmt.Errorf("initializing: %w", err))
 	}
+	defer shutdownTracing()
 
-	log.Println("Kubelet and Cri-o started")
+	ctx, span := tracer.Start(rootCtx, "bootstrap")
 
-	log.Println("Initializing Kubernetes Cluster")
-	kubeadmOut, err := RunCommand("kubeadm", "init", "--config", "/root/clusterconfig.yaml")
-	if err != nil {
-		log.Printf("Failed to run kubeadm: %s\n", err)
-		log.Fatalf("Kubeadm output: %s\n", kubeadmOut)
+	report := NewReport()
+	StartTelemetry(conf)
+	notifyWebhook = conf.Notifications.Webhook
+
+	err = bootstrap(ctx, conf, fix, report)
+	if err != nil && interrupted(ctx) {
+		failure := asFailure(err)
+		failure.Code = ExitInterrupted
+		failure.Err = fmt.Errorf("%w: %w", context.Cause(ctx), failure.Err)
+		err = failure
 	}
+	endSpan(span, err)
 
-	k8sConf, err := clientcmd.BuildConfigFromFlags("", "/etc/kubernetes/admin.conf")
 	if err != nil {
-		log.Fatalf("Failed to parse kubernetes config: %s\n", err)
+		failure := asFailure(err)
+		report.Failure = &FailureReport{
+			Phase:     failure.Phase,
+			Component: failure.Component,
+			Error:     failure.Err.Error(),
+			Output:    failure.Output,
+			ExitCode:  failure.Code,
+			Bundle:    recordFailure(failure, nil),
+		}
+	} else {
+		FinishTelemetry(report.Releases)
 	}
 
-	k8sClient, err := kubernetes.NewForConfig(k8sConf)
-	if err != nil {
-		log.Fatalf("Failed to create kubernetes client: %s\n", err)
+	report.FinishedAt = time.Now()
+	if err := report.Write(ReportPath); err != nil {
+		log.Printf("Failed to write bootstrap report: %s\n", err)
 	}
 
-	for true {
-		pods, err := k8sClient.CoreV1().Pods("kube-system").List(context.Background(), meta.ListOptions{})
-		if err != nil || len(pods.Items) == 0 {
-			log.Printf("Kubernetes not yet ready: %s\n", err)
-			time.Sleep(time.Second * 10)
-			continue
-		} else {
-			log.Println("Kubernetes ready")
-			break
+	if err == nil {
+		if !devKind {
+			if err := markBootstrapped(); err != nil {
+				log.Printf("Failed to mark the host as bootstrapped: %s\n", err)
+			}
 		}
+		log.Println("Successfully initialized Kubernetes Cluster")
+	}
+	printSummary(report.FinishedAt.Sub(report.StartedAt), err)
+	if jsonOutput {
+		writeResult(NewResult(report))
 	}
 
-	log.Println("Untainting node")
-	clearTaintOut, err := RunCommand("bash", "-c", "kubectl taint nodes $(hostname -f) node-role.kubernetes.io/control-plane=master:NoSchedule- --kubeconfig='/etc/kubernetes/admin.conf'")
+	return err
+}
+
+func bootstrap(ctx context.Context, conf *Config, fix bool, report *Report) error {
+	engine, err := PolicyEngineRelease(conf)
 	if err != nil {
-		log.Printf("Failed to clear master node taint: %s\n", err)
-		log.Fatalf("Kubectl output: %s\n", clearTaintOut)
+		return failedWith(ExitConfig, "policy-engine", err)
 	}
 
-	log.Println("Creating Gateway CRDs")
-	// gatewayCRDsOut, err := RunCommand("bash", "-c", "curl -L https://github.com/kubernetes-sigs/gateway-api/releases/latest/download/standard-install.yaml | kubectl apply --kubeconfig='/etc/kubernetes/admin.conf' -f -")
-	gatewayCRDsOut, err := RunCommand("bash", "-c", "kubectl apply --kubeconfig='/etc/kubernetes/admin.conf' -f https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_gatewayclasses.yaml -f https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_gateways.yaml -f https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_httproutes.yaml -f https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/standard/gateway.networking.k8s.io_referencegrants.yaml -f https://raw.githubusercontent.com/kubernetes-sigs/gateway-api/v0.7.1/config/crd/experimental/gateway.networking.k8s.io_tlsroutes.yaml")
-	if err != nil {
-		log.Printf("Failed to apply gateway CRDs")
-		log.Fatalf("Kubectl output: %s\n", gatewayCRDsOut)
+	if devKind {
+		applyDevKind(conf)
+		if err := phase(ctx, "kind", CreateKindCluster); err != nil {
+			return err
+		}
+	} else {
+		logCloud(conf)
+		if err := bootstrapHost(ctx, conf, fix); err != nil {
+			return err
+		}
 	}
 
-	log.Println("Adding Helm Repos")
+	var k8sClient *kubernetes.Clientset
+	if err := phase(ctx, "api-wait", func(ctx context.Context) error {
+		k8sClient, err = KubeClient()
+		if err != nil {
+			return failed("kubernetes", err)
+		}
 
-	ciliumRepo := repo.Entry{
-		Name: "cilium",
-		URL:  "https://helm.cilium.io/",
+		return WaitForAPI(ctx, conf, k8sClient)
+	}); err != nil {
+		return err
 	}
 
-	helmClient, err := helmClientForNs("default")
-	if err != nil {
-		log.Fatalf("Failed to create helm client: %s\n", err)
+	// kind already untaints the control plane of single node clusters.
+	if !devKind {
+		if err := phase(ctx, "untaint", func(ctx context.Context) error {
+			log.Println("Untainting node")
+			name, err := registeredNodeName(ctx, k8sClient)
+			if err != nil {
+				return failed("kubernetes", fmt.Errorf("finding the node: %w", err))
+			}
+			report.Node = name
+
+			clearTaintOut, err := RunCommand(ctx, "kubectl", "taint", "nodes", name, "node-role.kubernetes.io/control-plane=master:NoSchedule-", "--kubeconfig="+KubeconfigPath)
+			if err != nil {
+				return commandFailed("kubernetes", clearTaintOut, fmt.Errorf("clearing the control plane taint: %w", err))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
 	}
 
-	if err = helmClient.AddOrUpdateChartRepo(ciliumRepo); err != nil {
-		log.Fatalf("Failed to add Cilium Helm chart: %s\n", err)
+	if err := phase(ctx, "namespaces", func(ctx context.Context) error {
+		return ApplyNamespaces(ctx, k8sClient, conf)
+	}); err != nil {
+		return err
 	}
 
-	kyvernoRepo := repo.Entry{
-		Name: "kyverno",
-		URL:  "https://kyverno.github.io/kyverno/",
+	if err := phase(ctx, "priority-classes", func(ctx context.Context) error {
+		return ApplyPriorityClasses(ctx, conf)
+	}); err != nil {
+		return err
 	}
 
-	if err = helmClient.AddOrUpdateChartRepo(kyvernoRepo); err != nil {
-		log.Fatalf("Failed to add Kyverno Helm chart: %s\n", err)
+	if err := phase(ctx, "gateway-crds", func(ctx context.Context) error {
+		log.Println("Creating Gateway CRDs")
+		var args []string
+		for _, crd := range gatewayCRDs {
+			args = append(args, "-f", crd)
+		}
+
+		gatewayCRDsOut, err := applyOwned(ctx, "gateway-crds", args...)
+		if err != nil {
+			return commandFailed("gateway-api", gatewayCRDsOut, fmt.Errorf("applying the CRDs: %w", err))
+		}
+
+		// Cilium only enables its Gateway API support for CRDs it can see.
+		if err := WaitForCRDs(ctx, crdTimeout, gatewayCRDNames()...); err != nil {
+			return failed("gateway-api", err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	rookRepo := repo.Entry{
-		Name: "rook",
-		URL:  "https://charts.rook.io/release",
+	var helmClient helmclient.Client
+	if err := phase(ctx, "helm-repos", func(ctx context.Context) error {
+		log.Println("Adding Helm Repos")
+
+		helmClient, err = helmClientForNs("default")
+		if err != nil {
+			return failed("helm", fmt.Errorf("creating the client: %w", err))
+		}
+
+		releases, err := Releases(conf, "")
+		if err != nil {
+			return failedWith(ExitConfig, "releases", fmt.Errorf("rendering: %w", err))
+		}
+
+		for _, chartRepo := range []repo.Entry{ciliumRepo, engine.Repo, rookRepo, gitopsRepo} {
+			var specs []*helmclient.ChartSpec
+			for _, release := range releases {
+				if release.Repo.Name == chartRepo.Name {
+					specs = append(specs, release.Spec)
+				}
+			}
+
+			if err := addChartRepo(conf, helmClient, chartRepo, specs...); err != nil {
+				return failed("helm", fmt.Errorf("adding the %s chart repo: %w", chartRepo.Name, err))
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	if err = helmClient.AddOrUpdateChartRepo(rookRepo); err != nil {
-		log.Fatalf("Failed to add Rook Ceph Helm chart: %s\n", err)
+	if err := phase(ctx, "cilium", func(ctx context.Context) error {
+		defaultIp := kindAPIHost
+		if !devKind {
+			defaultIp, err = primaryNodeIP(conf)
+			if err != nil {
+				return failed("cilium", fmt.Errorf("getting the node IP: %w", err))
+			}
+		}
+		log.Printf("Default IP: %s\n", defaultIp)
+
+		cilium, err := ciliumSpec(conf, defaultIp)
+		if err != nil {
+			return failedWith(ExitConfig, "cilium", fmt.Errorf("rendering values: %w", err))
+		}
+
+		log.Println("Deploying Cilium")
+		if err := InstallOrUpgradeSpec(ctx, conf, helmClient, cilium); err != nil {
+			return installFailed("cilium", err)
+		}
+
+		for _, apply := range []func(context.Context, *Config) error{ApplyLoadBalancer, ApplyBGP, ApplyEgressGateways} {
+			if err := apply(ctx, conf); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	gitopsRepo := repo.Entry{
-		Name: "gitops",
-		URL:  "https://helm.gitops.weave.works/",
+	// The node stays tainted uninitialized, keeping everything but Cilium
+	// from being scheduled, until the cloud-controller-manager runs.
+	if _, ok := cloudEnabled(conf); ok {
+		if err := phase(ctx, "cloud", func(ctx context.Context) error {
+			return InstallCloud(ctx, conf)
+		}); err != nil {
+			return err
+		}
 	}
 
-	if err = helmClient.AddOrUpdateChartRepo(gitopsRepo); err != nil {
-		log.Fatalf("Failed to add Weave GitOps Helm chart: %s\n", err)
+	// Only once there is a network, and the node is initialized, can the
+	// rest of kube-system start.
+	if err := phase(ctx, "system-pods", func(ctx context.Context) error {
+		if err := waitForSystemPods(ctx, k8sClient); err != nil {
+			return failed("kube-system", err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	defaultIp := GetDefaultIP().String()
-	log.Printf("Default IP: %s\n", defaultIp)
+	// With CoreDNS running, check it resolves over the new network.
+	if conf.Network.DNSCheck.Enabled {
+		if err := phase(ctx, "dns", func(ctx context.Context) error {
+			if err := CheckDNS(ctx, k8sClient, conf); err != nil {
+				return failed("dns", err)
+			}
 
-	log.Println("Deploying Cilium")
-	ciliumSpec := helmclient.ChartSpec{
-		ReleaseName: "cilium",
-		ChartName:   "cilium/cilium",
-		Namespace:   "kube-system",
-		UpgradeCRDs: true,
-		Wait:        true,
-		WaitForJobs: true,
-		Timeout:     time.Minute * 7,
-		Version:     "v1.14.0",
-		ValuesYaml:  strings.Replace(CiliumYaml, "K8SHOST", defaultIp, 1),
+			return nil
+		}); err != nil {
+			return err
+		}
 	}
 
-	if _, err := helmClient.InstallOrUpgradeChart(context.Background(), &ciliumSpec, nil); err != nil {
-		log.Fatalf("Failed to install Cilium: %s\n", err)
+	if err := phase(ctx, "policy-engine", func(ctx context.Context) error {
+		log.Printf("Deploying %s\n", engine.Spec.ReleaseName)
+		if err := InstallSpecWithNSClient(ctx, conf, engine.Spec.Namespace, engine.Spec); err != nil {
+			return installFailed(engine.Spec.ReleaseName, err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	log.Println("Creating Kyverno namespace")
-	kyvNsSpec := core.Namespace{
-		meta.TypeMeta{
-			Kind:       "namespace",
-			APIVersion: "v1",
-		},
-		meta.ObjectMeta{
-			Name: "kyverno",
-		},
-		core.NamespaceSpec{},
-		core.NamespaceStatus{},
+	if err := phase(ctx, "rook", func(ctx context.Context) error {
+		if devKind {
+			log.Printf("Using kind's %s StorageClass instead of Rook Ceph\n", kindStorageClass)
+			return nil
+		}
+		if provider, ok := cloudEnabled(conf); ok {
+			log.Printf("Using the %s StorageClass of the cloud CSI driver instead of Rook Ceph\n", provider.StorageClass)
+			return nil
+		}
+
+		rookOROut, err := applyOwned(ctx, "rook", "-f", "/root/rook-overrides.yaml")
+		if err != nil {
+			return commandFailed("rook-ceph", rookOROut, fmt.Errorf("applying the overrides: %w", err))
+		}
+
+		rookHelm, err := helmClientForNs("rook-ceph")
+		if err != nil {
+			return failed("rook-ceph", fmt.Errorf("creating the helm client: %w", err))
+		}
+
+		log.Println("Deploying Rook Ceph operator")
+		if err := InstallOrUpgradeSpec(ctx, conf, rookHelm, rookOperatorSpec()); err != nil {
+			return installFailed("rook-ceph", err)
+		}
+		if err := WaitForCRDs(ctx, crdTimeout, rookCRDs...); err != nil {
+			return failed("rook-ceph", err)
+		}
+
+		log.Println("Deploying Rook Ceph cluster")
+		if err := InstallOrUpgradeSpec(ctx, conf, rookHelm, rookClusterSpec()); err != nil {
+			return installFailed("rook-ceph-cluster", err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
-	_, err = k8sClient.CoreV1().Namespaces().Create(context.Background(), &kyvNsSpec, meta.CreateOptions{})
-	if err != nil {
-		log.Fatalf("Failed to create kyverno namespace: %s\n", err)
+
+	if err := phase(ctx, "gitops", func(ctx context.Context) error {
+		log.Println("Deploying Weave GitOps")
+		if err := InstallSpecWithNSClient(ctx, conf, "weave-gitops", gitopsSpec()); err != nil {
+			return installFailed("weave-gitops", err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	kyvernoSpec := helmclient.ChartSpec{
-		ReleaseName: "kyverno",
-		ChartName:   "kyverno/kyverno",
-		Namespace:   "kyverno",
-		UpgradeCRDs: true,
-		Wait:        true,
-		WaitForJobs: true,
-		Timeout:     time.Minute * 4,
+	if err := phase(ctx, "policies", func(ctx context.Context) error {
+		return ApplyPolicies(ctx, conf)
+	}); err != nil {
+		return err
 	}
 
-	log.Println("Deploying Kyverno")
-	if err = InstallSpecWithNSClient("kyverno", &kyvernoSpec); err != nil {
-		log.Fatalf("Failed to install Kyverno: %s\n", err)
+	if err := phase(ctx, "addons", func(ctx context.Context) error {
+		return InstallAddons(ctx, conf)
+	}); err != nil {
+		return err
 	}
 
-	rookNsSpec := core.Namespace{
-		meta.TypeMeta{
-			Kind:       "namespace",
-			APIVersion: "v1",
-		},
-		meta.ObjectMeta{
-			Name:   "rook-ceph",
-			Labels: map[string]string{"pod-security.kubernetes.io/enforce": "privileged"},
-		},
-		core.NamespaceSpec{},
-		core.NamespaceStatus{},
+	if err := phase(ctx, "tenants", func(ctx context.Context) error {
+		return ApplyTenants(ctx, conf)
+	}); err != nil {
+		return err
 	}
 
-	log.Println("Creating rook-ceph namespace")
-	_, err = k8sClient.CoreV1().Namespaces().Create(context.Background(), &rookNsSpec, meta.CreateOptions{})
+	releases, err := Releases(conf, "")
 	if err != nil {
-		log.Fatalf("Failed to create rook-ceph namespace: %s\n", err)
+		return failedWith(ExitConfig, "releases", fmt.Errorf("rendering: %w", err))
 	}
 
-	rookOROut, err := RunCommand("bash", "-c", "kubectl apply --kubeconfig='/etc/kubernetes/admin.conf' -f /root/rook-overrides.yaml")
-	if err != nil {
-		log.Printf("Failed to create rook overrides: %s\n", err)
-		log.Fatalf("Kubectl output: %s\n", rookOROut)
+	if err := phase(ctx, "helm-history", func(ctx context.Context) error {
+		if err := PruneReleaseHistory(ctx, k8sClient, releases, conf.Helm.MaxHistory); err != nil {
+			return failed("helm", fmt.Errorf("pruning release history: %w", err))
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	rookHelm, err := helmClientForNs("rook-ceph")
-	if err != nil {
-		log.Fatalf("Failed to create rook helm client")
+	if conf.Helm.RunTests {
+		if err := phase(ctx, "helm-tests", func(ctx context.Context) error {
+			for _, release := range releases {
+				client, err := helmClientForNs(release.Spec.Namespace)
+				if err != nil {
+					return failed(release.Spec.ReleaseName, fmt.Errorf("creating the helm client: %w", err))
+				}
+
+				log.Printf("Testing %s\n", release.Spec.ReleaseName)
+				result := TestRelease(ctx, client, release.Spec)
+				if !result.Passed {
+					log.Printf("Tests for %s failed: %s\n", release.Spec.ReleaseName, result.Error)
+				}
+				report.Tests = append(report.Tests, result)
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
 	}
 
-	rookOpSpec := helmclient.ChartSpec{
-		ReleaseName: "rook-ceph",
-		ChartName:   "rook/rook-ceph",
-		Namespace:   "rook-ceph",
-		Wait:        true,
-		WaitForJobs: true,
-		Timeout:     time.Minute * 2,
-		UpgradeCRDs: true,
-		ValuesYaml:  RookOperatorYaml,
+	for _, release := range releases {
+		version := release.Spec.Version
+		if client, err := helmClientForNs(release.Spec.Namespace); err == nil {
+			if rel, err := client.GetRelease(release.Spec.ReleaseName); err == nil {
+				version = rel.Chart.Metadata.Version
+			}
+		}
+
+		report.Releases = append(report.Releases, ReleaseReport{
+			Name:      release.Spec.ReleaseName,
+			Namespace: release.Spec.Namespace,
+			Chart:     release.Spec.ChartName,
+			Version:   version,
+		})
 	}
 
-	log.Println("Deploying Rook Ceph operator")
-	if _, err := rookHelm.InstallOrUpgradeChart(context.Background(), &rookOpSpec, nil); err != nil {
-		log.Fatalf("Failed to install rook-ceph operator: %s\n", err)
+	return nil
+}
+
+// bootstrapHost prepares the host and runs kubeadm init on it.
+func bootstrapHost(ctx context.Context, conf *Config, fix bool) error {
+	if conf.Provision.Enabled {
+		if err := phase(ctx, "provision", func(ctx context.Context) error {
+			return ProvisionHost(ctx, conf)
+		}); err != nil {
+			return err
+		}
 	}
 
-	rookClusterSpec := helmclient.ChartSpec{
-		ReleaseName: "rook-ceph-cluster",
-		ChartName:   "rook/rook-ceph-cluster",
-		Namespace:   "rook-ceph",
-		Wait:        true,
-		WaitForJobs: true,
-		Timeout:     time.Minute * 5,
-		UpgradeCRDs: true,
-		ValuesYaml:  CephClusterYaml,
+	if err := phase(ctx, "preflight", func(ctx context.Context) error {
+		return Preflight(ctx, conf, fix)
+	}); err != nil {
+		return err
 	}
 
-	log.Println("Deploying Rook Ceph cluster")
-	if _, err := rookHelm.InstallOrUpgradeChart(context.Background(), &rookClusterSpec, nil); err != nil {
-		log.Fatalf("Failed to install rook-ceph-cluster: %s\n", err)
+	if conf.OSUpdates.Enabled {
+		if err := phase(ctx, "os-updates", func(ctx context.Context) error {
+			return ConfigureOSUpdates(ctx, conf)
+		}); err != nil {
+			return err
+		}
 	}
 
-	gitopsNsSpec := core.Namespace{
-		meta.TypeMeta{
-			Kind:       "namespace",
-			APIVersion: "v1",
-		},
-		meta.ObjectMeta{
-			Name: "weave-gitops",
-		},
-		core.NamespaceSpec{},
-		core.NamespaceStatus{},
+	if err := phase(ctx, "sysctl", func(ctx context.Context) error {
+		return ConfigureSysctl(ctx, conf)
+	}); err != nil {
+		return err
 	}
 
-	log.Println("Creating weave-gitops namespace")
-	_, err = k8sClient.CoreV1().Namespaces().Create(context.Background(), &gitopsNsSpec, meta.CreateOptions{})
-	if err != nil {
-		log.Fatalf("Failed to create weave-gitops namespace: %s\n", err)
+	if len(conf.Hugepages.Pages) > 0 {
+		if err := phase(ctx, "hugepages", func(ctx context.Context) error {
+			return ConfigureHugepages(ctx, conf)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := phase(ctx, "cgroups", func(ctx context.Context) error {
+		return ConfigureCgroups(ctx, conf)
+	}); err != nil {
+		return err
 	}
 
-	gitopsSpec := helmclient.ChartSpec{
-		ReleaseName: "weave-gitops",
-		ChartName:   "gitops/weave-gitops",
-		Namespace:   "weave-gitops",
-		Wait:        true,
-		WaitForJobs: true,
-		Timeout:     time.Minute * 15,
-		ValuesYaml:  GitOpsYaml,
+	if err := phase(ctx, "selinux", func(ctx context.Context) error {
+		return ConfigureSELinux(ctx)
+	}); err != nil {
+		return err
 	}
-	log.Println("Deploying Weave GitOps")
-	if err = InstallSpecWithNSClient("weave-gitops", &gitopsSpec); err != nil {
-		log.Fatalf("Failed to install weave-gitops: %s\n", err)
+
+	if err := phase(ctx, "runtime", func(ctx context.Context) error {
+		log.Println("Enabling and starting Kubelet and Cri-o")
+		enableKubeletOut, err := RunCommand(ctx, "bash", "-c", "systemctl enable --now kubelet crio")
+		if err != nil {
+			return commandFailed("kubelet", enableKubeletOut, fmt.Errorf("enabling kubelet and crio: %w", err))
+		}
+
+		log.Println("Kubelet and Cri-o started")
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	log.Println("Installing default policies")
-	defPolOut, err := RunCommand("bash", "-c", "kubectl apply --kubeconfig='/etc/kubernetes/admin.conf' -f /root/default-policies.yaml")
-	if err != nil {
-		log.Printf("Failed to install default kyverno policies: %s\n", err)
-		log.Fatalf("Kubectl output: %s\n", defPolOut)
+	if err := phase(ctx, "kubeadm-init", func(ctx context.Context) error {
+		clusterConfig, err := writeKubeadmConfig(ctx, conf)
+		if err != nil {
+			return failedWith(ExitConfig, "kubeadm", fmt.Errorf("rendering the config: %w", err))
+		}
+
+		args := []string{"init", "--config", clusterConfig}
+
+		patched, err := writeKubeadmPatches(conf)
+		if err != nil {
+			return failed("kubeadm", fmt.Errorf("writing patches: %w", err))
+		}
+		if patched {
+			args = append(args, "--patches", KubeadmPatchesDir)
+		}
+		if len(conf.Kubeadm.SkipPhases) > 0 {
+			args = append(args, "--skip-phases", strings.Join(conf.Kubeadm.SkipPhases, ","))
+		}
+
+		log.Println("Initializing Kubernetes Cluster")
+		kubeadmOut, err := RunCommand(ctx, "kubeadm", args...)
+		if err != nil {
+			return &Failure{Component: "kubeadm", Output: kubeadmOut, Code: ExitKubeadm, Err: fmt.Errorf("init: %w", err)}
+		}
+
+		if err := copyAdminKubeconfig(); err != nil {
+			return failed("kubeadm", fmt.Errorf("copying the admin kubeconfig: %w", err))
+		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
-	log.Println("Successfully initialized Kubernetes Cluster")
+
+	return nil
 }
 
 var kubeConfig = []byte{}
 
-func initKubeConf() {
+func initKubeConf() error {
 	if len(kubeConfig) == 0 {
-		kubeConfigI, err := os.ReadFile("/etc/kubernetes/admin.conf")
+		kubeConfigI, err := os.ReadFile(KubeconfigPath)
 		if err != nil {
-			log.Fatalf("Failed to read kubeconfig file: %s\n", err)
+			return fmt.Errorf("reading kubeconfig: %w", err)
 		}
 		kubeConfig = kubeConfigI
 	}
+
+	return nil
+}
+
+// KubeClient builds a client from the admin kubeconfig kubeadm writes.
+func KubeClient() (*kubernetes.Clientset, error) {
+	k8sConf, err := clientcmd.BuildConfigFromFlags("", KubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("parsing kubeconfig: %w", err)
+	}
+
+	return kubernetes.NewForConfig(k8sConf)
+}
+
+// The Helm repository config and index cache every client shares, kept
+// across runs so fresh indexes aren't downloaded again.
+var (
+	helmRepositoryCache  = helmCacheDir()
+	helmRepositoryConfig = filepath.Join(helmRepositoryCache, "repositories.yaml")
+)
+
+// helmCacheDir is /var/cache/orsted/helm for root and the user's cache
+// directory otherwise, for commands like render that don't need root.
+func helmCacheDir() string {
+	if os.Geteuid() != 0 {
+		if dir, err := os.UserCacheDir(); err == nil {
+			return filepath.Join(dir, "orsted", "helm")
+		}
+	}
+
+	return "/var/cache/orsted/helm"
 }
 
+var (
+	helmClients   = map[string]helmclient.Client{}
+	helmClientsMu sync.Mutex
+)
+
+// helmClientForNs returns the client for releases in ns. Clients are built
+// once per namespace and reused, each one reads the kubeconfig and sets up
+// its caches.
 func helmClientForNs(ns string) (helmclient.Client, error) {
-	initKubeConf()
+	helmClientsMu.Lock()
+	defer helmClientsMu.Unlock()
+
+	if client, ok := helmClients[ns]; ok {
+		return client, nil
+	}
+
+	if err := initKubeConf(); err != nil {
+		return nil, err
+	}
 	kubeConfOptions := helmclient.KubeConfClientOptions{
 		Options: &helmclient.Options{
 			Namespace:        ns,
-			RepositoryCache:  "/tmp/.helmcache",
-			RepositoryConfig: "/tmp/.helmrepo",
+			RepositoryCache:  helmRepositoryCache,
+			RepositoryConfig: helmRepositoryConfig,
 			Debug:            false,
 			Linting:          true,
 		},
@@ -313,39 +614,175 @@ func helmClientForNs(ns string) (helmclient.Client, error) {
 		KubeConfig:  kubeConfig,
 	}
 
-	return helmclient.NewClientFromKubeConf(&kubeConfOptions)
+	client, err := helmclient.NewClientFromKubeConf(&kubeConfOptions)
+	if err != nil {
+		return nil, err
+	}
+	helmClients[ns] = client
+
+	return client, nil
+}
+
+// resetHelmClients drops the kubeconfig and the clients built from it, for
+// when the cluster they reach is torn down.
+func resetHelmClients() {
+	helmClientsMu.Lock()
+	defer helmClientsMu.Unlock()
+
+	kubeConfig = []byte{}
+	helmClients = map[string]helmclient.Client{}
+}
+
+// applyHelmDefaults sets the options every managed release shares and
+// merges the user's values file for the release, local or remote.
+func applyHelmDefaults(conf *Config, spec *helmclient.ChartSpec) error {
+	spec.MaxHistory = conf.Helm.MaxHistory
+
+	path, ok := conf.Helm.Values[spec.ReleaseName]
+	if !ok {
+		return nil
+	}
+
+	data, err := readValues(path)
+	if err != nil {
+		return err
+	}
+
+	values, err := mergeValues(spec.ValuesYaml, data)
+	if err != nil {
+		return fmt.Errorf("merging %s into %s values: %w", path, spec.ReleaseName, err)
+	}
+	spec.ValuesYaml = values
+
+	return nil
 }
 
-func InstallSpecWithNSClient(ns string, spec *helmclient.ChartSpec) error {
+// mergeValues merges override over the base values, like passing both to
+// helm with -f.
+func mergeValues(base string, override []byte) (string, error) {
+	baseValues := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(base), &baseValues); err != nil {
+		return "", err
+	}
+
+	overrideValues := map[string]interface{}{}
+	if err := yaml.Unmarshal(override, &overrideValues); err != nil {
+		return "", err
+	}
+
+	merged, err := yaml.Marshal(chartutil.CoalesceTables(overrideValues, baseValues))
+	return string(merged), err
+}
+
+// helmOptions returns the post-renderers of a release: rewriting its
+// images when digest pinning or mirrors are configured, injecting its
+// scheduling config, and labeling its objects as owned by orsted.
+func helmOptions(conf *Config, release string) (*helmclient.GenericHelmOptions, error) {
+	var chain postRenderChain
+	if conf.Images.PinDigests || len(conf.Images.Mirrors) > 0 {
+		rewriter, err := NewImageRewriter(conf.Images)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, rewriter)
+	}
+	if workloads := conf.Scheduling.forRelease(release); !workloads.empty() {
+		chain = append(chain, WorkloadInjector{workloads})
+	}
+	chain = append(chain, ownerLabeler{phase: releasePhase(conf, release)})
+
+	return &helmclient.GenericHelmOptions{PostRenderer: chain}, nil
+}
+
+func InstallSpecWithNSClient(ctx context.Context, conf *Config, ns string, spec *helmclient.ChartSpec) error {
 	client, err := helmClientForNs(ns)
 	if err != nil {
 		return err
 	}
 
-	if _, err := client.InstallChart(context.Background(), spec, nil); err != nil {
+	if err := applyHelmDefaults(conf, spec); err != nil {
 		return err
 	}
 
-	return nil
+	opts, err := helmOptions(conf, spec.ReleaseName)
+	if err != nil {
+		return err
+	}
+
+	ctx, span := tracer.Start(ctx, "helm install "+spec.ReleaseName, chartAttributes(spec))
+	_, err = client.InstallChart(ctx, withVendoredChart(spec), opts)
+	endSpan(span, err)
+
+	return err
 }
 
-func RunCommand(command string, args ...string) (string, error) {
+func InstallOrUpgradeSpec(ctx context.Context, conf *Config, client helmclient.Client, spec *helmclient.ChartSpec) error {
+	if err := applyHelmDefaults(conf, spec); err != nil {
+		return err
+	}
+
+	opts, err := helmOptions(conf, spec.ReleaseName)
+	if err != nil {
+		return err
+	}
+
+	ctx, span := tracer.Start(ctx, "helm upgrade --install "+spec.ReleaseName, chartAttributes(spec))
+	_, err = client.InstallOrUpgradeChart(ctx, withVendoredChart(spec), opts)
+	endSpan(span, err)
+
+	return err
+}
+
+func RunCommand(ctx context.Context, command string, args ...string) (string, error) {
+	return RunCommandWithInput(ctx, "", command, args...)
+}
+
+func RunCommandWithInput(ctx context.Context, input string, command string, args ...string) (string, error) {
+	// Tokens and passwords are passed on command lines too.
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = Redact(arg)
+	}
+
+	_, span := tracer.Start(ctx, command, trace.WithAttributes(attribute.StringSlice("args", redacted)))
+	out, err := commandRunner(ctx, input, command, args...)
+	endSpan(span, err)
+	return out, err
+}
+
+// commandRunner runs every command orsted shells out to, the integration
+// harness swaps it to fake or fail commands.
+var commandRunner = execCommand
+
+func execCommand(ctx context.Context, input string, command string, args ...string) (string, error) {
 	var out strings.Builder
-	cmd := exec.Command(command, args...)
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = strings.NewReader(input)
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 	err := cmd.Run()
 	return out.String(), err
 }
 
-func GetDefaultIP() net.IP {
+// ApplyManifest pipes a multi-document YAML manifest to kubectl apply,
+// labeling its objects as owned by phase.
+func ApplyManifest(ctx context.Context, phase string, manifest string) (string, error) {
+	labeled, err := labelManifest(manifest, phase)
+	if err != nil {
+		return "", fmt.Errorf("labeling the manifest: %w", err)
+	}
+
+	return RunCommandWithInput(ctx, labeled, "kubectl", "apply", "--kubeconfig="+KubeconfigPath, "-f", "-")
+}
+
+func GetDefaultIP() (net.IP, error) {
 	conn, err := net.Dial("udp", "1.1.1.1:80")
 	if err != nil {
-		log.Fatalf("Failed to get default ip: %s", err)
+		return nil, fmt.Errorf("getting the default IP: %w", err)
 	}
 	defer conn.Close()
 
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
 
-	return localAddr.IP
+	return localAddr.IP, nil
 }
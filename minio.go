@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"log"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+//go:embed values/minio.yaml
+var MinIOYaml string
+
+var minioRepo = repo.Entry{
+	Name: "minio",
+	URL:  "https://charts.min.io/",
+}
+
+// MinIOEndpoint is the in-cluster S3 endpoint of the minio addon.
+const MinIOEndpoint = "http://minio.minio.svc:9000"
+
+func minioSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	values, err := renderValues("minio", MinIOYaml, conf.Addons.MinIO)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "minio",
+		ChartName:       "minio/minio",
+		Namespace:       "minio",
+		CreateNamespace: true,
+		Wait:            true,
+		WaitForJobs:     true,
+		Timeout:         time.Minute * 5,
+		Version:         conf.Addons.MinIO.Version,
+		ValuesYaml:      values,
+	}, nil
+}
+
+// prepareMinIO generates the root credentials once, later installs keep
+// using the existing secret.
+func prepareMinIO(ctx context.Context, conf *Config) error {
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	created, err := ensureSecret(ctx, "minio", "minio-root", map[string]string{
+		"rootUser":     "orsted",
+		"rootPassword": password,
+	})
+	if created {
+		log.Printf("Generated MinIO credentials in minio/minio-root, S3 endpoint %s\n", MinIOEndpoint)
+	}
+
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Multus has no official Helm chart, so the thick plugin DaemonSet is
+// applied straight from the release's deployment manifest.
+const multusManifestURL = "https://raw.githubusercontent.com/k8snetworkplumbingwg/multus-cni/%s/deployments/multus-daemonset-thick.yml"
+
+// installMultus applies the Multus DaemonSet of the configured version and
+// waits for the NetworkAttachmentDefinition CRD to be served.
+func installMultus(ctx context.Context, conf *Config) error {
+	version := conf.Addons.Multus.Version
+
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Get(fmt.Sprintf(multusManifestURL, version))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching multus manifest: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// The manifests of tagged releases still reference the snapshot image.
+	manifest, err := markFetched(strings.ReplaceAll(string(data), ":snapshot-thick", ":"+version+"-thick"))
+	if err != nil {
+		return err
+	}
+	if out, err := ApplyManifest(ctx, "addons", manifest); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	if out, err := RunCommand(ctx, "kubectl", "wait", "--kubeconfig="+KubeconfigPath,
+		"--for=condition=established", "--timeout=2m",
+		"crd/network-attachment-definitions.k8s.cni.cncf.io"); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	return nil
+}
+
+// multusManifests renders a NetworkAttachmentDefinition for every
+// configured network.
+func multusManifests(conf *Config) (string, error) {
+	var objects []object
+
+	for _, network := range conf.Addons.Multus.Networks {
+		nad, err := networkAttachmentObject(network, nil, nil)
+		if err != nil {
+			return "", err
+		}
+
+		objects = append(objects, nad)
+	}
+
+	return renderObjects(objects)
+}
+
+// networkAttachmentObject builds the NetworkAttachmentDefinition of a
+// network, filling its CNI config in from defaults where it leaves keys out.
+func networkAttachmentObject(network NetworkAttachment, defaults map[string]interface{}, annotations map[string]string) (object, error) {
+	config := map[string]interface{}{
+		"cniVersion": "0.3.1",
+	}
+	for key, value := range defaults {
+		config[key] = value
+	}
+	for key, value := range network.Config {
+		config[key] = value
+	}
+	config["name"] = network.Name
+
+	ns := network.Namespace
+	if ns == "" {
+		ns = "default"
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := object{"name": network.Name, "namespace": ns}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+
+	return object{
+		"apiVersion": "k8s.cni.cncf.io/v1",
+		"kind":       "NetworkAttachmentDefinition",
+		"metadata":   metadata,
+		"spec":       object{"config": string(data)},
+	}, nil
+}
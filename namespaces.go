@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fieldManager owns the fields orsted applies server-side.
+const fieldManager = "orsted"
+
+// coreNamespaces are the namespaces the core stack is installed into
+// besides those Helm creates, before the config's are merged in.
+func coreNamespaces(conf *Config) []NamespaceConfig {
+	namespaces := []NamespaceConfig{{Name: "weave-gitops"}}
+	if rookEnabled(conf) {
+		// The Ceph daemons need host access the other levels forbid.
+		namespaces = append(namespaces, NamespaceConfig{Name: "rook-ceph", PodSecurity: PodSecurityConfig{Enforce: "privileged"}})
+	}
+
+	return namespaces
+}
+
+// Namespaces returns the namespaces orsted manages, sorted by name: the
+// core ones and those of the tenants, with the config's merged over them
+// by name, followed by the rest of the config's.
+func Namespaces(conf *Config) []NamespaceConfig {
+	byName := map[string]NamespaceConfig{}
+	for _, ns := range append(coreNamespaces(conf), tenantNamespaces(conf)...) {
+		byName[ns.Name] = ns
+	}
+
+	for _, ns := range conf.Namespaces {
+		merged, ok := byName[ns.Name]
+		if !ok {
+			byName[ns.Name] = ns
+			continue
+		}
+
+		merged.Labels = mergeStrings(merged.Labels, ns.Labels)
+		merged.Annotations = mergeStrings(merged.Annotations, ns.Annotations)
+		if ns.PodSecurity.Enforce != "" {
+			merged.PodSecurity.Enforce = ns.PodSecurity.Enforce
+		}
+		if ns.PodSecurity.Audit != "" {
+			merged.PodSecurity.Audit = ns.PodSecurity.Audit
+		}
+		if ns.PodSecurity.Warn != "" {
+			merged.PodSecurity.Warn = ns.PodSecurity.Warn
+		}
+		merged.Quota = mergeStrings(merged.Quota, ns.Quota)
+		merged.Limits = mergeLimits(merged.Limits, ns.Limits)
+		byName[ns.Name] = merged
+	}
+
+	namespaces := make([]NamespaceConfig, 0, len(byName))
+	for _, ns := range byName {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+
+	return namespaces
+}
+
+func mergeStrings(base map[string]string, override map[string]string) map[string]string {
+	if len(override) == 0 {
+		return base
+	}
+
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+func mergeLimits(base LimitsConfig, override LimitsConfig) LimitsConfig {
+	return LimitsConfig{
+		DefaultRequest: mergeStrings(base.DefaultRequest, override.DefaultRequest),
+		Default:        mergeStrings(base.Default, override.Default),
+		Max:            mergeStrings(base.Max, override.Max),
+	}
+}
+
+// namespaceResourcesManifest renders the LimitRange and ResourceQuota of
+// every managed namespace that has any, the resources of the config with
+// those of the namespace merged over them. Core namespaces only get those
+// set for them.
+func namespaceResourcesManifest(conf *Config) (string, error) {
+	core := map[string]bool{}
+	for _, ns := range coreNamespaces(conf) {
+		core[ns.Name] = true
+	}
+
+	var objects []object
+	for _, ns := range Namespaces(conf) {
+		quota, limits := ns.Quota, ns.Limits
+		if !core[ns.Name] {
+			quota = mergeStrings(conf.Resources.Quota, quota)
+			limits = mergeLimits(conf.Resources.Limits, limits)
+		}
+
+		if container := limitRange(limits); container != nil {
+			objects = append(objects, object{
+				"apiVersion": "v1",
+				"kind":       "LimitRange",
+				"metadata":   object{"name": "orsted", "namespace": ns.Name},
+				"spec":       object{"limits": []object{container}},
+			})
+		}
+		if len(quota) > 0 {
+			objects = append(objects, object{
+				"apiVersion": "v1",
+				"kind":       "ResourceQuota",
+				"metadata":   object{"name": "orsted", "namespace": ns.Name},
+				"spec":       object{"hard": quota},
+			})
+		}
+	}
+
+	return renderObjects(objects)
+}
+
+// limitRange is the container limits of a LimitRange, nil when none are
+// set.
+func limitRange(limits LimitsConfig) object {
+	if len(limits.DefaultRequest) == 0 && len(limits.Default) == 0 && len(limits.Max) == 0 {
+		return nil
+	}
+
+	container := object{"type": "Container"}
+	for key, resources := range map[string]map[string]string{
+		"defaultRequest": limits.DefaultRequest,
+		"default":        limits.Default,
+		"max":            limits.Max,
+	} {
+		if len(resources) > 0 {
+			container[key] = resources
+		}
+	}
+
+	return container
+}
+
+// ApplyNamespaces creates the managed namespaces, or updates the labels
+// and annotations orsted set on them, with server-side apply. They are
+// labeled as owned by the namespaces phase. Namespaces
+// that already exist are left as they are otherwise. Their limit ranges
+// and quotas are applied before anything is deployed into them.
+func ApplyNamespaces(ctx context.Context, k8sClient kubernetes.Interface, conf *Config) error {
+	for _, ns := range Namespaces(conf) {
+		labels := ownerLabels("namespaces")
+		for k, v := range ns.Labels {
+			labels[k] = v
+		}
+		for mode, level := range map[string]string{
+			"enforce": ns.PodSecurity.Enforce,
+			"audit":   ns.PodSecurity.Audit,
+			"warn":    ns.PodSecurity.Warn,
+		} {
+			if level != "" {
+				labels["pod-security.kubernetes.io/"+mode] = level
+			}
+		}
+
+		apply := corev1ac.Namespace(ns.Name).WithLabels(labels)
+		if len(ns.Annotations) > 0 {
+			apply.WithAnnotations(ns.Annotations)
+		}
+
+		log.Printf("Applying namespace %s\n", ns.Name)
+		_, err := k8sClient.CoreV1().Namespaces().Apply(ctx, apply, meta.ApplyOptions{FieldManager: fieldManager, Force: true})
+		if err != nil {
+			return failed("namespaces", fmt.Errorf("applying %s: %w", ns.Name, err))
+		}
+	}
+
+	manifest, err := namespaceResourcesManifest(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "namespaces", fmt.Errorf("rendering the limit ranges and quotas: %w", err))
+	}
+	if manifest == "" {
+		return nil
+	}
+
+	log.Println("Applying namespace limit ranges and quotas")
+	out, err := ApplyManifest(ctx, "namespaces", manifest)
+	if err != nil {
+		return commandFailed("namespaces", out, fmt.Errorf("applying the limit ranges and quotas: %w", err))
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// clusterCIDRs holds at most one CIDR of each IP family.
+type clusterCIDRs struct {
+	IPv4 string
+	IPv6 string
+	// First is the family listed first, which kubeadm and the kubelet
+	// treat as primary.
+	First string
+}
+
+func (c clusterCIDRs) list() []string {
+	if c.First == c.IPv6 {
+		return nonEmpty(c.IPv6, c.IPv4)
+	}
+
+	return nonEmpty(c.IPv4, c.IPv6)
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, value := range values {
+		if value != "" {
+			out = append(out, value)
+		}
+	}
+
+	return out
+}
+
+func parseClusterCIDRs(cidrs []string) (clusterCIDRs, error) {
+	var parsed clusterCIDRs
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return parsed, err
+		}
+
+		family := &parsed.IPv6
+		if ip.To4() != nil {
+			family = &parsed.IPv4
+		}
+		if *family != "" {
+			return parsed, fmt.Errorf("more than one CIDR of the same family in %v", cidrs)
+		}
+
+		*family = cidr
+		if parsed.First == "" {
+			parsed.First = cidr
+		}
+	}
+
+	return parsed, nil
+}
+
+// cidrs parses the pod and service CIDRs, which have to be of the same IP
+// families as kubeadm requires for dual-stack.
+func (n NetworkConfig) cidrs() (clusterCIDRs, clusterCIDRs, error) {
+	pods, err := parseClusterCIDRs(n.PodCIDRs)
+	if err != nil {
+		return pods, clusterCIDRs{}, err
+	}
+
+	services, err := parseClusterCIDRs(n.ServiceCIDRs)
+	if err != nil {
+		return pods, services, err
+	}
+
+	if len(n.ServiceCIDRs) > 0 && ((pods.IPv4 == "") != (services.IPv4 == "") || (pods.IPv6 == "") != (services.IPv6 == "")) {
+		return pods, services, errors.New("pod and service CIDRs must be of the same IP families")
+	}
+
+	return pods, services, nil
+}
+
+// GetDefaultIPv6 returns the address of the default IPv6 route, or nil when
+// the node has none.
+func GetDefaultIPv6() net.IP {
+	conn, err := net.Dial("udp6", "[2606:4700:4700::1111]:80")
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+// nodeIPs lists the node's addresses of the configured families, primary
+// family first, as the kubelet's --node-ip expects for dual-stack.
+func nodeIPs(conf *Config) ([]string, error) {
+	pods, _, err := conf.Network.cidrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := clusterCIDRs{}
+	if pods.IPv4 != "" {
+		v4, err := GetDefaultIP()
+		if err != nil {
+			return nil, err
+		}
+		ips.IPv4 = v4.String()
+	}
+	if pods.IPv6 != "" {
+		v6 := GetDefaultIPv6()
+		if v6 == nil {
+			return nil, errors.New("an IPv6 pod CIDR is configured but the node has no IPv6 default route")
+		}
+		ips.IPv6 = v6.String()
+	}
+	ips.First = ips.IPv4
+	if pods.First == pods.IPv6 {
+		ips.First = ips.IPv6
+	}
+
+	return ips.list(), nil
+}
+
+// primaryNodeIP is the node address the API server is reached at, of the
+// primary configured family.
+func primaryNodeIP(conf *Config) (string, error) {
+	if len(conf.Network.PodCIDRs) == 0 {
+		ip, err := GetDefaultIP()
+		if err != nil {
+			return "", err
+		}
+		return ip.String(), nil
+	}
+
+	ips, err := nodeIPs(conf)
+	if err != nil {
+		return "", err
+	}
+
+	return ips[0], nil
+}
+
+// applyKubeadmNetworking sets the pod and service CIDRs and the node IPs
+// in the kubeadm config documents.
+func applyKubeadmNetworking(conf *Config, docs []map[string]interface{}) ([]map[string]interface{}, error) {
+	if len(conf.Network.PodCIDRs) == 0 {
+		return docs, nil
+	}
+
+	pods, services, err := conf.Network.cidrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := nodeIPs(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, cluster := kubeadmDocument(docs, "kubeadm.k8s.io/v1beta3", "ClusterConfiguration")
+	networking := childMap(cluster, "networking")
+	networking["podSubnet"] = strings.Join(pods.list(), ",")
+	if len(services.list()) > 0 {
+		networking["serviceSubnet"] = strings.Join(services.list(), ",")
+	}
+
+	apiVersion, _ := cluster["apiVersion"].(string)
+	docs, initConfig := kubeadmDocument(docs, apiVersion, "InitConfiguration")
+	args := childMap(childMap(initConfig, "nodeRegistration"), "kubeletExtraArgs")
+	args["node-ip"] = strings.Join(ips, ",")
+
+	return docs, nil
+}
+
+// ciliumNetworkValues enables the configured IP families in Cilium and
+// hands out pod addresses from the configured CIDRs.
+func ciliumNetworkValues(conf *Config, overrides map[string]interface{}) error {
+	if len(conf.Network.PodCIDRs) == 0 {
+		return nil
+	}
+
+	pods, _, err := conf.Network.cidrs()
+	if err != nil {
+		return err
+	}
+
+	overrides["ipv4"] = map[string]interface{}{"enabled": pods.IPv4 != ""}
+	overrides["ipv6"] = map[string]interface{}{"enabled": pods.IPv6 != ""}
+
+	operator := map[string]interface{}{}
+	if pods.IPv4 != "" {
+		operator["clusterPoolIPv4PodCIDRList"] = []string{pods.IPv4}
+	}
+	if pods.IPv6 != "" {
+		operator["clusterPoolIPv6PodCIDRList"] = []string{pods.IPv6}
+	}
+	overrides["ipam"] = map[string]interface{}{"operator": operator}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SSHOptions is how orsted logs into a node to reset it. The host key has
+// to be in KnownHosts already.
+type SSHOptions struct {
+	User       string
+	Port       int
+	KeyFile    string
+	KnownHosts string
+}
+
+// CordonNode marks a node unschedulable, or schedulable again.
+func CordonNode(ctx context.Context, k8sClient *kubernetes.Clientset, name string, unschedulable bool) error {
+	patch := fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable)
+	_, err := k8sClient.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, []byte(patch), meta.PatchOptions{})
+	return err
+}
+
+// DrainNode cordons a node and evicts its pods, except those of
+// DaemonSets and static pods, respecting PodDisruptionBudgets. Evictions
+// a budget blocks are retried until the timeout.
+func DrainNode(ctx context.Context, k8sClient *kubernetes.Clientset, name string, timeout time.Duration) error {
+	if err := CordonNode(ctx, k8sClient, name, true); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		pods, err := k8sClient.CoreV1().Pods("").List(ctx, meta.ListOptions{FieldSelector: "spec.nodeName=" + name})
+		if err != nil {
+			return err
+		}
+
+		remaining := 0
+		for _, pod := range pods.Items {
+			if !evictable(pod) {
+				continue
+			}
+			remaining++
+
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+
+			err := k8sClient.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policy.Eviction{
+				ObjectMeta: meta.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+			switch {
+			case err == nil:
+				log.Printf("Evicting %s/%s\n", pod.Namespace, pod.Name)
+			case apierrors.IsTooManyRequests(err):
+				log.Printf("Eviction of %s/%s blocked by a disruption budget, retrying\n", pod.Namespace, pod.Name)
+			case apierrors.IsNotFound(err):
+			default:
+				return fmt.Errorf("evicting %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+		}
+
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d pods still on %s: %w", remaining, name, ctx.Err())
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+// evictable reports whether draining has to move a pod off its node.
+// DaemonSet pods would be recreated on the node right away, and static
+// pods can't be evicted through the API at all.
+func evictable(pod core.Pod) bool {
+	if _, ok := pod.Annotations[core.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+
+	return pod.Status.Phase != core.PodSucceeded && pod.Status.Phase != core.PodFailed
+}
+
+// RemoveNode drains a node, resets kubeadm on it over SSH and deletes it
+// from the cluster.
+func RemoveNode(ctx context.Context, k8sClient *kubernetes.Clientset, name string, timeout time.Duration, opts SSHOptions) error {
+	node, err := k8sClient.CoreV1().Nodes().Get(ctx, name, meta.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	address := ""
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == core.NodeInternalIP {
+			address = addr.Address
+			break
+		}
+	}
+	if address == "" {
+		return fmt.Errorf("%s has no internal IP to connect to", name)
+	}
+
+	if err := DrainNode(ctx, k8sClient, name, timeout); err != nil {
+		return err
+	}
+
+	log.Printf("Resetting kubeadm on %s\n", name)
+	out, err := runSSH(address, opts, "kubeadm reset --force")
+	if err != nil {
+		log.Printf("Kubeadm output: %s\n", out)
+		return fmt.Errorf("resetting %s: %w", name, err)
+	}
+
+	return k8sClient.CoreV1().Nodes().Delete(ctx, name, meta.DeleteOptions{})
+}
+
+func runSSH(host string, opts SSHOptions, command string) (string, error) {
+	client, err := dialSSH(host, opts)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(command)
+	return string(out), err
+}
+
+func dialSSH(host string, opts SSHOptions) (*ssh.Client, error) {
+	key, err := os.ReadFile(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeys, err := knownhosts.New(opts.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(opts.Port)), &ssh.ClientConfig{
+		User:            opts.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         time.Second * 30,
+	})
+}
+
+// defaultSSHKey is the first of the usual private keys in ~/.ssh.
+func defaultSSHKey() string {
+	home, _ := os.UserHomeDir()
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return filepath.Join(home, ".ssh", "id_ed25519")
+}
+
+func defaultKnownHosts() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// object is an untyped Kubernetes object for manifests built in code
+// rather than from a template.
+type object map[string]interface{}
+
+func secretObject(name string, ns string, data map[string]string) object {
+	return object{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   object{"name": name, "namespace": ns},
+		"type":       "Opaque",
+		"stringData": data,
+	}
+}
+
+// renderObjects joins objects into a multi-document manifest.
+func renderObjects(objects []object) (string, error) {
+	var manifest strings.Builder
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+
+		manifest.WriteString("---\n")
+		manifest.Write(data)
+	}
+
+	return manifest.String(), nil
+}
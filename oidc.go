@@ -0,0 +1,79 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+)
+
+//go:embed manifests/gitops-oidc.yaml
+var GitOpsOIDCManifest string
+
+// oidcClusterClient is the public client kubectl logs in with, e.g. through
+// the kubelogin plugin. The apiserver only accepts tokens issued to it.
+const oidcClusterClient = "kubernetes"
+
+var oidcClusterRedirects = []string{"http://localhost:8000", "http://localhost:18000"}
+
+// OIDCIssuer is the identity provider addon the apiserver and Weave GitOps
+// accept logins from.
+type OIDCIssuer struct {
+	Issuer        string
+	CAFile        string
+	UsernameClaim string
+	GroupsClaim   string
+	GitOps        OIDCClient
+}
+
+// oidcIssuer returns the enabled identity provider, or nil without one.
+func oidcIssuer(conf *Config) (*OIDCIssuer, error) {
+	dex, keycloak := conf.Addons.Dex, conf.Addons.Keycloak
+
+	switch {
+	case dex.Enabled && keycloak.Enabled:
+		return nil, errors.New("only one of dex and keycloak can be enabled")
+	case dex.Enabled:
+		return &OIDCIssuer{dex.Issuer, dex.CAFile, dex.UsernameClaim, dex.GroupsClaim, dex.GitOps}, nil
+	case keycloak.Enabled:
+		return &OIDCIssuer{keycloak.Issuer(), keycloak.CAFile, keycloak.UsernameClaim, "groups", keycloak.GitOps}, nil
+	}
+
+	return nil, nil
+}
+
+// oidcAPIServerArgs are the kube-apiserver flags accepting tokens of the
+// identity provider.
+func oidcAPIServerArgs(conf *Config) ([]string, error) {
+	issuer, err := oidcIssuer(conf)
+	if err != nil || issuer == nil {
+		return nil, err
+	}
+
+	args := []string{
+		"--oidc-issuer-url=" + issuer.Issuer,
+		"--oidc-client-id=" + oidcClusterClient,
+		"--oidc-username-claim=" + issuer.UsernameClaim,
+		"--oidc-username-prefix=oidc:",
+		"--oidc-groups-claim=" + issuer.GroupsClaim,
+		"--oidc-groups-prefix=oidc:",
+	}
+	if issuer.CAFile != "" {
+		args = append(args, "--oidc-ca-file="+issuer.CAFile)
+	}
+
+	return args, nil
+}
+
+// gitopsOIDCManifest points Weave GitOps at the identity provider when a
+// redirect URL for it is configured.
+func gitopsOIDCManifest(conf *Config) (string, error) {
+	issuer, err := oidcIssuer(conf)
+	if err != nil || issuer == nil || issuer.GitOps.RedirectURL == "" {
+		return "", err
+	}
+
+	if issuer.GitOps.ClientSecret == "" {
+		return "", errors.New("gitops OIDC client needs a clientSecret")
+	}
+
+	return renderValues("gitops-oidc", GitOpsOIDCManifest, issuer)
+}
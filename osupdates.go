@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Kubernetes components are only ever upgraded through orsted, unattended
+// updates must never move them out of step with each other.
+var heldPackages = []string{"kubeadm", "kubelet", "kubectl", "cri-o", "cri-tools"}
+
+const dnfAutomaticConf = `[commands]
+upgrade_type = %s
+download_updates = yes
+apply_updates = yes
+# kured drains and reboots the node inside its own window
+reboot = never
+
+[emitters]
+emit_via = stdio
+
+[base]
+excludepkgs = %s
+`
+
+const aptUnattendedConf = `APT::Periodic::Update-Package-Lists "1";
+APT::Periodic::Unattended-Upgrade "1";
+Unattended-Upgrade::Automatic-Reboot "false";
+%sUnattended-Upgrade::Package-Blacklist {
+%s};
+`
+
+const timerDropIn = `[Timer]
+OnCalendar=
+OnCalendar=%s
+RandomizedDelaySec=0
+`
+
+// dnfRebootCheck tells kured a reboot is pending on distributions that do
+// not write /var/run/reboot-required.
+const dnfRebootCheck = "sh -c '! needs-restarting -r'"
+
+const zypperRebootCheck = "sh -c '! zypper needs-rebooting'"
+
+// RebootSentinelCommand is the SentinelCommand of the config, or else the
+// reboot check of the distribution of the host, so bootstrap, upgrade,
+// render and diff all give kured the same one.
+func (k KuredConfig) RebootSentinelCommand() string {
+	if k.SentinelCommand != "" {
+		return k.SentinelCommand
+	}
+
+	distro, err := DetectDistro()
+	if err != nil {
+		return ""
+	}
+
+	return distro.RebootCheck
+}
+
+// zypperPatchUnit installs patches from the timer, openSUSE has no
+// unattended upgrade tool of its own.
+const zypperPatchUnit = `[Unit]
+Description=Install package patches
+
+[Service]
+Type=oneshot
+ExecStart=/usr/bin/zypper --non-interactive patch%s
+`
+
+const zypperPatchTimer = `[Unit]
+Description=Install package patches in the maintenance window
+
+[Timer]
+OnCalendar=%s
+
+[Install]
+WantedBy=timers.target
+`
+
+func ConfigureOSUpdates(ctx context.Context, conf *Config) error {
+	distro, err := DetectDistro()
+	if err != nil {
+		return failed("os-updates", err)
+	}
+
+	log.Printf("Configuring unattended updates for %s\n", distro.Name)
+	if err := distro.ConfigureUpdates(ctx, conf); err != nil {
+		return failed("os-updates", err)
+	}
+
+	if !conf.Addons.Kured.Enabled {
+		log.Println("OS updates are enabled without kured, updates that need a reboot wait for a manual one")
+	}
+
+	return nil
+}
+
+func dnfAutomatic(ctx context.Context, conf *Config) error {
+	upgradeType := "default"
+	if conf.OSUpdates.SecurityOnly {
+		upgradeType = "security"
+	}
+
+	if err := runHostCommand(ctx, "dnf", "install", "-y", "dnf-automatic", "dnf-utils"); err != nil {
+		return err
+	}
+	if err := writeFile("/etc/dnf/automatic.conf", fmt.Sprintf(dnfAutomaticConf, upgradeType, strings.Join(heldPackages, " "))); err != nil {
+		return err
+	}
+
+	return enableUpdateTimer(ctx, "dnf-automatic.timer", conf.OSUpdates.Schedule)
+}
+
+func aptUnattendedUpgrades(ctx context.Context, conf *Config) error {
+	origins := ""
+	if !conf.OSUpdates.SecurityOnly {
+		origins = "Unattended-Upgrade::Origins-Pattern {\n\t\"origin=*\";\n};\n"
+	}
+
+	held := ""
+	for _, pkg := range heldPackages {
+		held += fmt.Sprintf("\t\"%s\";\n", pkg)
+	}
+
+	if err := runHostCommand(ctx, "env", "DEBIAN_FRONTEND=noninteractive", "apt-get", "install", "-y", "unattended-upgrades"); err != nil {
+		return err
+	}
+	if err := writeFile("/etc/apt/apt.conf.d/52orsted-unattended-upgrades", fmt.Sprintf(aptUnattendedConf, origins, held)); err != nil {
+		return err
+	}
+
+	return enableUpdateTimer(ctx, "apt-daily-upgrade.timer", conf.OSUpdates.Schedule)
+}
+
+// zypperPatches skips the held packages through the locks Hold puts on
+// them.
+func zypperPatches(ctx context.Context, conf *Config) error {
+	category := ""
+	if conf.OSUpdates.SecurityOnly {
+		category = " --category security"
+	}
+
+	if err := writeFile("/etc/systemd/system/orsted-patch.service", fmt.Sprintf(zypperPatchUnit, category)); err != nil {
+		return err
+	}
+	if err := writeFile("/etc/systemd/system/orsted-patch.timer", fmt.Sprintf(zypperPatchTimer, conf.OSUpdates.Schedule)); err != nil {
+		return err
+	}
+
+	return enableUpdateTimer(ctx, "orsted-patch.timer", conf.OSUpdates.Schedule)
+}
+
+// enableUpdateTimer moves timer into the maintenance window and starts it.
+func enableUpdateTimer(ctx context.Context, timer string, schedule string) error {
+	if err := writeFile(filepath.Join("/etc/systemd/system", timer+".d", "orsted.conf"), fmt.Sprintf(timerDropIn, schedule)); err != nil {
+		return err
+	}
+
+	log.Printf("Enabling %s for %s\n", timer, schedule)
+	if err := runHostCommand(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+
+	return runHostCommand(ctx, "systemctl", "enable", "--now", timer)
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func writeFile(path string, contents string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(contents), 0644)
+}
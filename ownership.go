@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/postrender"
+)
+
+// The labels stamped on everything orsted creates, its namespaces, the
+// objects of its releases and the manifests it applies, so what it owns
+// can be told apart from what was created on the cluster since.
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "orsted"
+	phaseLabel     = "orsted.io/phase"
+)
+
+// fetchedLabel marks objects applied from manifests orsted fetches or
+// applies as they are rather than renders, gc can't tell whether they are
+// still wanted and leaves them alone.
+const fetchedLabel = "orsted.io/fetched"
+
+// managedSelector selects every object orsted owns.
+const managedSelector = managedByLabel + "=" + managedByValue
+
+// ownerLabels are the labels of objects created in phase.
+func ownerLabels(phase string) map[string]string {
+	return map[string]string{
+		managedByLabel: managedByValue,
+		phaseLabel:     phase,
+	}
+}
+
+// releasePhase is the bootstrap phase that installs the release name of
+// the config, addons unless it is part of the core stack.
+func releasePhase(conf *Config, name string) string {
+	switch name {
+	case "cilium":
+		return "cilium"
+	case "kyverno", "gatekeeper":
+		return "policy-engine"
+	case "rook-ceph", "rook-ceph-cluster":
+		return "rook"
+	case "weave-gitops":
+		return "gitops"
+	}
+
+	if cloud, err := cloudReleases(conf); err == nil {
+		for _, release := range cloud {
+			if release.Spec.ReleaseName == name {
+				return "cloud"
+			}
+		}
+	}
+
+	return "addons"
+}
+
+// labelObject merges labels into the labels of a decoded object.
+func labelObject(obj map[string]interface{}, labels map[string]string) {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		obj["metadata"] = metadata
+	}
+
+	existing, ok := metadata["labels"].(map[string]interface{})
+	if !ok {
+		existing = map[string]interface{}{}
+		metadata["labels"] = existing
+	}
+	for k, v := range labels {
+		existing[k] = v
+	}
+}
+
+// labelManifest stamps the owner labels of phase on every object of a
+// multi-document manifest.
+func labelManifest(manifest string, phase string) (string, error) {
+	labeled, err := mapManifests(manifest, func(obj map[string]interface{}) error {
+		labelObject(obj, ownerLabels(phase))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return labeled.String(), nil
+}
+
+// markFetched labels every object of a fetched manifest with
+// fetchedLabel.
+func markFetched(manifest string) (string, error) {
+	marked, err := mapManifests(manifest, func(obj map[string]interface{}) error {
+		labelObject(obj, map[string]string{fetchedLabel: "true"})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return marked.String(), nil
+}
+
+// ownerLabeler is a Helm post-renderer stamping the owner labels of phase
+// on every object of a release. It replaces the managed-by label charts
+// set to Helm, which Helm only checks when adopting objects it didn't
+// create.
+type ownerLabeler struct {
+	phase string
+}
+
+func (l ownerLabeler) Run(rendered *bytes.Buffer) (*bytes.Buffer, error) {
+	return mapManifests(rendered.String(), func(obj map[string]interface{}) error {
+		labelObject(obj, ownerLabels(l.phase))
+		return nil
+	})
+}
+
+// postRenderChain runs Helm post-renderers one after the other.
+type postRenderChain []postrender.PostRenderer
+
+func (c postRenderChain) Run(rendered *bytes.Buffer) (*bytes.Buffer, error) {
+	for _, renderer := range c {
+		var err error
+		if rendered, err = renderer.Run(rendered); err != nil {
+			return nil, err
+		}
+	}
+
+	return rendered, nil
+}
+
+// applyOwned runs kubectl apply with the source args, -f or -k with a
+// file, URL or kustomization, and labels what was applied as owned by
+// phase and fetched, for manifests orsted doesn't render itself.
+func applyOwned(ctx context.Context, phase string, args ...string) (string, error) {
+	applyArgs := append([]string{"apply", "--kubeconfig=" + KubeconfigPath}, args...)
+	if out, err := RunCommand(ctx, "kubectl", applyArgs...); err != nil {
+		return out, err
+	}
+
+	labelArgs := append([]string{"label", "--kubeconfig=" + KubeconfigPath, "--overwrite"}, args...)
+	for k, v := range ownerLabels(phase) {
+		labelArgs = append(labelArgs, fmt.Sprintf("%s=%s", k, v))
+	}
+	labelArgs = append(labelArgs, fetchedLabel+"=true")
+
+	return RunCommand(ctx, "kubectl", labelArgs...)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var crdResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// releasePlan is what upgrading a single release would change.
+type releasePlan struct {
+	Name    string
+	Chart   string
+	Current string
+	Target  string
+	// CRDs lists the CRDs the release would create or whose versions
+	// would change, with a note on which.
+	CRDs []string
+	// Restarts lists the workloads whose pods would be replaced.
+	Restarts []string
+	// Outages lists the restarted workloads with a single replica.
+	Outages []string
+}
+
+// PlanUpgrade prints what Upgrade would change without applying anything:
+// the Kubernetes version, the chart version of every release, the CRDs the
+// charts would touch and the workloads that would restart.
+func PlanUpgrade(out io.Writer, conf *Config) error {
+	ctx := context.Background()
+
+	k8sConf, err := clientcmd.BuildConfigFromFlags("", KubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	crds, err := dynamic.NewForConfig(k8sConf)
+	if err != nil {
+		return err
+	}
+
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+
+	serverVersion, err := k8sClient.Discovery().ServerVersion()
+	if err != nil {
+		return err
+	}
+
+	kubeadmVersion, err := RunCommand(ctx, "kubeadm", "version", "-o", "short")
+	if err != nil {
+		return fmt.Errorf("kubeadm version: %w: %s", err, kubeadmVersion)
+	}
+
+	defaultIp, err := primaryNodeIP(conf)
+	if err != nil {
+		return err
+	}
+
+	releases, err := Releases(conf, defaultIp)
+	if err != nil {
+		return err
+	}
+
+	var rewriter *ImageRewriter
+	if conf.Images.PinDigests || len(conf.Images.Mirrors) > 0 {
+		rewriter, err = NewImageRewriter(conf.Images)
+		if err != nil {
+			return err
+		}
+	}
+
+	var plans []releasePlan
+	for _, release := range releases {
+		spec := release.Spec
+		if err := applyHelmDefaults(conf, spec); err != nil {
+			return err
+		}
+
+		client, err := helmClientForNs(spec.Namespace)
+		if err != nil {
+			return err
+		}
+
+		if err := addChartRepo(conf, client, release.Repo); err != nil {
+			return err
+		}
+
+		plan := releasePlan{Name: spec.ReleaseName, Chart: spec.ChartName, Current: "not installed", Target: spec.Version}
+		if plan.Target == "" {
+			plan.Target = "latest"
+		}
+
+		currentManifest := ""
+		if rel, err := client.GetRelease(spec.ReleaseName); err == nil {
+			plan.Current = rel.Chart.Metadata.Version
+			currentManifest = rel.Manifest
+		}
+
+		rendered, err := client.TemplateChart(spec, nil)
+		if err != nil {
+			return fmt.Errorf("rendering %s: %w", spec.ReleaseName, err)
+		}
+
+		// Installed manifests went through the image rewriter, the
+		// rendered one has to as well for the pod templates to compare.
+		if rewriter != nil {
+			buf, err := rewriter.Run(bytes.NewBuffer(rendered))
+			if err != nil {
+				return err
+			}
+			rendered = buf.Bytes()
+		}
+
+		current, err := manifestObjects(currentManifest)
+		if err != nil {
+			return err
+		}
+
+		target, err := manifestObjects(string(rendered))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range sortedKeys(target) {
+			obj := target[key]
+			kind, _ := obj["kind"].(string)
+
+			switch kind {
+			case "CustomResourceDefinition":
+				change, err := crdChange(ctx, crds, obj)
+				if err != nil {
+					return err
+				}
+				if change != "" {
+					plan.CRDs = append(plan.CRDs, change)
+				}
+			case "Deployment", "StatefulSet", "DaemonSet":
+				old, ok := current[key]
+				if ok && reflect.DeepEqual(podTemplate(old), podTemplate(obj)) {
+					continue
+				}
+				if !ok && plan.Current == "not installed" {
+					continue
+				}
+
+				plan.Restarts = append(plan.Restarts, key)
+				if kind != "DaemonSet" && replicas(obj) == 1 {
+					plan.Outages = append(plan.Outages, key)
+				}
+			}
+		}
+
+		plans = append(plans, plan)
+	}
+
+	fmt.Fprintf(out, "Kubernetes: %s -> %s\n\n", serverVersion.GitVersion, strings.TrimSpace(kubeadmVersion))
+
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "RELEASE\tCHART\tCURRENT\tTARGET\tCRDS\tRESTARTS\tDISRUPTION")
+	for _, plan := range plans {
+		disruption := "none"
+		switch {
+		case plan.Current == "not installed":
+			disruption = "new install"
+		case len(plan.Outages) > 0:
+			disruption = fmt.Sprintf("downtime, %d single replica", len(plan.Outages))
+		case len(plan.Restarts) > 0:
+			disruption = "rolling restart"
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", plan.Name, plan.Chart, plan.Current, plan.Target, len(plan.CRDs), len(plan.Restarts), disruption)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		if len(plan.CRDs) == 0 && len(plan.Restarts) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(out, "\n%s:\n", plan.Name)
+		for _, crd := range plan.CRDs {
+			fmt.Fprintf(out, "  crd      %s\n", crd)
+		}
+		for _, workload := range plan.Restarts {
+			fmt.Fprintf(out, "  restart  %s\n", workload)
+		}
+	}
+
+	return nil
+}
+
+// manifestObjects parses a manifest into its objects keyed by
+// kind/namespace/name.
+func manifestObjects(manifest string) (map[string]map[string]interface{}, error) {
+	objects := map[string]map[string]interface{}{}
+	_, err := mapManifests(manifest, func(obj map[string]interface{}) error {
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		ns, _ := metadata["namespace"].(string)
+		name, _ := metadata["name"].(string)
+		kind, _ := obj["kind"].(string)
+
+		objects[kind+"/"+ns+"/"+name] = obj
+		return nil
+	})
+
+	return objects, err
+}
+
+func sortedKeys(objects map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func podTemplate(obj map[string]interface{}) interface{} {
+	spec, _ := obj["spec"].(map[string]interface{})
+	return spec["template"]
+}
+
+func replicas(obj map[string]interface{}) int {
+	spec, _ := obj["spec"].(map[string]interface{})
+	if count, ok := spec["replicas"].(float64); ok {
+		return int(count)
+	}
+
+	return 1
+}
+
+// crdChange describes how applying a CRD would change the cluster, or
+// returns nothing when its versions stay the same.
+func crdChange(ctx context.Context, client dynamic.Interface, crd map[string]interface{}) (string, error) {
+	metadata, _ := crd["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+
+	existing, err := client.Resource(crdResource).Get(ctx, name, meta.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return name + " (new)", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	before := crdVersions(existing.Object)
+	after := crdVersions(crd)
+	if before == after {
+		return "", nil
+	}
+
+	return fmt.Sprintf("%s (%s -> %s)", name, before, after), nil
+}
+
+// crdVersions summarizes the served versions of a CRD, marking the storage
+// version with an asterisk.
+func crdVersions(crd map[string]interface{}) string {
+	data, _ := json.Marshal(crd["spec"])
+
+	var spec struct {
+		Versions []struct {
+			Name    string `json:"name"`
+			Served  bool   `json:"served"`
+			Storage bool   `json:"storage"`
+		} `json:"versions"`
+	}
+	_ = json.Unmarshal(data, &spec)
+
+	var versions []string
+	for _, version := range spec.Versions {
+		if !version.Served {
+			continue
+		}
+		if version.Storage {
+			versions = append(versions, version.Name+"*")
+		} else {
+			versions = append(versions, version.Name)
+		}
+	}
+
+	return strings.Join(versions, ",")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxRestarts is how often a container may restart while its pod starts,
+// e.g. waiting for a webhook or the API, before the pod counts as crash
+// looping once it is back off, which no amount of waiting fixes.
+const maxRestarts = 5
+
+// errCrashLooping marks a pod crash looping, waits stop on it.
+var errCrashLooping = errors.New("crash looping")
+
+// podsReady reports whether every pod of pods that isn't done has the
+// Ready condition, failing on the first one crash looping. Failed pods,
+// like evicted ones, are done as their controller replaced them.
+func podsReady(pods []core.Pod) (bool, error) {
+	ready := true
+	for _, pod := range pods {
+		if pod.Status.Phase == core.PodSucceeded || pod.Status.Phase == core.PodFailed {
+			continue
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if crashLooping(status) {
+				return false, fmt.Errorf("pod %s/%s is %w, container %s restarted %d times", pod.Namespace, pod.Name, errCrashLooping, status.Name, status.RestartCount)
+			}
+		}
+
+		if !podHasCondition(pod, core.PodReady) {
+			ready = false
+		}
+	}
+
+	return ready, nil
+}
+
+// crashLooping reports whether a container is backing off from crashing
+// right now. The restart count alone doesn't tell, it counts the restarts
+// of the pod's whole life.
+func crashLooping(status core.ContainerStatus) bool {
+	if status.Ready || status.State.Waiting == nil {
+		return false
+	}
+
+	return status.State.Waiting.Reason == "CrashLoopBackOff" && status.RestartCount > maxRestarts
+}
+
+func podHasCondition(pod core.Pod, conditionType core.PodConditionType) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == core.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// workloadPodsReady reports whether the pods of a Deployment, StatefulSet
+// or DaemonSet are ready, going by its selector.
+func workloadPodsReady(ctx context.Context, k8sClient *kubernetes.Clientset, kind string, ns string, name string) (bool, error) {
+	apps := k8sClient.AppsV1()
+
+	var selector *meta.LabelSelector
+	switch kind {
+	case "Deployment":
+		d, err := apps.Deployments(ns).Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		selector = d.Spec.Selector
+	case "StatefulSet":
+		s, err := apps.StatefulSets(ns).Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		selector = s.Spec.Selector
+	case "DaemonSet":
+		d, err := apps.DaemonSets(ns).Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		selector = d.Spec.Selector
+	default:
+		return true, nil
+	}
+
+	pods, err := k8sClient.CoreV1().Pods(ns).List(ctx, meta.ListOptions{LabelSelector: meta.FormatLabelSelector(selector)})
+	if err != nil {
+		return false, err
+	}
+
+	return podsReady(pods.Items)
+}
+
+// systemPodsTimeout is how long kube-system may take to be ready once the
+// CNI is installed.
+const systemPodsTimeout = time.Minute * 5
+
+// waitForSystemPods waits for every pod in kube-system, CoreDNS and Cilium
+// among them, to be ready.
+func waitForSystemPods(ctx context.Context, k8sClient *kubernetes.Clientset) error {
+	ctx, cancel := context.WithTimeout(ctx, systemPodsTimeout)
+	defer cancel()
+
+	log.Println("Waiting for the kube-system pods to be ready")
+	for {
+		pods, err := k8sClient.CoreV1().Pods("kube-system").List(ctx, meta.ListOptions{})
+		if err == nil {
+			var ready bool
+			if ready, err = podsReady(pods.Items); err != nil {
+				return err
+			}
+			if ready {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("kube-system pods not ready: %w", ctx.Err())
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+// runPod creates pod, waits up to timeout for it to finish and returns it
+// along with its logs, deleting it either way. The pod failing isn't an
+// error, callers tell from its phase.
+func runPod(ctx context.Context, k8sClient *kubernetes.Clientset, pod *core.Pod, timeout time.Duration) (*core.Pod, string, error) {
+	pods := k8sClient.CoreV1().Pods(pod.Namespace)
+	pod, err := pods.Create(ctx, pod, meta.CreateOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer pods.Delete(context.Background(), pod.Name, meta.DeleteOptions{})
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for pod.Status.Phase != core.PodSucceeded && pod.Status.Phase != core.PodFailed {
+		select {
+		case <-waitCtx.Done():
+			return pod, "", fmt.Errorf("pod %s/%s didn't finish: %w", pod.Namespace, pod.Name, waitCtx.Err())
+		case <-time.After(time.Second * 2):
+		}
+
+		if pod, err = pods.Get(waitCtx, pod.Name, meta.GetOptions{}); err != nil {
+			return nil, "", err
+		}
+	}
+
+	logs, err := pods.GetLogs(pod.Name, &core.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return pod, "", fmt.Errorf("reading the logs of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	return pod, string(logs), nil
+}
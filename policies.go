@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+var (
+	//go:embed manifests/verify-images.yaml
+	VerifyImagesManifest string
+
+	//go:embed values/gatekeeper.yaml
+	GatekeeperYaml string
+)
+
+var gatekeeperRepo = repo.Entry{
+	Name: "gatekeeper",
+	URL:  "https://open-policy-agent.github.io/gatekeeper/charts",
+}
+
+func gatekeeperSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	values, err := renderValues("gatekeeper", GatekeeperYaml, conf.Policies.Gatekeeper)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "gatekeeper",
+		ChartName:       "gatekeeper/gatekeeper",
+		Namespace:       "gatekeeper-system",
+		CreateNamespace: true,
+		Wait:            true,
+		WaitForJobs:     true,
+		Timeout:         time.Minute * 4,
+		Version:         conf.Policies.Gatekeeper.Version,
+		ValuesYaml:      values,
+	}, nil
+}
+
+// PolicyEngineRelease returns the release of the admission policy engine
+// selected in the config.
+func PolicyEngineRelease(conf *Config) (Release, error) {
+	switch conf.Policies.Engine {
+	case "kyverno":
+		return Release{kyvernoRepo, kyvernoSpec()}, nil
+	case "gatekeeper":
+		if len(conf.Policies.VerifyImages) > 0 {
+			return Release{}, errors.New("image verification policies require the kyverno policy engine")
+		}
+
+		spec, err := gatekeeperSpec(conf)
+		if err != nil {
+			return Release{}, err
+		}
+
+		return Release{gatekeeperRepo, spec}, nil
+	}
+
+	return Release{}, fmt.Errorf("unknown policy engine %q, expected kyverno or gatekeeper", conf.Policies.Engine)
+}
+
+// ApplyPolicies installs the default policies of the configured engine
+// once it is running.
+func ApplyPolicies(ctx context.Context, conf *Config) error {
+	switch conf.Policies.Engine {
+	case "kyverno":
+		if err := WaitForCRDs(ctx, crdTimeout, kyvernoCRDs...); err != nil {
+			return failed("kyverno", err)
+		}
+
+		log.Println("Installing default policies")
+		defPolOut, err := applyOwned(ctx, "policies", "-f", "/root/default-policies.yaml")
+		if err != nil {
+			return commandFailed("kyverno", defPolOut, fmt.Errorf("installing the default policies: %w", err))
+		}
+
+		if len(conf.Policies.VerifyImages) == 0 {
+			return nil
+		}
+
+		verifyImages, err := verifyImagesManifest(conf)
+		if err != nil {
+			return failedWith(ExitConfig, "kyverno", fmt.Errorf("rendering the image verification policies: %w", err))
+		}
+
+		log.Println("Installing image verification policies")
+		verifyOut, err := ApplyManifest(ctx, "policies", verifyImages)
+		if err != nil {
+			return commandFailed("kyverno", verifyOut, fmt.Errorf("installing the image verification policies: %w", err))
+		}
+	case "gatekeeper":
+		gatekeeper := conf.Policies.Gatekeeper
+		if err := WaitForCRDs(ctx, crdTimeout, gatekeeperCRDs...); err != nil {
+			return failed("gatekeeper", err)
+		}
+
+		log.Println("Installing Gatekeeper constraint templates")
+		libraryOut, err := applyOwned(ctx, "policies", "-k", gatekeeper.Library)
+		if err != nil {
+			return commandFailed("gatekeeper", libraryOut, fmt.Errorf("installing the constraint templates: %w", err))
+		}
+
+		// Gatekeeper creates a CRD for every template asynchronously, the
+		// constraints can only be applied once their kinds exist.
+		log.Println("Installing default constraints")
+		deadline := time.Now().Add(time.Minute * 2)
+		for {
+			constraintsOut, err := applyOwned(ctx, "policies", "-f", gatekeeper.Constraints)
+			if err == nil {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				return commandFailed("gatekeeper", constraintsOut, fmt.Errorf("installing the default constraints: %w", err))
+			}
+			time.Sleep(time.Second * 5)
+		}
+	}
+
+	return nil
+}
+
+// verifyImagesManifest renders a Kyverno ClusterPolicy for every configured
+// image signature verification.
+func verifyImagesManifest(conf *Config) (string, error) {
+	if conf.Policies.Engine != "kyverno" {
+		return "", errors.New("image verification policies require the kyverno policy engine")
+	}
+
+	for _, policy := range conf.Policies.VerifyImages {
+		if policy.Name == "" {
+			return "", errors.New("image verification policy without a name")
+		}
+		if len(policy.ImageReferences) == 0 {
+			return "", fmt.Errorf("image verification policy %s matches no images", policy.Name)
+		}
+		if len(policy.PublicKeys) == 0 && len(policy.Keyless) == 0 {
+			return "", fmt.Errorf("image verification policy %s has neither public keys nor keyless identities", policy.Name)
+		}
+	}
+
+	return renderValues("verify-images", VerifyImagesManifest, conf.Policies)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hostPorts are the ports the control plane, Cilium and Ceph listen on
+// with host networking. Anything already bound to one of them makes
+// kubeadm or a chart fail partway through.
+type hostPorts struct {
+	Proto    string
+	From, To int
+	Owner    string
+}
+
+func requiredPorts(conf *Config) []hostPorts {
+	ports := []hostPorts{
+		{"tcp", 6443, 6443, "kube-apiserver"},
+		{"tcp", 10250, 10250, "kubelet"},
+		{"tcp", 10257, 10257, "kube-controller-manager"},
+		{"tcp", 10259, 10259, "kube-scheduler"},
+		{"tcp", 4240, 4240, "cilium health"},
+		{"tcp", 4244, 4244, "hubble"},
+		{"tcp", 9879, 9879, "cilium agent health"},
+		{"udp", 8472, 8472, "cilium vxlan"},
+		{"tcp", 3300, 3300, "ceph mon"},
+		{"tcp", 6789, 6789, "ceph mon"},
+		{"tcp", 6800, 7300, "ceph osd"},
+	}
+
+	if len(conf.Etcd.External.Endpoints) == 0 {
+		ports = append(ports, hostPorts{"tcp", 2379, 2380, "etcd"})
+	}
+	if conf.Network.BGP.Enabled {
+		ports = append(ports, hostPorts{"tcp", 179, 179, "cilium bgp"})
+	}
+
+	return ports
+}
+
+// checkPorts fails for every required port something on the host is
+// already listening on, naming the process holding it.
+func checkPorts(ctx context.Context, conf *Config) error {
+	out, err := RunCommand(ctx, "ss", "-H", "-l", "-n", "-p", "-t", "-u")
+	if err != nil {
+		return fmt.Errorf("ss: %w: %s", err, out)
+	}
+
+	// Protocol and port to the process listening on it, from lines like
+	// tcp LISTEN 0 4096 *:6443 *:* users:(("kube-apiserver",pid=1,fd=3))
+	bound := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+
+		port := fields[4][strings.LastIndex(fields[4], ":")+1:]
+		process := "unknown process"
+		if len(fields) > 6 {
+			process = fields[6]
+		}
+		bound[fields[0]+"/"+port] = process
+	}
+
+	var problems []string
+	for _, ports := range requiredPorts(conf) {
+		for port := ports.From; port <= ports.To; port++ {
+			if process, ok := bound[ports.Proto+"/"+strconv.Itoa(port)]; ok {
+				problems = append(problems, fmt.Sprintf("%s/%d needed by %s is held by %s", ports.Proto, port, ports.Owner, process))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
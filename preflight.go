@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// PreflightCheck verifies the host before anything is changed on it, so
+// problems surface right away instead of halfway through the bootstrap.
+type PreflightCheck struct {
+	Name  string
+	Check func(ctx context.Context, conf *Config) error
+	// Fix optionally corrects what Check found, when preflight runs with
+	// fixes enabled. The check is run again afterwards.
+	Fix func(ctx context.Context, conf *Config) error
+}
+
+// Connectivity goes first, the version checks download charts.
+var PreflightChecks = []PreflightCheck{
+	{
+		Name:  "connectivity",
+		Check: checkConnectivity,
+	},
+	{
+		Name: "version-skew",
+		Check: func(ctx context.Context, conf *Config) error {
+			return CheckVersionSkew(ctx, conf, "")
+		},
+		Fix: func(ctx context.Context, conf *Config) error {
+			if !conf.Provision.Enabled {
+				return errors.New("enable provision to install the configured Kubernetes version")
+			}
+
+			return ProvisionHost(ctx, conf)
+		},
+	},
+	{
+		Name:  "kernel",
+		Check: checkKernelParams,
+		Fix:   ConfigureSysctl,
+	},
+	{
+		Name:  "hostname",
+		Check: checkHostname,
+		Fix:   fixHostname,
+	},
+	{
+		Name:  "ports",
+		Check: checkPorts,
+	},
+	{
+		Name:  "resources",
+		Check: checkResources,
+	},
+	{
+		Name:  "time-sync",
+		Check: checkTimeSync,
+		Fix:   enableTimeSync,
+	},
+}
+
+// Preflight runs every check, fixing what it can when fix is set, and
+// fails if any of them still fail.
+func Preflight(ctx context.Context, conf *Config, fix bool) error {
+	failing := 0
+	for _, check := range PreflightChecks {
+		err := check.Check(ctx, conf)
+		if err != nil && fix && check.Fix != nil {
+			log.Printf("Preflight %s failed, fixing: %s\n", check.Name, err)
+			if err = check.Fix(ctx, conf); err == nil {
+				err = check.Check(ctx, conf)
+			}
+		}
+
+		if err != nil {
+			if check.Fix != nil && !fix {
+				err = fmt.Errorf("%w, run with --fix to correct it", err)
+			}
+			log.Printf("Preflight %s failed: %s\n", check.Name, err)
+			failing++
+		}
+	}
+
+	if failing > 0 {
+		return failedWith(ExitPreflight, "", fmt.Errorf("%d preflight checks failed", failing))
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// prettyOutput prints a header when each phase starts and its outcome and
+// duration when it ends on stdout, over the logs on stderr.
+var prettyOutput bool
+
+const (
+	colorReset = "\033[0m"
+	colorBold  = "\033[1m"
+	colorGreen = "\033[32m"
+	colorRed   = "\033[31m"
+)
+
+// useColor reports whether stdout is a terminal and NO_COLOR isn't set.
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(color string, text string) string {
+	if !useColor() {
+		return text
+	}
+
+	return color + text + colorReset
+}
+
+func printPhaseStart(name string) {
+	if !prettyOutput {
+		return
+	}
+
+	fmt.Println(colorize(colorBold, "==> "+name))
+}
+
+func printPhaseEnd(name string, took time.Duration, err error) {
+	if !prettyOutput {
+		return
+	}
+
+	took = took.Round(time.Millisecond * 100)
+	if err != nil {
+		fmt.Printf("%s %s (%s): %s\n", colorize(colorRed, "✗"), name, took, Redact(err.Error()))
+		return
+	}
+
+	fmt.Printf("%s %s (%s)\n", colorize(colorGreen, "✓"), name, took)
+}
+
+// printSummary prints the outcome of the whole bootstrap.
+func printSummary(took time.Duration, err error) {
+	if !prettyOutput {
+		return
+	}
+
+	took = took.Round(time.Second)
+	if err != nil {
+		fmt.Println(colorize(colorBold+colorRed, fmt.Sprintf("Bootstrap failed after %s", took)))
+		return
+	}
+
+	fmt.Println(colorize(colorBold+colorGreen, fmt.Sprintf("Cluster ready in %s", took)))
+}
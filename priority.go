@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// The PriorityClasses of the platform components, so storage, networking
+// and policy aren't evicted before application pods under memory
+// pressure. They rank below system-node-critical and
+// system-cluster-critical, which the Cilium agent and the Ceph daemons
+// keep, names starting with system- being reserved for those.
+const (
+	platformCriticalClass = "platform-critical"
+	platformClass         = "platform"
+)
+
+// priorityClassesManifest renders the platform PriorityClasses. The
+// highest a class outside of system- may have is a billion.
+func priorityClassesManifest(conf *Config) (string, error) {
+	var objects []object
+	for _, class := range []struct {
+		name        string
+		value       int
+		description string
+	}{
+		{platformCriticalClass, 1000000000, "Platform components the cluster doesn't work without, like the Cilium operator, Rook and the policy admission webhooks."},
+		{platformClass, 100000000, "Platform components that can wait for the critical ones, scheduled before applications."},
+	} {
+		objects = append(objects, object{
+			"apiVersion":       "scheduling.k8s.io/v1",
+			"kind":             "PriorityClass",
+			"metadata":         object{"name": class.name},
+			"value":            class.value,
+			"globalDefault":    false,
+			"preemptionPolicy": "PreemptLowerPriority",
+			"description":      class.description,
+		})
+	}
+
+	return renderObjects(objects)
+}
+
+// ApplyPriorityClasses creates the platform PriorityClasses the core
+// releases are installed with.
+func ApplyPriorityClasses(ctx context.Context, conf *Config) error {
+	manifest, err := priorityClassesManifest(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "priority-classes", fmt.Errorf("rendering: %w", err))
+	}
+
+	log.Println("Creating the platform priority classes")
+	out, err := ApplyManifest(ctx, "priority-classes", manifest)
+	if err != nil {
+		return commandFailed("priority-classes", out, fmt.Errorf("applying: %w", err))
+	}
+
+	return nil
+}
+
+// ciliumPriorityValues puts the Cilium components besides the agent, which
+// is system-node-critical, in the platform classes.
+func ciliumPriorityValues(overrides map[string]interface{}) {
+	overrides["operator"] = map[string]interface{}{"priorityClassName": platformCriticalClass}
+	overrides["envoy"] = map[string]interface{}{"priorityClassName": platformCriticalClass}
+	overrides["hubble"] = map[string]interface{}{
+		"relay": map[string]interface{}{"priorityClassName": platformClass},
+		"ui":    map[string]interface{}{"priorityClassName": platformClass},
+	}
+}
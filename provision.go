@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+const (
+	kubernetesRepoURL = "https://pkgs.k8s.io/core:/stable:/%s/%s/"
+	crioRepoURL       = "https://pkgs.k8s.io/addons:/cri-o:/stable:/%s/%s/"
+
+	// defaultKubernetesVersion is provisioned when no version is
+	// configured and kubeadm isn't installed yet.
+	defaultKubernetesVersion = "v1.28"
+)
+
+// kubernetesPackages are pinned to the configured version, CRI-O only to
+// its minor through the repo.
+var kubernetesPackages = []string{"kubeadm", "kubelet", "kubectl"}
+
+// ProvisionHost installs the Kubernetes packages and CRI-O when any of them
+// is missing or kubeadm and kubelet aren't the configured version,
+// defaultKubernetesVersion when none is.
+func ProvisionHost(ctx context.Context, conf *Config) error {
+	if hostMatchesVersion(ctx, conf) {
+		log.Println("Kubernetes packages are installed, skipping provisioning")
+		return nil
+	}
+
+	version := conf.Kubernetes.Version
+	if version == "" {
+		version = defaultKubernetesVersion
+	}
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return failedWith(ExitConfig, "kubernetes", fmt.Errorf("invalid version %q: %w", version, err))
+	}
+	minor := fmt.Sprintf("v%d.%d", parsed.Major(), parsed.Minor())
+
+	distro, err := DetectDistro()
+	if err != nil {
+		return failed("provision", err)
+	}
+	repos := []struct {
+		name, url string
+		packages  []string
+	}{
+		{"kubernetes", fmt.Sprintf(kubernetesRepoURL, minor, distro.RepoFormat), []string{"kubelet", "kubeadm", "kubectl", "cri-tools", "kubernetes-cni"}},
+		{"cri-o", fmt.Sprintf(crioRepoURL, minor, distro.RepoFormat), []string{"cri-o"}},
+	}
+	for _, repo := range repos {
+		if err := distro.AddRepo(ctx, repo.name, repo.url, repo.packages); err != nil {
+			return failed(repo.name, fmt.Errorf("adding the package repo: %w", err))
+		}
+	}
+
+	log.Printf("Installing Kubernetes %s packages and CRI-O on %s\n", version, distro.Name)
+	if err := distro.Install(ctx, pinnedPatch(version), kubernetesPackages...); err != nil {
+		return failed("kubernetes", fmt.Errorf("installing the packages: %w", err))
+	}
+	if err := distro.Install(ctx, "", "cri-o"); err != nil {
+		return failed("cri-o", fmt.Errorf("installing: %w", err))
+	}
+	if err := distro.Hold(ctx, append(kubernetesPackages, "cri-o")...); err != nil {
+		return failed("kubernetes", fmt.Errorf("holding the packages: %w", err))
+	}
+
+	return nil
+}
+
+// hostMatchesVersion reports whether kubeadm, kubelet, kubectl and CRI-O
+// are installed, with kubeadm and kubelet of the configured version.
+func hostMatchesVersion(ctx context.Context, conf *Config) bool {
+	for _, command := range []string{"kubeadm", "kubelet", "kubectl", "crio"} {
+		if !hasCommand(command) {
+			return false
+		}
+	}
+
+	for _, command := range [][]string{{"kubeadm", "version", "-o", "short"}, {"kubelet", "--version"}} {
+		installed, err := commandVersion(ctx, command[0], command[1:]...)
+		if err != nil || !kubernetesVersionMatches(conf, installed) {
+			return false
+		}
+	}
+
+	return true
+}
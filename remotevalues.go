@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// valuesSource is where a values file of helm.values comes from: a path on
+// the node, an https URL, optionally pinned to the sha256 of its contents
+// with ?checksum=sha256:<hex>, or a file in a Git repository written as
+// git::<repo>//<file>?ref=<ref>, pinned when ref is a full commit hash.
+type valuesSource struct {
+	Path     string
+	URL      string
+	Checksum string
+	Repo     string
+	File     string
+	Ref      string
+}
+
+var commitHash = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+func parseValuesSource(source string) (valuesSource, error) {
+	if rest, ok := strings.CutPrefix(source, "git::"); ok {
+		rest, query, _ := strings.Cut(rest, "?")
+		// The repo URL has a // of its own after the scheme.
+		schemeEnd := 0
+		if i := strings.Index(rest, "://"); i >= 0 {
+			schemeEnd = i + 3
+		}
+		i := strings.Index(rest[schemeEnd:], "//")
+		if i < 0 {
+			return valuesSource{}, fmt.Errorf("%s has no //<file> after the repository", source)
+		}
+
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			return valuesSource{}, fmt.Errorf("%s: %w", source, err)
+		}
+
+		return valuesSource{
+			Repo: rest[:schemeEnd+i],
+			File: rest[schemeEnd+i+2:],
+			Ref:  params.Get("ref"),
+		}, nil
+	}
+
+	if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") {
+		return valuesSource{Path: source}, nil
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return valuesSource{}, err
+	}
+
+	query := u.Query()
+	checksum := query.Get("checksum")
+	query.Del("checksum")
+	u.RawQuery = query.Encode()
+
+	if checksum != "" {
+		sum, ok := strings.CutPrefix(checksum, "sha256:")
+		if !ok {
+			return valuesSource{}, fmt.Errorf("%s: checksum %q isn't sha256:<hex>", source, checksum)
+		}
+		checksum = strings.ToLower(sum)
+	}
+
+	return valuesSource{URL: u.String(), Checksum: checksum}, nil
+}
+
+// pinned reports whether the source can't change without the config
+// changing too.
+func (s valuesSource) pinned() bool {
+	return s.Path != "" || s.Checksum != "" || commitHash.MatchString(s.Ref)
+}
+
+var (
+	remoteValues   = map[string][]byte{}
+	remoteValuesMu sync.Mutex
+)
+
+// readValues reads the values file of source, which may be SOPS
+// encrypted. Remote sources are fetched once per run, so every release
+// rendered in it sees the same values.
+func readValues(source string) ([]byte, error) {
+	parsed, err := parseValuesSource(source)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Path != "" {
+		return readConfigFile(parsed.Path)
+	}
+
+	remoteValuesMu.Lock()
+	defer remoteValuesMu.Unlock()
+
+	if data, ok := remoteValues[source]; ok {
+		return data, nil
+	}
+	if !parsed.pinned() {
+		log.Printf("%s isn't pinned to a checksum or commit, its values may change between runs\n", source)
+	}
+
+	var data []byte
+	if parsed.URL != "" {
+		data, err = fetchValues(parsed)
+	} else {
+		data, err = gitValues(parsed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", source, err)
+	}
+
+	if isSOPSEncrypted(data) {
+		if data, err = decryptRemote(data); err != nil {
+			return nil, err
+		}
+	}
+
+	remoteValues[source] = data
+	return data, nil
+}
+
+func fetchValues(source valuesSource) ([]byte, error) {
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Get(source.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.Checksum != "" {
+		sum := sha256.Sum256(data)
+		if actual := hex.EncodeToString(sum[:]); actual != source.Checksum {
+			return nil, fmt.Errorf("checksum is sha256:%s, expected sha256:%s", actual, source.Checksum)
+		}
+	}
+
+	return data, nil
+}
+
+// gitValues clones the repository of source at its ref, or the default
+// branch, and reads the file.
+func gitValues(source valuesSource) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "orsted-values")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	if out, err := RunCommand(ctx, "git", "clone", "--quiet", "--no-checkout", source.Repo, dir); err != nil {
+		return nil, fmt.Errorf("cloning: %w: %s", err, out)
+	}
+
+	ref := source.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if out, err := RunCommand(ctx, "git", "-C", dir, "checkout", "--quiet", ref); err != nil {
+		return nil, fmt.Errorf("checking out %s: %w: %s", ref, err, out)
+	}
+
+	return os.ReadFile(filepath.Join(dir, filepath.FromSlash(source.File)))
+}
+
+// decryptRemote decrypts fetched values with sops, which only reads them
+// from a file.
+func decryptRemote(data []byte) ([]byte, error) {
+	file, err := os.CreateTemp("", "orsted-values-*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptSOPS(file.Name(), "yaml")
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	helmclient "github.com/mittwald/go-helm-client"
+)
+
+// Render writes everything a bootstrap would install to outDir without a
+// cluster: the kubeadm config, the values and rendered manifests of every
+// release and the manifests orsted applies itself.
+//
+//	kubeadm/clusterconfig.yaml
+//	values/<release>.yaml
+//	charts/<release>.yaml
+//	manifests/<name>.yaml
+func Render(ctx context.Context, conf *Config, outDir string) error {
+	// Rendered for the installed kubeadm as bootstrap would, otherwise for
+	// the configured version or the one provisioning would install.
+	version := conf.Kubernetes.Version
+	if version == "" {
+		version = defaultKubernetesVersion
+	}
+	if kubeadm, err := commandVersion(ctx, "kubeadm", "version", "-o", "short"); err == nil {
+		version = "v" + kubeadm.String()
+	} else {
+		log.Printf("kubeadm isn't installed, rendering the kubeadm config for %s\n", version)
+	}
+
+	kubeadm, err := renderKubeadmConfig(conf, version)
+	if err != nil {
+		return fmt.Errorf("rendering the kubeadm config: %w", err)
+	}
+	if err := writeRendered(outDir, "kubeadm", "clusterconfig", kubeadm); err != nil {
+		return err
+	}
+
+	defaultIp, err := primaryNodeIP(conf)
+	if err != nil {
+		return err
+	}
+
+	releases, err := Releases(conf, defaultIp)
+	if err != nil {
+		return err
+	}
+
+	for _, release := range releases {
+		spec := release.Spec
+		if err := applyHelmDefaults(conf, spec); err != nil {
+			return err
+		}
+		if err := writeRendered(outDir, "values", spec.ReleaseName, spec.ValuesYaml); err != nil {
+			return err
+		}
+
+		client, err := templateClient(spec.Namespace)
+		if err != nil {
+			return err
+		}
+		if err := addChartRepo(conf, client, release.Repo); err != nil {
+			return fmt.Errorf("adding the %s chart repo: %w", release.Repo.Name, err)
+		}
+
+		log.Printf("Rendering %s\n", spec.ReleaseName)
+		rendered, err := client.TemplateChart(spec, nil)
+		if err != nil {
+			return fmt.Errorf("rendering %s: %w", spec.ReleaseName, err)
+		}
+		opts, err := helmOptions(conf, spec.ReleaseName)
+		if err != nil {
+			return err
+		}
+		buf, err := opts.PostRenderer.Run(bytes.NewBuffer(rendered))
+		if err != nil {
+			return fmt.Errorf("post-rendering %s: %w", spec.ReleaseName, err)
+		}
+		rendered = buf.Bytes()
+
+		if err := writeRendered(outDir, "charts", spec.ReleaseName, string(rendered)); err != nil {
+			return err
+		}
+	}
+
+	manifests, err := renderManifests(conf)
+	if err != nil {
+		return err
+	}
+	for name, manifest := range manifests {
+		if err := writeRendered(outDir, "manifests", name, manifest); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Rendered %d releases and %d manifests to %s\n", len(releases), len(manifests), outDir)
+	return nil
+}
+
+// renderManifests renders the manifests bootstrap applies besides the
+// charts, by name, labeled as owned by the phase applying them. Those
+// fetched when applying, like the Gateway API CRDs, aren't included.
+func renderManifests(conf *Config) (map[string]string, error) {
+	manifests := map[string]string{}
+	add := func(name string, phase string, manifest string) error {
+		labeled, err := labelManifest(manifest, phase)
+		if err != nil {
+			return fmt.Errorf("labeling the %s manifest: %w", name, err)
+		}
+		manifests[name] = labeled
+		return nil
+	}
+	render := func(name string, phase string, fn func(conf *Config) (string, error)) error {
+		manifest, err := fn(conf)
+		if err != nil {
+			return fmt.Errorf("rendering the %s manifest: %w", name, err)
+		}
+		if manifest == "" {
+			return nil
+		}
+		return add(name, phase, manifest)
+	}
+
+	if err := render("namespace-resources", "namespaces", namespaceResourcesManifest); err != nil {
+		return nil, err
+	}
+	if err := render("priority-classes", "priority-classes", priorityClassesManifest); err != nil {
+		return nil, err
+	}
+	if len(conf.Network.LoadBalancer.Pools) > 0 {
+		if err := render("loadbalancer", "cilium", loadBalancerManifest); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Network.BGP.Enabled {
+		if err := render("bgp", "cilium", bgpManifest); err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.Network.EgressGateways) > 0 {
+		if err := render("egress-gateways", "cilium", egressGatewayManifest); err != nil {
+			return nil, err
+		}
+	}
+	if err := render("cloud", "cloud", cloudManifest); err != nil {
+		return nil, err
+	}
+	if conf.Policies.Engine == "kyverno" && len(conf.Policies.VerifyImages) > 0 {
+		if err := render("verify-images", "policies", verifyImagesManifest); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, addon := range configAddons(conf) {
+		if !addon.Enabled(conf) || addon.Manifests == nil {
+			continue
+		}
+		if err := render(addon.Name, "addons", addon.Manifests); err != nil {
+			return nil, err
+		}
+	}
+	if conf.Addons.Harbor.Enabled && conf.Addons.Harbor.Storage == "s3" {
+		if err := add("harbor-bucket", "addons", HarborBucketManifest); err != nil {
+			return nil, err
+		}
+	}
+	if err := render("tenants", "tenants", tenantsManifest); err != nil {
+		return nil, err
+	}
+
+	// The manifests bootstrap applies from the host as they are, and
+	// labels afterwards.
+	for name, path := range map[string]string{"default-policies": "/root/default-policies.yaml", "rook-overrides": "/root/rook-overrides.yaml"} {
+		phase := "rook"
+		if name == "default-policies" {
+			if conf.Policies.Engine != "kyverno" {
+				continue
+			}
+			phase = "policies"
+		}
+
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			log.Printf("%s doesn't exist, skipping it\n", path)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if err := add(name, phase, string(data)); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifests, nil
+}
+
+func writeRendered(outDir string, kind string, name string, contents string) error {
+	dir := filepath.Join(outDir, kind)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// Values and manifests carry the config's secrets in plain text.
+	return os.WriteFile(filepath.Join(dir, name+".yaml"), []byte(contents), 0600)
+}
+
+// templateClient is a Helm client for ns without a kubeconfig, for
+// templating and fetching charts, which are client only.
+func templateClient(ns string) (helmclient.Client, error) {
+	return helmclient.New(&helmclient.Options{
+		Namespace:        ns,
+		RepositoryCache:  helmRepositoryCache,
+		RepositoryConfig: helmRepositoryConfig,
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReportPath is where the summary of the last bootstrap is written.
+const ReportPath = "/var/lib/orsted/report.json"
+
+type Report struct {
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt,omitempty"`
+	Node       string          `json:"node,omitempty"`
+	Releases   []ReleaseReport `json:"releases,omitempty"`
+	Tests      []TestResult    `json:"tests,omitempty"`
+	Failure    *FailureReport  `json:"failure,omitempty"`
+
+	// NetworkBenchmarks are the results of the last bench network.
+	NetworkBenchmarks []NetworkBenchmark `json:"networkBenchmarks,omitempty"`
+}
+
+// FailureReport describes the Failure a bootstrap stopped on.
+type FailureReport struct {
+	Phase     string `json:"phase,omitempty"`
+	Component string `json:"component,omitempty"`
+	Error     string `json:"error"`
+	Output    string `json:"output,omitempty"`
+	ExitCode  int    `json:"exitCode"`
+	Bundle    string `json:"bundle,omitempty"`
+}
+
+type ReleaseReport struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Chart     string `json:"chart"`
+	Version   string `json:"version"`
+}
+
+func NewReport() *Report {
+	return &Report{StartedAt: time.Now()}
+}
+
+// ReadReport reads the report written to path.
+func ReadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &report, nil
+}
+
+func (r *Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Test logs and errors are command output that may echo secrets.
+	data = []byte(Redact(string(data)))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
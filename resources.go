@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const gib = 1 << 30
+
+// resourceRequirements are the CPUs, memory and free disk in GiB a node
+// needs. Ceph counts the total size of the empty disks Rook can claim.
+type resourceRequirements struct {
+	CPUs       int
+	Memory     uint64
+	Containers uint64
+	Etcd       uint64
+	Ceph       uint64
+}
+
+// resourceProfiles follow the sysctl profiles. Below the minimum bootstrap
+// fails, below the recommendation it only warns.
+var resourceProfiles = map[string]struct{ Minimum, Recommended resourceRequirements }{
+	"default": {
+		Minimum:     resourceRequirements{CPUs: 2, Memory: 4, Containers: 20, Etcd: 2, Ceph: 10},
+		Recommended: resourceRequirements{CPUs: 4, Memory: 16, Containers: 50, Etcd: 10, Ceph: 100},
+	},
+	"storage-heavy": {
+		Minimum:     resourceRequirements{CPUs: 4, Memory: 8, Containers: 20, Etcd: 2, Ceph: 50},
+		Recommended: resourceRequirements{CPUs: 8, Memory: 32, Containers: 100, Etcd: 20, Ceph: 500},
+	},
+	"network-heavy": {
+		Minimum:     resourceRequirements{CPUs: 4, Memory: 8, Containers: 20, Etcd: 2, Ceph: 10},
+		Recommended: resourceRequirements{CPUs: 8, Memory: 16, Containers: 50, Etcd: 10, Ceph: 100},
+	},
+}
+
+// checkResources compares the host with the requirements of the
+// configured profile.
+func checkResources(ctx context.Context, conf *Config) error {
+	profile, ok := resourceProfiles[conf.Sysctl.Profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", conf.Sysctl.Profile)
+	}
+
+	memory, err := totalMemory()
+	if err != nil {
+		return err
+	}
+
+	containers, err := freeDisk("/var/lib/containers")
+	if err != nil {
+		return err
+	}
+
+	ceph, err := cephDisks(ctx)
+	if err != nil {
+		return err
+	}
+
+	type resource struct {
+		name                      string
+		found, minimum, recommend uint64
+	}
+	resources := []resource{
+		{"CPUs", uint64(runtime.NumCPU()), uint64(profile.Minimum.CPUs), uint64(profile.Recommended.CPUs)},
+		{"GiB of memory", memory / gib, profile.Minimum.Memory, profile.Recommended.Memory},
+		{"GiB free in /var/lib/containers", containers / gib, profile.Minimum.Containers, profile.Recommended.Containers},
+		{"GiB of empty disks for Ceph", ceph / gib, profile.Minimum.Ceph, profile.Recommended.Ceph},
+	}
+
+	if len(conf.Etcd.External.Endpoints) == 0 {
+		etcd, err := freeDisk("/var/lib/etcd")
+		if err != nil {
+			return err
+		}
+		resources = append(resources, resource{"GiB free in /var/lib/etcd", etcd / gib, profile.Minimum.Etcd, profile.Recommended.Etcd})
+	}
+
+	var problems []string
+	for _, r := range resources {
+		switch {
+		case r.found < r.minimum:
+			problems = append(problems, fmt.Sprintf("%d %s, the %s profile needs at least %d", r.found, r.name, conf.Sysctl.Profile, r.minimum))
+		case r.found < r.recommend:
+			log.Printf("Only %d %s, the %s profile recommends %d\n", r.found, r.name, conf.Sysctl.Profile, r.recommend)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func totalMemory() (uint64, error) {
+	meminfo, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer meminfo.Close()
+
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+
+	return 0, errors.New("no MemTotal in /proc/meminfo")
+}
+
+// freeDisk is the space available on the filesystem path will be created
+// on, the one of its closest existing parent.
+func freeDisk(path string) (uint64, error) {
+	for {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(path, &stat)
+		if err == nil {
+			return stat.Bavail * uint64(stat.Bsize), nil
+		} else if !errors.Is(err, os.ErrNotExist) || path == "/" {
+			return 0, err
+		}
+
+		path = filepath.Dir(path)
+	}
+}
+
+// blockDevice is a disk Rook can claim.
+type blockDevice struct {
+	Name string
+	Size uint64
+}
+
+// emptyDisks lists the disks Rook would consume: whole disks without
+// partitions, filesystems or mounts.
+func emptyDisks(ctx context.Context) ([]blockDevice, error) {
+	out, err := RunCommand(ctx, "lsblk", "--json", "--bytes", "--output", "NAME,TYPE,SIZE,FSTYPE,MOUNTPOINT")
+	if err != nil {
+		return nil, fmt.Errorf("lsblk: %w: %s", err, out)
+	}
+
+	var devices struct {
+		Blockdevices []struct {
+			Name       string        `json:"name"`
+			Type       string        `json:"type"`
+			Size       json.Number   `json:"size"`
+			FSType     *string       `json:"fstype"`
+			Mountpoint *string       `json:"mountpoint"`
+			Children   []interface{} `json:"children"`
+		} `json:"blockdevices"`
+	}
+	// Older lsblk quotes every value, which json.Number accepts as well.
+	if err := json.Unmarshal([]byte(out), &devices); err != nil {
+		return nil, err
+	}
+
+	var disks []blockDevice
+	for _, device := range devices.Blockdevices {
+		if device.Type == "disk" && device.FSType == nil && device.Mountpoint == nil && len(device.Children) == 0 {
+			size, err := strconv.ParseUint(device.Size.String(), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			disks = append(disks, blockDevice{device.Name, size})
+		}
+	}
+
+	return disks, nil
+}
+
+// cephDisks is the total size of the disks Rook would consume.
+func cephDisks(ctx context.Context) (uint64, error) {
+	disks, err := emptyDisks(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, disk := range disks {
+		total += disk.Size
+	}
+
+	return total, nil
+}
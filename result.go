@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// jsonOutput makes bootstrap print nothing on stdout but a single Result
+// when it ends, for Terraform provisioners and external data sources.
+var jsonOutput bool
+
+// resultWritten keeps exit from printing a second Result after Bootstrap
+// already printed one.
+var resultWritten bool
+
+// Result is what a bootstrap run with --json prints on stdout.
+type Result struct {
+	Status     string            `json:"status"`
+	ExitCode   int               `json:"exitCode"`
+	Kubeconfig string            `json:"kubeconfig,omitempty"`
+	Node       string            `json:"node,omitempty"`
+	Endpoints  map[string]string `json:"endpoints,omitempty"`
+	Versions   ResultVersions    `json:"versions"`
+	Releases   []ReleaseReport   `json:"releases,omitempty"`
+	Failure    *FailureReport    `json:"failure,omitempty"`
+}
+
+// ResultVersions are the versions of what the bootstrap installed, the
+// Kubernetes one as reported by the API server.
+type ResultVersions struct {
+	Orsted     string `json:"orsted"`
+	Kubernetes string `json:"kubernetes,omitempty"`
+}
+
+// NewResult describes the bootstrap the report is of, reaching the
+// cluster for its endpoints and version when it succeeded.
+func NewResult(report *Report) *Result {
+	result := &Result{
+		Status:   "succeeded",
+		Versions: ResultVersions{Orsted: orstedVersion()},
+		Node:     report.Node,
+		Releases: report.Releases,
+		Failure:  report.Failure,
+	}
+	if report.Failure != nil {
+		result.Status = "failed"
+		result.ExitCode = report.Failure.ExitCode
+		return result
+	}
+
+	result.Kubeconfig = KubeconfigPath
+	if k8sConf, err := clientcmd.BuildConfigFromFlags("", KubeconfigPath); err == nil {
+		result.Endpoints = map[string]string{"apiServer": k8sConf.Host}
+	}
+	if client, err := KubeClient(); err == nil {
+		if version, err := client.Discovery().ServerVersion(); err == nil {
+			result.Versions.Kubernetes = version.GitVersion
+		}
+	}
+
+	return result
+}
+
+// failureResult describes a run that failed before Bootstrap could
+// report on it, like on an invalid config.
+func failureResult(failure *Failure) *Result {
+	return &Result{
+		Status:   "failed",
+		ExitCode: failure.Code,
+		Versions: ResultVersions{Orsted: orstedVersion()},
+		Failure: &FailureReport{
+			Phase:     failure.Phase,
+			Component: failure.Component,
+			Error:     failure.Err.Error(),
+			Output:    failure.Output,
+			ExitCode:  failure.Code,
+		},
+	}
+}
+
+// writeResult prints result on stdout as a single line of JSON.
+func writeResult(result *Result) {
+	resultWritten = true
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to encode the result: %s\n", err)
+		return
+	}
+
+	os.Stdout.Write([]byte(Redact(string(data)) + "\n"))
+}
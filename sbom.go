@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/google/uuid"
+)
+
+// SBOMComponent is a single entry of the bill of materials, either a Go
+// module compiled into orsted or a container image one of the releases
+// deploys.
+type SBOMComponent struct {
+	Type    string
+	Name    string
+	Version string
+	PURL    string
+	// Release is the Helm release deploying an image.
+	Release string
+}
+
+// SBOM collects the components of the orsted binary and the stack the
+// config deploys.
+func SBOM(conf *Config) ([]SBOMComponent, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil, errors.New("binary was built without module information")
+	}
+
+	components := []SBOMComponent{}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+
+		components = append(components, SBOMComponent{
+			Type:    "library",
+			Name:    dep.Path,
+			Version: dep.Version,
+			PURL:    fmt.Sprintf("pkg:golang/%s@%s", dep.Path, dep.Version),
+		})
+	}
+
+	lock, err := LoadImageLock(conf.Images.LockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := ReleaseImages(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	for release, list := range images {
+		for _, image := range list {
+			named, err := reference.ParseNormalizedNamed(image)
+			if err != nil {
+				return nil, err
+			}
+
+			version := ""
+			if tagged, ok := named.(reference.Tagged); ok {
+				version = tagged.Tag()
+			}
+
+			digest := lock[image]
+			if digested, ok := named.(reference.Digested); ok {
+				digest = digested.Digest().String()
+			}
+
+			components = append(components, SBOMComponent{
+				Type:    "container",
+				Name:    reference.Path(named),
+				Version: version,
+				PURL:    imagePURL(named, version, digest),
+				Release: release,
+			})
+		}
+	}
+
+	sort.Slice(components, func(i, j int) bool {
+		if components[i].Type != components[j].Type {
+			return components[i].Type < components[j].Type
+		}
+		return components[i].PURL < components[j].PURL
+	})
+
+	return components, nil
+}
+
+// imagePURL builds the package URL of an image, which identifies it by
+// digest when one is known.
+func imagePURL(named reference.Named, tag string, digest string) string {
+	path := reference.Path(named)
+	name := path[strings.LastIndex(path, "/")+1:]
+
+	version := ""
+	if digest != "" {
+		version = "@" + url.PathEscape(digest)
+	}
+
+	query := url.Values{}
+	query.Set("repository_url", reference.Domain(named)+"/"+path)
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+
+	return "pkg:oci/" + name + version + "?" + query.Encode()
+}
+
+// version is the release tag orsted was built as, set by make with
+// -ldflags -X.
+var version string
+
+func orstedVersion() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+
+	return info.Main.Version
+}
+
+// WriteCycloneDX writes the components as a CycloneDX 1.5 JSON document.
+func WriteCycloneDX(w io.Writer, components []SBOMComponent) error {
+	type property struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	type component struct {
+		Type       string     `json:"type"`
+		Name       string     `json:"name"`
+		Version    string     `json:"version,omitempty"`
+		PURL       string     `json:"purl"`
+		BOMRef     string     `json:"bom-ref"`
+		Properties []property `json:"properties,omitempty"`
+	}
+
+	bom := map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + uuid.NewString(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"component": map[string]string{
+				"type":    "application",
+				"name":    "orsted",
+				"version": orstedVersion(),
+			},
+		},
+	}
+
+	list := []component{}
+	for _, c := range components {
+		entry := component{
+			Type:    c.Type,
+			Name:    c.Name,
+			Version: c.Version,
+			PURL:    c.PURL,
+			BOMRef:  c.PURL,
+		}
+		if c.Release != "" {
+			entry.Properties = []property{{"orsted:release", c.Release}}
+		}
+
+		list = append(list, entry)
+	}
+	bom["components"] = list
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bom)
+}
+
+// WriteSPDX writes the components as an SPDX 2.3 JSON document.
+func WriteSPDX(w io.Writer, components []SBOMComponent) error {
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+
+	type pkg struct {
+		SPDXID           string        `json:"SPDXID"`
+		Name             string        `json:"name"`
+		VersionInfo      string        `json:"versionInfo,omitempty"`
+		DownloadLocation string        `json:"downloadLocation"`
+		PrimaryPurpose   string        `json:"primaryPackagePurpose"`
+		Comment          string        `json:"comment,omitempty"`
+		ExternalRefs     []externalRef `json:"externalRefs"`
+	}
+
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+
+	packages := []pkg{{
+		SPDXID:           "SPDXRef-orsted",
+		Name:             "orsted",
+		VersionInfo:      orstedVersion(),
+		DownloadLocation: "NOASSERTION",
+		PrimaryPurpose:   "APPLICATION",
+		ExternalRefs:     []externalRef{},
+	}}
+	relationships := []relationship{{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-orsted"}}
+
+	for i, c := range components {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i)
+
+		entry := pkg{
+			SPDXID:           id,
+			Name:             c.Name,
+			VersionInfo:      c.Version,
+			DownloadLocation: "NOASSERTION",
+			PrimaryPurpose:   "LIBRARY",
+			ExternalRefs:     []externalRef{{"PACKAGE-MANAGER", "purl", c.PURL}},
+		}
+		if c.Type == "container" {
+			entry.PrimaryPurpose = "CONTAINER"
+			entry.Comment = "Deployed by the " + c.Release + " release"
+		}
+
+		packages = append(packages, entry)
+		relationships = append(relationships, relationship{"SPDXRef-orsted", "DEPENDS_ON", id})
+	}
+
+	doc := map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              "orsted-" + orstedVersion(),
+		"documentNamespace": "https://spdx.org/spdxdocs/orsted-" + uuid.NewString(),
+		"creationInfo": map[string]interface{}{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: orsted-" + orstedVersion()},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+)
+
+// forRelease merges the entry of the release name over the entry "*".
+func (c SchedulingConfig) forRelease(name string) WorkloadConfig {
+	merged := WorkloadConfig{
+		NodeSelector: map[string]string{},
+		Labels:       map[string]string{},
+		Annotations:  map[string]string{},
+	}
+
+	for _, key := range []string{"*", name} {
+		conf, ok := c[key]
+		if !ok {
+			continue
+		}
+
+		merged.Tolerations = append(merged.Tolerations, conf.Tolerations...)
+		if conf.PriorityClassName != "" {
+			merged.PriorityClassName = conf.PriorityClassName
+		}
+		for k, v := range conf.NodeSelector {
+			merged.NodeSelector[k] = v
+		}
+		for k, v := range conf.Labels {
+			merged.Labels[k] = v
+		}
+		for k, v := range conf.Annotations {
+			merged.Annotations[k] = v
+		}
+	}
+
+	return merged
+}
+
+func (w WorkloadConfig) empty() bool {
+	return len(w.Tolerations) == 0 && len(w.NodeSelector) == 0 && w.PriorityClassName == "" &&
+		len(w.Labels) == 0 && len(w.Annotations) == 0
+}
+
+// WorkloadInjector is a Helm post-renderer setting the scheduling and
+// metadata of a WorkloadConfig on the workloads of a release and the pods
+// they create.
+type WorkloadInjector struct {
+	conf WorkloadConfig
+}
+
+func (w WorkloadInjector) Run(rendered *bytes.Buffer) (*bytes.Buffer, error) {
+	return mapManifests(rendered.String(), func(obj map[string]interface{}) error {
+		injectWorkload(obj, w.conf)
+		return nil
+	})
+}
+
+// injectWorkload sets conf on obj when it is a pod or creates pods,
+// leaving other objects alone.
+func injectWorkload(obj map[string]interface{}, conf WorkloadConfig) {
+	kind, _ := obj["kind"].(string)
+	spec, _ := obj["spec"].(map[string]interface{})
+
+	var template map[string]interface{}
+	switch kind {
+	case "Pod":
+		template = obj
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		template, _ = spec["template"].(map[string]interface{})
+	case "CronJob":
+		jobTemplate, _ := spec["jobTemplate"].(map[string]interface{})
+		jobSpec, _ := jobTemplate["spec"].(map[string]interface{})
+		template, _ = jobSpec["template"].(map[string]interface{})
+	}
+	if template == nil {
+		return
+	}
+
+	annotateObject(obj, conf.Labels, conf.Annotations)
+	if kind != "Pod" {
+		annotateObject(template, conf.Labels, conf.Annotations)
+	}
+
+	podSpec, ok := template["spec"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if len(conf.NodeSelector) > 0 {
+		selector, ok := podSpec["nodeSelector"].(map[string]interface{})
+		if !ok {
+			selector = map[string]interface{}{}
+			podSpec["nodeSelector"] = selector
+		}
+		for k, v := range conf.NodeSelector {
+			selector[k] = v
+		}
+	}
+
+	if conf.PriorityClassName != "" {
+		podSpec["priorityClassName"] = conf.PriorityClassName
+		// The admission plugin resolving the class rejects pods whose
+		// priority doesn't match it.
+		delete(podSpec, "priority")
+	}
+
+	tolerations, _ := podSpec["tolerations"].([]interface{})
+	for _, toleration := range conf.Tolerations {
+		if !hasToleration(tolerations, toleration.object()) {
+			tolerations = append(tolerations, toleration.object())
+		}
+	}
+	if len(tolerations) > 0 {
+		podSpec["tolerations"] = tolerations
+	}
+}
+
+// annotateObject merges labels and annotations into the metadata of a
+// decoded object or pod template.
+func annotateObject(obj map[string]interface{}, labels map[string]string, annotations map[string]string) {
+	if len(labels) > 0 {
+		labelObject(obj, labels)
+	}
+	if len(annotations) == 0 {
+		return
+	}
+
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		obj["metadata"] = metadata
+	}
+
+	existing, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		existing = map[string]interface{}{}
+		metadata["annotations"] = existing
+	}
+	for k, v := range annotations {
+		existing[k] = v
+	}
+}
+
+// object is the toleration as it appears in a pod spec.
+func (t Toleration) object() map[string]interface{} {
+	operator := t.Operator
+	if operator == "" && t.Value == "" {
+		operator = "Exists"
+	}
+
+	toleration := map[string]interface{}{}
+	for k, v := range map[string]string{"key": t.Key, "operator": operator, "value": t.Value, "effect": t.Effect} {
+		if v != "" {
+			toleration[k] = v
+		}
+	}
+
+	return toleration
+}
+
+func hasToleration(tolerations []interface{}, toleration map[string]interface{}) bool {
+	for _, existing := range tolerations {
+		existing, _ := existing.(map[string]interface{})
+		if len(existing) != len(toleration) {
+			continue
+		}
+
+		same := true
+		for k, v := range toleration {
+			if existing[k] != v {
+				same = false
+				break
+			}
+		}
+		if same {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SchemaID is where the published schema of the config lives, editors
+// pick it up from a `# yaml-language-server: $schema=` comment.
+const SchemaID = "https://git.jessnuko.bid/Jess4Tech/orsted/raw/branch/main/orsted.schema.json"
+
+// configField is a key of a config section, with the fields of embedded
+// structs like AddonConfig promoted as encoding/json does.
+type configField struct {
+	Name string
+	Type reflect.Type
+}
+
+func configFields(t reflect.Type) []configField {
+	var fields []configField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			fields = append(fields, configFields(field.Type)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields = append(fields, configField{name, field.Type})
+	}
+
+	return fields
+}
+
+// ConfigSchema returns the JSON schema of the config file, derived from
+// the Config struct so it can't drift from what LoadConfig accepts.
+func ConfigSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = SchemaID
+	schema["title"] = "orsted config"
+
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for _, field := range configFields(t) {
+			properties[field.Name] = typeSchema(field.Type)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+
+	// interface{} values like Dex connector configs are passed on as is.
+	return map[string]interface{}{}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Secret is a config value, like a password or token, that is masked
+// whenever it is printed or marshaled. Reveal returns the actual value
+// where it has to be handed on.
+type Secret string
+
+const redacted = "<redacted>"
+
+var (
+	secretsMu    sync.Mutex
+	knownSecrets = map[string]bool{}
+)
+
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*s = Secret(value)
+	registerSecret(value)
+	return nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// registerSecret makes Redact mask a value wherever it shows up, e.g. in
+// command output that echoes rendered values. Very short values would mask
+// unrelated text and are left alone.
+func registerSecret(value string) {
+	if len(value) < 4 {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	knownSecrets[value] = true
+}
+
+// Redact masks every secret loaded from the config in text.
+func Redact(text string) string {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	for value := range knownSecrets {
+		text = strings.ReplaceAll(text, value, redacted)
+	}
+
+	return text
+}
+
+// redactWriter masks secrets in everything written through it.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// generatePassword returns a random URL safe password.
+func generatePassword() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// ensureSecret creates a secret, and its namespace, unless the secret
+// already exists. It reports whether the secret was created.
+func ensureSecret(ctx context.Context, ns string, name string, data map[string]string) (bool, error) {
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = k8sClient.CoreV1().Namespaces().Create(ctx, &core.Namespace{
+		ObjectMeta: meta.ObjectMeta{Name: ns},
+	}, meta.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return false, err
+	}
+
+	_, err = k8sClient.CoreV1().Secrets(ns).Create(ctx, &core.Secret{
+		ObjectMeta: meta.ObjectMeta{Name: name, Namespace: ns},
+		StringData: data,
+	}, meta.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
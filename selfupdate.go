@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// ReleasesURL lists orsted's releases through the Gitea API.
+const ReleasesURL = "https://git.jessnuko.bid/api/v1/repos/Jess4Tech/orsted/releases"
+
+// releasePublicKey is the base64 ed25519 key release binaries are signed
+// with, set at build time with -ldflags -X. Without it self-update refuses
+// to run unless told to skip the signature. The signature covers the tag of the release, a line of its
+// own, followed by the binary, so an old signed binary can't be passed off
+// as a newer release.
+var releasePublicKey string
+
+// The assets `make release` uploads.
+const (
+	releaseBinary    = "orsted.gz"
+	releaseChecksum  = "orsted.gz.sha256"
+	releaseSignature = "orsted.gz.sig"
+)
+
+type giteaRelease struct {
+	TagName    string `json:"tag_name"`
+	Prerelease bool   `json:"prerelease"`
+	Draft      bool   `json:"draft"`
+	Assets     []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// UpdateOptions are what SelfUpdate is allowed beyond installing a newer
+// signed release.
+type UpdateOptions struct {
+	// Force installs a release older than the running version.
+	Force bool
+	// InsecureSkipSignature installs a release without verifying its
+	// signature when orsted was built without a release public key. The
+	// checksum comes from the same release, it proves nothing.
+	InsecureSkipSignature bool
+}
+
+// SelfUpdate replaces the running binary with the newest release of the
+// channel: stable only takes full releases, edge prereleases as well.
+func SelfUpdate(releasesURL string, channel string, opts UpdateOptions) error {
+	if channel != "stable" && channel != "edge" {
+		return fmt.Errorf("unknown channel %q, expected stable or edge", channel)
+	}
+	if releasePublicKey == "" && !opts.InsecureSkipSignature {
+		return errors.New("orsted was built without a release public key and can't verify releases, pass --insecure-skip-signature to update anyway")
+	}
+
+	var releases []giteaRelease
+	if err := fetchJSON(releasesURL, &releases); err != nil {
+		return fmt.Errorf("listing releases: %w", err)
+	}
+
+	// Gitea lists the newest release first.
+	var release *giteaRelease
+	for i := range releases {
+		if releases[i].Draft || (releases[i].Prerelease && channel == "stable") {
+			continue
+		}
+		release = &releases[i]
+		break
+	}
+	if release == nil {
+		return fmt.Errorf("no %s release found", channel)
+	}
+
+	latest, err := semver.NewVersion(release.TagName)
+	if err != nil {
+		return fmt.Errorf("release %s: %w", release.TagName, err)
+	}
+	// Development builds have no version to compare with.
+	if current, err := semver.NewVersion(orstedVersion()); err == nil {
+		switch {
+		case latest.Equal(current):
+			log.Printf("orsted %s is the latest %s release\n", release.TagName, channel)
+			return nil
+		case latest.LessThan(current) && !opts.Force:
+			return fmt.Errorf("the latest %s release %s is older than orsted %s, pass --force to downgrade", channel, release.TagName, orstedVersion())
+		}
+	}
+
+	assets := map[string][]byte{}
+	for _, asset := range release.Assets {
+		if asset.Name != releaseBinary && asset.Name != releaseChecksum && asset.Name != releaseSignature {
+			continue
+		}
+
+		data, err := fetch(asset.URL)
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", asset.Name, err)
+		}
+		assets[asset.Name] = data
+	}
+
+	binary, ok := assets[releaseBinary]
+	if !ok {
+		return fmt.Errorf("release %s has no %s", release.TagName, releaseBinary)
+	}
+
+	checksum, ok := assets[releaseChecksum]
+	if !ok {
+		return fmt.Errorf("release %s has no %s", release.TagName, releaseChecksum)
+	}
+	sum := sha256.Sum256(binary)
+	if fields := strings.Fields(string(checksum)); len(fields) == 0 || fields[0] != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("%s does not match its checksum", releaseBinary)
+	}
+
+	if releasePublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return errors.New("the release public key built into orsted is invalid")
+		}
+
+		signature, ok := assets[releaseSignature]
+		if !ok {
+			return fmt.Errorf("release %s is not signed", release.TagName)
+		}
+		signed := append([]byte(release.TagName+"\n"), binary...)
+		if !ed25519.Verify(key, signed, signature) {
+			return fmt.Errorf("%s has an invalid signature", releaseBinary)
+		}
+	} else {
+		log.Println("Skipping the signature check, orsted was built without a release public key")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(binary))
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return err
+	}
+
+	// Renaming within the directory swaps the binary atomically, even while
+	// it is running.
+	tmp, err := os.CreateTemp(filepath.Dir(executable), ".orsted-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, gz); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), executable); err != nil {
+		return err
+	}
+
+	log.Printf("Updated orsted from %s to %s\n", orstedVersion(), release.TagName)
+	return nil
+}
+
+func fetch(url string) ([]byte, error) {
+	client := http.Client{Timeout: time.Minute * 5}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func fetchJSON(url string, out interface{}) error {
+	data, err := fetch(url)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
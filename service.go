@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FirstBootUnitPath is the unit bootstrapping the machine on boot, until a
+// bootstrap succeeded.
+const FirstBootUnitPath = "/etc/systemd/system/orsted-firstboot.service"
+
+// BootstrappedMarker is created once a bootstrap succeeded, keeping the
+// first boot unit, which resets half initialized clusters, from running on
+// later boots.
+const BootstrappedMarker = "/var/lib/orsted/bootstrapped"
+
+// A failed attempt leaves a half initialized cluster behind, which kubeadm
+// refuses to init over, so it is reset before the next attempt. Failures
+// of the config and of preflight checks aren't transient and aren't
+// retried until the next boot.
+const firstBootUnit = `[Unit]
+Description=Bootstrap Kubernetes with orsted
+Wants=network-online.target
+After=network-online.target time-sync.target
+ConditionPathExists=!%[1]s
+StartLimitIntervalSec=%[2]d
+StartLimitBurst=%[3]d
+%[4]s
+[Service]
+Type=oneshot
+ExecStartPre=/bin/sh -c 'if [ -e %[5]s ]; then kubeadm reset --force; fi'
+ExecStart="%[6]s" --config "%[7]s"
+ExecStartPost=/usr/bin/touch %[1]s
+Restart=on-failure
+RestartSec=%[8]d
+RestartPreventExitStatus=%[9]d %[10]d
+TimeoutStartSec=%[11]d
+SyslogIdentifier=orsted-firstboot
+StandardOutput=journal
+StandardError=journal
+PrivateTmp=yes
+ProtectHome=read-only
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// ServiceOptions is how the first boot unit retries failed bootstraps.
+type ServiceOptions struct {
+	// Retries is how many times a failed bootstrap is retried before the
+	// unit gives up until the next boot.
+	Retries    int
+	RetryDelay time.Duration
+	// Timeout fails a bootstrap that hangs, so it is retried.
+	Timeout time.Duration
+	// OnFailure is a unit started once the retries are used up.
+	OnFailure string
+}
+
+// DefaultServiceOptions are used when installing the unit from bake.
+var DefaultServiceOptions = ServiceOptions{Retries: 3, RetryDelay: time.Minute, Timeout: time.Hour * 2}
+
+// InstallService writes and enables the unit bootstrapping with the config
+// at configPath on boot.
+func InstallService(ctx context.Context, configPath string, opts ServiceOptions) error {
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding the orsted binary: %w", err)
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+
+	// A cluster bootstrapped before the unit existed, or by hand, would be
+	// taken for a failed attempt and reset on the next boot.
+	if _, err := os.Stat(AdminKubeconfigPath); err == nil {
+		if _, err := os.Stat(BootstrappedMarker); errors.Is(err, os.ErrNotExist) {
+			log.Printf("%s exists, marking the host as bootstrapped so the unit leaves the cluster alone\n", AdminKubeconfigPath)
+			if err := markBootstrapped(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeFile(FirstBootUnitPath, firstBootUnitFor(binary, configPath, opts)); err != nil {
+		return err
+	}
+
+	log.Printf("Enabling %s\n", filepath.Base(FirstBootUnitPath))
+	if err := runHostCommand(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+
+	return runHostCommand(ctx, "systemctl", "enable", filepath.Base(FirstBootUnitPath))
+}
+
+// markBootstrapped creates BootstrappedMarker.
+func markBootstrapped() error {
+	return writeFile(BootstrappedMarker, time.Now().UTC().Format(time.RFC3339)+"\n")
+}
+
+func firstBootUnitFor(binary string, configPath string, opts ServiceOptions) string {
+	onFailure := ""
+	if opts.OnFailure != "" {
+		onFailure = "OnFailure=" + opts.OnFailure + "\n"
+	}
+
+	// Each attempt may take up to the timeout and the delay, the burst
+	// allows the first attempt and the retries within that interval.
+	interval := time.Duration(opts.Retries+1) * (opts.Timeout + opts.RetryDelay)
+
+	return fmt.Sprintf(firstBootUnit,
+		BootstrappedMarker,
+		int(interval.Seconds()),
+		opts.Retries+1,
+		onFailure,
+		AdminKubeconfigPath,
+		binary,
+		configPath,
+		int(opts.RetryDelay.Seconds()),
+		ExitConfig,
+		ExitPreflight,
+		int(opts.Timeout.Seconds()),
+	)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+var versionPattern = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+// appCompat are the Kubernetes minors each release line of an app is
+// supported on upstream, for charts whose kubeVersion doesn't say.
+var appCompat = map[string]map[string][2]uint64{
+	"cilium": {
+		"1.14": {16, 27},
+		"1.15": {16, 29},
+		"1.16": {16, 30},
+	},
+	"kyverno": {
+		"1.10": {24, 26},
+		"1.11": {25, 28},
+		"1.12": {26, 29},
+		"1.13": {28, 31},
+	},
+}
+
+// ciliumMinKernel is the oldest kernel each Cilium release line runs on.
+var ciliumMinKernel = map[string]string{
+	"1.14": "4.19.57",
+	"1.15": "4.19.57",
+	"1.16": "5.4.0",
+}
+
+type skewCheck struct {
+	Component string
+	Found     string
+	Requires  string
+	OK        bool
+}
+
+// CheckVersionSkew verifies kubeadm is the configured Kubernetes version and
+// that it, the kubelet and CRI-O on the host and the pinned charts all
+// support each other, logging the matrix of checks.
+// server is the version of the running cluster when upgrading, empty
+// before init.
+func CheckVersionSkew(ctx context.Context, conf *Config, server string) error {
+	kubeadm, err := commandVersion(ctx, "kubeadm", "version", "-o", "short")
+	if err != nil {
+		return err
+	}
+
+	kubelet, err := commandVersion(ctx, "kubelet", "--version")
+	if err != nil {
+		return err
+	}
+
+	crio, err := commandVersion(ctx, "crio", "--version")
+	if err != nil {
+		return err
+	}
+
+	osRelease, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return err
+	}
+	kernel, err := parseVersion(string(osRelease))
+	if err != nil {
+		return err
+	}
+
+	minor := func(v *semver.Version) string {
+		return fmt.Sprintf("%d.%d", v.Major(), v.Minor())
+	}
+
+	checks := []skewCheck{
+		{
+			Component: "kubelet",
+			Found:     kubelet.String(),
+			Requires:  fmt.Sprintf("%d.%d or %s (kubeadm)", kubeadm.Major(), kubeadm.Minor()-1, minor(kubeadm)),
+			OK:        kubelet.Major() == kubeadm.Major() && kubelet.Minor() <= kubeadm.Minor() && kubelet.Minor()+1 >= kubeadm.Minor(),
+		},
+		{
+			Component: "cri-o",
+			Found:     crio.String(),
+			Requires:  minor(kubeadm) + " (kubeadm)",
+			OK:        minor(crio) == minor(kubeadm),
+		},
+	}
+
+	if conf.Kubernetes.Version != "" {
+		checks = append(checks, skewCheck{
+			Component: "kubeadm",
+			Found:     kubeadm.String(),
+			Requires:  conf.Kubernetes.Version + " (config)",
+			OK:        kubernetesVersionMatches(conf, kubeadm),
+		})
+	}
+
+	if server != "" {
+		current, err := parseVersion(server)
+		if err != nil {
+			return err
+		}
+
+		checks = append(checks, skewCheck{
+			Component: "kubeadm",
+			Found:     kubeadm.String(),
+			Requires:  fmt.Sprintf("%s or %d.%d (cluster)", minor(current), current.Major(), current.Minor()+1),
+			OK:        kubeadm.Major() == current.Major() && kubeadm.Minor() >= current.Minor() && kubeadm.Minor() <= current.Minor()+1,
+		})
+	}
+
+	defaultIp, err := primaryNodeIP(conf)
+	if err != nil {
+		return err
+	}
+
+	releases, err := Releases(conf, defaultIp)
+	if err != nil {
+		return err
+	}
+
+	for _, release := range releases {
+		spec := release.Spec
+
+		client, err := helmClientForNs(spec.Namespace)
+		if err != nil {
+			return err
+		}
+
+		if err := addChartRepo(conf, client, release.Repo); err != nil {
+			return err
+		}
+
+		version := spec.Version
+		if version == "" {
+			version = ">0.0.0-0"
+		}
+
+		chart, _, err := client.GetChart(spec.ChartName, &action.ChartPathOptions{Version: version})
+		if err != nil {
+			return fmt.Errorf("fetching %s chart: %w", spec.ReleaseName, err)
+		}
+
+		component := spec.ReleaseName + " " + chart.Metadata.Version
+		if constraint := chart.Metadata.KubeVersion; constraint != "" {
+			checks = append(checks, skewCheck{
+				Component: component,
+				Found:     kubeadm.String(),
+				Requires:  "kubernetes " + constraint + " (chart)",
+				OK:        chartutil.IsCompatibleRange(constraint, kubeadm.String()),
+			})
+		}
+
+		app, err := parseVersion(chart.Metadata.AppVersion)
+		if err != nil {
+			continue
+		}
+
+		if supported, ok := appCompat[spec.ReleaseName][minor(app)]; ok {
+			checks = append(checks, skewCheck{
+				Component: component,
+				Found:     kubeadm.String(),
+				Requires:  fmt.Sprintf("kubernetes 1.%d to 1.%d", supported[0], supported[1]),
+				OK:        kubeadm.Major() == 1 && kubeadm.Minor() >= supported[0] && kubeadm.Minor() <= supported[1],
+			})
+		}
+
+		if required, ok := ciliumMinKernel[minor(app)]; ok && spec.ReleaseName == "cilium" {
+			checks = append(checks, skewCheck{
+				Component: component,
+				Found:     "kernel " + kernel.String(),
+				Requires:  "kernel >= " + required,
+				OK:        !kernel.LessThan(semver.MustParse(required)),
+			})
+		}
+	}
+
+	var matrix strings.Builder
+	table := tabwriter.NewWriter(&matrix, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "COMPONENT\tFOUND\tREQUIRES\tSTATUS")
+
+	failed := 0
+	for _, check := range checks {
+		status := "ok"
+		if !check.OK {
+			status = "INCOMPATIBLE"
+			failed++
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", check.Component, check.Found, check.Requires, status)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Version compatibility:\n%s", matrix.String())
+	if failed > 0 {
+		return fmt.Errorf("%d incompatible component versions", failed)
+	}
+
+	return nil
+}
+
+// commandVersion runs a version command and parses the first version in its
+// output.
+func commandVersion(ctx context.Context, command string, args ...string) (*semver.Version, error) {
+	out, err := RunCommand(ctx, command, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s version: %w: %s", command, err, out)
+	}
+
+	version, err := parseVersion(out)
+	if err != nil {
+		return nil, fmt.Errorf("%s version: %w", command, err)
+	}
+
+	return version, nil
+}
+
+// parseVersion picks the major.minor.patch out of strings like
+// "Kubernetes v1.28.2" or a kernel release, dropping any suffix.
+func parseVersion(s string) (*semver.Version, error) {
+	match := versionPattern.FindString(s)
+	if match == "" {
+		return nil, fmt.Errorf("no version in %q", strings.TrimSpace(s))
+	}
+
+	return semver.NewVersion(match)
+}
+
+// pinnedPatch is the patch version without the v when version pins one,
+// empty when it is only a minor.
+func pinnedPatch(version string) string {
+	version = strings.TrimPrefix(version, "v")
+	if strings.Count(version, ".") != 2 {
+		return ""
+	}
+
+	return version
+}
+
+// kubernetesVersionMatches reports whether an installed version is the
+// configured one: the same minor, and the same patch when one is pinned.
+// Any version matches when none is configured.
+func kubernetesVersionMatches(conf *Config, installed *semver.Version) bool {
+	if conf.Kubernetes.Version == "" {
+		return true
+	}
+
+	want, err := parseVersion(conf.Kubernetes.Version)
+	if err != nil {
+		return false
+	}
+
+	if installed.Major() != want.Major() || installed.Minor() != want.Minor() {
+		return false
+	}
+
+	return pinnedPatch(conf.Kubernetes.Version) == "" || installed.Patch() == want.Patch()
+}
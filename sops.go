@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ageKeyPath is set by the --age-key flag.
+var ageKeyPath string
+
+// DefaultAgeKeyPath is used when neither the flag, the environment nor a
+// systemd credential provide an age key.
+const DefaultAgeKeyPath = "/etc/orsted/age.key"
+
+// configureAgeKey points sops at the age key used to decrypt the config and
+// values files. Keys already exported in SOPS_AGE_KEY or SOPS_AGE_KEY_FILE
+// win over a systemd credential named age-key and the default path.
+func configureAgeKey() {
+	if ageKeyPath != "" {
+		os.Setenv("SOPS_AGE_KEY_FILE", ageKeyPath)
+		return
+	}
+
+	if os.Getenv("SOPS_AGE_KEY") != "" || os.Getenv("SOPS_AGE_KEY_FILE") != "" {
+		return
+	}
+
+	candidates := []string{DefaultAgeKeyPath}
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		candidates = append([]string{filepath.Join(dir, "age-key")}, candidates...)
+	}
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			os.Setenv("SOPS_AGE_KEY_FILE", path)
+			return
+		}
+	}
+}
+
+// isSOPSEncrypted reports whether a YAML or JSON document carries sops
+// metadata.
+func isSOPSEncrypted(data []byte) bool {
+	var doc struct {
+		SOPS map[string]interface{} `json:"sops"`
+	}
+
+	return yaml.Unmarshal(data, &doc) == nil && doc.SOPS["mac"] != nil
+}
+
+// decryptSOPS decrypts a file with the sops binary into the given output
+// type, yaml or json.
+func decryptSOPS(path string, outputType string) ([]byte, error) {
+	// Warnings sops prints would end up in the decrypted document if they
+	// shared its output, as they do through RunCommand.
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(context.Background(), "sops", "--decrypt", "--output-type", outputType, path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// readConfigFile reads a YAML file that may be SOPS encrypted.
+func readConfigFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !isSOPSEncrypted(data) {
+		return data, err
+	}
+
+	return decryptSOPS(path, "yaml")
+}
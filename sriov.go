@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//go:embed manifests/sriov.yaml
+var SRIOVManifest string
+
+// sriovResourcePrefix is the extended resource prefix the device plugin
+// advertises VFs under, e.g. orsted.io/fast_net.
+const sriovResourcePrefix = "orsted.io"
+
+const sriovScriptPath = "/usr/local/sbin/orsted-sriov"
+
+// The VFs are recreated by a unit on every boot, before the kubelet starts
+// handing them out to pods.
+const sriovUnit = `[Unit]
+Description=Create SR-IOV virtual functions
+Before=kubelet.service
+After=network-pre.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=` + sriovScriptPath + `
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// sriovCreateVFs only changes the VF count when it differs, since doing so
+// tears down VFs that pods might still be using.
+const sriovCreateVFs = `dev=/sys/class/net/%[1]s/device
+if [ "$(cat $dev/sriov_numvfs)" != "%[2]d" ]; then
+  echo 0 > $dev/sriov_numvfs
+  echo %[2]d > $dev/sriov_numvfs
+fi
+`
+
+const sriovBindVFIO = `for vf in $dev/virtfn*; do
+  addr=$(basename "$(readlink -f "$vf")")
+  echo vfio-pci > /sys/bus/pci/devices/$addr/driver_override
+  if [ -e /sys/bus/pci/devices/$addr/driver ]; then
+    [ "$(basename "$(readlink -f /sys/bus/pci/devices/$addr/driver)")" = vfio-pci ] && continue
+    echo $addr > /sys/bus/pci/devices/$addr/driver/unbind
+  fi
+  echo $addr > /sys/bus/pci/drivers_probe
+done
+`
+
+func (i SRIOVInterface) resourceName() string {
+	if i.ResourceName != "" {
+		return i.ResourceName
+	}
+
+	return strings.NewReplacer("-", "_", ".", "_").Replace(i.Name)
+}
+
+// sriovPreflight checks the host can provide the configured VFs: the IOMMU
+// has to be on, every interface has to support SR-IOV with enough VFs, and
+// the vfio-pci driver has to load when VFs are handed out for userspace.
+func sriovPreflight(ctx context.Context, conf *Config) error {
+	groups, err := os.ReadDir("/sys/kernel/iommu_groups")
+	if err != nil || len(groups) == 0 {
+		return fmt.Errorf("IOMMU is disabled, boot with intel_iommu=on or amd_iommu=on and iommu=pt")
+	}
+
+	vfio := false
+	for _, iface := range conf.Addons.SRIOV.Interfaces {
+		switch iface.DeviceType {
+		case "", "netdevice":
+		case "vfio-pci":
+			vfio = true
+		default:
+			return fmt.Errorf("unknown device type %q for %s", iface.DeviceType, iface.Name)
+		}
+
+		data, err := os.ReadFile("/sys/class/net/" + iface.Name + "/device/sriov_totalvfs")
+		if err != nil {
+			return fmt.Errorf("%s does not support SR-IOV: %w", iface.Name, err)
+		}
+
+		total, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return err
+		}
+
+		if iface.NumVFs < 1 || iface.NumVFs > total {
+			return fmt.Errorf("%s supports 1 to %d VFs, %d configured", iface.Name, total, iface.NumVFs)
+		}
+	}
+
+	if vfio {
+		if out, err := RunCommand(ctx, "modprobe", "vfio-pci"); err != nil {
+			return fmt.Errorf("loading vfio-pci: %w: %s", err, out)
+		}
+	}
+
+	return nil
+}
+
+// prepareSRIOV creates the configured VFs on the node and keeps them
+// across reboots.
+func prepareSRIOV(ctx context.Context, conf *Config) error {
+	if err := sriovPreflight(ctx, conf); err != nil {
+		return err
+	}
+
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\nset -e\n")
+
+	vfio := false
+	for _, iface := range conf.Addons.SRIOV.Interfaces {
+		fmt.Fprintf(&script, sriovCreateVFs, iface.Name, iface.NumVFs)
+		if iface.DeviceType == "vfio-pci" {
+			vfio = true
+			script.WriteString(sriovBindVFIO)
+		}
+	}
+
+	if vfio {
+		if err := writeFile("/etc/modules-load.d/vfio-pci.conf", "vfio-pci\n"); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFile(sriovScriptPath, script.String()); err != nil {
+		return err
+	}
+	if err := os.Chmod(sriovScriptPath, 0755); err != nil {
+		return err
+	}
+	if err := writeFile("/etc/systemd/system/orsted-sriov.service", sriovUnit); err != nil {
+		return err
+	}
+
+	if out, err := RunCommand(ctx, "systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	// Restarting applies config changes, the script leaves VFs of the right
+	// count alone.
+	if out, err := RunCommand(ctx, "systemctl", "enable", "orsted-sriov.service"); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+	if out, err := RunCommand(ctx, "systemctl", "restart", "orsted-sriov.service"); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	return nil
+}
+
+// sriovManifests renders the device plugin, with a resource pool per
+// interface, the SR-IOV CNI and the networks attached to each pool.
+func sriovManifests(conf *Config) (string, error) {
+	sriov := conf.Addons.SRIOV
+
+	type selectors struct {
+		PFNames []string `json:"pfNames"`
+		Drivers []string `json:"drivers,omitempty"`
+	}
+
+	type resource struct {
+		ResourceName   string    `json:"resourceName"`
+		ResourcePrefix string    `json:"resourcePrefix"`
+		Selectors      selectors `json:"selectors"`
+	}
+
+	resources := []resource{}
+	var objects []object
+	for _, iface := range sriov.Interfaces {
+		r := resource{
+			ResourceName:   iface.resourceName(),
+			ResourcePrefix: sriovResourcePrefix,
+			Selectors:      selectors{PFNames: []string{iface.Name}},
+		}
+		if iface.DeviceType == "vfio-pci" {
+			r.Selectors.Drivers = []string{"vfio-pci"}
+		}
+		resources = append(resources, r)
+
+		annotations := map[string]string{
+			"k8s.v1.cni.cncf.io/resourceName": sriovResourcePrefix + "/" + r.ResourceName,
+		}
+		for _, network := range iface.Networks {
+			nad, err := networkAttachmentObject(network, map[string]interface{}{"type": "sriov"}, annotations)
+			if err != nil {
+				return "", err
+			}
+
+			objects = append(objects, nad)
+		}
+	}
+
+	config, err := json.Marshal(map[string]interface{}{"resourceList": resources})
+	if err != nil {
+		return "", err
+	}
+
+	manifest, err := renderValues("sriov", SRIOVManifest, struct {
+		Version    string
+		CNIVersion string
+		Config     string
+	}{sriov.Version, sriov.CNIVersion, string(config)})
+	if err != nil {
+		return "", err
+	}
+
+	networks, err := renderObjects(objects)
+	if err != nil {
+		return "", err
+	}
+
+	return manifest + networks, nil
+}
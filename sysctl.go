@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const sysctlPath = "/etc/sysctl.d/90-orsted.conf"
+
+// sysctlProfiles are applied on top of the default profile. The default
+// covers what every node needs: inotify for the many watchers kubelet,
+// Cilium and operators set up, forwarding, bridged traffic passing
+// iptables and loose reverse path filtering for Cilium's routing, and map
+// counts for Ceph and search workloads.
+var sysctlProfiles = map[string]map[string]string{
+	"default": {
+		"fs.inotify.max_user_watches":        "524288",
+		"fs.inotify.max_user_instances":      "8192",
+		"fs.file-max":                        "2097152",
+		"net.ipv4.ip_forward":                "1",
+		"net.ipv4.conf.all.rp_filter":        "0",
+		"net.ipv4.conf.default.rp_filter":    "0",
+		"net.bridge.bridge-nf-call-iptables": "1",
+		"net.netfilter.nf_conntrack_max":     "262144",
+		"vm.max_map_count":                   "262144",
+	},
+	// Ceph OSDs want lots of threads, async IO and free memory to avoid
+	// stalls under writeback pressure.
+	"storage-heavy": {
+		"kernel.pid_max":            "4194303",
+		"fs.aio-max-nr":             "1048576",
+		"vm.swappiness":             "10",
+		"vm.dirty_ratio":            "10",
+		"vm.dirty_background_ratio": "5",
+		"vm.min_free_kbytes":        "262144",
+	},
+	// Many connections through Cilium's datapath and large socket buffers
+	// for high throughput links.
+	"network-heavy": {
+		"net.core.somaxconn":                "32768",
+		"net.core.netdev_max_backlog":       "16384",
+		"net.core.rmem_max":                 "16777216",
+		"net.core.wmem_max":                 "16777216",
+		"net.ipv4.tcp_rmem":                 "4096 87380 16777216",
+		"net.ipv4.tcp_wmem":                 "4096 65536 16777216",
+		"net.ipv4.ip_local_port_range":      "1024 65535",
+		"net.netfilter.nf_conntrack_max":    "1048576",
+		"net.ipv4.neigh.default.gc_thresh1": "8192",
+		"net.ipv4.neigh.default.gc_thresh2": "32768",
+		"net.ipv4.neigh.default.gc_thresh3": "65536",
+	},
+}
+
+// kernelRequirements are the settings kubeadm and Cilium can't work
+// without. Cilium's datapath additionally needs the BPF JIT, which can
+// only be built into the kernel, not turned on by sysctl.
+var kernelRequirements = map[string]string{
+	"net.ipv4.ip_forward":                "1",
+	"net.ipv4.conf.all.rp_filter":        "0",
+	"net.ipv4.conf.default.rp_filter":    "0",
+	"net.bridge.bridge-nf-call-iptables": "1",
+	"net.core.bpf_jit_enable":            "1",
+}
+
+// checkKernelParams compares the running kernel's settings with
+// kernelRequirements. The bridge settings only exist with br_netfilter
+// loaded.
+func checkKernelParams(ctx context.Context, conf *Config) error {
+	filesystems, err := os.ReadFile("/proc/filesystems")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(filesystems), "\tbpf\n") {
+		return errors.New("the kernel does not support the bpf filesystem Cilium needs")
+	}
+
+	keys := make([]string, 0, len(kernelRequirements))
+	for key := range kernelRequirements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		data, err := os.ReadFile("/proc/sys/" + strings.ReplaceAll(key, ".", "/"))
+		if errors.Is(err, os.ErrNotExist) {
+			problems = append(problems, key+" is missing")
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if value := strings.TrimSpace(string(data)); value != kernelRequirements[key] {
+			problems = append(problems, fmt.Sprintf("%s is %s, needs %s", key, value, kernelRequirements[key]))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// sysctlSettings merges the default profile, the configured profile and
+// the config's own settings, later ones winning.
+func sysctlSettings(conf *Config) (map[string]string, error) {
+	profile, ok := sysctlProfiles[conf.Sysctl.Profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown sysctl profile %q", conf.Sysctl.Profile)
+	}
+
+	// Forwarding turns off router advertisements unless they are accepted
+	// explicitly, which would cost SLAAC configured nodes their address.
+	ipv6 := map[string]string{}
+	if pods, _, err := conf.Network.cidrs(); err == nil && pods.IPv6 != "" {
+		ipv6 = map[string]string{
+			"net.ipv6.conf.all.forwarding":    "1",
+			"net.ipv6.conf.all.accept_ra":     "2",
+			"net.ipv6.conf.default.accept_ra": "2",
+		}
+	}
+
+	settings := map[string]string{}
+	for _, layer := range []map[string]string{sysctlProfiles["default"], ipv6, profile, conf.Sysctl.Settings} {
+		for key, value := range layer {
+			settings[key] = value
+		}
+	}
+
+	return settings, nil
+}
+
+// ConfigureSysctl writes the node's sysctl settings and applies them.
+func ConfigureSysctl(ctx context.Context, conf *Config) error {
+	settings, err := sysctlSettings(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "sysctl", err)
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# Written by orsted, profile %s\n", conf.Sysctl.Profile)
+	for _, key := range keys {
+		fmt.Fprintf(&out, "%s = %s\n", key, settings[key])
+	}
+	if err := writeFile(sysctlPath, out.String()); err != nil {
+		return failed("sysctl", err)
+	}
+
+	// The conntrack settings only exist once the module is loaded.
+	if err := writeFile("/etc/modules-load.d/orsted.conf", "nf_conntrack\nbr_netfilter\n"); err != nil {
+		return failed("sysctl", err)
+	}
+	if modOut, err := RunCommand(ctx, "modprobe", "-a", "nf_conntrack", "br_netfilter"); err != nil {
+		return commandFailed("sysctl", modOut, fmt.Errorf("loading kernel modules: %w", err))
+	}
+
+	log.Printf("Applying sysctl profile %s\n", conf.Sysctl.Profile)
+	sysctlOut, err := RunCommand(ctx, "sysctl", "--system")
+	if err != nil {
+		return commandFailed("sysctl", sysctlOut, fmt.Errorf("applying: %w", err))
+	}
+
+	return nil
+}
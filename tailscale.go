@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+//go:embed values/tailscale-operator.yaml
+var TailscaleOperatorYaml string
+
+var tailscaleRepo = repo.Entry{
+	Name: "tailscale",
+	URL:  "https://pkgs.tailscale.com/helmcharts",
+}
+
+func tailscaleSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	tailscale := conf.Addons.Tailscale
+	if tailscale.ClientID == "" || tailscale.ClientSecret == "" {
+		return nil, errors.New("tailscale needs an OAuth clientID and clientSecret")
+	}
+
+	values, err := renderValues("tailscale-operator", TailscaleOperatorYaml, tailscale)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "tailscale-operator",
+		ChartName:       "tailscale/tailscale-operator",
+		Namespace:       "tailscale",
+		CreateNamespace: true,
+		Wait:            true,
+		Timeout:         time.Minute * 3,
+		Version:         tailscale.Version,
+		ValuesYaml:      values,
+	}, nil
+}
+
+// configureTailscale exposes the configured services on the tailnet, e.g.
+// the service Cilium creates for a Gateway.
+func configureTailscale(ctx context.Context, conf *Config) error {
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return err
+	}
+
+	for _, service := range conf.Addons.Tailscale.Expose {
+		ns, name, ok := strings.Cut(service, "/")
+		if !ok {
+			return fmt.Errorf("tailscale expose %q is not namespace/name", service)
+		}
+
+		log.Printf("Exposing %s on the tailnet\n", service)
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{"tailscale.com/expose":"true","tailscale.com/hostname":%q}}}`, ns+"-"+name)
+		_, err := k8sClient.CoreV1().Services(ns).Patch(ctx, name, types.MergePatchType, []byte(patch), meta.PatchOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TelemetryStatePath keeps the report of the running bootstrap. A report
+// still marked running here belongs to a bootstrap that was interrupted or
+// crashed, and is sent as a failure by the next run.
+const TelemetryStatePath = "/var/lib/orsted/telemetry.json"
+
+// TelemetryReport is everything telemetry sends: no hostnames, addresses
+// or config values, only the outcome, timings and versions.
+type TelemetryReport struct {
+	RunID       string            `json:"runId"`
+	Orsted      string            `json:"orsted"`
+	Distro      string            `json:"distro"`
+	Kubernetes  string            `json:"kubernetes"`
+	Status      string            `json:"status"`
+	FailedPhase string            `json:"failedPhase,omitempty"`
+	Phases      []PhaseTiming     `json:"phases"`
+	Components  map[string]string `json:"components"`
+}
+
+type PhaseTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// telemetry is the report of the current bootstrap, nil unless telemetry
+// is enabled.
+var (
+	telemetry         *TelemetryReport
+	telemetryEndpoint string
+)
+
+// StartTelemetry sends the report a failed previous bootstrap left behind
+// and starts recording this one.
+func StartTelemetry(conf *Config) {
+	if !conf.Telemetry.Enabled || conf.Telemetry.Endpoint == "" {
+		return
+	}
+	telemetryEndpoint = conf.Telemetry.Endpoint
+
+	var previous TelemetryReport
+	data, err := os.ReadFile(TelemetryStatePath)
+	if err == nil && json.Unmarshal(data, &previous) == nil && previous.Status == "running" {
+		previous.Status = "failed"
+		if len(previous.Phases) > 0 {
+			previous.FailedPhase = previous.Phases[len(previous.Phases)-1].Name
+		}
+		sendTelemetry(&previous)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to read the previous telemetry report: %s\n", err)
+	}
+
+	distro := "unknown"
+	if detected, err := DetectDistro(); err == nil {
+		distro = detected.Name
+	}
+
+	telemetry = &TelemetryReport{
+		RunID:      uuid.NewString(),
+		Orsted:     orstedVersion(),
+		Distro:     distro,
+		Kubernetes: conf.Kubernetes.Version,
+		Status:     "running",
+		Components: map[string]string{},
+	}
+
+	if defaultIp, err := primaryNodeIP(conf); err == nil {
+		if releases, err := Releases(conf, defaultIp); err == nil {
+			for _, release := range releases {
+				telemetry.Components[release.Spec.ChartName] = release.Spec.Version
+			}
+		}
+	}
+
+	saveTelemetry()
+}
+
+// telemetryPhase records a phase as started, the duration is filled in
+// once it finishes.
+func telemetryPhase(name string) func() {
+	if telemetry == nil {
+		return func() {}
+	}
+
+	telemetry.Phases = append(telemetry.Phases, PhaseTiming{Name: name})
+	saveTelemetry()
+
+	i := len(telemetry.Phases) - 1
+	start := time.Now()
+	return func() {
+		telemetry.Phases[i].Seconds = time.Since(start).Seconds()
+		saveTelemetry()
+	}
+}
+
+// FinishTelemetry sends the report of a successful bootstrap with the
+// chart versions actually installed.
+func FinishTelemetry(releases []ReleaseReport) {
+	if telemetry == nil {
+		return
+	}
+
+	for _, release := range releases {
+		telemetry.Components[release.Chart] = release.Version
+	}
+	telemetry.Status = "succeeded"
+	finishTelemetry()
+}
+
+// FailTelemetry sends the report of a bootstrap that stopped on failure.
+func FailTelemetry(failure *Failure) {
+	if telemetry == nil {
+		return
+	}
+
+	telemetry.Status = "failed"
+	telemetry.FailedPhase = failure.Phase
+	finishTelemetry()
+}
+
+func finishTelemetry() {
+	sendTelemetry(telemetry)
+	if err := os.Remove(TelemetryStatePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to remove the telemetry report: %s\n", err)
+	}
+}
+
+func saveTelemetry() {
+	data, err := json.Marshal(telemetry)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(TelemetryStatePath), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(TelemetryStatePath, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Failed to save the telemetry report: %s\n", err)
+	}
+}
+
+// sendTelemetry posts a report, only logging failures, telemetry never
+// stops a bootstrap.
+func sendTelemetry(report *TelemetryReport) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Failed to encode the telemetry report: %s\n", err)
+		return
+	}
+
+	client := http.Client{Timeout: time.Second * 10}
+	resp, err := client.Post(telemetryEndpoint, "application/json", bytes.NewReader(data))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("endpoint returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to send telemetry: %s\n", err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// tenantLabel carries the name of the tenant a namespace belongs to, the
+// isolation policies of the tenant select its namespaces by it.
+const tenantLabel = "orsted.io/tenant"
+
+// namespaces are the namespaces of the tenant, the first one holding its
+// GitOps sync.
+func (t TenantConfig) namespaces() []string {
+	if len(t.Namespaces) > 0 {
+		return t.Namespaces
+	}
+
+	return []string{t.Name}
+}
+
+func (t TenantConfig) gitopsAccount() string {
+	return t.Name + "-gitops"
+}
+
+// tenantNamespaces are the namespaces of the config's tenants, labeled
+// with their tenant.
+func tenantNamespaces(conf *Config) []NamespaceConfig {
+	var namespaces []NamespaceConfig
+	for _, tenant := range conf.Tenants {
+		for _, ns := range tenant.namespaces() {
+			namespaces = append(namespaces, NamespaceConfig{
+				Name:        ns,
+				Labels:      map[string]string{tenantLabel: tenant.Name},
+				PodSecurity: tenant.PodSecurity,
+				Quota:       tenant.Quota,
+				Limits:      tenant.Limits,
+			})
+		}
+	}
+
+	return namespaces
+}
+
+// tenantsUseGitOps reports whether a tenant syncs from Git, which needs
+// Flux.
+func tenantsUseGitOps(conf *Config) bool {
+	for _, tenant := range conf.Tenants {
+		if tenant.GitOps.URL != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tenantSubject is the RBAC subject of a user, or of a group written as
+// group:<name>.
+func tenantSubject(subject string) object {
+	kind := "User"
+	if group, ok := strings.CutPrefix(subject, "group:"); ok {
+		kind, subject = "Group", group
+	}
+
+	return object{"apiGroup": "rbac.authorization.k8s.io", "kind": kind, "name": subject}
+}
+
+func roleBinding(ns string, name string, clusterRole string, subjects []object) object {
+	return object{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "RoleBinding",
+		"metadata":   object{"name": name, "namespace": ns},
+		"roleRef": object{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "ClusterRole",
+			"name":     clusterRole,
+		},
+		"subjects": subjects,
+	}
+}
+
+// tenantsManifest renders the role bindings and isolation policies of
+// every tenant namespace, and the Flux sync of the tenants syncing from
+// Git. Their quotas and limit ranges are those of the managed namespaces.
+func tenantsManifest(conf *Config) (string, error) {
+	var objects []object
+	for _, tenant := range conf.Tenants {
+		namespaces := tenant.namespaces()
+		home := namespaces[0]
+
+		for _, ns := range namespaces {
+			for _, role := range []struct {
+				name     string
+				subjects []string
+			}{{"admin", tenant.Admins}, {"edit", tenant.Members}, {"view", tenant.Viewers}} {
+				if len(role.subjects) == 0 {
+					continue
+				}
+
+				var subjects []object
+				for _, subject := range role.subjects {
+					subjects = append(subjects, tenantSubject(subject))
+				}
+				objects = append(objects, roleBinding(ns, "orsted-tenant-"+role.name, role.name, subjects))
+			}
+
+			if tenant.GitOps.URL != "" {
+				objects = append(objects, roleBinding(ns, "orsted-tenant-gitops", "admin", []object{{
+					"kind":      "ServiceAccount",
+					"name":      tenant.gitopsAccount(),
+					"namespace": home,
+				}}))
+			}
+
+			if tenant.Isolated {
+				from := []object{{"namespaceSelector": object{"matchLabels": object{tenantLabel: tenant.Name}}}}
+				for _, allowed := range tenant.AllowFrom {
+					from = append(from, object{"namespaceSelector": object{"matchLabels": object{"kubernetes.io/metadata.name": allowed}}})
+				}
+
+				objects = append(objects, object{
+					"apiVersion": "networking.k8s.io/v1",
+					"kind":       "NetworkPolicy",
+					"metadata":   object{"name": "orsted-tenant-isolation", "namespace": ns},
+					"spec": object{
+						"podSelector": object{},
+						"policyTypes": []string{"Ingress"},
+						"ingress":     []object{{"from": from}},
+					},
+				})
+			}
+		}
+
+		if tenant.GitOps.URL != "" {
+			objects = append(objects, tenantSync(tenant, home)...)
+		}
+	}
+
+	return renderObjects(objects)
+}
+
+// tenantSync is the Flux GitRepository and Kustomization syncing the
+// repository of a tenant into ns, impersonating a ServiceAccount bound to
+// admin in its namespaces only.
+func tenantSync(tenant TenantConfig, ns string) []object {
+	gitops := tenant.GitOps
+	branch := gitops.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	interval := gitops.Interval
+	if interval == "" {
+		interval = "5m"
+	}
+	path := gitops.Path
+	if path == "" {
+		path = "./"
+	}
+
+	source := object{
+		"interval": interval,
+		"url":      gitops.URL,
+		"ref":      object{"branch": branch},
+	}
+	if gitops.SecretRef != "" {
+		source["secretRef"] = object{"name": gitops.SecretRef}
+	}
+
+	return []object{
+		{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   object{"name": tenant.gitopsAccount(), "namespace": ns},
+		},
+		{
+			"apiVersion": "source.toolkit.fluxcd.io/v1",
+			"kind":       "GitRepository",
+			"metadata":   object{"name": tenant.Name, "namespace": ns},
+			"spec":       source,
+		},
+		{
+			"apiVersion": "kustomize.toolkit.fluxcd.io/v1",
+			"kind":       "Kustomization",
+			"metadata":   object{"name": tenant.Name, "namespace": ns},
+			"spec": object{
+				"interval":           interval,
+				"prune":              true,
+				"path":               path,
+				"targetNamespace":    ns,
+				"serviceAccountName": tenant.gitopsAccount(),
+				"sourceRef":          object{"kind": "GitRepository", "name": tenant.Name},
+			},
+		},
+	}
+}
+
+// ApplyTenants sets up the namespaces of the tenants, created along with
+// the managed namespaces, for their teams, once Flux is installed for
+// those syncing from Git.
+func ApplyTenants(ctx context.Context, conf *Config) error {
+	if len(conf.Tenants) == 0 {
+		return nil
+	}
+
+	if tenantsUseGitOps(conf) {
+		if err := WaitForCRDs(ctx, crdTimeout, "gitrepositories.source.toolkit.fluxcd.io", "kustomizations.kustomize.toolkit.fluxcd.io"); err != nil {
+			return failed("tenants", err)
+		}
+	}
+
+	manifest, err := tenantsManifest(conf)
+	if err != nil {
+		return failedWith(ExitConfig, "tenants", fmt.Errorf("rendering the tenants: %w", err))
+	}
+	if manifest == "" {
+		return nil
+	}
+
+	log.Printf("Configuring %d tenants\n", len(conf.Tenants))
+	out, err := ApplyManifest(ctx, "tenants", manifest)
+	if err != nil {
+		return commandFailed("tenants", out, fmt.Errorf("applying the tenants: %w", err))
+	}
+
+	return nil
+}
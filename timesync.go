@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// clockTolerance is how far the clock may be off. HTTP dates only have
+// second precision, so this can't get much tighter.
+const clockTolerance = time.Second * 5
+
+// timedatectl reports the time sync state as properties like
+// NTPSynchronized=yes.
+func timedatectl(ctx context.Context) (map[string]string, error) {
+	out, err := RunCommand(ctx, "timedatectl", "show")
+	if err != nil {
+		return nil, fmt.Errorf("timedatectl: %w: %s", err, out)
+	}
+
+	properties := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			properties[key] = value
+		}
+	}
+
+	return properties, nil
+}
+
+// checkTimeSync verifies chrony or systemd-timesyncd keeps the clock
+// synchronized and that it agrees with the Date of a registry's response,
+// since certificates and etcd leases both go wrong on a skewed clock.
+func checkTimeSync(ctx context.Context, conf *Config) error {
+	properties, err := timedatectl(ctx)
+	if err != nil {
+		return err
+	}
+
+	if properties["NTP"] != "yes" {
+		return errors.New("no time synchronization service is enabled")
+	}
+	if properties["NTPSynchronized"] != "yes" {
+		return errors.New("the clock is not synchronized yet")
+	}
+
+	client := http.Client{Timeout: time.Second * 10}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://registry.k8s.io/v2/", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("registry.k8s.io sent no usable date: %w", err)
+	}
+
+	if offset := time.Since(remote).Round(time.Second); offset > clockTolerance || offset < -clockTolerance {
+		return fmt.Errorf("the clock is off by %s", offset)
+	}
+
+	return nil
+}
+
+// enableTimeSync turns on the installed time sync service and waits for it
+// to synchronize the clock.
+func enableTimeSync(ctx context.Context, conf *Config) error {
+	out, err := RunCommand(ctx, "timedatectl", "set-ntp", "true")
+	if err != nil {
+		return fmt.Errorf("timedatectl: %w: %s", err, out)
+	}
+
+	deadline := time.Now().Add(time.Minute * 2)
+	for time.Now().Before(deadline) {
+		properties, err := timedatectl(ctx)
+		if err != nil {
+			return err
+		}
+		if properties["NTPSynchronized"] == "yes" {
+			return nil
+		}
+
+		time.Sleep(time.Second * 5)
+	}
+
+	return errors.New("the clock did not synchronize within 2 minutes")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("git.jessnuko.bid/orsted")
+
+// InitTracing installs an OTLP exporting tracer provider when an endpoint is
+// configured. The returned func flushes and stops it.
+func InitTracing(ctx context.Context, conf TracingConfig) (func(), error) {
+	if conf.Endpoint == "" {
+		return func() {}, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Endpoint)}
+	if conf.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String("orsted"),
+		semconv.HostNameKey.String(hostname),
+	)
+
+	// Spans are exported as they end rather than batched, a failed phase
+	// exits the process and would otherwise drop everything before it.
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func() { provider.Shutdown(context.Background()) }, nil
+}
+
+// Phases are the names of the bootstrap phases in the order they run, for
+// completing them on the command line. Keep them in sync with Bootstrap.
+var Phases = []string{
+	"provision", "preflight", "os-updates", "sysctl", "hugepages", "cgroups", "selinux", "runtime",
+	"kubeadm-init", "kind", "api-wait", "untaint", "namespaces", "priority-classes", "gateway-crds",
+	"helm-repos", "cilium", "cloud", "system-pods", "dns", "policy-engine", "rook", "gitops",
+	"policies", "addons", "tenants", "helm-history", "helm-tests",
+}
+
+// phase runs one bootstrap step inside its own span, timing it for
+// telemetry. A failure is returned with the phase recorded in it.
+func phase(ctx context.Context, name string, run func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, name)
+	done := telemetryPhase(name)
+	phaseMu.Lock()
+	phaseState.Current = name
+	phaseMu.Unlock()
+	printPhaseStart(name)
+	start := time.Now()
+
+	err := run(ctx)
+	done()
+	took := time.Since(start)
+	printPhaseEnd(name, took, err)
+	phaseMu.Lock()
+	phaseState.Current = ""
+	phaseState.Finished = append(phaseState.Finished, PhaseTiming{Name: name, Seconds: took.Seconds()})
+	phaseMu.Unlock()
+	if err == nil {
+		span.End()
+		return nil
+	}
+
+	failure := asFailure(err)
+	if failure.Phase == "" {
+		failure.Phase = name
+	}
+	endSpan(span, failure)
+
+	return failure
+}
+
+func chartAttributes(spec *helmclient.ChartSpec) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("helm.chart", spec.ChartName),
+		attribute.String("helm.version", spec.Version),
+		attribute.String("helm.namespace", spec.Namespace),
+	)
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		// Errors quote command output, which may hold secrets.
+		message := Redact(err.Error())
+		span.RecordError(errors.New(message))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
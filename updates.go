@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// CheckUpdates compares every release the config deploys against the
+// newest stable chart in its repository and prints the ones that are behind.
+func CheckUpdates(out io.Writer, conf *Config) error {
+	releases, err := Releases(conf, "")
+	if err != nil {
+		return err
+	}
+
+	indexes := map[string]*repo.IndexFile{}
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "RELEASE\tCHART\tPINNED\tLATEST\tCHANGELOG")
+
+	outdated := 0
+	for _, release := range releases {
+		index, ok := indexes[release.Repo.URL]
+		if !ok {
+			index, err = fetchIndex(release.Repo.URL)
+			if err != nil {
+				return fmt.Errorf("%s: %w", release.Repo.Name, err)
+			}
+			indexes[release.Repo.URL] = index
+		}
+
+		chart := strings.TrimPrefix(release.Spec.ChartName, release.Repo.Name+"/")
+		latest, err := index.Get(chart, "")
+		if err != nil {
+			return fmt.Errorf("%s: %w", release.Spec.ChartName, err)
+		}
+
+		pinned := release.Spec.Version
+		if pinned == "" {
+			pinned = "unpinned"
+		} else if !isNewer(latest.Version, pinned) {
+			continue
+		}
+
+		outdated++
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", release.Spec.ReleaseName, release.Spec.ChartName, pinned, latest.Version, changelogURL(latest))
+	}
+
+	if outdated == 0 {
+		fmt.Fprintln(out, "All charts are up to date")
+		return nil
+	}
+
+	return table.Flush()
+}
+
+func fetchIndex(url string) (*repo.IndexFile, error) {
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Get(strings.TrimSuffix(url, "/") + "/index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching index: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	index := repo.NewIndexFile()
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, err
+	}
+	index.SortEntries()
+
+	return index, nil
+}
+
+func isNewer(latest string, pinned string) bool {
+	latestVer, err := semver.NewVersion(latest)
+	if err != nil {
+		return latest != pinned
+	}
+
+	// A range is up to date as long as the latest version is in it.
+	pinnedVer, err := semver.NewVersion(pinned)
+	if err != nil {
+		return !versionMatches(pinned, latest)
+	}
+
+	return latestVer.GreaterThan(pinnedVer)
+}
+
+// changelogURL points at the GitHub releases of the chart's source when it
+// has one, otherwise at its home page.
+func changelogURL(chart *repo.ChartVersion) string {
+	for _, source := range chart.Sources {
+		if strings.HasPrefix(source, "https://github.com/") {
+			return strings.TrimSuffix(source, "/") + "/releases"
+		}
+	}
+
+	return chart.Home
+}
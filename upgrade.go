@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Upgrade brings every release on an existing cluster to the versions and
+// values of the current config. Each upgrade is atomic, and a release whose
+// workloads don't become ready afterwards is rolled back to its previous
+// revision.
+func Upgrade(conf *Config) error {
+	ctx, span := tracer.Start(rootCtx, "upgrade")
+	err := upgrade(ctx, conf)
+	endSpan(span, err)
+
+	return err
+}
+
+func upgrade(ctx context.Context, conf *Config) error {
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return failed("kubernetes", err)
+	}
+
+	serverVersion, err := k8sClient.Discovery().ServerVersion()
+	if err != nil {
+		return failed("kubernetes", fmt.Errorf("getting the cluster version: %w", err))
+	}
+
+	if err := CheckVersionSkew(ctx, conf, serverVersion.GitVersion); err != nil {
+		return failedWith(ExitPreflight, "version-skew", err)
+	}
+
+	defaultIp, err := primaryNodeIP(conf)
+	if err != nil {
+		return failed("network", fmt.Errorf("getting the node IP: %w", err))
+	}
+
+	releases, err := Releases(conf, defaultIp)
+	if err != nil {
+		return failedWith(ExitConfig, "releases", fmt.Errorf("rendering: %w", err))
+	}
+
+	if err := ApplyNamespaces(ctx, k8sClient, conf); err != nil {
+		return err
+	}
+
+	if err := ApplyPriorityClasses(ctx, conf); err != nil {
+		return err
+	}
+
+	// Chart addons create what their charts depend on first, like the
+	// secrets their values refer to.
+	for _, addon := range configAddons(conf) {
+		if addon.Spec == nil || addon.Prepare == nil || !addon.Enabled(conf) {
+			continue
+		}
+
+		if err := addon.Prepare(ctx, conf); err != nil {
+			return failed(addon.Name, fmt.Errorf("preparing: %w", err))
+		}
+	}
+
+	failing := 0
+	for _, release := range releases {
+		spec := release.Spec
+		spec.Atomic = true
+		spec.CleanupOnFail = true
+
+		client, err := helmClientForNs(spec.Namespace)
+		if err != nil {
+			return failed(spec.ReleaseName, fmt.Errorf("creating the helm client: %w", err))
+		}
+
+		if err := addChartRepo(conf, client, release.Repo, spec); err != nil {
+			return failed(spec.ReleaseName, fmt.Errorf("adding the %s chart repo: %w", release.Repo.Name, err))
+		}
+
+		log.Printf("Upgrading %s\n", spec.ReleaseName)
+		if err := InstallOrUpgradeSpec(ctx, conf, client, spec); err != nil {
+			// Atomic upgrades have already been rolled back by Helm.
+			log.Printf("Failed to upgrade %s, rolled back: %s\n", spec.ReleaseName, err)
+			failing++
+			continue
+		}
+
+		if err := verifyRelease(ctx, k8sClient, client, spec); err != nil {
+			failing++
+
+			// A release installed by this upgrade has no revision to go
+			// back to, it is left as it is for inspection.
+			rel, getErr := client.GetRelease(spec.ReleaseName)
+			if getErr == nil && rel.Version <= 1 {
+				log.Printf("%s is unhealthy after its first install: %s\n", spec.ReleaseName, err)
+				continue
+			}
+
+			log.Printf("%s is unhealthy after upgrade, rolling back: %s\n", spec.ReleaseName, err)
+			if err := client.RollbackRelease(spec); err != nil {
+				return failed(spec.ReleaseName, fmt.Errorf("rolling back: %w", err))
+			}
+		}
+	}
+
+	for _, apply := range []func(context.Context, *Config) error{ApplyLoadBalancer, ApplyBGP, ApplyEgressGateways} {
+		if err := apply(ctx, conf); err != nil {
+			return err
+		}
+	}
+
+	for _, addon := range configAddons(conf) {
+		if !addon.Enabled(conf) {
+			continue
+		}
+
+		// Addons without a chart are upgraded by installing them again.
+		if addon.Spec == nil && addon.Prepare != nil {
+			if err := addon.Prepare(ctx, conf); err != nil {
+				return failed(addon.Name, fmt.Errorf("upgrading: %w", err))
+			}
+		}
+
+		if err := finishAddon(ctx, conf, addon); err != nil {
+			return err
+		}
+	}
+
+	if err := ApplyPolicies(ctx, conf); err != nil {
+		return err
+	}
+
+	if err := ApplyTenants(ctx, conf); err != nil {
+		return err
+	}
+
+	if err := PruneReleaseHistory(ctx, k8sClient, releases, conf.Helm.MaxHistory); err != nil {
+		return failed("helm", fmt.Errorf("pruning release history: %w", err))
+	}
+
+	if failing > 0 {
+		return failed("releases", fmt.Errorf("%d of %d failed to upgrade", failing, len(releases)))
+	}
+
+	log.Println("Successfully upgraded all releases")
+	return nil
+}
+
+type releaseWorkload struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:"metadata"`
+}
+
+// releaseWorkloads lists the Deployments, StatefulSets and DaemonSets of
+// an installed release.
+func releaseWorkloads(client helmclient.Client, spec *helmclient.ChartSpec) ([]releaseWorkload, error) {
+	rel, err := client.GetRelease(spec.ReleaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []releaseWorkload
+	for _, doc := range releaseutil.SplitManifests(rel.Manifest) {
+		var w releaseWorkload
+		if err := yaml.Unmarshal([]byte(doc), &w); err != nil {
+			return nil, err
+		}
+
+		if w.Namespace == "" {
+			w.Namespace = spec.Namespace
+		}
+
+		switch w.Kind {
+		case "Deployment", "StatefulSet", "DaemonSet":
+			workloads = append(workloads, w)
+		}
+	}
+
+	return workloads, nil
+}
+
+// verifyRelease waits for the Deployments, StatefulSets and DaemonSets of a
+// release to finish rolling out and their pods to be ready, failing when
+// one is crash looping.
+func verifyRelease(ctx context.Context, k8sClient *kubernetes.Clientset, client helmclient.Client, spec *helmclient.ChartSpec) error {
+	workloads, err := releaseWorkloads(client, spec)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, spec.Timeout)
+	defer cancel()
+
+	for _, w := range workloads {
+		// Errors reaching the API are retried until the timeout, only a
+		// crash loop fails right away.
+		var lastErr error
+		for {
+			ready, err := workloadReady(ctx, k8sClient, w.Kind, w.Namespace, w.Name)
+			if err == nil && ready {
+				ready, err = workloadPodsReady(ctx, k8sClient, w.Kind, w.Namespace, w.Name)
+			}
+			if errors.Is(err, errCrashLooping) {
+				return err
+			}
+			if err == nil && ready {
+				break
+			}
+			lastErr = err
+
+			select {
+			case <-ctx.Done():
+				if lastErr != nil {
+					return fmt.Errorf("%s %s/%s not ready: %w, last error: %s", w.Kind, w.Namespace, w.Name, ctx.Err(), lastErr)
+				}
+				return fmt.Errorf("%s %s/%s not ready: %w", w.Kind, w.Namespace, w.Name, ctx.Err())
+			case <-time.After(time.Second * 5):
+			}
+		}
+	}
+
+	return nil
+}
+
+func workloadReady(ctx context.Context, k8sClient *kubernetes.Clientset, kind string, ns string, name string) (bool, error) {
+	apps := k8sClient.AppsV1()
+
+	switch kind {
+	case "Deployment":
+		d, err := apps.Deployments(ns).Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+
+		return d.Status.ObservedGeneration >= d.Generation &&
+			d.Status.UpdatedReplicas == replicas &&
+			d.Status.AvailableReplicas == replicas, nil
+	case "StatefulSet":
+		s, err := apps.StatefulSets(ns).Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		replicas := int32(1)
+		if s.Spec.Replicas != nil {
+			replicas = *s.Spec.Replicas
+		}
+
+		return s.Status.ObservedGeneration >= s.Generation &&
+			s.Status.ReadyReplicas == replicas, nil
+	case "DaemonSet":
+		d, err := apps.DaemonSets(ns).Get(ctx, name, meta.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		return d.Status.ObservedGeneration >= d.Generation &&
+			d.Status.UpdatedNumberScheduled == d.Status.DesiredNumberScheduled &&
+			d.Status.NumberReady == d.Status.DesiredNumberScheduled, nil
+	}
+
+	return true, nil
+}
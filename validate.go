@@ -0,0 +1,683 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigProblem is something wrong with the config file, at Line of the
+// file, or of the closest section present when Path itself is missing.
+type ConfigProblem struct {
+	Line    int
+	Path    string
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	if p.Path == "" {
+		return fmt.Sprintf("%d: %s", p.Line, p.Message)
+	}
+
+	return fmt.Sprintf("%d: %s: %s", p.Line, p.Path, p.Message)
+}
+
+// keyPath is a path to a key of the config, made of keys and list
+// indexes.
+type keyPath []interface{}
+
+func (p keyPath) String() string {
+	var out strings.Builder
+	for _, elem := range p {
+		switch elem := elem.(type) {
+		case int:
+			fmt.Fprintf(&out, "[%d]", elem)
+		case string:
+			if out.Len() > 0 {
+				out.WriteString(".")
+			}
+			out.WriteString(elem)
+		}
+	}
+
+	return out.String()
+}
+
+func (p keyPath) with(elems ...interface{}) keyPath {
+	return append(append(keyPath{}, p...), elems...)
+}
+
+type configValidator struct {
+	root     *yamlv3.Node
+	problems []ConfigProblem
+}
+
+func (v *configValidator) report(path keyPath, format string, args ...interface{}) {
+	v.problems = append(v.problems, ConfigProblem{v.line(path), path.String(), fmt.Sprintf(format, args...)})
+}
+
+// line finds the line of path, falling back to that of its closest parent
+// present in the file.
+func (v *configValidator) line(path keyPath) int {
+	node, line := v.root, 1
+	for _, elem := range path {
+		if node == nil {
+			break
+		}
+
+		var next *yamlv3.Node
+		switch elem := elem.(type) {
+		case int:
+			if node.Kind == yamlv3.SequenceNode && elem < len(node.Content) {
+				next = node.Content[elem]
+				line = next.Line
+			}
+		case string:
+			if node.Kind == yamlv3.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == elem {
+						next = node.Content[i+1]
+						line = node.Content[i].Line
+					}
+				}
+			}
+		}
+		node = next
+	}
+
+	return line
+}
+
+// ValidateConfig checks the config file at path for unknown keys and
+// values of the wrong type, invalid addresses and CIDRs, options that
+// conflict and credentials enabled addons need. A missing file is valid,
+// the defaults are used.
+func ValidateConfig(path string) ([]ConfigProblem, error) {
+	data, err := readConfigFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	v := &configValidator{}
+	if len(doc.Content) > 0 {
+		v.root = doc.Content[0]
+		v.checkNode(v.root, reflect.TypeOf(Config{}), nil)
+	}
+	if len(v.problems) > 0 {
+		return v.problems, nil
+	}
+
+	conf := DefaultConfig()
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return []ConfigProblem{{Line: 1, Message: err.Error()}}, nil
+	}
+	if err := applyAddonState(conf); err != nil {
+		return nil, err
+	}
+
+	v.checkCloud(conf)
+	v.checkAutoscaler(conf)
+	v.checkNetwork(conf.Network)
+	v.checkHost(conf)
+	v.checkNamespaces(conf.Namespaces)
+	v.checkTenants(conf.Tenants)
+	v.checkQuantities(keyPath{"resources", "quota"}, conf.Resources.Quota)
+	v.checkLimits(keyPath{"resources", "limits"}, conf.Resources.Limits)
+	v.checkHelm(conf.Helm)
+	v.checkPolicies(conf.Policies)
+	v.checkAddons(conf.Addons)
+	v.checkCatalog(conf)
+	v.checkScheduling(conf.Scheduling)
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+
+	return v.problems, nil
+}
+
+// checkNode reports the keys of node that aren't fields of t, and values
+// that don't decode into t.
+func (v *configValidator) checkNode(node *yamlv3.Node, t reflect.Type, path keyPath) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			v.report(path, "expected a mapping")
+			return
+		}
+
+		fields := map[string]reflect.Type{}
+		for _, field := range configFields(t) {
+			fields[field.Name] = field.Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			fieldType, ok := fields[key]
+			if !ok {
+				v.problems = append(v.problems, ConfigProblem{node.Content[i].Line, path.with(key).String(), "unknown key"})
+				continue
+			}
+			v.checkNode(node.Content[i+1], fieldType, path.with(key))
+		}
+	case reflect.Map:
+		if node.Kind != yamlv3.MappingNode {
+			v.report(path, "expected a mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkNode(node.Content[i+1], t.Elem(), path.with(node.Content[i].Value))
+		}
+	case reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			v.report(path, "expected a list")
+			return
+		}
+		for i, item := range node.Content {
+			v.checkNode(item, t.Elem(), path.with(i))
+		}
+	case reflect.Bool:
+		// YAML 1.1 booleans like yes and on are still booleans to LoadConfig.
+		switch strings.ToLower(node.Value) {
+		case "y", "yes", "n", "no", "on", "off":
+			if node.Kind == yamlv3.ScalarNode && node.Style == 0 {
+				return
+			}
+		}
+		v.checkScalar(node, path, "a boolean", "!!bool")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.checkScalar(node, path, "an integer", "!!int")
+	case reflect.Float32, reflect.Float64:
+		v.checkScalar(node, path, "a number", "!!int", "!!float")
+	case reflect.String:
+		// Unquoted numbers and booleans are read as strings too.
+		v.checkScalar(node, path, "a string", "!!str", "!!int", "!!float", "!!bool")
+	}
+}
+
+func (v *configValidator) checkScalar(node *yamlv3.Node, path keyPath, kind string, tags ...string) {
+	if node.Kind == yamlv3.ScalarNode {
+		for _, tag := range tags {
+			if node.ShortTag() == tag {
+				return
+			}
+		}
+	}
+
+	v.problems = append(v.problems, ConfigProblem{node.Line, path.String(), "expected " + kind})
+}
+
+func (v *configValidator) checkCIDRs(path keyPath, cidrs []string) bool {
+	valid := true
+	for i, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			v.report(path.with(i), "invalid CIDR %q", cidr)
+			valid = false
+		}
+	}
+
+	return valid
+}
+
+func (v *configValidator) checkIP(path keyPath, ip string) {
+	if net.ParseIP(ip) == nil {
+		v.report(path, "invalid IP address %q", ip)
+	}
+}
+
+// require reports each of the named values of an enabled section that is
+// empty, as what needs it.
+func (v *configValidator) require(path keyPath, what string, values map[string]string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if values[key] == "" {
+			v.report(path.with(key), "%s needs %s set", what, key)
+		}
+	}
+}
+
+func (v *configValidator) oneOf(path keyPath, value string, allowed ...string) {
+	for _, option := range allowed {
+		if value == option {
+			return
+		}
+	}
+
+	v.report(path, "unknown value %q, expected %s", value, strings.Join(allowed, ", "))
+}
+
+func (v *configValidator) checkNetwork(network NetworkConfig) {
+	path := keyPath{"network"}
+
+	pods := v.checkCIDRs(path.with("podCIDRs"), network.PodCIDRs)
+	services := v.checkCIDRs(path.with("serviceCIDRs"), network.ServiceCIDRs)
+	if pods && services {
+		if _, _, err := network.cidrs(); err != nil {
+			v.report(path.with("podCIDRs"), "%s", err)
+		}
+	}
+
+	bgp := path.with("bgp")
+	if network.BGP.Enabled {
+		if network.BGP.LocalASN == 0 {
+			v.report(bgp.with("localASN"), "bgp needs a localASN")
+		}
+		for i, peer := range network.BGP.Peers {
+			v.checkIP(bgp.with("peers", i, "address"), peer.Address)
+			if peer.ASN == 0 {
+				v.report(bgp.with("peers", i, "asn"), "bgp peer needs an asn")
+			}
+		}
+	}
+
+	pools := path.with("loadBalancer", "pools")
+	for i, pool := range network.LoadBalancer.Pools {
+		if pool.Name == "" {
+			v.report(pools.with(i, "name"), "load balancer pool needs a name")
+		}
+		v.checkCIDRs(pools.with(i, "cidrs"), pool.CIDRs)
+	}
+
+	gateways := path.with("egressGateways")
+	for i, gw := range network.EgressGateways {
+		if gw.Name == "" {
+			v.report(gateways.with(i, "name"), "egress gateway needs a name")
+		}
+		v.checkCIDRs(gateways.with(i, "destinationCIDRs"), gw.DestinationCIDRs)
+		v.checkCIDRs(gateways.with(i, "excludedCIDRs"), gw.ExcludedCIDRs)
+		if gw.EgressIP != "" {
+			v.checkIP(gateways.with(i, "egressIP"), gw.EgressIP)
+			if gw.Interface != "" {
+				v.report(gateways.with(i, "interface"), "egressIP and interface can't both be set")
+			}
+		}
+	}
+
+	records := path.with("dnsCheck", "records")
+	for i, record := range network.DNSCheck.Records {
+		if record.Name == "" {
+			v.report(records.with(i, "name"), "dns check record needs a name")
+		}
+		if record.Address != "" {
+			v.checkIP(records.with(i, "address"), record.Address)
+		}
+	}
+}
+
+func (v *configValidator) checkCloud(conf *Config) {
+	path := keyPath{"cloud"}
+
+	if conf.Cloud.Provider != "" {
+		v.oneOf(path.with("provider"), conf.Cloud.Provider, "none", "aws", "gcp", "hetzner", "proxmox")
+	}
+	if _, ok := cloudEnabled(conf); !ok {
+		return
+	}
+
+	switch cloudName(conf) {
+	case "hetzner":
+		v.require(path.with("hetzner"), "hetzner", map[string]string{"token": string(conf.Cloud.Hetzner.Token)})
+	case "gcp":
+		v.require(path.with("gcp"), "gcp", map[string]string{"serviceAccountKey": string(conf.Cloud.GCP.ServiceAccountKey)})
+	case "proxmox":
+		proxmox := conf.Cloud.Proxmox
+		v.require(path.with("proxmox"), "proxmox", map[string]string{
+			"url":         proxmox.URL,
+			"region":      proxmox.Region,
+			"tokenID":     proxmox.TokenID,
+			"tokenSecret": string(proxmox.TokenSecret),
+		})
+	}
+}
+
+func (v *configValidator) checkAutoscaler(conf *Config) {
+	autoscaler := conf.Addons.ClusterAutoscaler
+	if !autoscaler.Enabled {
+		return
+	}
+	path := keyPath{"addons", "clusterAutoscaler"}
+
+	provider := cloudName(conf)
+	if _, ok := cloudEnabled(conf); !ok || provider == "proxmox" {
+		v.report(path.with("enabled"), "the cluster autoscaler needs cloud.controllerManager on aws, gcp or hetzner")
+		return
+	}
+
+	v.checkDuration(path.with("scaleDownUnneededTime"), autoscaler.ScaleDownUnneededTime)
+	if len(autoscaler.NodeGroups) == 0 && provider != "aws" {
+		v.report(path.with("nodeGroups"), "%s has no node group discovery, list the groups to scale", provider)
+	}
+	if provider == "hetzner" {
+		v.require(path, "the cluster autoscaler on hetzner", map[string]string{"joinCloudInit": autoscaler.JoinCloudInit})
+	}
+
+	for i, group := range autoscaler.NodeGroups {
+		if group.Name == "" || group.Max < 1 || group.Min < 0 || group.Min > group.Max {
+			v.report(path.with("nodeGroups", i), "node groups need a name and 0 <= min <= max, max at least 1")
+		}
+		if provider == "hetzner" && group.InstanceType == "" {
+			v.report(path.with("nodeGroups", i, "instanceType"), "hetzner node groups need the server type to create")
+		}
+	}
+}
+
+func (v *configValidator) checkHost(conf *Config) {
+	kubelet := conf.Kubelet
+	if kubelet.CPUManagerPolicy == "static" && kubelet.ReservedSystemCPUs == "" &&
+		kubelet.KubeReserved["cpu"] == "" && kubelet.SystemReserved["cpu"] == "" {
+		v.report(keyPath{"kubelet", "cpuManagerPolicy"}, "the static CPU manager policy needs reservedSystemCPUs or reserved cpu")
+	}
+	if kubelet.MemoryManagerPolicy == "Static" && len(kubelet.ReservedMemory) == 0 {
+		v.report(keyPath{"kubelet", "memoryManagerPolicy"}, "the Static memory manager policy needs reservedMemory")
+	}
+
+	external := conf.Etcd.External
+	if len(external.Endpoints) > 0 {
+		v.require(keyPath{"etcd", "external"}, "external etcd", map[string]string{
+			"caFile":   external.CAFile,
+			"certFile": external.CertFile,
+			"keyFile":  external.KeyFile,
+		})
+	}
+
+	v.checkDuration(keyPath{"kubernetes", "apiTimeout"}, conf.Kubernetes.APITimeout)
+
+	if _, ok := sysctlProfiles[conf.Sysctl.Profile]; !ok {
+		profiles := make([]string, 0, len(sysctlProfiles))
+		for profile := range sysctlProfiles {
+			profiles = append(profiles, profile)
+		}
+		sort.Strings(profiles)
+		v.oneOf(keyPath{"sysctl", "profile"}, conf.Sysctl.Profile, profiles...)
+	}
+
+	for i, pages := range conf.Hugepages.Pages {
+		v.oneOf(keyPath{"hugepages", "pages", i, "size"}, pages.Size, "2Mi", "1Gi")
+	}
+}
+
+func (v *configValidator) checkNamespaces(namespaces []NamespaceConfig) {
+	seen := map[string]bool{}
+	for i, ns := range namespaces {
+		path := keyPath{"namespaces", i}
+		if errs := validation.IsDNS1123Label(ns.Name); len(errs) > 0 {
+			v.report(path.with("name"), "invalid namespace name %q: %s", ns.Name, errs[0])
+		}
+		if seen[ns.Name] {
+			v.report(path.with("name"), "namespace %s is listed twice", ns.Name)
+		}
+		seen[ns.Name] = true
+
+		for mode, level := range map[string]string{"enforce": ns.PodSecurity.Enforce, "audit": ns.PodSecurity.Audit, "warn": ns.PodSecurity.Warn} {
+			if level != "" {
+				v.oneOf(path.with("podSecurity", mode), level, "privileged", "baseline", "restricted")
+			}
+		}
+
+		v.checkQuantities(path.with("quota"), ns.Quota)
+		v.checkLimits(path.with("limits"), ns.Limits)
+	}
+}
+
+func (v *configValidator) checkTenants(tenants []TenantConfig) {
+	owners := map[string]string{}
+	for i, tenant := range tenants {
+		path := keyPath{"tenants", i}
+		if errs := validation.IsDNS1123Label(tenant.Name); len(errs) > 0 {
+			v.report(path.with("name"), "invalid tenant name %q: %s", tenant.Name, errs[0])
+		}
+
+		for j, ns := range tenant.namespaces() {
+			nsPath := path.with("name")
+			if len(tenant.Namespaces) > 0 {
+				nsPath = path.with("namespaces", j)
+				if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+					v.report(nsPath, "invalid namespace name %q: %s", ns, errs[0])
+				}
+			}
+			if owner, ok := owners[ns]; ok {
+				v.report(nsPath, "namespace %s already belongs to tenant %s", ns, owner)
+			}
+			owners[ns] = tenant.Name
+		}
+
+		for mode, level := range map[string]string{"enforce": tenant.PodSecurity.Enforce, "audit": tenant.PodSecurity.Audit, "warn": tenant.PodSecurity.Warn} {
+			if level != "" {
+				v.oneOf(path.with("podSecurity", mode), level, "privileged", "baseline", "restricted")
+			}
+		}
+
+		v.checkQuantities(path.with("quota"), tenant.Quota)
+		v.checkLimits(path.with("limits"), tenant.Limits)
+
+		if tenant.GitOps.URL == "" {
+			for key, value := range map[string]string{"branch": tenant.GitOps.Branch, "path": tenant.GitOps.Path, "secretRef": tenant.GitOps.SecretRef} {
+				if value != "" {
+					v.report(path.with("gitops", key), "%s is set without a gitops url", key)
+				}
+			}
+		}
+		if tenant.GitOps.Interval != "" {
+			v.checkDuration(path.with("gitops", "interval"), tenant.GitOps.Interval)
+		}
+	}
+}
+
+func (v *configValidator) checkLimits(path keyPath, limits LimitsConfig) {
+	v.checkQuantities(path.with("defaultRequest"), limits.DefaultRequest)
+	v.checkQuantities(path.with("default"), limits.Default)
+	v.checkQuantities(path.with("max"), limits.Max)
+}
+
+// checkQuantities reports the values of resources that aren't quantities
+// like 500m or 2Gi.
+func (v *configValidator) checkQuantities(path keyPath, resources map[string]string) {
+	for name, quantity := range resources {
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			v.report(path.with(name), "%q isn't a quantity like 500m or 2Gi", quantity)
+		}
+	}
+}
+
+func (v *configValidator) checkHelm(helm HelmConfig) {
+	v.checkDuration(keyPath{"helm", "indexMaxAge"}, helm.IndexMaxAge)
+	for release, source := range helm.Values {
+		if _, err := parseValuesSource(source); err != nil {
+			v.report(keyPath{"helm", "values", release}, "%s", err)
+		}
+	}
+}
+
+func (v *configValidator) checkDuration(path keyPath, duration string) {
+	if _, err := time.ParseDuration(duration); err != nil {
+		v.report(path, "%q isn't a duration like 1h", duration)
+	}
+}
+
+func (v *configValidator) checkPolicies(policies PoliciesConfig) {
+	path := keyPath{"policies"}
+
+	v.oneOf(path.with("engine"), policies.Engine, "kyverno", "gatekeeper")
+	if len(policies.VerifyImages) > 0 {
+		if policies.Engine != "kyverno" {
+			v.report(path.with("verifyImages"), "image verification policies require the kyverno policy engine")
+		}
+		v.oneOf(path.with("imageVerificationAction"), policies.ImageVerificationAction, "Enforce", "Audit")
+	}
+}
+
+// checkCatalog loads the catalogs of the config and reports the custom
+// addon settings no catalog has an addon for.
+func (v *configValidator) checkCatalog(conf *Config) {
+	for i, source := range conf.Catalog.Sources {
+		addons, err := LoadCatalogs([]string{source})
+		if err != nil {
+			v.report(keyPath{"catalog", "sources", i}, "%s", err)
+			continue
+		}
+
+		conf.catalogAddons = withAddons(conf.catalogAddons, addons...)
+	}
+
+	for name := range conf.Addons.Custom {
+		if _, ok := findAddon(conf, name); !ok {
+			v.report(keyPath{"addons", "custom", name}, "no catalog has an addon %s", name)
+		}
+	}
+}
+
+func (v *configValidator) checkScheduling(scheduling SchedulingConfig) {
+	for release, workloads := range scheduling {
+		for i, toleration := range workloads.Tolerations {
+			path := keyPath{"scheduling", release, "tolerations", i}
+			if toleration.Operator != "" {
+				v.oneOf(path.with("operator"), toleration.Operator, "Exists", "Equal")
+			}
+			if toleration.Operator == "Exists" && toleration.Value != "" {
+				v.report(path.with("value"), "tolerations with the Exists operator take no value")
+			}
+			if toleration.Effect != "" {
+				v.oneOf(path.with("effect"), toleration.Effect, "NoSchedule", "PreferNoSchedule", "NoExecute")
+			}
+		}
+	}
+}
+
+func (v *configValidator) checkAddons(addons AddonsConfig) {
+	path := keyPath{"addons"}
+
+	if addons.Dex.Enabled && addons.Keycloak.Enabled {
+		v.report(path.with("keycloak", "enabled"), "only one of dex and keycloak can be enabled")
+	}
+	if addons.Dex.Enabled {
+		if !strings.HasPrefix(addons.Dex.Issuer, "https://") {
+			v.report(path.with("dex", "issuer"), "dex issuer %q is not an https URL", addons.Dex.Issuer)
+		}
+		v.checkOIDCClient(path.with("dex", "gitops"), addons.Dex.GitOps)
+	}
+	if keycloak := addons.Keycloak; keycloak.Enabled {
+		v.require(path.with("keycloak"), "keycloak", map[string]string{
+			"hostname":         keycloak.Hostname,
+			"adminPassword":    string(keycloak.AdminPassword),
+			"databasePassword": string(keycloak.DatabasePassword),
+		})
+		v.checkOIDCClient(path.with("keycloak", "gitops"), keycloak.GitOps)
+	}
+
+	if harbor := addons.Harbor; harbor.Enabled {
+		v.require(path.with("harbor"), "harbor", map[string]string{"adminPassword": string(harbor.AdminPassword)})
+		v.oneOf(path.with("harbor", "storage"), harbor.Storage, "block", "s3")
+	}
+	if gitea := addons.Gitea; gitea.Enabled {
+		v.require(path.with("gitea"), "gitea", map[string]string{"adminPassword": string(gitea.AdminPassword)})
+	}
+	if tailscale := addons.Tailscale; tailscale.Enabled {
+		v.require(path.with("tailscale"), "tailscale", map[string]string{
+			"clientID":     tailscale.ClientID,
+			"clientSecret": string(tailscale.ClientSecret),
+		})
+	}
+	if cloudflared := addons.Cloudflared; cloudflared.Enabled {
+		v.require(path.with("cloudflared"), "cloudflared", map[string]string{
+			"accountTag":   cloudflared.AccountTag,
+			"tunnelID":     cloudflared.TunnelID,
+			"tunnelSecret": string(cloudflared.TunnelSecret),
+		})
+	}
+
+	if descheduler := addons.Descheduler; descheduler.Enabled {
+		if fields := strings.Fields(descheduler.Schedule); len(fields) != 5 {
+			v.report(path.with("descheduler", "schedule"), "%q isn't a cron schedule like */15 * * * *", descheduler.Schedule)
+		}
+		if descheduler.MaxEvictionsPerNode < 1 {
+			v.report(path.with("descheduler", "maxEvictionsPerNode"), "at least one pod has to be evicted per node")
+		}
+	}
+
+	if addons.ExternalSecrets.Enabled {
+		for i, store := range addons.ExternalSecrets.Stores {
+			storePath := path.with("externalSecrets", "stores", i)
+			v.oneOf(storePath.with("type"), store.Type, "vault", "aws", "sops")
+			switch store.Type {
+			case "aws":
+				if store.AWS.AccessKeyID != "" {
+					v.require(storePath.with("aws"), "secret store "+store.Name, map[string]string{"secretAccessKey": string(store.AWS.SecretAccessKey)})
+				}
+			case "sops":
+				v.require(storePath.with("sops"), "secret store "+store.Name, map[string]string{"file": store.SOPS.File})
+			}
+		}
+	}
+
+	if addons.CertManager.Enabled {
+		for i, issuer := range addons.CertManager.Issuers {
+			issuerPath := path.with("certManager", "issuers", i)
+			what := "issuer " + issuer.Name
+			v.require(issuerPath, what, map[string]string{"name": issuer.Name, "email": issuer.Email})
+			v.oneOf(issuerPath.with("solver"), issuer.Solver, "cloudflare", "route53", "rfc2136")
+			switch issuer.Solver {
+			case "cloudflare":
+				v.require(issuerPath.with("cloudflare"), what, map[string]string{"apiToken": string(issuer.Cloudflare.APIToken)})
+			case "route53":
+				v.require(issuerPath.with("route53"), what, map[string]string{"region": issuer.Route53.Region})
+				if issuer.Route53.AccessKeyID != "" {
+					v.require(issuerPath.with("route53"), what, map[string]string{"secretAccessKey": string(issuer.Route53.SecretAccessKey)})
+				}
+			case "rfc2136":
+				v.require(issuerPath.with("rfc2136"), what, map[string]string{
+					"nameserver":  issuer.RFC2136.Nameserver,
+					"tsigKeyName": issuer.RFC2136.TSIGKeyName,
+					"tsigSecret":  string(issuer.RFC2136.TSIGSecret),
+				})
+			}
+		}
+	}
+}
+
+func (v *configValidator) checkOIDCClient(path keyPath, client OIDCClient) {
+	if client.RedirectURL != "" {
+		v.require(path, "gitops OIDC client", map[string]string{"clientSecret": string(client.ClientSecret)})
+	}
+}
+
+// FormatProblems lists problems as file:line: path: message, one per line.
+func FormatProblems(file string, problems []ConfigProblem) string {
+	var out strings.Builder
+	for _, problem := range problems {
+		fmt.Fprintf(&out, "%s:%s\n", file, problem)
+	}
+
+	return out.String()
+}
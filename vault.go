@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+//go:embed values/vault.yaml
+var VaultYaml string
+
+var hashicorpRepo = repo.Entry{
+	Name: "hashicorp",
+	URL:  "https://helm.releases.hashicorp.com",
+}
+
+func vaultSpec(conf *Config) (*helmclient.ChartSpec, error) {
+	values, err := renderValues("vault", VaultYaml, conf.Addons.Vault)
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     "vault",
+		ChartName:       "hashicorp/vault",
+		Namespace:       "vault",
+		CreateNamespace: true,
+		// A fresh Vault is sealed and never becomes ready before
+		// configureVault has initialized it.
+		Wait:       false,
+		Timeout:    time.Minute * 5,
+		Version:    conf.Addons.Vault.Version,
+		ValuesYaml: values,
+	}, nil
+}
+
+// vaultInit is the output of `vault operator init -format=json`.
+type vaultInit struct {
+	UnsealKeys   []string `json:"unseal_keys_b64"`
+	RecoveryKeys []string `json:"recovery_keys_b64"`
+	RootToken    string   `json:"root_token"`
+}
+
+// vaultExec runs a script in the Vault pod. Secrets are handed over on
+// stdin so they never show up in process arguments or traces.
+func vaultExec(ctx context.Context, input string, script string) (string, error) {
+	return RunCommandWithInput(ctx, input, "kubectl", "--kubeconfig="+KubeconfigPath, "exec", "-i", "-n", "vault", "vault-0", "--", "sh", "-c", script)
+}
+
+func vaultStatus(ctx context.Context) (initialized bool, sealed bool, err error) {
+	// vault status exits 2 while sealed, the JSON is printed regardless.
+	out, execErr := vaultExec(ctx, "", "vault status -format=json")
+
+	var status struct {
+		Initialized bool `json:"initialized"`
+		Sealed      bool `json:"sealed"`
+	}
+	if err := json.Unmarshal([]byte(out), &status); err != nil {
+		return false, false, fmt.Errorf("%w: %s", execErr, out)
+	}
+
+	return status.Initialized, status.Sealed, nil
+}
+
+// configureVault initializes and unseals Vault if needed and enables the
+// Kubernetes auth method. It is safe to run against a configured Vault.
+func configureVault(ctx context.Context, conf *Config) error {
+	vault := conf.Addons.Vault
+
+	var initialized, sealed bool
+	var err error
+	deadline := time.Now().Add(time.Minute * 5)
+	for {
+		initialized, sealed, err = vaultStatus(ctx)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("vault did not start: %w", err)
+		}
+		time.Sleep(time.Second * 5)
+	}
+
+	if !initialized {
+		log.Println("Initializing Vault")
+
+		args := fmt.Sprintf("-key-shares=%d -key-threshold=%d", vault.KeyShares, vault.KeyThreshold)
+		if vault.Seal.Type != "" {
+			args = fmt.Sprintf("-recovery-shares=%d -recovery-threshold=%d", vault.KeyShares, vault.KeyThreshold)
+		}
+
+		out, err := vaultExec(ctx, "", "vault operator init -format=json "+args)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+
+		if err := os.WriteFile(vault.InitFile, []byte(out), 0600); err != nil {
+			return err
+		}
+		log.Printf("Vault root token and keys written to %s, move them somewhere safe\n", vault.InitFile)
+	}
+
+	data, err := os.ReadFile(vault.InitFile)
+	if err != nil {
+		return fmt.Errorf("reading vault keys: %w", err)
+	}
+
+	var keys vaultInit
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	if vault.Seal.Type != "" {
+		// Auto-unseal takes a moment after init.
+		for i := 0; sealed && i < 12; i++ {
+			time.Sleep(time.Second * 5)
+			if _, sealed, err = vaultStatus(ctx); err != nil {
+				return err
+			}
+		}
+	} else if sealed {
+		log.Println("Unsealing Vault")
+		if len(keys.UnsealKeys) < vault.KeyThreshold {
+			return errors.New("not enough unseal keys to unseal vault")
+		}
+
+		for _, key := range keys.UnsealKeys[:vault.KeyThreshold] {
+			out, err := vaultExec(ctx, key+"\n", `read -r key; vault operator unseal "$key" >/dev/null`)
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, out)
+			}
+		}
+	}
+
+	log.Println("Configuring Vault Kubernetes auth")
+	return vaultRootExec(ctx, conf,
+		"vault auth list -format=json | grep -q '\"kubernetes/\"' || vault auth enable kubernetes",
+		"vault write auth/kubernetes/config kubernetes_host=https://kubernetes.default.svc:443",
+	)
+}
+
+// vaultRootExec runs commands in the Vault pod with the root token from the
+// init file, stopping at the first that fails.
+func vaultRootExec(ctx context.Context, conf *Config, commands ...string) error {
+	data, err := os.ReadFile(conf.Addons.Vault.InitFile)
+	if err != nil {
+		return fmt.Errorf("reading vault keys: %w", err)
+	}
+
+	var keys vaultInit
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	registerSecret(keys.RootToken)
+
+	script := strings.Join(append([]string{"read -r VAULT_TOKEN", "export VAULT_TOKEN"}, commands...), " && ")
+	out, err := vaultExec(ctx, keys.RootToken+"\n", script)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+//go:embed values/vcluster.yaml
+var VClusterYaml string
+
+var loftRepo = repo.Entry{
+	Name: "loft",
+	URL:  "https://charts.loft.sh",
+}
+
+// vclusterSyncedLabel is set by the vcluster syncer on the objects it
+// copies from a virtual cluster to the host.
+const vclusterSyncedLabel = "vcluster.loft.sh/managed-by"
+
+// vclusterNamespace is the host namespace a virtual cluster runs in. Its
+// release is created outside the config, gc recognizes it by it.
+func vclusterNamespace(name string) string {
+	return "vcluster-" + name
+}
+
+func vclusterSpec(conf *Config, name string, ip string) (*helmclient.ChartSpec, error) {
+	values, err := renderValues("vcluster", VClusterYaml, map[string]string{"IP": ip})
+	if err != nil {
+		return nil, err
+	}
+
+	return &helmclient.ChartSpec{
+		ReleaseName:     name,
+		ChartName:       "loft/vcluster",
+		Namespace:       vclusterNamespace(name),
+		CreateNamespace: true,
+		Wait:            true,
+		Timeout:         time.Minute * 5,
+		Version:         conf.VCluster.Version,
+		ValuesYaml:      values,
+	}, nil
+}
+
+// CreateVCluster installs a virtual cluster for a tenant on the host cluster
+// and writes its kubeconfig to the configured directory, returning its path.
+// The kubeconfig reaches the virtual API server on a node port of the host.
+func CreateVCluster(ctx context.Context, conf *Config, name string) (string, error) {
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid name %q: %s", name, errs[0])
+	}
+
+	ip, err := primaryNodeIP(conf)
+	if err != nil {
+		return "", err
+	}
+
+	spec, err := vclusterSpec(conf, name, ip)
+	if err != nil {
+		return "", fmt.Errorf("rendering values: %w", err)
+	}
+
+	client, err := helmClientForNs(spec.Namespace)
+	if err != nil {
+		return "", err
+	}
+	if err := addChartRepo(conf, client, loftRepo); err != nil {
+		return "", fmt.Errorf("adding the chart repo: %w", err)
+	}
+
+	log.Printf("Deploying vcluster %s\n", name)
+	if err := InstallOrUpgradeSpec(ctx, conf, client, spec); err != nil {
+		return "", err
+	}
+
+	return writeVClusterKubeconfig(ctx, conf, name, ip)
+}
+
+// writeVClusterKubeconfig copies the kubeconfig the vcluster syncer stores
+// in the host cluster, pointing it at the node port of its service.
+func writeVClusterKubeconfig(ctx context.Context, conf *Config, name string, ip string) (string, error) {
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return "", err
+	}
+	ns := vclusterNamespace(name)
+
+	service, err := k8sClient.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("getting the vcluster service: %w", err)
+	}
+	var nodePort int32
+	for _, port := range service.Spec.Ports {
+		if port.Name == "https" {
+			nodePort = port.NodePort
+		}
+	}
+	if nodePort == 0 {
+		return "", fmt.Errorf("the vcluster service has no https node port")
+	}
+
+	// The syncer writes the secret once the virtual API server is up.
+	var data []byte
+	for start := time.Now(); ; time.Sleep(time.Second * 5) {
+		secret, err := k8sClient.CoreV1().Secrets(ns).Get(ctx, "vc-"+name, metav1.GetOptions{})
+		if err == nil && len(secret.Data["config"]) > 0 {
+			data = secret.Data["config"]
+			break
+		}
+		if time.Since(start) > time.Minute*5 {
+			return "", fmt.Errorf("timed out waiting for the vcluster kubeconfig")
+		}
+	}
+
+	kubeconfig, err := clientcmd.Load(data)
+	if err != nil {
+		return "", fmt.Errorf("parsing the vcluster kubeconfig: %w", err)
+	}
+	for _, cluster := range kubeconfig.Clusters {
+		cluster.Server = "https://" + net.JoinHostPort(ip, strconv.Itoa(int(nodePort)))
+	}
+	for _, kubeContext := range kubeconfig.Contexts {
+		kubeContext.Namespace = "default"
+	}
+
+	if err := os.MkdirAll(conf.VCluster.KubeconfigDir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(conf.VCluster.KubeconfigDir, name+".kubeconfig")
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		return "", err
+	}
+
+	return path, os.Chmod(path, 0600)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//go:embed web/index.html
+var webIndex []byte
+
+// ServeWeb serves the progress page over TLS with cert on addr: the phases,
+// the health of every release and, once the bootstrap succeeded, how to
+// reach the cluster. The admin kubeconfig is only handed out for token.
+func (s *ControlServer) ServeWeb(addr string, token string, cert tls.Certificate) error {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webIndex)
+	})
+
+	mux.HandleFunc("/api/progress", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, s.progress())
+	})
+
+	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		health, err := releaseHealth(r.Context(), s.conf)
+		if err != nil {
+			http.Error(w, Redact(err.Error()), http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, health)
+	})
+
+	mux.HandleFunc("/api/access", func(w http.ResponseWriter, r *http.Request) {
+		if s.progress()["status"] != "succeeded" {
+			http.Error(w, "the cluster isn't bootstrapped yet", http.StatusConflict)
+			return
+		}
+
+		access, err := accessDetails(s.conf)
+		if err != nil {
+			http.Error(w, Redact(err.Error()), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, access)
+	})
+
+	mux.HandleFunc("/kubeconfig", func(w http.ResponseWriter, r *http.Request) {
+		// Only from the header, a query string ends up in proxy and
+		// access logs.
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+
+		kubeconfig, err := os.ReadFile(KubeconfigPath)
+		if err != nil {
+			http.Error(w, "no kubeconfig yet", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Header().Set("Content-Disposition", `attachment; filename="kubeconfig"`)
+		w.Write(kubeconfig)
+	})
+
+	log.Printf("Serving the progress page on https://%s\n", addr)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// webCertificate creates the self-signed certificate of the progress page
+// for a single run, returning it with its SHA-256 fingerprint to check the
+// browser's warning against. The node is reached by IP before it has a
+// name, so no CA could vouch for it anyway.
+func webCertificate() (tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "orsted " + hostname},
+		NotBefore:    time.Now().Add(-time.Minute * 5),
+		NotAfter:     time.Now().Add(time.Hour * 24 * 30),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{hostname},
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	sum := sha256.Sum256(der)
+	fingerprint := make([]string, len(sum))
+	for i, b := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, strings.Join(fingerprint, ":"), nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %s\n", err)
+	}
+}
+
+type ComponentHealth struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+}
+
+// releaseHealth reports every managed release as ready, progressing or
+// missing, going by its workloads.
+func releaseHealth(ctx context.Context, conf *Config) ([]ComponentHealth, error) {
+	k8sClient, err := KubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := Releases(conf, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var health []ComponentHealth
+	for _, release := range releases {
+		spec := release.Spec
+		component := ComponentHealth{Name: spec.ReleaseName, Namespace: spec.Namespace, Status: "ready"}
+
+		client, err := helmClientForNs(spec.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		workloads, err := releaseWorkloads(client, spec)
+		if err != nil {
+			component.Status = "missing"
+		}
+		for _, w := range workloads {
+			if ready, err := workloadReady(ctx, k8sClient, w.Kind, w.Namespace, w.Name); err != nil || !ready {
+				component.Status = "progressing"
+				break
+			}
+		}
+
+		health = append(health, component)
+	}
+
+	return health, nil
+}
+
+// accessDetails is how to reach the bootstrapped cluster.
+func accessDetails(conf *Config) (map[string]string, error) {
+	ip, err := primaryNodeIP(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"apiServer": "https://" + net.JoinHostPort(ip, "6443"),
+		"gitops":    "kubectl -n weave-gitops port-forward svc/weave-gitops 9001, then http://localhost:9001",
+	}, nil
+}